@@ -17,52 +17,179 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/audit"
 	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/liveness"
 	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/notify"
 	protoAPI "github.com/Vidkin/metrics/internal/proto"
 	"github.com/Vidkin/metrics/internal/repository/storage"
 	"github.com/Vidkin/metrics/internal/router"
+	"github.com/Vidkin/metrics/internal/stream"
+	"github.com/Vidkin/metrics/pkg/clientid"
 	"github.com/Vidkin/metrics/pkg/interceptors"
+	"github.com/Vidkin/metrics/pkg/middleware"
+	"github.com/Vidkin/metrics/pkg/ratelimit"
 	"github.com/Vidkin/metrics/proto"
 )
 
 type ServerApp struct {
-	config     *config.ServerConfig
-	httpSrv    *http.Server
-	gRPCServer *grpc.Server
-	repository router.Repository
+	config       *config.ServerConfig
+	httpSrv      *http.Server
+	gRPCServer   *grpc.Server
+	repository   router.Repository
+	auditor      *audit.Auditor
+	sweeper      *router.Sweeper
+	recorder     *router.Recorder
+	snapshots    *router.SnapshotManager
+	rates        *router.RateSampler
+	alerts       *alert.Engine
+	agents       *liveness.Tracker
+	notifier     *notify.Notifier
+	notifyCtx    context.Context
+	cancelNotify context.CancelFunc
 }
 
 func NewServerApp(cfg *config.ServerConfig) (*ServerApp, error) {
 	if err := logger.Initialize(cfg.LogLevel); err != nil {
 		return nil, err
 	}
+	proxies, err := clientid.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
 	repo, err := router.NewRepository(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	auditor, err := router.NewAuditor(cfg, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	sweeper, err := router.NewSweeper(repo, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sweeper != nil {
+		sweeper.Audit = auditor
+	}
+
+	rates := router.NewRateSampler(repo, cfg)
+	hub := stream.NewHub(cfg.StreamBuffer)
+
+	recorder, err := router.NewRecorder(repo, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if recorder != nil {
+		recorder.Audit = auditor
+		recorder.Expiry = sweeper
+		recorder.Stream = hub
+		if rates != nil {
+			recorder.Rates = rates.History
+		}
+	}
+
+	alerts, err := router.NewAlertEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := router.NewSnapshotManager(repo, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if snapshots != nil {
+		snapshots.Expiry = sweeper
+	}
+
+	cardinalityLimiter, err := router.NewCardinalityLimiter(context.Background(), repo, cfg)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := router.NewMetadataRegistry(context.Background(), repo, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sweeper != nil {
+		sweeper.Cardinality = cardinalityLimiter
+		sweeper.Metadata = registry
+	}
+	if snapshots != nil {
+		snapshots.Cardinality = cardinalityLimiter
+	}
+	if recorder != nil {
+		recorder.Cardinality = cardinalityLimiter
+		recorder.Metadata = registry
+	}
+
 	serverApp := &ServerApp{
 		config:     cfg,
 		repository: repo,
+		auditor:    auditor,
+		sweeper:    sweeper,
+		recorder:   recorder,
+		snapshots:  snapshots,
+		rates:      rates,
+		alerts:     alerts,
+		notifier:   router.NewNotifier(cfg),
 	}
+	if serverApp.notifier != nil {
+		serverApp.notifier.Silencer = &router.Silencer{Repository: repo}
+	}
+	serverApp.notifyCtx, serverApp.cancelNotify = context.WithCancel(context.Background())
 
 	if cfg.UseGRPC {
-		s := grpc.NewServer(
+		var limiter *ratelimit.Limiter
+		if cfg.RateLimit > 0 {
+			limiter = ratelimit.New(cfg.RateLimit, cfg.RateBurst)
+		}
+		opts := []grpc.ServerOption{
 			grpc.ChainUnaryInterceptor(
 				interceptors.LoggingInterceptor,
 				interceptors.TrustedSubnetInterceptor(cfg.TrustedSubnet),
-				interceptors.HashInterceptor(cfg.Key)))
+				interceptors.RateLimitInterceptor(limiter),
+				interceptors.HashInterceptor(cfg.Key)),
+		}
+		if cfg.MaxBatchSize > 0 {
+			opts = append(opts, grpc.MaxRecvMsgSize(int(cfg.MaxBatchSize)))
+		}
+		serverApp.agents = router.NewAgentTracker(cfg)
+		s := grpc.NewServer(opts...)
 		proto.RegisterMetricsServer(s, &protoAPI.MetricsServer{
-			Repository:    repo,
-			LastStoreTime: time.Now(),
-			StoreInterval: (int)(cfg.StoreInterval),
-			RetryCount:    cfg.RetryCount,
+			Repository:      repo,
+			LastStoreTime:   time.Now(),
+			StoreInterval:   (int)(cfg.StoreInterval),
+			RetryCount:      cfg.RetryCount,
+			MaxBatchMetrics: cfg.MaxBatchMetrics,
+			Cardinality:     cardinalityLimiter,
+			Audit:           auditor,
+			Batches:         router.NewBatchCache[*proto.UpdateMetricsResponse](cfg),
+			Expiry:          sweeper,
+			Metadata:        registry,
+			Agents:          serverApp.agents,
+			Stream:          hub,
 		})
 		serverApp.gRPCServer = s
 	} else {
 		chiRouter := chi.NewRouter()
+		chiRouter.Use(middleware.RealIP(proxies))
 		metricRouter := router.NewMetricRouter(chiRouter, repo, cfg)
+		metricRouter.Cardinality = cardinalityLimiter
+		metricRouter.Metadata = registry
+		metricRouter.Audit = auditor
+		metricRouter.Expiry = sweeper
+		metricRouter.Alerts = alerts
+		metricRouter.Stream = hub
+		metricRouter.Snapshots = snapshots
+		serverApp.agents = metricRouter.Agents
+		if rates != nil {
+			metricRouter.Rates = rates.History
+			metricRouter.Gauges = rates.Gauges
+		}
 		serverApp.httpSrv = &http.Server{
 			Addr:    cfg.ServerAddress.Address,
 			Handler: metricRouter.Router,
@@ -103,24 +230,104 @@ func (a *ServerApp) Serve() {
 
 func (a *ServerApp) DumpToFile() error {
 	if dumper, ok := a.repository.(router.Dumper); ok {
-		for i := 0; i <= a.config.RetryCount; i++ {
-			err := dumper.FullDump()
-			if err != nil {
-				var pathErr *os.PathError
-				if errors.As(err, &pathErr) && i != a.config.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-				logger.Log.Info("error saving metrics", zap.Error(err))
-			} else {
-				return nil
-			}
+		err := router.RetryFile(a.config.RetryCount, dumper.FullDump)
+		if err == nil {
+			return nil
 		}
+		logger.Log.Info("error saving metrics", zap.Error(err))
 	}
 	return errors.New("provided Repository does not implement Dumper")
 }
 
+// Sweep deletes or marks stale the series that have expired. Deletions are
+// persisted immediately if metrics are stored synchronously.
+func (a *ServerApp) Sweep() {
+	deleted, err := a.sweeper.Sweep(context.Background())
+	if err != nil {
+		logger.Log.Info("error sweep expired series", zap.Error(err))
+	}
+	if deleted == 0 || a.config.StoreInterval != 0 {
+		return
+	}
+	if _, ok := a.repository.(router.Dumper); ok {
+		if err := a.DumpToFile(); err != nil {
+			logger.Log.Info("error dump metrics after sweep", zap.Error(err))
+		}
+	}
+}
+
+// SampleRates records the current totals of the counters the rates are
+// computed from and the values of the gauges drawn by the dashboard.
+func (a *ServerApp) SampleRates() {
+	if err := a.rates.Sample(context.Background()); err != nil {
+		logger.Log.Info("error sample counters", zap.Error(err))
+	}
+}
+
+// Record evaluates the recording rules and writes their results. The results
+// are persisted immediately if metrics are stored synchronously.
+func (a *ServerApp) Record() {
+	recorded, err := a.recorder.Record(context.Background())
+	if err != nil {
+		logger.Log.Info("error record series", zap.Error(err))
+	}
+	if len(recorded) == 0 || a.config.StoreInterval != 0 {
+		return
+	}
+	if _, ok := a.repository.(router.Dumper); ok {
+		if err := a.DumpToFile(); err != nil {
+			logger.Log.Info("error dump metrics after recording", zap.Error(err))
+		}
+	}
+}
+
+// Snapshot takes a scheduled snapshot of the repository and deletes the
+// oldest snapshots beyond the retention count.
+func (a *ServerApp) Snapshot() {
+	info, err := a.snapshots.Take(context.Background())
+	if err != nil {
+		logger.Log.Info("error take snapshot", zap.Error(err))
+		return
+	}
+	logger.Log.Info("snapshot taken", zap.String("snapshot", info.Name), zap.Int("metrics", info.Metrics))
+}
+
+// EvalAlerts evaluates the alerting rules against the stored metrics, logs
+// the alerts that started firing or were resolved and notifies the webhooks
+// about them.
+func (a *ServerApp) EvalAlerts() {
+	metrics, err := a.repository.GetMetrics(context.Background())
+	if err != nil {
+		logger.Log.Info("error get metrics for alerts", zap.Error(err))
+		return
+	}
+	changed := a.alerts.Eval(time.Now(), metrics)
+	for _, al := range changed {
+		logger.Log.Info("alert state changed",
+			zap.String("alert", al.Name),
+			zap.String("state", string(al.State)),
+			zap.Float64("value", al.Value))
+	}
+	if a.notifier != nil && len(changed) > 0 {
+		a.notifier.Notify(a.notifyCtx, changed)
+	}
+}
+
+// CheckAgents detects the agents that went down or came back, logs them and
+// notifies the webhooks about them.
+func (a *ServerApp) CheckAgents() {
+	changed := a.agents.Check(time.Now())
+	for _, al := range changed {
+		logger.Log.Info("agent liveness changed",
+			zap.String("agent", al.Labels[liveness.LabelAgent]),
+			zap.String("address", al.Labels[liveness.LabelAddress]),
+			zap.String("state", string(al.State)))
+	}
+	if a.notifier != nil && len(changed) > 0 {
+		a.notifier.Notify(a.notifyCtx, changed)
+	}
+}
+
 func (a *ServerApp) Run() {
 	logger.Log.Info("running server", zap.String("address", a.config.ServerAddress.Address))
 
@@ -136,6 +343,80 @@ func (a *ServerApp) Run() {
 		}()
 	}
 
+	if a.sweeper != nil {
+		interval := a.config.TTLSweepInterval
+		if interval <= 0 {
+			interval = 60
+		}
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		go func() {
+			for range ticker.C {
+				a.Sweep()
+			}
+		}()
+	}
+
+	if a.rates != nil {
+		interval := a.config.RateInterval
+		if interval <= 0 {
+			interval = 10
+		}
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		go func() {
+			for range ticker.C {
+				a.SampleRates()
+			}
+		}()
+	}
+
+	if a.recorder != nil {
+		interval := a.config.RecordInterval
+		if interval <= 0 {
+			interval = 15
+		}
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		go func() {
+			for range ticker.C {
+				a.Record()
+			}
+		}()
+	}
+
+	if a.snapshots != nil && a.config.SnapshotInterval > 0 {
+		ticker := time.NewTicker(time.Duration(a.config.SnapshotInterval) * time.Second)
+		go func() {
+			for range ticker.C {
+				a.Snapshot()
+			}
+		}()
+	}
+
+	if a.alerts != nil {
+		interval := a.config.AlertInterval
+		if interval <= 0 {
+			interval = 15
+		}
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		go func() {
+			for range ticker.C {
+				a.EvalAlerts()
+			}
+		}()
+	}
+
+	if a.agents != nil {
+		interval := a.config.AlertInterval
+		if interval <= 0 {
+			interval = 15
+		}
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		go func() {
+			for range ticker.C {
+				a.CheckAgents()
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	<-quit
@@ -159,6 +440,19 @@ func (a *ServerApp) Stop() {
 		}
 	}
 
+	if a.cancelNotify != nil {
+		a.cancelNotify()
+	}
+	if a.notifier != nil {
+		a.notifier.Wait()
+	}
+
+	if a.auditor != nil {
+		if err := a.auditor.Close(); err != nil {
+			logger.Log.Info("error close audit log", zap.Error(err))
+		}
+	}
+
 	logger.Log.Info("dump metrics before exit")
 	if _, ok := a.repository.(*storage.FileStorage); ok {
 		if err := a.DumpToFile(); err != nil {
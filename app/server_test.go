@@ -1,6 +1,10 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -9,13 +13,19 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/Vidkin/metrics/internal/alert"
 	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/liveness"
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/notify"
 	"github.com/Vidkin/metrics/internal/repository/storage"
 	"github.com/Vidkin/metrics/internal/router"
 )
 
 func TestNewServerApp(t *testing.T) {
+	badMetadataFile := filepath.Join(t.TempDir(), "metadata.json")
+	require.NoError(t, os.WriteFile(badMetadataFile, []byte("{"), 0666))
+
 	tests := []struct {
 		cfg     *config.ServerConfig
 		name    string
@@ -36,6 +46,25 @@ func TestNewServerApp(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "test bad series policy with HTTP",
+			cfg: &config.ServerConfig{
+				LogLevel:      "info",
+				MaxSeries:     10,
+				SeriesPolicy:  "ignore",
+				ServerAddress: &config.ServerAddress{Address: "127.0.0.1:8080"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test bad metadata file with HTTP",
+			cfg: &config.ServerConfig{
+				LogLevel:      "info",
+				MetadataFile:  badMetadataFile,
+				ServerAddress: &config.ServerAddress{Address: "127.0.0.1:8080"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "test good with gRPC",
 			cfg: &config.ServerConfig{
@@ -276,3 +305,74 @@ func TestServerApp_Stop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerApp_EvalAlerts(t *testing.T) {
+	received := make(chan notify.Payload, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.Payload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		received <- p
+	}))
+	defer ts.Close()
+
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(rules, []byte(`[{"name": "HighCPU", "expr": "CPU > 90"}]`), 0644))
+	app, err := NewServerApp(&config.ServerConfig{
+		LogLevel:       "info",
+		UseGRPC:        true,
+		AlertRulesFile: rules,
+		AlertWebhooks:  ts.URL,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, app.notifier)
+
+	value := 95.0
+	require.NoError(t, app.repository.UpdateMetric(context.Background(), &me.Metric{ID: "CPU", MType: "gauge", Value: &value}))
+	app.EvalAlerts()
+	app.notifier.Wait()
+
+	select {
+	case p := <-received:
+		require.Len(t, p.Alerts, 1)
+		assert.Equal(t, "HighCPU", p.Alerts[0].Name)
+		assert.Equal(t, alert.StateFiring, p.Status)
+	default:
+		t.Fatal("no notification received")
+	}
+}
+
+func TestServerApp_CheckAgents(t *testing.T) {
+	received := make(chan notify.Payload, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.Payload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		received <- p
+	}))
+	defer ts.Close()
+
+	app, err := NewServerApp(&config.ServerConfig{
+		LogLevel:      "info",
+		UseGRPC:       true,
+		AlertWebhooks: ts.URL,
+		AgentInterval: 10,
+		AgentMisses:   3,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, app.agents)
+
+	delta := int64(1)
+	lastSeen := time.Now().Add(-time.Minute)
+	app.agents.Observe("key:agent-1", "10.0.0.1", []me.Metric{{ID: liveness.PollCountMetric, MType: "counter", Delta: &delta}}, lastSeen)
+	app.CheckAgents()
+	app.notifier.Wait()
+
+	select {
+	case p := <-received:
+		require.Len(t, p.Alerts, 1)
+		assert.Equal(t, liveness.AlertName, p.Alerts[0].Name)
+		assert.Equal(t, "key:agent-1", p.Alerts[0].Labels[liveness.LabelAgent])
+		assert.Equal(t, alert.StateFiring, p.Status)
+	default:
+		t.Fatal("no notification received")
+	}
+}
@@ -0,0 +1,30 @@
+// Metricsctl is the offline administration tool of the metrics storage. See
+// the ctl package for the commands.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/Vidkin/metrics/internal/ctl"
+)
+
+// main exits with the status code of run. The exitmain analyzer allows
+// os.Exit here, see pkg/exitcheck.
+func main() {
+	os.Exit(run())
+}
+
+// run runs the command of the arguments and returns the exit status code.
+// It is separate from main, so its deferred calls run before the exit.
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return ctl.Run(ctx, os.Args[1:], ctl.Env{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Getenv: os.Getenv,
+	})
+}
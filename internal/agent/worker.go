@@ -10,6 +10,7 @@ import (
 	"errors"
 	"io"
 	"math/rand/v2"
+	"net/http"
 	"net/url"
 	"runtime"
 	"strconv"
@@ -21,15 +22,19 @@ import (
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	pb "google.golang.org/protobuf/proto"
 
 	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/idempotency"
 	"github.com/Vidkin/metrics/internal/logger"
 	"github.com/Vidkin/metrics/internal/metric"
 	"github.com/Vidkin/metrics/internal/router"
 	"github.com/Vidkin/metrics/pkg/hash"
+	"github.com/Vidkin/metrics/pkg/interceptors"
 	"github.com/Vidkin/metrics/pkg/ip"
 	"github.com/Vidkin/metrics/proto"
 )
@@ -69,8 +74,8 @@ const (
 
 	CounterMetricPollCount = "PollCount"
 
-	MetricTypeCounter = "counter"
-	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = metric.TypeCounter
+	MetricTypeGauge   = metric.TypeGauge
 
 	RequestRetryCount = 3
 )
@@ -280,6 +285,9 @@ func (mw *MetricWorker) SendMetricsGRPC(ctx context.Context, chIn chan []*metric
 				}
 			}
 
+			// The same batch ID is sent with every retry, so the server
+			// applies the batch only once.
+			batchID := idempotency.NewBatchID()
 			for i := 0; i <= RequestRetryCount; i++ {
 				ctxTimeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
 				defer cancel()
@@ -288,6 +296,7 @@ func (mw *MetricWorker) SendMetricsGRPC(ctx context.Context, chIn chan []*metric
 					Metrics: protoMetrics,
 				}
 
+				md := metadata.New(map[string]string{idempotency.MetadataBatchID: batchID})
 				if mw.config.Key != "" {
 					data, err := pb.Marshal(req)
 					if err != nil {
@@ -296,19 +305,30 @@ func (mw *MetricWorker) SendMetricsGRPC(ctx context.Context, chIn chan []*metric
 					}
 					h := hash.GetHashSHA256(mw.config.Key, data)
 					hEnc := base64.StdEncoding.EncodeToString(h)
-					md := metadata.New(map[string]string{"HashSHA256": hEnc})
-					ctxTimeout = metadata.NewOutgoingContext(ctxTimeout, md)
+					md.Set("HashSHA256", hEnc)
 				}
+				ctxTimeout = metadata.NewOutgoingContext(ctxTimeout, md)
 
-				_, err := mw.clientGRPC.UpdateMetrics(ctxTimeout, req)
+				var trailer metadata.MD
+				_, err := mw.clientGRPC.UpdateMetrics(ctxTimeout, req, grpc.Trailer(&trailer))
 				if err != nil {
-					if e, ok := status.FromError(err); ok {
+					e, ok := status.FromError(err)
+					if ok {
 						logger.Log.Error("code = " + e.Code().String() + ", message = " + e.Message())
 					} else {
 						logger.Log.Error("error update metrics", zap.Error(err))
 					}
 
 					if i != RequestRetryCount {
+						if ok && e.Code() == codes.ResourceExhausted {
+							var retryAfter string
+							if values := trailer.Get(interceptors.RetryAfterKey); len(values) > 0 {
+								retryAfter = values[0]
+							}
+							if !sleepContext(ctx, retryAfterDelay(retryAfter, i)) {
+								return
+							}
+						}
 						continue
 					}
 				}
@@ -337,6 +357,9 @@ func (mw *MetricWorker) SendMetrics(ctx context.Context, chIn chan []*metric.Met
 				logger.Log.Info("error close gzip writer", zap.Error(err))
 			}
 
+			// The same batch ID is sent with every retry, so the server
+			// applies the batch only once.
+			batchID := idempotency.NewBatchID()
 			for i := 0; i <= RequestRetryCount; i++ {
 				req := mw.client.R()
 				if mw.config.Key != "" {
@@ -349,12 +372,13 @@ func (mw *MetricWorker) SendMetrics(ctx context.Context, chIn chan []*metric.Met
 					logger.Log.Info("error get net interfaces", zap.Error(err))
 					return
 				}
-				_, err = req.
+				resp, err := req.
 					SetHeader("Content-Type", "application/json").
 					SetHeader("Content-Encoding", "gzip").
 					SetHeader("Accept-Encoding", "gzip").
 					SetHeader("X-Real-IP", interfaces[0]).
-					SetBody(buf).
+					SetHeader(idempotency.HeaderBatchID, batchID).
+					SetBody(buf.Bytes()).
 					Post(serverURL)
 				if err != nil {
 					var urlErr *url.Error
@@ -366,6 +390,18 @@ func (mw *MetricWorker) SendMetrics(ctx context.Context, chIn chan []*metric.Met
 					logger.Log.Info("error post request", zap.Error(err))
 					return
 				}
+				closeResponseBody(resp)
+
+				statusCode := resp.StatusCode()
+				if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+					logger.Log.Info("server asked to retry later", zap.Int("status", statusCode))
+					if i != RequestRetryCount {
+						if !sleepContext(ctx, retryAfterDelay(resp.Header().Get("Retry-After"), i)) {
+							return
+						}
+						continue
+					}
+				}
 				break
 			}
 
@@ -375,6 +411,44 @@ func (mw *MetricWorker) SendMetrics(ctx context.Context, chIn chan []*metric.Met
 	}
 }
 
+// retryAfterDelay returns how long to wait before the next attempt to send
+// metrics. The value of a Retry-After header (or trailer) may be either
+// a number of seconds or an HTTP date. If it is empty or malformed, the
+// usual backoff for the given attempt is used.
+func retryAfterDelay(retryAfter string, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(retryAfter); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return time.Duration(1+attempt*2) * time.Second
+}
+
+// sleepContext pauses for the given duration. It returns false if the
+// context is done before the duration elapses.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func closeResponseBody(resp *resty.Response) {
+	if resp == nil || resp.RawResponse == nil || resp.RawResponse.Body == nil {
+		return
+	}
+	if err := resp.RawResponse.Body.Close(); err != nil {
+		logger.Log.Info("error close resp raw body", zap.Error(err))
+	}
+}
+
 func (mw *MetricWorker) Poll(ctx context.Context) {
 	startTime := time.Now()
 	protocol := "http"
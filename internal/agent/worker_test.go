@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,6 +21,7 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/idempotency"
 	"github.com/Vidkin/metrics/internal/metric"
 	proto2 "github.com/Vidkin/metrics/internal/proto"
 	mock2 "github.com/Vidkin/metrics/internal/repository/mock"
@@ -109,6 +111,117 @@ func TestSendMetrics(t *testing.T) {
 	}
 }
 
+func TestSendMetrics_RetryAfter(t *testing.T) {
+	serverRepository := router.NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300}
+	metricRouter := router.NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		metricRouter.Router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := resty.New()
+	client.SetDoNotParseResponse(true)
+	memStats := &runtime.MemStats{}
+	memoryStorage := router.NewFileStorage("")
+	mw := New(memoryStorage, memStats, client, nil, &config.AgentConfig{Key: ""})
+
+	chIn := make(chan []*metric.Metric, 10)
+	go mw.CollectMetrics(context.TODO(), chIn, 10)
+
+	start := time.Now()
+	mw.SendMetrics(context.TODO(), chIn, ts.URL+"/updates/")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(2), requests.Load())
+
+	ctx := context.TODO()
+	testMetrics, _ := mw.repository.GetMetrics(ctx)
+	serverMetrics, _ := serverRepository.GetMetrics(ctx)
+	assert.ElementsMatch(t, testMetrics, serverMetrics)
+}
+
+func TestSendMetrics_LostResponse(t *testing.T) {
+	serverRepository := router.NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300}
+	metricRouter := router.NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+
+	var (
+		requests atomic.Int32
+		batchIDs []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		batchIDs = append(batchIDs, r.Header.Get(idempotency.HeaderBatchID))
+		if requests.Add(1) == 1 {
+			// The batch is applied, but the response is lost.
+			metricRouter.Router.ServeHTTP(httptest.NewRecorder(), r)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		metricRouter.Router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := resty.New()
+	client.SetDoNotParseResponse(true)
+	memStats := &runtime.MemStats{}
+	memoryStorage := router.NewFileStorage("")
+	mw := New(memoryStorage, memStats, client, nil, &config.AgentConfig{Key: ""})
+
+	chIn := make(chan []*metric.Metric, 10)
+	go mw.CollectMetrics(context.TODO(), chIn, 10)
+
+	mw.SendMetrics(context.TODO(), chIn, ts.URL+"/updates/")
+	require.Len(t, batchIDs, 2)
+	assert.NotEmpty(t, batchIDs[0])
+	assert.Equal(t, batchIDs[0], batchIDs[1])
+
+	ctx := context.TODO()
+	testMetrics, _ := mw.repository.GetMetrics(ctx)
+	serverMetrics, _ := serverRepository.GetMetrics(ctx)
+	assert.ElementsMatch(t, testMetrics, serverMetrics)
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		attempt    int
+		want       time.Duration
+	}{
+		{
+			name:       "test seconds",
+			retryAfter: "3",
+			want:       3 * time.Second,
+		},
+		{
+			name:    "test empty value uses backoff",
+			attempt: 1,
+			want:    3 * time.Second,
+		},
+		{
+			name:       "test malformed value uses backoff",
+			retryAfter: "soon",
+			want:       time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, retryAfterDelay(test.retryAfter, test.attempt))
+		})
+	}
+}
+
 func TestSendMetricsGRPC(t *testing.T) {
 	tests := []struct {
 		name    string
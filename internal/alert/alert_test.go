@@ -0,0 +1,191 @@
+package alert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func gauge(id string, v float64) *metric.Metric {
+	return &metric.Metric{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) *metric.Metric {
+	return &metric.Metric{ID: id, MType: "counter", Delta: &d}
+}
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantFor time.Duration
+		wantErr bool
+	}{
+		{
+			name:    "test gauge with for",
+			expr:    "CPUutilization1 > 90 for 5m",
+			want:    "CPUutilization1 > 90",
+			wantFor: 5 * time.Minute,
+		},
+		{
+			name:    "test rate without spaces",
+			expr:    "rate(PollCount)==0 for 2m",
+			want:    "rate(PollCount) == 0",
+			wantFor: 2 * time.Minute,
+		},
+		{
+			name: "test without for",
+			expr: "FreeMemory <= 1e6",
+			want: "FreeMemory <= 1e+06",
+		},
+		{
+			name:    "test query",
+			expr:    "avg(CPUutilization*{type=\"gauge\"}) > 90 for 1m",
+			want:    "avg(CPUutilization*{type=\"gauge\"}) > 90",
+			wantFor: time.Minute,
+		},
+		{name: "test unknown function", expr: "median(PollCount) > 1", wantErr: true},
+		{name: "test bad threshold", expr: "PollCount > 1x", wantErr: true},
+		{name: "test bad duration", expr: "PollCount > 1 for ever", wantErr: true},
+		{name: "test no operator", expr: "PollCount 1", wantErr: true},
+		{
+			name:    "test several lines",
+			expr:    "HeapAlloc\n> 1\nfor 1m",
+			want:    "HeapAlloc > 1",
+			wantFor: time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseExpr(test.expr)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got.Query.String())
+			assert.Equal(t, test.wantFor, got.For)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "HighCPU", "expr": "CPUutilization1 > 90 for 5m", "labels": {"severity": "page"}},
+		{"name": "AgentDown", "expr": "rate(PollCount) == 0 for 2m"}
+	]`), 0644))
+
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "page", rules[0].Labels["severity"])
+	assert.Equal(t, "rate(PollCount) == 0 for 2m0s", rules[1].Parsed().String())
+
+	_, err = ParseRules([]byte(`[{"name": "a", "expr": "x > 1"}, {"name": "a", "expr": "y > 1"}]`))
+	assert.Error(t, err)
+	_, err = ParseRules([]byte(`[{"expr": "x > 1"}]`))
+	assert.Error(t, err)
+}
+
+func TestEngine_Eval(t *testing.T) {
+	rules, err := ParseRules([]byte(`[
+		{"name": "HighCPU", "expr": "CPU > 90 for 5m"},
+		{"name": "AgentDown", "expr": "rate(PollCount) == 0 for 1m"}
+	]`))
+	require.NoError(t, err)
+	e := NewEngine(rules)
+	e.ResolvedRetention = time.Minute
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	changed := e.Eval(at(0), []*metric.Metric{gauge("CPU", 95), counter("PollCount", 10)})
+	assert.Empty(t, changed)
+	alerts := e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "HighCPU", alerts[0].Name)
+	assert.Equal(t, StatePending, alerts[0].State)
+
+	changed = e.Eval(at(time.Minute), []*metric.Metric{gauge("CPU", 50), counter("PollCount", 20)})
+	assert.Empty(t, changed)
+	assert.Empty(t, e.Alerts(), "pending alert must be dropped silently")
+
+	e.Eval(at(2*time.Minute), []*metric.Metric{gauge("CPU", 95), counter("PollCount", 20)})
+	changed = e.Eval(at(3*time.Minute), []*metric.Metric{gauge("CPU", 95), counter("PollCount", 20)})
+	require.Len(t, changed, 1)
+	assert.Equal(t, "AgentDown", changed[0].Name)
+	assert.Equal(t, StateFiring, changed[0].State)
+
+	changed = e.Eval(at(7*time.Minute), []*metric.Metric{gauge("CPU", 99), counter("PollCount", 20)})
+	require.Len(t, changed, 1)
+	assert.Equal(t, "HighCPU", changed[0].Name)
+	assert.Equal(t, StateFiring, changed[0].State)
+	assert.Equal(t, 99.0, changed[0].Value)
+
+	// A counter reset still counts as an increase.
+	changed = e.Eval(at(8*time.Minute), []*metric.Metric{gauge("CPU", 99), counter("PollCount", 3)})
+	require.Len(t, changed, 1)
+	assert.Equal(t, "AgentDown", changed[0].Name)
+	assert.Equal(t, StateResolved, changed[0].State)
+	assert.NotNil(t, changed[0].ResolvedAt)
+
+	alerts = e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, StateResolved, alerts[0].State)
+	assert.Equal(t, StateFiring, alerts[1].State)
+
+	e.Eval(at(9*time.Minute), []*metric.Metric{gauge("CPU", 99), counter("PollCount", 4)})
+	alerts = e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "HighCPU", alerts[0].Name)
+}
+
+func TestEngine_EvalNoData(t *testing.T) {
+	rules, err := ParseRules([]byte(`[{"name": "Low", "expr": "Free < 10"}]`))
+	require.NoError(t, err)
+	e := NewEngine(rules)
+	now := time.Now()
+
+	changed := e.Eval(now, []*metric.Metric{gauge("Free", 1)})
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateFiring, changed[0].State)
+
+	changed = e.Eval(now.Add(time.Second), nil)
+	require.Len(t, changed, 1)
+	assert.Equal(t, StateResolved, changed[0].State)
+}
+
+func TestEngine_EvalVector(t *testing.T) {
+	rules, err := ParseRules([]byte(`[{"name": "HighCPU", "expr": "CPU* > 90 for 1m"}]`))
+	require.NoError(t, err)
+	e := NewEngine(rules)
+	start := time.Now()
+
+	e.Eval(start, []*metric.Metric{gauge("CPU1", 95), gauge("CPU2", 50)})
+	changed := e.Eval(start.Add(time.Minute), []*metric.Metric{gauge("CPU1", 95), gauge("CPU2", 99)})
+	require.Len(t, changed, 1)
+	assert.Equal(t, "CPU1", changed[0].Metric)
+	assert.Equal(t, StateFiring, changed[0].State)
+
+	// Every series has its own alert, each crossing the threshold fires.
+	changed = e.Eval(start.Add(2*time.Minute), []*metric.Metric{gauge("CPU1", 10), gauge("CPU2", 99)})
+	require.Len(t, changed, 2)
+	assert.Equal(t, "CPU1", changed[0].Metric)
+	assert.Equal(t, StateResolved, changed[0].State)
+	assert.Equal(t, "CPU2", changed[1].Metric)
+	assert.Equal(t, StateFiring, changed[1].State)
+	assert.Equal(t, 99.0, changed[1].Value)
+
+	alerts := e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "CPU1", alerts[0].Metric)
+	assert.Equal(t, "CPU2", alerts[1].Metric)
+}
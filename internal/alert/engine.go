@@ -0,0 +1,222 @@
+package alert
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/query"
+	"github.com/Vidkin/metrics/internal/rate"
+)
+
+// State is the state of an alert.
+type State string
+
+// Alert states.
+const (
+	// StatePending means the condition holds, but not for long enough.
+	StatePending State = "pending"
+	// StateFiring means the condition has held for the "for" duration.
+	StateFiring State = "firing"
+	// StateResolved means the condition stopped holding after the alert was
+	// firing.
+	StateResolved State = "resolved"
+)
+
+// LabelAlertName is the label that holds the rule name of an alert in
+// notification groups and silence matchers.
+const LabelAlertName = "alertname"
+
+// DefaultResolvedRetention is how long resolved alerts are listed by
+// Engine.Alerts.
+const DefaultResolvedRetention = 15 * time.Minute
+
+// Alert is the alert of a rule for a series.
+type Alert struct {
+	ActiveAt    time.Time         `json:"activeAt"`
+	FiredAt     *time.Time        `json:"firedAt,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolvedAt,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Name        string            `json:"name"`
+	Expr        string            `json:"expr"`
+	Metric      string            `json:"metric"`
+	Description string            `json:"description,omitempty"`
+	State       State             `json:"state"`
+	Value       float64           `json:"value"`
+}
+
+// Engine evaluates alerting rules and tracks the states of their alerts. It
+// is safe for concurrent use.
+//
+// The engine samples the metrics at each evaluation for the rate and
+// increase functions, so "rate(PollCount)" is the rate between the last two
+// evaluations and windows are limited to rate.DefaultRetention.
+//
+// Fields:
+//   - ResolvedRetention: How long resolved alerts are listed.
+type Engine struct {
+	alerts            map[string]map[string]*Alert
+	history           *rate.History
+	rules             []Rule
+	ResolvedRetention time.Duration
+	mu                sync.RWMutex
+}
+
+// NewEngine creates an Engine.
+//
+// Parameters:
+//   - rules: The rules parsed by ParseRules or LoadRules.
+//
+// Returns:
+//   - A pointer to the newly created Engine.
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{
+		rules:             rules,
+		alerts:            make(map[string]map[string]*Alert),
+		history:           rate.NewHistory(rate.DefaultRetention),
+		ResolvedRetention: DefaultResolvedRetention,
+	}
+}
+
+// Rules returns the rules of the engine.
+func (e *Engine) Rules() []Rule {
+	return e.rules
+}
+
+// Eval evaluates all rules against the current metrics.
+//
+// A rule whose metric doesn't exist, or whose rate can't be computed yet,
+// has no data and its condition doesn't hold. The alerts of a rule are
+// tracked per series, so every series of a vector has its own alert.
+//
+// Parameters:
+//   - now: The evaluation time.
+//   - metrics: All metrics of the repository.
+//
+// Returns:
+//   - The alerts that started firing or were resolved by this evaluation.
+func (e *Engine) Eval(now time.Time, metrics []*metric.Metric) []Alert {
+	// A counter and a gauge can have the same name; the rate is computed
+	// for the counter.
+	totals := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		if m.Value != nil {
+			totals[m.ID] = *m.Value
+		}
+	}
+	for _, m := range metrics {
+		if m.Delta != nil {
+			totals[m.ID] = float64(*m.Delta)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, name := range e.history.Names() {
+		if _, ok := totals[name]; !ok {
+			e.history.Delete(name)
+		}
+	}
+	for name, v := range totals {
+		e.history.Record(name, v, now)
+	}
+	env := query.NewEnv(metrics)
+	env.Now = now
+	env.Rates = e.history
+
+	var changed []Alert
+	for _, r := range e.rules {
+		expr := r.parsed
+		alerts := e.alerts[r.Name]
+		if alerts == nil {
+			alerts = make(map[string]*Alert)
+			e.alerts[r.Name] = alerts
+		}
+
+		var ruleChanged []Alert
+		holds := make(map[string]struct{})
+		for _, sample := range expr.Eval(env) {
+			if _, ok := holds[sample.Name]; ok {
+				continue
+			}
+			holds[sample.Name] = struct{}{}
+
+			a := alerts[sample.Name]
+			if a == nil || a.State == StateResolved {
+				a = &Alert{
+					Name:        r.Name,
+					Expr:        r.Expr,
+					Metric:      sample.Name,
+					Description: r.Description,
+					Labels:      r.Labels,
+					State:       StatePending,
+					ActiveAt:    now,
+				}
+				alerts[sample.Name] = a
+			}
+			a.Value = sample.Value
+			if a.State == StatePending && now.Sub(a.ActiveAt) >= expr.For {
+				firedAt := now
+				a.State = StateFiring
+				a.FiredAt = &firedAt
+				ruleChanged = append(ruleChanged, *a)
+			}
+		}
+
+		for series, a := range alerts {
+			if _, ok := holds[series]; ok {
+				continue
+			}
+			switch a.State {
+			case StatePending:
+				delete(alerts, series)
+			case StateFiring:
+				resolvedAt := now
+				a.State = StateResolved
+				a.ResolvedAt = &resolvedAt
+				ruleChanged = append(ruleChanged, *a)
+			case StateResolved:
+				if now.Sub(*a.ResolvedAt) >= e.ResolvedRetention {
+					delete(alerts, series)
+				}
+			}
+		}
+		if len(alerts) == 0 {
+			delete(e.alerts, r.Name)
+		}
+		sortAlerts(ruleChanged)
+		changed = append(changed, ruleChanged...)
+	}
+	return changed
+}
+
+// Alerts returns the pending, firing and recently resolved alerts sorted by
+// name and metric.
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var alerts []Alert
+	for _, series := range e.alerts {
+		for _, a := range series {
+			alerts = append(alerts, *a)
+		}
+	}
+	if alerts == nil {
+		alerts = []Alert{}
+	}
+	sortAlerts(alerts)
+	return alerts
+}
+
+// sortAlerts sorts alerts by name and metric.
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Name != alerts[j].Name {
+			return alerts[i].Name < alerts[j].Name
+		}
+		return alerts[i].Metric < alerts[j].Metric
+	})
+}
@@ -0,0 +1,153 @@
+// Package alert provides an alerting rules engine.
+//
+// A rule is a query expression with an optional "for" duration, e.g.
+// "CPUutilization1 > 90 for 5m" or "rate(PollCount) == 0 for 2m", see package
+// query. The condition holds while the expression has a value; a comparison
+// that doesn't hold has none. While the condition holds, the alert of the
+// rule is pending; once it has held for the "for" duration, the alert is
+// firing. When the condition no longer holds, a firing alert is resolved.
+//
+// A rule whose expression is a vector, e.g. "CPUutilization* > 90", has an
+// alert per series of the vector, each with its own state.
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/query"
+)
+
+// forRegexp splits an expression into the query and the "for" duration. The
+// query may span several lines.
+var forRegexp = regexp.MustCompile(`(?s)^(.*?)(?:\s+for\s+(\S+))?\s*$`)
+
+// Expr is a parsed rule expression.
+type Expr struct {
+	Query query.Expr
+	For   time.Duration
+}
+
+// ParseExpr parses a rule expression.
+//
+// Parameters:
+//   - s: The expression, e.g. "CPUutilization1 > 90 for 5m".
+//
+// Returns:
+//   - The parsed expression.
+//   - An error if the expression is malformed.
+func ParseExpr(s string) (Expr, error) {
+	m := forRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return Expr{}, fmt.Errorf("bad alert expression %q", s)
+	}
+	q, err := query.Parse(m[1])
+	if err != nil {
+		return Expr{}, fmt.Errorf("bad alert expression %q: %w", s, err)
+	}
+
+	e := Expr{Query: q}
+	if m[2] != "" {
+		e.For, err = time.ParseDuration(m[2])
+		if err != nil {
+			return Expr{}, fmt.Errorf("bad alert expression %q: %w", s, err)
+		}
+		if e.For < 0 {
+			return Expr{}, fmt.Errorf("bad alert expression %q: negative duration", s)
+		}
+	}
+	return e, nil
+}
+
+// Eval evaluates the condition of the expression.
+//
+// Parameters:
+//   - env: The environment with the metrics.
+//
+// Returns:
+//   - The series the condition holds for with their values: the samples of
+//     a vector, or for a scalar one sample named after the first selector.
+//     It is empty if the condition doesn't hold, e.g. without data.
+func (e Expr) Eval(env *query.Env) []query.Sample {
+	v, err := e.Query.Eval(env)
+	if err != nil || v.Empty() {
+		return nil
+	}
+	if v.Type == query.ValueTypeVector {
+		return v.Vector
+	}
+	var name string
+	if selectors := query.Selectors(e.Query); len(selectors) > 0 {
+		name = selectors[0]
+	}
+	return []query.Sample{{Name: name, Value: v.Scalar}}
+}
+
+// String returns the expression in its canonical form.
+func (e Expr) String() string {
+	if e.For > 0 {
+		return fmt.Sprintf("%s for %s", e.Query, e.For)
+	}
+	return e.Query.String()
+}
+
+// Rule is an alerting rule.
+type Rule struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Name        string            `json:"name"`
+	Expr        string            `json:"expr"`
+	Description string            `json:"description,omitempty"`
+	parsed      Expr
+}
+
+// Parsed returns the parsed expression of the rule. It is only set for
+// rules returned by ParseRules and LoadRules.
+func (r Rule) Parsed() Expr {
+	return r.parsed
+}
+
+// ParseRules parses a JSON array of rules.
+//
+// Parameters:
+//   - data: The JSON array.
+//
+// Returns:
+//   - The parsed rules.
+//   - An error if the JSON is malformed, a rule has no name, names are
+//     duplicated or an expression is malformed.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parse alert rules: %w", err)
+	}
+
+	names := make(map[string]struct{}, len(rules))
+	for i := range rules {
+		if rules[i].Name == "" {
+			return nil, fmt.Errorf("alert rule %d has no name", i)
+		}
+		if _, ok := names[rules[i].Name]; ok {
+			return nil, fmt.Errorf("duplicate alert rule %s", rules[i].Name)
+		}
+		names[rules[i].Name] = struct{}{}
+
+		expr, err := ParseExpr(rules[i].Expr)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", rules[i].Name, err)
+		}
+		rules[i].parsed = expr
+	}
+	return rules, nil
+}
+
+// LoadRules reads the rules from a JSON file, see ParseRules.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
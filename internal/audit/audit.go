@@ -0,0 +1,340 @@
+// Package audit provides an append-only audit log of the changes made to
+// metrics.
+//
+// Every accepted update, delete or snapshot restore is described by an Entry that records who
+// made the change (client identity, the address of the connection and the
+// real IP address the client reported, if any), which metric was changed,
+// its old and new values and when the change happened. Entries are
+// written asynchronously by an Auditor to a Sink, such as a rotated file or a
+// Postgres table.
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// Operations recorded in the audit log.
+const (
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+)
+
+// DefaultBufferSize is the number of entries an Auditor buffers before Log
+// blocks.
+const DefaultBufferSize = 1024
+
+// maxBatchSize is the maximum number of entries written to a Sink at once.
+const maxBatchSize = 256
+
+// ErrClosed is returned by Log after the Auditor has been closed.
+var ErrClosed = errors.New("auditor is closed")
+
+// Entry is a single record of the audit log. RemoteAddr is the address of
+// the connection the change came from; ReportedIP is the unchecked address
+// the client reported in the X-Real-IP header.
+type Entry struct {
+	Time       time.Time `json:"ts"`
+	OldValue   *string   `json:"old_value,omitempty"`
+	NewValue   *string   `json:"new_value,omitempty"`
+	Client     string    `json:"client"`
+	RemoteAddr string    `json:"remote_addr"`
+	ReportedIP string    `json:"reported_ip,omitempty"`
+	Op         string    `json:"op"`
+	MType      string    `json:"type"`
+	ID         string    `json:"id"`
+}
+
+// Sink is a destination of audit entries. Implementations must append
+// entries and never modify already written ones.
+type Sink interface {
+	Write(ctx context.Context, entries []Entry) error
+	Close() error
+}
+
+// MultiSink writes audit entries to several sinks.
+type MultiSink []Sink
+
+// Write writes the entries to every sink. It returns the first error, but
+// still tries all sinks.
+func (m MultiSink) Write(ctx context.Context, entries []Entry) error {
+	var firstErr error
+	for _, s := range m {
+		if err := s.Write(ctx, entries); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close closes every sink and returns the first error.
+func (m MultiSink) Close() error {
+	var firstErr error
+	for _, s := range m {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Getter is the part of a metrics repository needed to read old values.
+type Getter interface {
+	GetMetric(ctx context.Context, mType string, name string) (*metric.Metric, error)
+}
+
+// Key returns the key of a metric in a snapshot.
+func Key(mType, name string) string {
+	return mType + "/" + name
+}
+
+// Snapshot reads the current values of the given metrics. Metrics that don't
+// exist yet are missing from the result.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the reads.
+//   - repository: The repository to read the values from.
+//   - metrics: The metrics whose values are read.
+//
+// Returns:
+//   - A map from Key to the current value of the metric.
+func Snapshot(ctx context.Context, repository Getter, metrics []metric.Metric) map[string]*metric.Metric {
+	snapshot := make(map[string]*metric.Metric, len(metrics))
+	for _, m := range metrics {
+		key := Key(m.MType, m.ID)
+		if _, ok := snapshot[key]; ok {
+			continue
+		}
+		if current, err := repository.GetMetric(ctx, m.MType, m.ID); err == nil && current != nil {
+			snapshot[key] = current
+		}
+	}
+	return snapshot
+}
+
+// UpdateEntries builds the audit entries of an applied update.
+//
+// New values are computed from the snapshot taken before the update the same
+// way storages apply metrics: a gauge is replaced and a counter is
+// incremented by its delta. Several updates of the same metric in one batch
+// produce a chain of entries.
+//
+// Parameters:
+//   - at: The time of the update.
+//   - client: The identity of the client that sent the update.
+//   - remoteAddr: The network address of the client.
+//   - before: The snapshot of the values taken before the update.
+//   - metrics: The applied metrics.
+//
+// Returns:
+//   - One entry per applied metric.
+func UpdateEntries(at time.Time, client, remoteAddr string, before map[string]*metric.Metric, metrics []metric.Metric) []Entry {
+	current := make(map[string]*metric.Metric, len(before))
+	for k, v := range before {
+		current[k] = v
+	}
+
+	entries := make([]Entry, 0, len(metrics))
+	for _, m := range metrics {
+		key := Key(m.MType, m.ID)
+		old := current[key]
+		updated := apply(old, m)
+		current[key] = updated
+		entries = append(entries, Entry{
+			Time:       at,
+			OldValue:   valueOf(old),
+			NewValue:   valueOf(updated),
+			Client:     client,
+			RemoteAddr: remoteAddr,
+			Op:         OpUpdate,
+			MType:      m.MType,
+			ID:         m.ID,
+		})
+	}
+	return entries
+}
+
+// DeleteEntry builds the audit entry of a deleted metric.
+func DeleteEntry(at time.Time, client, remoteAddr string, old *metric.Metric, mType, name string) Entry {
+	return Entry{
+		Time:       at,
+		OldValue:   valueOf(old),
+		Client:     client,
+		RemoteAddr: remoteAddr,
+		Op:         OpDelete,
+		MType:      mType,
+		ID:         name,
+	}
+}
+
+// RestoreEntries builds the audit entries of a restored snapshot. Every
+// restored series gets an OpRestore entry with the value it had before the
+// restore, if any, and its restored value. Counters are set to their totals,
+// not incremented. Every series removed by the restore gets an OpDelete
+// entry.
+//
+// Parameters:
+//   - at: The time of the restore.
+//   - client: The identity of the client that requested the restore.
+//   - remoteAddr: The network address of the client.
+//   - replaced: The metrics held by the repository before the restore.
+//   - restored: The metrics of the snapshot.
+//
+// Returns:
+//   - The entries of the restored series followed by the entries of the
+//     removed ones.
+func RestoreEntries(at time.Time, client, remoteAddr string, replaced []*metric.Metric, restored []metric.Metric) []Entry {
+	before := make(map[string]*metric.Metric, len(replaced))
+	for _, m := range replaced {
+		before[Key(m.MType, m.ID)] = m
+	}
+
+	entries := make([]Entry, 0, len(restored)+len(replaced))
+	for i := range restored {
+		m := &restored[i]
+		key := Key(m.MType, m.ID)
+		entries = append(entries, Entry{
+			Time:       at,
+			OldValue:   valueOf(before[key]),
+			NewValue:   valueOf(m),
+			Client:     client,
+			RemoteAddr: remoteAddr,
+			Op:         OpRestore,
+			MType:      m.MType,
+			ID:         m.ID,
+		})
+		delete(before, key)
+	}
+	for _, m := range replaced {
+		if _, ok := before[Key(m.MType, m.ID)]; ok {
+			entries = append(entries, DeleteEntry(at, client, remoteAddr, m, m.MType, m.ID))
+		}
+	}
+	return entries
+}
+
+func apply(old *metric.Metric, m metric.Metric) *metric.Metric {
+	updated := metric.Metric{ID: m.ID, MType: m.MType}
+	switch m.MType {
+	case metric.TypeGauge:
+		if m.Value == nil {
+			return old
+		}
+		v := *m.Value
+		updated.Value = &v
+	case metric.TypeCounter:
+		if m.Delta == nil {
+			return old
+		}
+		d := *m.Delta
+		if old != nil && old.Delta != nil {
+			d += *old.Delta
+		}
+		updated.Delta = &d
+	default:
+		return old
+	}
+	return &updated
+}
+
+func valueOf(m *metric.Metric) *string {
+	if m == nil || (m.Value == nil && m.Delta == nil) {
+		return nil
+	}
+	v := m.ValueAsString()
+	return &v
+}
+
+// Auditor writes audit entries to a Sink in the background. It is safe for
+// concurrent use.
+type Auditor struct {
+	sink    Sink
+	entries chan Entry
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// NewAuditor creates an Auditor and starts its background writer.
+//
+// Parameters:
+//   - sink: The destination of the audit entries.
+//   - bufferSize: The number of buffered entries. If it is not positive,
+//     DefaultBufferSize is used.
+//
+// Returns:
+//   - A pointer to the newly created Auditor.
+func NewAuditor(sink Sink, bufferSize int) *Auditor {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	a := &Auditor{
+		sink:    sink,
+		entries: make(chan Entry, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Log queues entries for writing. It blocks while the buffer is full, so
+// entries are never dropped.
+func (a *Auditor) Log(entries ...Entry) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+	for _, e := range entries {
+		a.entries <- e
+	}
+	return nil
+}
+
+// Close writes all queued entries and closes the sink.
+func (a *Auditor) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.entries)
+	a.mu.Unlock()
+
+	<-a.done
+	return a.sink.Close()
+}
+
+func (a *Auditor) run() {
+	defer close(a.done)
+
+	batch := make([]Entry, 0, maxBatchSize)
+	for e := range a.entries {
+		batch = append(batch[:0], e)
+	drain:
+		for len(batch) < maxBatchSize {
+			select {
+			case next, ok := <-a.entries:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		if err := a.sink.Write(context.Background(), batch); err != nil {
+			logger.Log.Error("error write audit entries", zap.Error(err), zap.Int("count", len(batch)))
+		}
+	}
+}
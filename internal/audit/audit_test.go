@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+type getterStub map[string]*metric.Metric
+
+func (g getterStub) GetMetric(_ context.Context, mType string, name string) (*metric.Metric, error) {
+	if m, ok := g[Key(mType, name)]; ok {
+		return m, nil
+	}
+	return nil, errors.New("metric not found")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestUpdateEntries(t *testing.T) {
+	repository := getterStub{
+		Key(metric.TypeCounter, "c1"): {ID: "c1", MType: metric.TypeCounter, Delta: ptr(int64(10))},
+		Key(metric.TypeGauge, "g1"):   {ID: "g1", MType: metric.TypeGauge, Value: ptr(1.5)},
+	}
+	metrics := []metric.Metric{
+		{ID: "c1", MType: metric.TypeCounter, Delta: ptr(int64(5))},
+		{ID: "c1", MType: metric.TypeCounter, Delta: ptr(int64(1))},
+		{ID: "g1", MType: metric.TypeGauge, Value: ptr(2.5)},
+		{ID: "g2", MType: metric.TypeGauge, Value: ptr(3.0)},
+	}
+
+	before := Snapshot(context.TODO(), repository, metrics)
+	assert.Len(t, before, 2)
+
+	at := time.Now()
+	entries := UpdateEntries(at, "key:agent", "10.0.0.1", before, metrics)
+	require.Len(t, entries, 4)
+
+	tests := []struct {
+		oldValue *string
+		newValue *string
+	}{
+		{oldValue: ptr("10"), newValue: ptr("15")},
+		{oldValue: ptr("15"), newValue: ptr("16")},
+		{oldValue: ptr("1.5"), newValue: ptr("2.5")},
+		{oldValue: nil, newValue: ptr("3")},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.oldValue, entries[i].OldValue)
+		assert.Equal(t, test.newValue, entries[i].NewValue)
+		assert.Equal(t, OpUpdate, entries[i].Op)
+		assert.Equal(t, "key:agent", entries[i].Client)
+		assert.Equal(t, "10.0.0.1", entries[i].RemoteAddr)
+		assert.Equal(t, at, entries[i].Time)
+	}
+
+	entry := DeleteEntry(at, "key:agent", "10.0.0.1", repository[Key(metric.TypeGauge, "g1")], metric.TypeGauge, "g1")
+	assert.Equal(t, OpDelete, entry.Op)
+	assert.Equal(t, ptr("1.5"), entry.OldValue)
+	assert.Nil(t, entry.NewValue)
+}
+
+func TestRestoreEntries(t *testing.T) {
+	replaced := []*metric.Metric{
+		{ID: "c1", MType: metric.TypeCounter, Delta: ptr(int64(20))},
+		{ID: "g1", MType: metric.TypeGauge, Value: ptr(3.0)},
+		{ID: "g2", MType: metric.TypeGauge, Value: ptr(4.0)},
+	}
+	restored := []metric.Metric{
+		{ID: "c1", MType: metric.TypeCounter, Delta: ptr(int64(7))},
+		{ID: "g1", MType: metric.TypeGauge, Value: ptr(1.5)},
+		{ID: "g3", MType: metric.TypeGauge, Value: ptr(2.0)},
+	}
+
+	at := time.Now()
+	entries := RestoreEntries(at, "key:admin", "10.0.0.1", replaced, restored)
+	require.Len(t, entries, 4)
+
+	tests := []struct {
+		oldValue *string
+		newValue *string
+		op       string
+		id       string
+	}{
+		{oldValue: ptr("20"), newValue: ptr("7"), op: OpRestore, id: "c1"},
+		{oldValue: ptr("3"), newValue: ptr("1.5"), op: OpRestore, id: "g1"},
+		{oldValue: nil, newValue: ptr("2"), op: OpRestore, id: "g3"},
+		{oldValue: ptr("4"), newValue: nil, op: OpDelete, id: "g2"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.id, entries[i].ID)
+		assert.Equal(t, test.op, entries[i].Op)
+		assert.Equal(t, test.oldValue, entries[i].OldValue)
+		assert.Equal(t, test.newValue, entries[i].NewValue)
+		assert.Equal(t, "key:admin", entries[i].Client)
+		assert.Equal(t, "10.0.0.1", entries[i].RemoteAddr)
+		assert.Equal(t, at, entries[i].Time)
+	}
+}
+
+func readEntries(t *testing.T, path string) []Entry {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		entries = append(entries, e)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestAuditor_FileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+
+	auditor := NewAuditor(sink, 1)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, auditor.Log(Entry{ID: "g1", MType: metric.TypeGauge, Op: OpUpdate, NewValue: ptr("1")}))
+	}
+	require.NoError(t, auditor.Close())
+	require.NoError(t, auditor.Close())
+	assert.ErrorIs(t, auditor.Log(Entry{}), ErrClosed)
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 10)
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 10, 2)
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, sink.Write(context.TODO(), []Entry{{ID: "g1", Op: OpUpdate}}))
+	}
+	require.NoError(t, sink.Close())
+
+	assert.Len(t, readEntries(t, path), 1)
+	assert.Len(t, readEntries(t, path+".1"), 1)
+	assert.Len(t, readEntries(t, path+".2"), 1)
+	assert.NoFileExists(t, path+".3")
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Defaults of the file sink rotation.
+const (
+	DefaultMaxFileSize    = 10 * 1024 * 1024
+	DefaultMaxFileBackups = 5
+)
+
+// FileSink writes audit entries to a file as JSON lines. When the file grows
+// over the maximum size it is rotated: "audit.log" is renamed to
+// "audit.log.1", "audit.log.1" to "audit.log.2" and so on, and the oldest
+// backup over the limit is removed.
+type FileSink struct {
+	file       *os.File
+	path       string
+	size       int64
+	maxSize    int64
+	maxBackups int
+	mu         sync.Mutex
+}
+
+// NewFileSink opens the audit log file for appending.
+//
+// Parameters:
+//   - path: The path of the audit log file.
+//   - maxSize: The size in bytes after which the file is rotated. If it is
+//     not positive, DefaultMaxFileSize is used.
+//   - maxBackups: The number of rotated files to keep. If it is not
+//     positive, DefaultMaxFileBackups is used.
+//
+// Returns:
+//   - A pointer to the newly created FileSink.
+//   - An error if the file can't be opened.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxFileBackups
+	}
+	f := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends the entries to the file and syncs it to disk.
+func (f *FileSink) Write(_ context.Context, entries []Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size >= f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(f.file)
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		n, err := w.Write(line)
+		f.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(backupName(f.path, f.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(f.path, i), backupName(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the audit log file.
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+)
+
+// PostgresSink writes audit entries to the audit_log table. The table is
+// created by the storage migrations.
+type PostgresSink struct {
+	Conn *sql.DB
+}
+
+// Write inserts the entries in a single transaction.
+func (p *PostgresSink) Write(ctx context.Context, entries []Entry) error {
+	tx, err := p.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Info("error begin tx", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO audit_log (created_at, client, remote_addr, operation, metric_type, metric_name, old_value, new_value, reported_ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))`)
+	if err != nil {
+		logger.Log.Info("error prepare stmt", zap.Error(err))
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if _, err = stmt.ExecContext(ctx, e.Time, e.Client, e.RemoteAddr, e.Op, e.MType, e.ID, e.OldValue, e.NewValue, e.ReportedIP); err != nil {
+			logger.Log.Info("error insert audit entry", zap.Error(err))
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close does nothing: the connection is owned by the metrics storage.
+func (p *PostgresSink) Close() error {
+	return nil
+}
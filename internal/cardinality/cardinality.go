@@ -0,0 +1,279 @@
+// Package cardinality provides protection against metric explosions.
+//
+// A Limiter tracks every distinct series (a pair of metric type and name)
+// known to the server and the client that created it. It caps the number of
+// series globally and per client. Updates of already known series are always
+// admitted; only the creation of new series is limited.
+package cardinality
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// Policy defines what happens to new series that exceed a limit.
+type Policy string
+
+// Supported limit policies.
+//
+//   - PolicyReject: the whole update is rejected with ErrLimitExceeded.
+//   - PolicyDrop: the series over the limit are silently dropped and the
+//     rest of the update is applied.
+const (
+	PolicyReject Policy = "reject"
+	PolicyDrop   Policy = "drop"
+)
+
+// Owners reported for series whose creator can't be identified.
+//
+//   - UnknownClient: the series existed before the Limiter was started.
+//   - AnonymousClient: the series was created by a client without identity.
+const (
+	UnknownClient   = "unknown"
+	AnonymousClient = "anonymous"
+)
+
+// ErrLimitExceeded is returned by Admit when the reject policy is in effect
+// and an update would create series over a limit.
+var ErrLimitExceeded = errors.New("series limit exceeded")
+
+// ParsePolicy converts a string to a Policy. An empty string means
+// PolicyReject.
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case "", PolicyReject:
+		return PolicyReject, nil
+	case PolicyDrop:
+		return PolicyDrop, nil
+	default:
+		return "", fmt.Errorf("unknown series limit policy: %s", s)
+	}
+}
+
+// ClientStat holds the number of series created by a single client.
+type ClientStat struct {
+	Client string `json:"client"`
+	Series int    `json:"series"`
+}
+
+// Stats is a snapshot of the current cardinality.
+type Stats struct {
+	Policy             Policy       `json:"policy"`
+	TopClients         []ClientStat `json:"top_clients"`
+	Series             int          `json:"series"`
+	MaxSeries          int          `json:"max_series"`
+	MaxSeriesPerClient int          `json:"max_series_per_client"`
+	Dropped            int64        `json:"dropped"`
+	Rejected           int64        `json:"rejected"`
+}
+
+// Limiter caps the number of distinct series. It is safe for concurrent use.
+type Limiter struct {
+	owners       map[string]string
+	perClient    map[string]int
+	policy       Policy
+	maxSeries    int
+	maxPerClient int
+	dropped      int64
+	rejected     int64
+	mu           sync.Mutex
+}
+
+// New creates a Limiter.
+//
+// Parameters:
+//   - maxSeries: The maximum number of series in total. Zero means no limit.
+//   - maxPerClient: The maximum number of series created by a single client.
+//     Zero means no limit.
+//   - policy: What to do with new series over a limit.
+//
+// Returns:
+//   - A pointer to the newly created Limiter.
+func New(maxSeries, maxPerClient int, policy Policy) *Limiter {
+	return &Limiter{
+		owners:       make(map[string]string),
+		perClient:    make(map[string]int),
+		policy:       policy,
+		maxSeries:    maxSeries,
+		maxPerClient: maxPerClient,
+	}
+}
+
+// Load registers already stored series. They are attributed to
+// UnknownClient and count towards the global limit only.
+func (l *Limiter) Load(metrics []*metric.Metric) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, m := range metrics {
+		key := seriesKey(m.MType, m.ID)
+		if _, ok := l.owners[key]; !ok {
+			l.owners[key] = UnknownClient
+		}
+	}
+}
+
+// Admission is the result of Admit.
+type Admission struct {
+	// Metrics holds the metrics that may be applied. With the drop policy
+	// some of the metrics passed to Admit may be missing.
+	Metrics []metric.Metric
+	client  string
+	created []string
+}
+
+// Admit checks a batch of metrics sent by a client against the limits and
+// registers the new series it contains. The new series count towards the
+// limits right away, so concurrent batches can't exceed them together. If
+// the admitted metrics can't be stored, the caller must undo the
+// registration with Rollback.
+//
+// Parameters:
+//   - client: The identity of the client that sent the metrics.
+//   - metrics: The metrics to admit.
+//
+// Returns:
+//   - The Admission with the metrics that may be applied.
+//   - ErrLimitExceeded if the reject policy is in effect and the batch
+//     contains series over a limit. In that case no series are registered.
+func (l *Limiter) Admit(client string, metrics []metric.Metric) (Admission, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if client == "" {
+		client = AnonymousClient
+	}
+
+	accepted := make([]metric.Metric, 0, len(metrics))
+	created := make(map[string]struct{})
+	total := len(l.owners)
+	own := l.perClient[client]
+	for _, m := range metrics {
+		key := seriesKey(m.MType, m.ID)
+		if _, ok := l.owners[key]; ok {
+			accepted = append(accepted, m)
+			continue
+		}
+		if _, ok := created[key]; ok {
+			accepted = append(accepted, m)
+			continue
+		}
+		if (l.maxSeries > 0 && total >= l.maxSeries) || (l.maxPerClient > 0 && own >= l.maxPerClient) {
+			if l.policy == PolicyReject {
+				l.rejected++
+				return Admission{}, ErrLimitExceeded
+			}
+			l.dropped++
+			continue
+		}
+		created[key] = struct{}{}
+		total++
+		own++
+		accepted = append(accepted, m)
+	}
+
+	admission := Admission{Metrics: accepted, client: client}
+	for key := range created {
+		l.owners[key] = client
+		admission.created = append(admission.created, key)
+	}
+	l.perClient[client] = own
+	return admission, nil
+}
+
+// Rollback unregisters the series created by an Admit call whose metrics
+// could not be stored. Series that were known before that call are kept.
+// It is safe to call Rollback on a nil Limiter.
+func (l *Limiter) Rollback(admission Admission) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range admission.created {
+		if l.owners[key] == admission.client {
+			l.forget(key)
+		}
+	}
+}
+
+// Forget unregisters a deleted series, so it no longer counts towards the
+// limits.
+func (l *Limiter) Forget(mType, name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.forget(seriesKey(mType, name))
+}
+
+func (l *Limiter) forget(key string) {
+	owner, ok := l.owners[key]
+	if !ok {
+		return
+	}
+	delete(l.owners, key)
+	if owner == UnknownClient {
+		return
+	}
+	if l.perClient[owner] <= 1 {
+		delete(l.perClient, owner)
+	} else {
+		l.perClient[owner]--
+	}
+}
+
+// Stats returns the current cardinality and the clients that created the
+// largest number of series.
+//
+// Parameters:
+//   - top: The maximum number of clients in the result. Zero or a negative
+//     value means all clients.
+func (l *Limiter) Stats(top int) Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clients := make([]ClientStat, 0, len(l.perClient)+1)
+	for client, n := range l.perClient {
+		clients = append(clients, ClientStat{Client: client, Series: n})
+	}
+	if unknown := len(l.owners) - sum(l.perClient); unknown > 0 {
+		clients = append(clients, ClientStat{Client: UnknownClient, Series: unknown})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Series != clients[j].Series {
+			return clients[i].Series > clients[j].Series
+		}
+		return clients[i].Client < clients[j].Client
+	})
+	if top > 0 && len(clients) > top {
+		clients = clients[:top]
+	}
+
+	return Stats{
+		Policy:             l.policy,
+		TopClients:         clients,
+		Series:             len(l.owners),
+		MaxSeries:          l.maxSeries,
+		MaxSeriesPerClient: l.maxPerClient,
+		Dropped:            l.dropped,
+		Rejected:           l.rejected,
+	}
+}
+
+func seriesKey(mType, name string) string {
+	return mType + "/" + name
+}
+
+func sum(counts map[string]int) int {
+	var s int
+	for _, n := range counts {
+		s += n
+	}
+	return s
+}
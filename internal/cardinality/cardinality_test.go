@@ -0,0 +1,140 @@
+package cardinality
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func gauge(id string) metric.Metric {
+	v := 1.0
+	return metric.Metric{ID: id, MType: "gauge", Value: &v}
+}
+
+func TestLimiter_Admit(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       Policy
+		maxSeries    int
+		maxPerClient int
+		batch        []metric.Metric
+		wantIDs      []string
+		wantErr      bool
+	}{
+		{
+			name:      "test within limits",
+			policy:    PolicyReject,
+			maxSeries: 3,
+			batch:     []metric.Metric{gauge("g1"), gauge("g2"), gauge("g2")},
+			wantIDs:   []string{"g1", "g2", "g2"},
+		},
+		{
+			name:      "test reject over global limit",
+			policy:    PolicyReject,
+			maxSeries: 2,
+			batch:     []metric.Metric{gauge("g1"), gauge("g2")},
+			wantErr:   true,
+		},
+		{
+			name:      "test drop over global limit",
+			policy:    PolicyDrop,
+			maxSeries: 2,
+			batch:     []metric.Metric{gauge("g1"), gauge("g2"), gauge("g0")},
+			wantIDs:   []string{"g1", "g0"},
+		},
+		{
+			name:         "test drop over client limit",
+			policy:       PolicyDrop,
+			maxPerClient: 1,
+			batch:        []metric.Metric{gauge("g1"), gauge("g2")},
+			wantIDs:      []string{"g1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := New(test.maxSeries, test.maxPerClient, test.policy)
+			l.Load([]*metric.Metric{{ID: "g0", MType: "gauge"}})
+
+			got, err := l.Admit("client", test.batch)
+			if test.wantErr {
+				require.ErrorIs(t, err, ErrLimitExceeded)
+				assert.Equal(t, 1, l.Stats(0).Series)
+				return
+			}
+			require.NoError(t, err)
+			ids := make([]string, 0, len(got.Metrics))
+			for _, m := range got.Metrics {
+				ids = append(ids, m.ID)
+			}
+			assert.Equal(t, test.wantIDs, ids)
+		})
+	}
+}
+
+func TestLimiter_Stats(t *testing.T) {
+	l := New(0, 0, PolicyReject)
+	l.Load([]*metric.Metric{{ID: "g0", MType: "gauge"}})
+
+	_, err := l.Admit("client1", []metric.Metric{gauge("g1"), gauge("g2")})
+	require.NoError(t, err)
+	_, err = l.Admit("client2", []metric.Metric{gauge("g3")})
+	require.NoError(t, err)
+	_, err = l.Admit("", []metric.Metric{gauge("g4")})
+	require.NoError(t, err)
+
+	stats := l.Stats(2)
+	assert.Equal(t, 5, stats.Series)
+	assert.Equal(t, []ClientStat{
+		{Client: "client1", Series: 2},
+		{Client: AnonymousClient, Series: 1},
+	}, stats.TopClients)
+
+	l.Forget("gauge", "g1")
+	l.Forget("gauge", "g0")
+	l.Forget("gauge", "unknownSeries")
+	stats = l.Stats(0)
+	assert.Equal(t, 3, stats.Series)
+	assert.Equal(t, []ClientStat{
+		{Client: AnonymousClient, Series: 1},
+		{Client: "client1", Series: 1},
+		{Client: "client2", Series: 1},
+	}, stats.TopClients)
+}
+
+func TestLimiter_Rollback(t *testing.T) {
+	l := New(3, 0, PolicyReject)
+	l.Load([]*metric.Metric{{ID: "g0", MType: "gauge"}})
+	_, err := l.Admit("client", []metric.Metric{gauge("g1")})
+	require.NoError(t, err)
+	admission, err := l.Admit("client", []metric.Metric{gauge("g0"), gauge("g1"), gauge("g2")})
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.Stats(0).Series)
+
+	l.Rollback(admission)
+	stats := l.Stats(0)
+	assert.Equal(t, 2, stats.Series)
+	assert.Equal(t, []ClientStat{
+		{Client: "client", Series: 1},
+		{Client: UnknownClient, Series: 1},
+	}, stats.TopClients)
+
+	var nilLimiter *Limiter
+	nilLimiter.Rollback(admission)
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, PolicyReject, p)
+
+	p, err = ParsePolicy("drop")
+	require.NoError(t, err)
+	assert.Equal(t, PolicyDrop, p)
+
+	_, err = ParsePolicy("ignore")
+	assert.Error(t, err)
+}
@@ -18,18 +18,54 @@ import (
 // The fields can be populated from environment variables, allowing for
 // flexible configuration without hardcoding values.
 type ServerConfig struct {
-	ServerAddress   *ServerAddress `json:"address"`
-	LogLevel        string
-	TrustedSubnet   string   `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	ConfigPath      string   `env:"CONFIG"`
-	FileStoragePath string   `env:"FILE_STORAGE_PATH" json:"store_file"`
-	DatabaseDSN     string   `env:"DATABASE_DSN" json:"database_dsn"`
-	Key             string   `env:"KEY" json:"hash_key"`
-	CryptoKey       string   `env:"CRYPTO_KEY" json:"crypto_key"`
-	StoreInterval   Interval `env:"STORE_INTERVAL" json:"store_interval"`
-	Restore         bool     `env:"RESTORE" json:"restore"`
-	UseGRPC         bool     `env:"USER_GRPC" json:"use_grpc"`
-	RetryCount      int
+	ServerAddress    *ServerAddress `json:"address"`
+	LogLevel         string
+	TrustedSubnet    string   `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedProxies   string   `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	ConfigPath       string   `env:"CONFIG"`
+	FileStoragePath  string   `env:"FILE_STORAGE_PATH" json:"store_file"`
+	DatabaseDSN      string   `env:"DATABASE_DSN" json:"database_dsn"`
+	Key              string   `env:"KEY" json:"hash_key"`
+	CryptoKey        string   `env:"CRYPTO_KEY" json:"crypto_key"`
+	SeriesPolicy     string   `env:"SERIES_LIMIT_POLICY" json:"series_limit_policy"`
+	AuditFile        string   `env:"AUDIT_FILE" json:"audit_file"`
+	MetricTTLRules   string   `env:"METRIC_TTL_RULES" json:"metric_ttl_rules"`
+	MetricTTLAction  string   `env:"METRIC_TTL_ACTION" json:"metric_ttl_action"`
+	MetadataFile     string   `env:"METADATA_FILE" json:"metadata_file"`
+	AlertRulesFile   string   `env:"ALERT_RULES_FILE" json:"alert_rules_file"`
+	AlertWebhooks    string   `env:"ALERT_WEBHOOKS" json:"alert_webhooks"`
+	AlertWebhookKey  string   `env:"ALERT_WEBHOOK_KEY" json:"alert_webhook_key"`
+	AlertGroupBy     string   `env:"ALERT_GROUP_BY" json:"alert_group_by"`
+	RecordingFile    string   `env:"RECORDING_RULES_FILE" json:"recording_rules_file"`
+	SnapshotDir      string   `env:"SNAPSHOT_DIR" json:"snapshot_dir"`
+	RateLimit        float64  `env:"RATE_LIMIT" json:"rate_limit"`
+	MaxBatchSize     int64    `env:"MAX_BATCH_SIZE" json:"max_batch_size"`
+	AuditMaxSize     int64    `env:"AUDIT_MAX_SIZE" json:"audit_max_size"`
+	StoreInterval    Interval `env:"STORE_INTERVAL" json:"store_interval"`
+	BatchIDTTL       Interval `env:"BATCH_ID_TTL" json:"batch_id_ttl"`
+	MetricTTL        Interval `env:"METRIC_TTL" json:"metric_ttl"`
+	TTLSweepInterval Interval `env:"TTL_SWEEP_INTERVAL" json:"ttl_sweep_interval"`
+	AlertInterval    Interval `env:"ALERT_EVAL_INTERVAL" json:"alert_eval_interval"`
+	AgentInterval    Interval `env:"AGENT_REPORT_INTERVAL" json:"agent_report_interval"`
+	RecordInterval   Interval `env:"RECORDING_EVAL_INTERVAL" json:"recording_eval_interval"`
+	RateInterval     Interval `env:"RATE_SAMPLE_INTERVAL" json:"rate_sample_interval"`
+	RateRetention    Interval `env:"RATE_RETENTION" json:"rate_retention"`
+	SnapshotInterval Interval `env:"SNAPSHOT_INTERVAL" json:"snapshot_interval"`
+	RateBurst        int      `env:"RATE_BURST" json:"rate_burst"`
+	MaxBatchMetrics  int      `env:"MAX_BATCH_METRICS" json:"max_batch_metrics"`
+	MaxSeries        int      `env:"MAX_SERIES" json:"max_series"`
+	MaxClientSeries  int      `env:"MAX_SERIES_PER_CLIENT" json:"max_series_per_client"`
+	AuditMaxBackups  int      `env:"AUDIT_MAX_BACKUPS" json:"audit_max_backups"`
+	AlertRetries     int      `env:"ALERT_WEBHOOK_RETRIES" json:"alert_webhook_retries"`
+	BatchCacheSize   int      `env:"BATCH_CACHE_SIZE" json:"batch_cache_size"`
+	AgentMisses      int      `env:"AGENT_MISSED_REPORTS" json:"agent_missed_reports"`
+	StreamBuffer     int      `env:"STREAM_BUFFER" json:"stream_buffer"`
+	SnapshotRetain   int      `env:"SNAPSHOT_RETAIN" json:"snapshot_retain"`
+	Restore          bool     `env:"RESTORE" json:"restore"`
+	UseGRPC          bool     `env:"USER_GRPC" json:"use_grpc"`
+	AuditDB          bool     `env:"AUDIT_DB" json:"audit_db"`
+	MetadataStrict   bool     `env:"METADATA_STRICT" json:"metadata_strict"`
+	RetryCount       int
 }
 
 // NewServerConfig initializes a new ServerConfig instance with default values
@@ -62,8 +98,44 @@ func (config *ServerConfig) parseFlags() error {
 	fs.StringVar(&config.Key, "k", "", "Hash key")
 	fs.StringVar(&config.CryptoKey, "crypto-key", "", "Crypto key")
 	fs.StringVar(&config.TrustedSubnet, "t", "", "Agent trusted subnet")
+	fs.StringVar(&config.TrustedProxies, "trusted-proxies", "", "Comma-separated subnets of the proxies whose X-Real-IP header is trusted")
 	fs.BoolVar(&config.Restore, "r", true, "Restore metrics on startup")
 	fs.BoolVar(&config.UseGRPC, "g", true, "Use gRPC")
+	fs.Float64Var(&config.RateLimit, "rate-limit", 0, "Requests per second allowed for a single client (0 - unlimited)")
+	fs.IntVar(&config.RateBurst, "rate-burst", 0, "Burst of requests allowed for a single client")
+	fs.Int64Var(&config.MaxBatchSize, "max-batch-size", 0, "Max request body size in bytes (0 - unlimited)")
+	fs.IntVar(&config.MaxBatchMetrics, "max-batch-metrics", 0, "Max metrics count in a single batch (0 - unlimited)")
+	fs.IntVar(&config.MaxSeries, "max-series", 0, "Max number of distinct series (0 - unlimited)")
+	fs.IntVar(&config.MaxClientSeries, "max-series-per-client", 0, "Max number of distinct series created by a single client (0 - unlimited)")
+	fs.StringVar(&config.SeriesPolicy, "series-limit-policy", "reject", "What to do with series over the limit: reject or drop")
+	fs.StringVar(&config.AuditFile, "audit-file", "", "Audit log file path")
+	fs.Int64Var(&config.AuditMaxSize, "audit-max-size", 0, "Audit log file size in bytes after which it is rotated")
+	fs.IntVar(&config.AuditMaxBackups, "audit-max-backups", 0, "Number of rotated audit log files to keep")
+	fs.BoolVar(&config.AuditDB, "audit-db", false, "Write audit log to the database")
+	fs.IntVar((*int)(&config.BatchIDTTL), "batch-id-ttl", 600, "How long applied batch IDs are remembered, in seconds")
+	fs.IntVar(&config.BatchCacheSize, "batch-cache-size", 10000, "Max number of remembered batch IDs")
+	fs.IntVar((*int)(&config.MetricTTL), "metric-ttl", 0, "Seconds without updates after which a series expires (0 - never)")
+	fs.StringVar(&config.MetricTTLRules, "metric-ttl-rules", "", "Series TTL by name pattern, e.g. host1_*=10m,tmp_*=30s")
+	fs.StringVar(&config.MetricTTLAction, "metric-ttl-action", "delete", "What to do with expired series: delete or stale")
+	fs.IntVar((*int)(&config.TTLSweepInterval), "ttl-sweep-interval", 60, "Interval of the search for expired series, in seconds")
+	fs.StringVar(&config.MetadataFile, "metadata-file", "", "Metric metadata file path")
+	fs.BoolVar(&config.MetadataStrict, "metadata-strict", false, "Reject updates whose type conflicts with the registered metric type")
+	fs.StringVar(&config.AlertRulesFile, "alert-rules-file", "", "Alerting rules file path")
+	fs.IntVar((*int)(&config.AlertInterval), "alert-eval-interval", 15, "Interval of the alerting rules evaluation and agent liveness checks, in seconds")
+	fs.StringVar(&config.AlertWebhooks, "alert-webhooks", "", "Comma-separated webhook URLs of alert notifications")
+	fs.StringVar(&config.AlertWebhookKey, "alert-webhook-key", "", "HMAC key of alert notification signatures")
+	fs.StringVar(&config.AlertGroupBy, "alert-group-by", "alertname", "Comma-separated labels that group alert notifications")
+	fs.IntVar(&config.AlertRetries, "alert-webhook-retries", 3, "Number of retries of a failed alert notification")
+	fs.IntVar((*int)(&config.AgentInterval), "agent-report-interval", 0, "Expected report interval of agents, in seconds (0 - learned from reports)")
+	fs.StringVar(&config.RecordingFile, "recording-rules-file", "", "Recording rules file path")
+	fs.IntVar((*int)(&config.RecordInterval), "recording-eval-interval", 15, "Interval of the recording rules evaluation, in seconds")
+	fs.IntVar((*int)(&config.RateInterval), "rate-sample-interval", 10, "Interval of the sampling of counters for rates, in seconds")
+	fs.IntVar((*int)(&config.RateRetention), "rate-retention", 3600, "How long counter samples are kept, in seconds (0 - rates are not computed)")
+	fs.IntVar(&config.AgentMisses, "agent-missed-reports", 3, "Number of missed reports after which an agent is down (0 - agents are not tracked)")
+	fs.IntVar(&config.StreamBuffer, "stream-buffer", 256, "Number of updates buffered per live stream subscriber before updates are dropped")
+	fs.StringVar(&config.SnapshotDir, "snapshot-dir", "", "Directory of the metrics snapshots (empty - snapshots are disabled)")
+	fs.IntVar((*int)(&config.SnapshotInterval), "snapshot-interval", 0, "Interval of the scheduled snapshots, in seconds (0 - no scheduled snapshots)")
+	fs.IntVar(&config.SnapshotRetain, "snapshot-retain", 7, "Number of snapshots kept (0 - all snapshots are kept)")
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		logger.Log.Error("error parse server flags", zap.Error(err))
@@ -98,6 +170,18 @@ func (config *ServerConfig) loadJSONConfig(path string) error {
 		return err
 	}
 
+	// For these options 0 is a valid setting (e.g. snapshot_retain 0 means
+	// "keep all"), so their presence is checked apart from the zero value.
+	var jsonOptional struct {
+		SnapshotRetain *int      `json:"snapshot_retain"`
+		RateRetention  *Interval `json:"rate_retention"`
+		AgentMisses    *int      `json:"agent_missed_reports"`
+		AlertRetries   *int      `json:"alert_webhook_retries"`
+	}
+	if err = json.Unmarshal(data, &jsonOptional); err != nil {
+		return err
+	}
+
 	if config.ServerAddress.Address == "" {
 		config.ServerAddress = jsonServerConfig.ServerAddress
 	}
@@ -109,7 +193,43 @@ func (config *ServerConfig) loadJSONConfig(path string) error {
 	restorePassed := false
 	hashKeyPassed := false
 	trustedSubnetPassed := false
+	trustedProxiesPassed := false
 	useGRPCPassed := false
+	rateLimitPassed := false
+	rateBurstPassed := false
+	maxBatchSizePassed := false
+	maxBatchMetricsPassed := false
+	maxSeriesPassed := false
+	maxClientSeriesPassed := false
+	seriesPolicyPassed := false
+	auditFilePassed := false
+	auditMaxSizePassed := false
+	auditMaxBackupsPassed := false
+	auditDBPassed := false
+	batchIDTTLPassed := false
+	batchCacheSizePassed := false
+	metricTTLPassed := false
+	metricTTLRulesPassed := false
+	metricTTLActionPassed := false
+	ttlSweepIntervalPassed := false
+	metadataFilePassed := false
+	metadataStrictPassed := false
+	alertRulesFilePassed := false
+	alertIntervalPassed := false
+	alertWebhooksPassed := false
+	alertWebhookKeyPassed := false
+	alertGroupByPassed := false
+	alertRetriesPassed := false
+	agentIntervalPassed := false
+	agentMissesPassed := false
+	recordingFilePassed := false
+	recordIntervalPassed := false
+	rateIntervalPassed := false
+	rateRetentionPassed := false
+	streamBufferPassed := false
+	snapshotDirPassed := false
+	snapshotIntervalPassed := false
+	snapshotRetainPassed := false
 
 	args := os.Args[1:]
 	for i := 0; i < len(args); i++ {
@@ -131,6 +251,78 @@ func (config *ServerConfig) loadJSONConfig(path string) error {
 			useGRPCPassed = true
 		case "--t", "-t":
 			trustedSubnetPassed = true
+		case "--trusted-proxies", "-trusted-proxies":
+			trustedProxiesPassed = true
+		case "--rate-limit", "-rate-limit":
+			rateLimitPassed = true
+		case "--rate-burst", "-rate-burst":
+			rateBurstPassed = true
+		case "--max-batch-size", "-max-batch-size":
+			maxBatchSizePassed = true
+		case "--max-batch-metrics", "-max-batch-metrics":
+			maxBatchMetricsPassed = true
+		case "--max-series", "-max-series":
+			maxSeriesPassed = true
+		case "--max-series-per-client", "-max-series-per-client":
+			maxClientSeriesPassed = true
+		case "--series-limit-policy", "-series-limit-policy":
+			seriesPolicyPassed = true
+		case "--audit-file", "-audit-file":
+			auditFilePassed = true
+		case "--audit-max-size", "-audit-max-size":
+			auditMaxSizePassed = true
+		case "--audit-max-backups", "-audit-max-backups":
+			auditMaxBackupsPassed = true
+		case "--audit-db", "-audit-db":
+			auditDBPassed = true
+		case "--batch-id-ttl", "-batch-id-ttl":
+			batchIDTTLPassed = true
+		case "--batch-cache-size", "-batch-cache-size":
+			batchCacheSizePassed = true
+		case "--metric-ttl", "-metric-ttl":
+			metricTTLPassed = true
+		case "--metric-ttl-rules", "-metric-ttl-rules":
+			metricTTLRulesPassed = true
+		case "--metric-ttl-action", "-metric-ttl-action":
+			metricTTLActionPassed = true
+		case "--ttl-sweep-interval", "-ttl-sweep-interval":
+			ttlSweepIntervalPassed = true
+		case "--metadata-file", "-metadata-file":
+			metadataFilePassed = true
+		case "--metadata-strict", "-metadata-strict":
+			metadataStrictPassed = true
+		case "--alert-rules-file", "-alert-rules-file":
+			alertRulesFilePassed = true
+		case "--alert-eval-interval", "-alert-eval-interval":
+			alertIntervalPassed = true
+		case "--alert-webhooks", "-alert-webhooks":
+			alertWebhooksPassed = true
+		case "--alert-webhook-key", "-alert-webhook-key":
+			alertWebhookKeyPassed = true
+		case "--alert-group-by", "-alert-group-by":
+			alertGroupByPassed = true
+		case "--alert-webhook-retries", "-alert-webhook-retries":
+			alertRetriesPassed = true
+		case "--agent-report-interval", "-agent-report-interval":
+			agentIntervalPassed = true
+		case "--agent-missed-reports", "-agent-missed-reports":
+			agentMissesPassed = true
+		case "--recording-rules-file", "-recording-rules-file":
+			recordingFilePassed = true
+		case "--recording-eval-interval", "-recording-eval-interval":
+			recordIntervalPassed = true
+		case "--rate-sample-interval", "-rate-sample-interval":
+			rateIntervalPassed = true
+		case "--rate-retention", "-rate-retention":
+			rateRetentionPassed = true
+		case "--stream-buffer", "-stream-buffer":
+			streamBufferPassed = true
+		case "--snapshot-dir", "-snapshot-dir":
+			snapshotDirPassed = true
+		case "--snapshot-interval", "-snapshot-interval":
+			snapshotIntervalPassed = true
+		case "--snapshot-retain", "-snapshot-retain":
+			snapshotRetainPassed = true
 		}
 	}
 
@@ -138,6 +330,10 @@ func (config *ServerConfig) loadJSONConfig(path string) error {
 		config.TrustedSubnet = jsonServerConfig.TrustedSubnet
 	}
 
+	if !trustedProxiesPassed {
+		config.TrustedProxies = jsonServerConfig.TrustedProxies
+	}
+
 	if !useGRPCPassed {
 		config.UseGRPC = jsonServerConfig.UseGRPC
 	}
@@ -166,5 +362,145 @@ func (config *ServerConfig) loadJSONConfig(path string) error {
 		config.Key = jsonServerConfig.Key
 	}
 
+	if !rateLimitPassed {
+		config.RateLimit = jsonServerConfig.RateLimit
+	}
+
+	if !rateBurstPassed {
+		config.RateBurst = jsonServerConfig.RateBurst
+	}
+
+	if !maxBatchSizePassed {
+		config.MaxBatchSize = jsonServerConfig.MaxBatchSize
+	}
+
+	if !maxBatchMetricsPassed {
+		config.MaxBatchMetrics = jsonServerConfig.MaxBatchMetrics
+	}
+
+	if !maxSeriesPassed {
+		config.MaxSeries = jsonServerConfig.MaxSeries
+	}
+
+	if !maxClientSeriesPassed {
+		config.MaxClientSeries = jsonServerConfig.MaxClientSeries
+	}
+
+	if !seriesPolicyPassed && jsonServerConfig.SeriesPolicy != "" {
+		config.SeriesPolicy = jsonServerConfig.SeriesPolicy
+	}
+
+	if !auditFilePassed {
+		config.AuditFile = jsonServerConfig.AuditFile
+	}
+
+	if !auditMaxSizePassed {
+		config.AuditMaxSize = jsonServerConfig.AuditMaxSize
+	}
+
+	if !auditMaxBackupsPassed {
+		config.AuditMaxBackups = jsonServerConfig.AuditMaxBackups
+	}
+
+	if !auditDBPassed {
+		config.AuditDB = jsonServerConfig.AuditDB
+	}
+
+	if !batchIDTTLPassed && jsonServerConfig.BatchIDTTL != 0 {
+		config.BatchIDTTL = jsonServerConfig.BatchIDTTL
+	}
+
+	if !batchCacheSizePassed && jsonServerConfig.BatchCacheSize != 0 {
+		config.BatchCacheSize = jsonServerConfig.BatchCacheSize
+	}
+
+	if !metricTTLPassed {
+		config.MetricTTL = jsonServerConfig.MetricTTL
+	}
+
+	if !metricTTLRulesPassed {
+		config.MetricTTLRules = jsonServerConfig.MetricTTLRules
+	}
+
+	if !metricTTLActionPassed && jsonServerConfig.MetricTTLAction != "" {
+		config.MetricTTLAction = jsonServerConfig.MetricTTLAction
+	}
+
+	if !ttlSweepIntervalPassed && jsonServerConfig.TTLSweepInterval != 0 {
+		config.TTLSweepInterval = jsonServerConfig.TTLSweepInterval
+	}
+
+	if !metadataFilePassed {
+		config.MetadataFile = jsonServerConfig.MetadataFile
+	}
+
+	if !metadataStrictPassed {
+		config.MetadataStrict = jsonServerConfig.MetadataStrict
+	}
+
+	if !alertRulesFilePassed {
+		config.AlertRulesFile = jsonServerConfig.AlertRulesFile
+	}
+
+	if !alertIntervalPassed && jsonServerConfig.AlertInterval != 0 {
+		config.AlertInterval = jsonServerConfig.AlertInterval
+	}
+
+	if !alertWebhooksPassed {
+		config.AlertWebhooks = jsonServerConfig.AlertWebhooks
+	}
+
+	if !alertWebhookKeyPassed {
+		config.AlertWebhookKey = jsonServerConfig.AlertWebhookKey
+	}
+
+	if !alertGroupByPassed && jsonServerConfig.AlertGroupBy != "" {
+		config.AlertGroupBy = jsonServerConfig.AlertGroupBy
+	}
+
+	if !alertRetriesPassed && jsonOptional.AlertRetries != nil {
+		config.AlertRetries = *jsonOptional.AlertRetries
+	}
+
+	if !agentIntervalPassed && jsonServerConfig.AgentInterval != 0 {
+		config.AgentInterval = jsonServerConfig.AgentInterval
+	}
+
+	if !agentMissesPassed && jsonOptional.AgentMisses != nil {
+		config.AgentMisses = *jsonOptional.AgentMisses
+	}
+
+	if !recordingFilePassed {
+		config.RecordingFile = jsonServerConfig.RecordingFile
+	}
+
+	if !recordIntervalPassed && jsonServerConfig.RecordInterval != 0 {
+		config.RecordInterval = jsonServerConfig.RecordInterval
+	}
+
+	if !rateIntervalPassed && jsonServerConfig.RateInterval != 0 {
+		config.RateInterval = jsonServerConfig.RateInterval
+	}
+
+	if !rateRetentionPassed && jsonOptional.RateRetention != nil {
+		config.RateRetention = *jsonOptional.RateRetention
+	}
+
+	if !streamBufferPassed && jsonServerConfig.StreamBuffer != 0 {
+		config.StreamBuffer = jsonServerConfig.StreamBuffer
+	}
+
+	if !snapshotDirPassed {
+		config.SnapshotDir = jsonServerConfig.SnapshotDir
+	}
+
+	if !snapshotIntervalPassed && jsonServerConfig.SnapshotInterval != 0 {
+		config.SnapshotInterval = jsonServerConfig.SnapshotInterval
+	}
+
+	if !snapshotRetainPassed && jsonOptional.SnapshotRetain != nil {
+		config.SnapshotRetain = *jsonOptional.SnapshotRetain
+	}
+
 	return nil
 }
@@ -53,3 +53,115 @@ func TestServerConfig_LoadJSONConfig_Invalid(t *testing.T) {
 	err := config.loadJSONConfig("invalid_path.json")
 	assert.Error(t, err)
 }
+
+func TestServerConfig_LoadJSONConfig_SnapshotRetain(t *testing.T) {
+	tests := []struct {
+		name       string
+		jsonConfig string
+		want       int
+	}{
+		{name: "keep all", jsonConfig: `{"snapshot_retain": 0}`, want: 0},
+		{name: "set", jsonConfig: `{"snapshot_retain": 3}`, want: 3},
+		{name: "absent", jsonConfig: `{}`, want: 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := os.CreateTemp("", "configServer.json")
+			require.NoError(t, err)
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString(test.jsonConfig)
+			require.NoError(t, err)
+			file.Close()
+
+			config := &ServerConfig{ServerAddress: &ServerAddress{}, SnapshotRetain: 7}
+			err = config.loadJSONConfig(file.Name())
+			require.NoError(t, err)
+			assert.Equal(t, test.want, config.SnapshotRetain)
+		})
+	}
+}
+
+func TestServerConfig_LoadJSONConfig_RateRetention(t *testing.T) {
+	tests := []struct {
+		name       string
+		jsonConfig string
+		want       Interval
+	}{
+		{name: "rates disabled", jsonConfig: `{"rate_retention": "0s"}`, want: 0},
+		{name: "set", jsonConfig: `{"rate_retention": "60s"}`, want: 60},
+		{name: "absent", jsonConfig: `{}`, want: 3600},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := os.CreateTemp("", "configServer.json")
+			require.NoError(t, err)
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString(test.jsonConfig)
+			require.NoError(t, err)
+			file.Close()
+
+			config := &ServerConfig{ServerAddress: &ServerAddress{}, RateRetention: 3600}
+			err = config.loadJSONConfig(file.Name())
+			require.NoError(t, err)
+			assert.Equal(t, test.want, config.RateRetention)
+		})
+	}
+}
+
+func TestServerConfig_LoadJSONConfig_AgentMisses(t *testing.T) {
+	tests := []struct {
+		name       string
+		jsonConfig string
+		want       int
+	}{
+		{name: "agents not tracked", jsonConfig: `{"agent_missed_reports": 0}`, want: 0},
+		{name: "set", jsonConfig: `{"agent_missed_reports": 5}`, want: 5},
+		{name: "absent", jsonConfig: `{}`, want: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := os.CreateTemp("", "configServer.json")
+			require.NoError(t, err)
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString(test.jsonConfig)
+			require.NoError(t, err)
+			file.Close()
+
+			config := &ServerConfig{ServerAddress: &ServerAddress{}, AgentMisses: 3}
+			err = config.loadJSONConfig(file.Name())
+			require.NoError(t, err)
+			assert.Equal(t, test.want, config.AgentMisses)
+		})
+	}
+}
+
+func TestServerConfig_LoadJSONConfig_AlertRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		jsonConfig string
+		want       int
+	}{
+		{name: "no retries", jsonConfig: `{"alert_webhook_retries": 0}`, want: 0},
+		{name: "set", jsonConfig: `{"alert_webhook_retries": 5}`, want: 5},
+		{name: "absent", jsonConfig: `{}`, want: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := os.CreateTemp("", "configServer.json")
+			require.NoError(t, err)
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString(test.jsonConfig)
+			require.NoError(t, err)
+			file.Close()
+
+			config := &ServerConfig{ServerAddress: &ServerAddress{}, AlertRetries: 3}
+			err = config.loadJSONConfig(file.Name())
+			require.NoError(t, err)
+			assert.Equal(t, test.want, config.AlertRetries)
+		})
+	}
+}
@@ -0,0 +1,648 @@
+// Package ctl implements metricsctl, the offline administration tool of the
+// metrics storage. It works directly against the file or the Postgres
+// storage, without a running server, so it must not be run against a file
+// that a server is writing at the same time.
+//
+// The storage is selected with the -f (file) or the -d (Postgres DSN) flag,
+// or the FILE_STORAGE_PATH and DATABASE_DSN environment variables, as for the
+// server. Postgres is opened without running the migrations, so the schema is
+// only changed by the migrate command.
+package ctl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	"github.com/Vidkin/metrics/internal/export"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/repository/storage"
+	"github.com/Vidkin/metrics/internal/router"
+)
+
+// Exit codes of Run.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+// errUsage means the command line is malformed. The usage has already been
+// printed.
+var errUsage = errors.New("usage")
+
+const usage = `Usage: metricsctl [-f file | -d dsn] <command> [arguments]
+
+Commands:
+  list [-type gauge|counter] [-prefix prefix]   list the metrics
+  get <type> <name>                              print the value of a metric
+  set <type> <name> <value>                      set a gauge or the total of a counter
+  delete <type> <name>                           delete a metric
+  dump [-format format] [-o file]                write every metric, to stdout by default
+  restore [-format format] [-i file]             set the metrics from a dump, from stdin by default
+  copy (-to-file file | -to-dsn dsn)             copy every metric to another storage
+  verify [-repair]                               check the integrity of the storage file
+  migrate up | down [-steps n] | version         run or roll back the Postgres migrations
+
+The formats are json (the default), ndjson, csv and prom.
+`
+
+// Env is the environment of a run: the streams and the variables.
+type Env struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Getenv func(string) string
+}
+
+// Run runs metricsctl with the arguments, without the program name.
+//
+// Parameters:
+//   - ctx: The context of the run.
+//   - args: The command line arguments.
+//   - env: The environment of the run.
+//
+// Returns:
+//   - The exit code: ExitOK, ExitError or ExitUsage.
+func Run(ctx context.Context, args []string, env Env) int {
+	fs := flag.NewFlagSet("metricsctl", flag.ContinueOnError)
+	fs.SetOutput(env.Stderr)
+	fs.Usage = func() { fmt.Fprint(env.Stderr, usage) }
+	filePath := fs.String("f", env.Getenv("FILE_STORAGE_PATH"), "Metrics file storage path")
+	dsn := fs.String("d", env.Getenv("DATABASE_DSN"), "Database DSN")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	c := &cli{env: env, filePath: *filePath, dsn: *dsn}
+	err := c.run(ctx, fs.Arg(0), fs.Args()[1:])
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errUsage):
+		return ExitUsage
+	default:
+		fmt.Fprintln(env.Stderr, "metricsctl:", err)
+		return ExitError
+	}
+}
+
+// cli runs a command.
+type cli struct {
+	env      Env
+	filePath string
+	dsn      string
+}
+
+func (c *cli) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "list":
+		return c.list(ctx, args)
+	case "get":
+		return c.get(ctx, args)
+	case "set":
+		return c.set(ctx, args)
+	case "delete":
+		return c.delete(ctx, args)
+	case "dump":
+		return c.dump(ctx, args)
+	case "restore":
+		return c.restore(ctx, args)
+	case "copy":
+		return c.copy(ctx, args)
+	case "verify":
+		return c.verify(ctx, args)
+	case "migrate":
+		return c.migrate(args)
+	}
+	return c.usageError("unknown command %q", command)
+}
+
+// usageError prints an error and the usage.
+func (c *cli) usageError(format string, a ...any) error {
+	fmt.Fprintf(c.env.Stderr, "metricsctl: "+format+"\n\n", a...)
+	fmt.Fprint(c.env.Stderr, usage)
+	return errUsage
+}
+
+// flags creates the flag set of a command.
+func (c *cli) flags(command string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(c.env.Stderr)
+	return fs
+}
+
+// parseArgs parses the flags of a command and checks the number of its
+// positional arguments.
+func (c *cli) parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != n {
+		return nil, c.usageError("%s takes %d arguments", fs.Name(), n)
+	}
+	return fs.Args(), nil
+}
+
+// backend is an opened storage.
+type backend struct {
+	repo router.Repository
+	file *storage.FileStorage
+	db   *sql.DB
+}
+
+// openBackend opens the file storage or the Postgres storage.
+func openBackend(ctx context.Context, filePath, dsn string) (*backend, error) {
+	switch {
+	case filePath != "" && dsn != "":
+		return nil, errors.New("both a storage file and a database DSN are set")
+	case dsn != "":
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err = db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &backend{repo: &storage.PostgresStorage{Conn: db}, db: db}, nil
+	case filePath != "":
+		file := router.NewFileStorage(filePath)
+		if err := file.Load(ctx); err != nil {
+			return nil, fmt.Errorf("load %s: %w", filePath, err)
+		}
+		return &backend{repo: file, file: file}, nil
+	}
+	return nil, errors.New("no storage: set a storage file with -f or a database DSN with -d")
+}
+
+// open opens the storage selected by the global flags.
+func (c *cli) open(ctx context.Context) (*backend, error) {
+	return openBackend(ctx, c.filePath, c.dsn)
+}
+
+// save writes the metrics of a file storage to its file. Postgres writes
+// them as they change.
+func (b *backend) save() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.FullDump()
+}
+
+func (b *backend) close() {
+	if b.db != nil {
+		b.db.Close()
+	}
+}
+
+// find returns a metric, or nil if there is no such metric.
+func (b *backend) find(ctx context.Context, mType, name string) (*metric.Metric, error) {
+	metrics, err := b.repo.ListMetrics(ctx, metric.Filter{Type: mType, Prefix: name, Sort: metric.SortName, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(metrics) == 0 || metrics[0].ID != name {
+		return nil, nil
+	}
+	return metrics[0], nil
+}
+
+// each calls fn for every metric, reading them in pages ordered by name.
+func (b *backend) each(ctx context.Context, filter metric.Filter, fn func(*metric.Metric) error) error {
+	filter.Sort, filter.Limit = metric.SortName, router.TransferBatchSize
+	for {
+		metrics, err := b.repo.ListMetrics(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, m := range metrics {
+			if err = fn(m); err != nil {
+				return err
+			}
+		}
+		if len(metrics) < filter.Limit {
+			return nil
+		}
+		last := metrics[len(metrics)-1]
+		filter.AfterID, filter.AfterType = last.ID, last.MType
+	}
+}
+
+// setAll sets the metrics read by next, in batches, until next returns
+// io.EOF. Counters are set to the read totals, as by the import endpoint.
+// It returns the number of metrics set.
+func (b *backend) setAll(ctx context.Context, next func() (metric.Metric, error)) (int, error) {
+	counters, err := b.repo.GetCounters(ctx)
+	if err != nil {
+		return 0, err
+	}
+	totals := make(map[string]int64, len(counters))
+	for _, c := range counters {
+		totals[c.ID] = *c.Delta
+	}
+
+	count := 0
+	batch := make([]metric.Metric, 0, router.TransferBatchSize)
+	for {
+		m, err := next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return count, err
+		}
+		if err == nil {
+			if m.MType == metric.TypeCounter {
+				delta := *m.Delta - totals[m.ID]
+				totals[m.ID] = *m.Delta
+				m.Delta = &delta
+			}
+			batch = append(batch, m)
+		}
+		if len(batch) == router.TransferBatchSize || (errors.Is(err, io.EOF) && len(batch) > 0) {
+			if err := b.repo.UpdateMetrics(ctx, &batch); err != nil {
+				return count, err
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+		if err != nil {
+			return count, b.save()
+		}
+	}
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	fs := c.flags("list")
+	mType := fs.String("type", "", "List the metrics of a type")
+	prefix := fs.String("prefix", "", "List the metrics whose names start with the prefix")
+	if _, err := c.parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *mType != "" && *mType != metric.TypeGauge && *mType != metric.TypeCounter {
+		return c.usageError("unknown metric type %q", *mType)
+	}
+
+	b, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	tw := tabwriter.NewWriter(c.env.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tVALUE")
+	err = b.each(ctx, metric.Filter{Type: *mType, Prefix: *prefix}, func(m *metric.Metric) error {
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", m.MType, m.ID, m.ValueAsString())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// metricArgs parses the type and the name of a metric.
+func (c *cli) metricArgs(command string, args []string, n int) ([]string, error) {
+	args, err := c.parseArgs(c.flags(command), args, n)
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != metric.TypeGauge && args[0] != metric.TypeCounter {
+		return nil, c.usageError("unknown metric type %q", args[0])
+	}
+	return args, nil
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	args, err := c.metricArgs("get", args, 2)
+	if err != nil {
+		return err
+	}
+	b, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	m, err := b.find(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("%s %q not found", args[0], args[1])
+	}
+	_, err = fmt.Fprintln(c.env.Stdout, m.ValueAsString())
+	return err
+}
+
+func (c *cli) set(ctx context.Context, args []string) error {
+	args, err := c.metricArgs("set", args, 3)
+	if err != nil {
+		return err
+	}
+	m := metric.Metric{MType: args[0], ID: args[1]}
+	if m.MType == metric.TypeGauge {
+		v, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return c.usageError("bad gauge value %q", args[2])
+		}
+		m.Value = &v
+	} else {
+		v, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return c.usageError("bad counter value %q", args[2])
+		}
+		m.Delta = &v
+	}
+
+	b, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	set := false
+	_, err = b.setAll(ctx, func() (metric.Metric, error) {
+		if set {
+			return metric.Metric{}, io.EOF
+		}
+		set = true
+		return m, nil
+	})
+	return err
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	args, err := c.metricArgs("delete", args, 2)
+	if err != nil {
+		return err
+	}
+	b, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	m, err := b.find(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("%s %q not found", args[0], args[1])
+	}
+	if err = b.repo.DeleteMetric(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	return b.save()
+}
+
+// formatFlag adds the -format flag to a command.
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", export.FormatJSON, "Format of the metrics: json, ndjson, csv or prom")
+}
+
+func (c *cli) dump(ctx context.Context, args []string) (err error) {
+	fs := c.flags("dump")
+	format := formatFlag(fs)
+	output := fs.String("o", "", "Output file, stdout by default")
+	if _, err = c.parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if export.ContentType(*format) == "" {
+		return c.usageError("unknown format %q", *format)
+	}
+
+	out := c.env.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = file
+	}
+	w, err := export.NewWriter(*format, out)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	if err = b.each(ctx, metric.Filter{}, w.Write); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (c *cli) restore(ctx context.Context, args []string) error {
+	fs := c.flags("restore")
+	format := formatFlag(fs)
+	input := fs.String("i", "", "Input file, stdin by default")
+	if _, err := c.parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if export.ContentType(*format) == "" {
+		return c.usageError("unknown format %q", *format)
+	}
+
+	in := c.env.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	r, err := export.NewReader(*format, in)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	count, err := b.setAll(ctx, r.Read)
+	if err != nil {
+		return fmt.Errorf("%w, %d metrics restored", err, count)
+	}
+	fmt.Fprintf(c.env.Stderr, "%d metrics restored\n", count)
+	return nil
+}
+
+func (c *cli) copy(ctx context.Context, args []string) error {
+	fs := c.flags("copy")
+	toFile := fs.String("to-file", "", "Copy to the metrics file storage")
+	toDSN := fs.String("to-dsn", "", "Copy to the database")
+	if _, err := c.parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if (*toFile == "") == (*toDSN == "") {
+		return c.usageError("copy takes either -to-file or -to-dsn")
+	}
+
+	source, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer source.close()
+	target, err := openBackend(ctx, *toFile, *toDSN)
+	if err != nil {
+		return err
+	}
+	defer target.close()
+
+	// The source is read into memory in pages while the target is written
+	// in batches, so the copy never holds the whole storage.
+	var (
+		page   []*metric.Metric
+		filter = metric.Filter{Sort: metric.SortName, Limit: router.TransferBatchSize}
+		done   bool
+	)
+	count, err := target.setAll(ctx, func() (metric.Metric, error) {
+		if len(page) == 0 && !done {
+			metrics, err := source.repo.ListMetrics(ctx, filter)
+			if err != nil {
+				return metric.Metric{}, err
+			}
+			page, done = metrics, len(metrics) < filter.Limit
+			if len(metrics) > 0 {
+				last := metrics[len(metrics)-1]
+				filter.AfterID, filter.AfterType = last.ID, last.MType
+			}
+		}
+		if len(page) == 0 {
+			return metric.Metric{}, io.EOF
+		}
+		m := *page[0]
+		page = page[1:]
+		return m, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w, %d metrics copied", err, count)
+	}
+	fmt.Fprintf(c.env.Stderr, "%d metrics copied\n", count)
+	return nil
+}
+
+func (c *cli) verify(ctx context.Context, args []string) error {
+	fs := c.flags("verify")
+	repair := fs.Bool("repair", false, "Skip the bad records and rewrite the file, keeping a copy of the damaged file")
+	if _, err := c.parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if c.filePath == "" {
+		return c.usageError("verify checks a storage file, set it with -f")
+	}
+
+	var (
+		report storage.FileReport
+		err    error
+	)
+	if *repair {
+		report, err = router.NewFileStorage(c.filePath).Recover(ctx)
+	} else {
+		report, err = storage.VerifyFile(c.filePath)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is unreadable: %w", c.filePath, err)
+	}
+	for _, problem := range report.Problems {
+		fmt.Fprintln(c.env.Stdout, problem)
+	}
+	fmt.Fprintf(c.env.Stdout, "%d metrics, %d problems\n", report.Metrics, len(report.Problems))
+	for _, path := range report.Quarantined {
+		fmt.Fprintf(c.env.Stderr, "damaged file kept as %s\n", path)
+	}
+	if len(report.Problems) > 0 && !*repair {
+		return fmt.Errorf("%s is damaged, repair it with verify -repair", c.filePath)
+	}
+	return nil
+}
+
+func (c *cli) migrate(args []string) error {
+	if len(args) == 0 {
+		return c.usageError("migrate takes up, down or version")
+	}
+	fs := c.flags("migrate " + args[0])
+	steps := 0
+	if args[0] == "down" {
+		fs.IntVar(&steps, "steps", 1, "Number of migrations to roll back")
+	}
+	if _, err := c.parseArgs(fs, args[1:], 0); err != nil {
+		return err
+	}
+	if c.dsn == "" {
+		return c.usageError("migrate works on the database, set its DSN with -d")
+	}
+
+	db, err := sql.Open("pgx", c.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		return err
+	}
+	source, err := iofs.New(storage.Migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		if steps <= 0 {
+			return c.usageError("steps must be positive")
+		}
+		err = m.Steps(-steps)
+	case "version":
+	default:
+		return c.usageError("migrate takes up, down or version")
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Fprintln(c.env.Stdout, "no migrations applied")
+	case err != nil:
+		return err
+	case dirty:
+		fmt.Fprintf(c.env.Stdout, "version %d (dirty)\n", version)
+	default:
+		fmt.Fprintf(c.env.Stdout, "version %d\n", version)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/repository/storage"
+)
+
+// run runs metricsctl and returns the exit code, stdout and stderr.
+func run(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), args, Env{
+		Stdin:  strings.NewReader(stdin),
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Getenv: func(string) string { return "" },
+	})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Metrics(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metrics.json")
+
+	code, _, stderr := run(t, "", "-f", file, "set", "gauge", "Alloc", "1.5")
+	require.Equal(t, ExitOK, code, stderr)
+	code, _, stderr = run(t, "", "-f", file, "set", "counter", "PollCount", "10")
+	require.Equal(t, ExitOK, code, stderr)
+	// Setting a counter sets its total.
+	code, _, stderr = run(t, "", "-f", file, "set", "counter", "PollCount", "7")
+	require.Equal(t, ExitOK, code, stderr)
+
+	code, stdout, _ := run(t, "", "-f", file, "get", "counter", "PollCount")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "7\n", stdout)
+
+	code, stdout, _ = run(t, "", "-f", file, "list")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "TYPE     NAME       VALUE\ngauge    Alloc      1.5\ncounter  PollCount  7\n", stdout)
+	code, stdout, _ = run(t, "", "-f", file, "list", "-type", "gauge")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "TYPE   NAME   VALUE\ngauge  Alloc  1.5\n", stdout)
+
+	code, _, stderr = run(t, "", "-f", file, "delete", "gauge", "Alloc")
+	require.Equal(t, ExitOK, code, stderr)
+	code, _, stderr = run(t, "", "-f", file, "get", "gauge", "Alloc")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, `gauge "Alloc" not found`)
+	code, _, _ = run(t, "", "-f", file, "delete", "gauge", "Alloc")
+	assert.Equal(t, ExitError, code)
+
+	code, _, _ = run(t, "", "-f", file, "set", "histogram", "a", "1")
+	assert.Equal(t, ExitUsage, code)
+	code, _, _ = run(t, "", "-f", file, "set", "counter", "a", "1.5")
+	assert.Equal(t, ExitUsage, code)
+	code, _, _ = run(t, "", "-f", file, "frobnicate")
+	assert.Equal(t, ExitUsage, code)
+	code, _, stderr = run(t, "", "list")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "no storage")
+}
+
+func TestRun_DumpRestoreCopy(t *testing.T) {
+	dir := t.TempDir()
+	source, target, copied := filepath.Join(dir, "source.json"), filepath.Join(dir, "target.json"), filepath.Join(dir, "copy.json")
+	code, _, stderr := run(t, "", "-f", source, "restore", "-format", "csv")
+	require.Equal(t, ExitOK, code, stderr)
+	code, _, stderr = run(t, "id,type,value\nAlloc,gauge,1.5\nPollCount,counter,7\n", "-f", source, "restore", "-format", "csv")
+	require.Equal(t, ExitOK, code, stderr)
+	assert.Equal(t, "2 metrics restored\n", stderr)
+
+	code, dump, stderr := run(t, "", "-f", source, "dump", "-format", "ndjson")
+	require.Equal(t, ExitOK, code, stderr)
+	assert.Equal(t, "{\"value\":1.5,\"id\":\"Alloc\",\"type\":\"gauge\"}\n{\"delta\":7,\"id\":\"PollCount\",\"type\":\"counter\"}\n", dump)
+
+	code, _, stderr = run(t, dump, "-f", target, "restore", "-format", "ndjson")
+	require.Equal(t, ExitOK, code, stderr)
+	code, stdout, _ := run(t, "", "-f", target, "dump")
+	assert.Equal(t, ExitOK, code)
+	assert.JSONEq(t, `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":7}]`, stdout)
+
+	code, _, stderr = run(t, "", "-f", source, "copy", "-to-file", copied)
+	require.Equal(t, ExitOK, code, stderr)
+	assert.Equal(t, "2 metrics copied\n", stderr)
+	code, stdout, _ = run(t, "", "-f", copied, "dump", "-format", "ndjson")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, dump, stdout)
+
+	code, _, _ = run(t, "", "-f", source, "dump", "-format", "xml")
+	assert.Equal(t, ExitUsage, code)
+	code, _, _ = run(t, "", "-f", source, "copy")
+	assert.Equal(t, ExitUsage, code)
+}
+
+func TestRun_Verify(t *testing.T) {
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.json"), filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(good, []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`), 0666))
+	require.NoError(t, os.WriteFile(bad, []byte(`[{"id":"Alloc","type":"gauge"},{"id":"a","type":"counter","delta":1},{"id":"a","type":"counter","delta":2}]`), 0666))
+
+	code, stdout, _ := run(t, "", "-f", good, "verify")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "1 metrics, 0 problems\n", stdout)
+
+	code, stdout, stderr := run(t, "", "-f", bad, "verify")
+	assert.Equal(t, ExitError, code)
+	assert.Equal(t, "record 1: gauge \"Alloc\" has no value\nrecord 3: counter \"a\" appears twice\n1 metrics, 2 problems\n", stdout)
+	assert.Contains(t, stderr, "is damaged")
+
+	// A damaged file isn't opened, it is repaired first.
+	code, _, stderr = run(t, "", "-f", bad, "list")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "storage file is damaged")
+	code, stdout, stderr = run(t, "", "-f", bad, "verify", "-repair")
+	assert.Equal(t, ExitOK, code, stderr)
+	assert.Equal(t, "record 1: gauge \"Alloc\" has no value\nrecord 3: counter \"a\" appears twice\n1 metrics, 2 problems\n", stdout)
+	assert.Contains(t, stderr, "damaged file kept as "+bad+storage.QuarantineSuffix)
+	code, stdout, _ = run(t, "", "-f", bad, "verify")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "1 metrics, 0 problems\n", stdout)
+	code, stdout, _ = run(t, "", "-f", bad, "get", "counter", "a")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "1\n", stdout)
+
+	code, _, _ = run(t, "", "verify")
+	assert.Equal(t, ExitUsage, code)
+}
@@ -0,0 +1,147 @@
+// Package expiry provides time-to-live policies for metric series.
+//
+// A series whose last update is older than its TTL is expired. The TTL of a
+// series is taken from the first rule whose glob pattern matches the series
+// name, or from the default TTL if no rule matches. A zero TTL means the
+// series never expires, so rules can also exempt series from the default.
+package expiry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Action is what happens to expired series.
+type Action string
+
+// Supported actions.
+const (
+	// ActionDelete deletes expired series from the storage.
+	ActionDelete Action = "delete"
+	// ActionStale keeps expired series in the storage, but marks them stale
+	// until they are updated again. Stale series are hidden from the metrics
+	// page, the Prometheus endpoint, the query API and the Grafana
+	// datasource, and flagged in the JSON listing. They can still be read
+	// by name and are exported, so an export stays a complete copy.
+	ActionStale Action = "stale"
+)
+
+// ParseAction parses the name of an action.
+func ParseAction(s string) (Action, error) {
+	switch Action(s) {
+	case ActionDelete, "":
+		return ActionDelete, nil
+	case ActionStale:
+		return ActionStale, nil
+	default:
+		return "", fmt.Errorf("unknown expiry action %q", s)
+	}
+}
+
+// Rule sets the TTL of the series whose names match a glob pattern.
+type Rule struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// ParseRules parses a comma-separated list of rules in the form
+// pattern=duration, e.g. "host1_*=10m,tmp_*=30s,important_*=0".
+//
+// Parameters:
+//   - s: The list of rules. An empty string means no rules.
+//
+// Returns:
+//   - The parsed rules in the order of the list.
+//   - An error if a rule is malformed.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pattern, ttl, ok := strings.Cut(item, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("bad expiry rule %q: want pattern=duration", item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad expiry rule %q: %w", item, err)
+		}
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("bad expiry rule %q: %w", item, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("bad expiry rule %q: negative duration", item)
+		}
+		rules = append(rules, Rule{Pattern: pattern, TTL: d})
+	}
+	return rules, nil
+}
+
+// Policy computes the TTL of series.
+type Policy struct {
+	Rules   []Rule
+	Default time.Duration
+}
+
+// ErrNegativeTTL is returned by NewPolicy for a negative default TTL.
+var ErrNegativeTTL = errors.New("negative ttl")
+
+// NewPolicy creates a Policy.
+//
+// Parameters:
+//   - defaultTTL: The TTL of series that match no rule. Zero means they
+//     never expire.
+//   - rules: The rules by name pattern, see ParseRules.
+//
+// Returns:
+//   - A pointer to the newly created Policy, or nil if no series can expire.
+//   - An error if the rules are malformed.
+func NewPolicy(defaultTTL time.Duration, rules string) (*Policy, error) {
+	if defaultTTL < 0 {
+		return nil, ErrNegativeTTL
+	}
+	parsed, err := ParseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{Rules: parsed, Default: defaultTTL}
+	if !p.enabled() {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func (p *Policy) enabled() bool {
+	if p.Default > 0 {
+		return true
+	}
+	for _, r := range p.Rules {
+		if r.TTL > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TTL returns the TTL of the series with the given name. Zero means the
+// series never expires.
+func (p *Policy) TTL(name string) time.Duration {
+	for _, r := range p.Rules {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			return r.TTL
+		}
+	}
+	return p.Default
+}
+
+// Expired reports whether the series with the given name and last update
+// time is expired at the moment now.
+func (p *Policy) Expired(name string, updated, now time.Time) bool {
+	ttl := p.TTL(name)
+	return ttl > 0 && now.Sub(updated) >= ttl
+}
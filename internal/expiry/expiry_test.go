@@ -0,0 +1,81 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		want    []Rule
+		wantErr bool
+	}{
+		{name: "empty", rules: ""},
+		{
+			name:  "several rules",
+			rules: "host1_*=10m, tmp_*=30s,important=0",
+			want: []Rule{
+				{Pattern: "host1_*", TTL: 10 * time.Minute},
+				{Pattern: "tmp_*", TTL: 30 * time.Second},
+				{Pattern: "important", TTL: 0},
+			},
+		},
+		{name: "missing duration", rules: "host1_*", wantErr: true},
+		{name: "missing pattern", rules: "=10m", wantErr: true},
+		{name: "bad duration", rules: "host1_*=ten", wantErr: true},
+		{name: "negative duration", rules: "host1_*=-1s", wantErr: true},
+		{name: "bad pattern", rules: "[=1s", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := ParseRules(test.rules)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, rules)
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := NewPolicy(0, "")
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	_, err = NewPolicy(-time.Second, "")
+	assert.ErrorIs(t, err, ErrNegativeTTL)
+
+	p, err = NewPolicy(time.Hour, "host1_*=10m,important_*=0")
+	require.NoError(t, err)
+	require.NotNil(t, p)
+
+	assert.Equal(t, 10*time.Minute, p.TTL("host1_cpu"))
+	assert.Equal(t, time.Duration(0), p.TTL("important_cpu"))
+	assert.Equal(t, time.Hour, p.TTL("host2_cpu"))
+
+	now := time.Now()
+	assert.True(t, p.Expired("host1_cpu", now.Add(-10*time.Minute), now))
+	assert.False(t, p.Expired("host1_cpu", now.Add(-time.Minute), now))
+	assert.False(t, p.Expired("important_cpu", now.Add(-24*time.Hour), now))
+	assert.True(t, p.Expired("host2_cpu", now.Add(-2*time.Hour), now))
+}
+
+func TestParseAction(t *testing.T) {
+	a, err := ParseAction("")
+	require.NoError(t, err)
+	assert.Equal(t, ActionDelete, a)
+
+	a, err = ParseAction("stale")
+	require.NoError(t, err)
+	assert.Equal(t, ActionStale, a)
+
+	_, err = ParseAction("archive")
+	assert.Error(t, err)
+}
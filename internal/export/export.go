@@ -0,0 +1,500 @@
+// Package export encodes and decodes metrics in the formats they are
+// exported and imported in, so they can be moved between environments.
+//
+// Every format carries the name, the type and the value of a metric; a
+// counter carries its total. The formats are:
+//   - json: a JSON array of metrics, as sent to the "/updates/" endpoint.
+//   - ndjson: a metric per line as JSON.
+//   - csv: the columns "id", "type" and "value" after a header row.
+//   - prom: the Prometheus text exposition format. A name that is not a
+//     valid Prometheus name is sanitized, and the original name is kept in
+//     the "id" label.
+//
+// Writers and readers handle a metric at a time, so a whole export never has
+// to be held in memory.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// Formats of the exported metrics.
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	FormatProm   = "prom"
+)
+
+// LabelID is the label of the prom format that keeps the name of a metric
+// that is not a valid Prometheus name.
+const LabelID = "id"
+
+// Formats lists the supported formats.
+var Formats = []string{FormatJSON, FormatNDJSON, FormatCSV, FormatProm}
+
+// ErrUnknownFormat means the format is not supported.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// csvHeader is the header row of the csv format.
+var csvHeader = []string{"id", "type", "value"}
+
+// ContentType returns the media type of a format.
+//
+// Parameters:
+//   - format: The format.
+//
+// Returns:
+//   - The media type, or an empty string if the format is unknown.
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatProm:
+		return "text/plain; version=0.0.4; charset=utf-8"
+	}
+	return ""
+}
+
+// Validate checks that a metric has a known type and a value of its type.
+//
+// Parameters:
+//   - m: The metric.
+//
+// Returns:
+//   - An error describing what is wrong with the metric, or nil.
+func Validate(m *metric.Metric) error {
+	switch {
+	case m.ID == "":
+		return errors.New("empty metric name")
+	case m.MType == metric.TypeGauge && m.Value == nil:
+		return fmt.Errorf("gauge %q has no value", m.ID)
+	case m.MType == metric.TypeCounter && m.Delta == nil:
+		return fmt.Errorf("counter %q has no value", m.ID)
+	case m.MType != metric.TypeGauge && m.MType != metric.TypeCounter:
+		return fmt.Errorf("metric %q has unknown type %q", m.ID, m.MType)
+	}
+	return nil
+}
+
+// PrometheusName converts a metric name to the Prometheus name syntax
+// [a-zA-Z_:][a-zA-Z0-9_:]* by replacing invalid characters with underscores.
+// A name starting with a digit is prefixed with an underscore.
+func PrometheusName(name string) string {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// Writer writes metrics in a format.
+type Writer interface {
+	// Write writes a metric.
+	Write(m *metric.Metric) error
+	// Close finishes the output, e.g. closes the JSON array. It doesn't
+	// close the underlying writer.
+	Close() error
+}
+
+// NewWriter creates a Writer of a format.
+//
+// Parameters:
+//   - format: The format.
+//   - w: The writer the metrics are written to.
+//
+// Returns:
+//   - The Writer, or ErrUnknownFormat.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatProm:
+		return &promWriter{w: w}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (jw *jsonWriter) Write(m *metric.Metric) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if jw.count == 0 {
+		sep = "[\n"
+	}
+	jw.count++
+	_, err = io.WriteString(jw.w, sep+string(data))
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	end := "\n]\n"
+	if jw.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(jw.w, end)
+	return err
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) Write(m *metric.Metric) error {
+	return nw.enc.Encode(m)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (cw *csvWriter) Write(m *metric.Metric) error {
+	if !cw.header {
+		cw.header = true
+		if err := cw.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	if err := cw.w.Write([]string{m.ID, m.MType, m.ValueAsString()}); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	if !cw.header {
+		cw.header = true
+		if err := cw.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type promWriter struct {
+	w io.Writer
+}
+
+func (pw *promWriter) Write(m *metric.Metric) error {
+	name := PrometheusName(m.ID)
+	series := name
+	if name != m.ID {
+		series += "{" + LabelID + "=\"" + escapeLabelValue(m.ID) + "\"}"
+	}
+	_, err := fmt.Fprintf(pw.w, "# TYPE %s %s\n%s %s\n", name, m.MType, series, m.ValueAsString())
+	return err
+}
+
+func (pw *promWriter) Close() error {
+	return nil
+}
+
+// escapeLabelValue escapes a label value as required by the exposition
+// format.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Reader reads metrics in a format.
+type Reader interface {
+	// Read reads the next metric. It returns io.EOF after the last one. The
+	// metrics it returns are valid, see Validate.
+	Read() (metric.Metric, error)
+}
+
+// NewReader creates a Reader of a format.
+//
+// Parameters:
+//   - format: The format.
+//   - r: The reader the metrics are read from.
+//
+// Returns:
+//   - The Reader, or ErrUnknownFormat.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonReader{dec: json.NewDecoder(r)}, nil
+	case FormatNDJSON:
+		return &ndjsonReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(csvHeader)
+		return &csvReader{r: cr}, nil
+	case FormatProm:
+		return &promReader{s: bufio.NewScanner(r), types: make(map[string]string)}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// recordError wraps the error of the n-th record of the input.
+func recordError(n int, err error) error {
+	return fmt.Errorf("record %d: %w", n, err)
+}
+
+type jsonReader struct {
+	dec     *json.Decoder
+	started bool
+	count   int
+}
+
+func (jr *jsonReader) Read() (metric.Metric, error) {
+	var m metric.Metric
+	if !jr.started {
+		jr.started = true
+		tok, err := jr.dec.Token()
+		if err != nil {
+			return m, err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return m, errors.New("expected a JSON array")
+		}
+	}
+	if !jr.dec.More() {
+		if _, err := jr.dec.Token(); err != nil {
+			return m, err
+		}
+		return m, io.EOF
+	}
+	jr.count++
+	if err := jr.dec.Decode(&m); err != nil {
+		return m, recordError(jr.count, err)
+	}
+	if err := Validate(&m); err != nil {
+		return m, recordError(jr.count, err)
+	}
+	return m, nil
+}
+
+type ndjsonReader struct {
+	dec   *json.Decoder
+	count int
+}
+
+func (nr *ndjsonReader) Read() (metric.Metric, error) {
+	var m metric.Metric
+	nr.count++
+	if err := nr.dec.Decode(&m); err != nil {
+		if errors.Is(err, io.EOF) {
+			return m, io.EOF
+		}
+		return m, recordError(nr.count, err)
+	}
+	if err := Validate(&m); err != nil {
+		return m, recordError(nr.count, err)
+	}
+	return m, nil
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header bool
+	count  int
+}
+
+func (cr *csvReader) Read() (metric.Metric, error) {
+	var m metric.Metric
+	if !cr.header {
+		cr.header = true
+		header, err := cr.r.Read()
+		if err != nil {
+			return m, err
+		}
+		for i, column := range csvHeader {
+			if strings.TrimSpace(header[i]) != column {
+				return m, fmt.Errorf("expected the header %q", strings.Join(csvHeader, ","))
+			}
+		}
+	}
+	record, err := cr.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return m, io.EOF
+		}
+		return m, err
+	}
+	cr.count++
+	m.ID, m.MType = record[0], record[1]
+	if err = setValue(&m, record[2]); err != nil {
+		return m, recordError(cr.count, err)
+	}
+	if err = Validate(&m); err != nil {
+		return m, recordError(cr.count, err)
+	}
+	return m, nil
+}
+
+// setValue parses the value of a metric according to its type.
+func setValue(m *metric.Metric, s string) error {
+	switch m.MType {
+	case metric.TypeGauge:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("bad value of gauge %q: %w", m.ID, err)
+		}
+		m.Value = &v
+	case metric.TypeCounter:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad value of counter %q: %w", m.ID, err)
+		}
+		m.Delta = &v
+	}
+	return nil
+}
+
+type promReader struct {
+	s     *bufio.Scanner
+	types map[string]string
+	count int
+}
+
+func (pr *promReader) Read() (metric.Metric, error) {
+	var m metric.Metric
+	for pr.s.Scan() {
+		line := strings.TrimSpace(pr.s.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) == 4 && fields[1] == "TYPE" {
+				pr.types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		pr.count++
+		name, id, value, err := parsePromSample(line)
+		if err != nil {
+			return m, recordError(pr.count, err)
+		}
+		m.ID = id
+		switch pr.types[name] {
+		case "", "untyped", metric.TypeGauge:
+			m.MType = metric.TypeGauge
+		case metric.TypeCounter:
+			m.MType = metric.TypeCounter
+		default:
+			return m, recordError(pr.count, fmt.Errorf("metric %q has unsupported type %q", name, pr.types[name]))
+		}
+		if m.MType == metric.TypeCounter {
+			// Counters are integers here, but may be written as floats.
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+				return m, recordError(pr.count, fmt.Errorf("bad value of counter %q", m.ID))
+			}
+			value = strconv.FormatInt(int64(f), 10)
+		}
+		if err = setValue(&m, value); err != nil {
+			return m, recordError(pr.count, err)
+		}
+		return m, nil
+	}
+	if err := pr.s.Err(); err != nil {
+		return m, err
+	}
+	return m, io.EOF
+}
+
+// parsePromSample parses a sample line of the exposition format. The only
+// label it accepts is LabelID, which overrides the name of the metric. The
+// timestamp, if any, is ignored.
+func parsePromSample(line string) (name, id, value string, err error) {
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return "", "", "", errors.New("malformed sample")
+	}
+	name, id, rest := line[:end], line[:end], line[end:]
+	if rest[0] == '{' {
+		labels, after, ok := cutLabels(rest[1:])
+		if !ok {
+			return "", "", "", errors.New("malformed labels")
+		}
+		for k, v := range labels {
+			if k != LabelID {
+				return "", "", "", fmt.Errorf("unsupported label %q", k)
+			}
+			id = v
+		}
+		rest = after
+	}
+	fields := strings.Fields(rest)
+	if len(fields) != 1 && len(fields) != 2 {
+		return "", "", "", errors.New("malformed sample")
+	}
+	return name, id, fields[0], nil
+}
+
+// cutLabels parses the labels of a sample up to the closing brace and
+// returns the rest of the line.
+func cutLabels(s string) (map[string]string, string, bool) {
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], true
+		}
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok || !strings.HasPrefix(rest, `"`) {
+			return nil, "", false
+		}
+		var value strings.Builder
+		i := 1
+		for ; i < len(rest) && rest[i] != '"'; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				switch rest[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(rest[i])
+				}
+				continue
+			}
+			value.WriteByte(rest[i])
+		}
+		if i == len(rest) {
+			return nil, "", false
+		}
+		labels[strings.TrimSpace(key)] = value.String()
+		s = rest[i+1:]
+	}
+}
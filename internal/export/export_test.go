@@ -0,0 +1,140 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func gauge(name string, v float64) metric.Metric {
+	return metric.Metric{ID: name, MType: metric.TypeGauge, Value: &v}
+}
+
+func counter(name string, v int64) metric.Metric {
+	return metric.Metric{ID: name, MType: metric.TypeCounter, Delta: &v}
+}
+
+func readAll(t *testing.T, r Reader) ([]metric.Metric, error) {
+	t.Helper()
+	var metrics []metric.Metric
+	for {
+		m, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return metrics, nil
+		}
+		if err != nil {
+			return metrics, err
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	metrics := []metric.Metric{
+		gauge("Alloc", 1.5),
+		gauge("Free.Memory", -2e-9),
+		counter("PollCount", 42),
+		gauge(`odd "name"`, math.Inf(1)),
+	}
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			in := metrics
+			if format == FormatJSON || format == FormatNDJSON {
+				// JSON has no infinity.
+				in = metrics[:3]
+			}
+			var buf bytes.Buffer
+			w, err := NewWriter(format, &buf)
+			require.NoError(t, err)
+			for i := range in {
+				require.NoError(t, w.Write(&in[i]))
+			}
+			require.NoError(t, w.Close())
+
+			r, err := NewReader(format, &buf)
+			require.NoError(t, err)
+			out, err := readAll(t, r)
+			require.NoError(t, err)
+			assert.Equal(t, in, out)
+		})
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(format, &buf)
+		require.NoError(t, err)
+		out, err := readAll(t, r)
+		require.NoError(t, err, format)
+		assert.Empty(t, out, format)
+	}
+}
+
+func TestPromFormat(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatProm, &buf)
+	require.NoError(t, err)
+	c := counter("9lives", 3)
+	require.NoError(t, w.Write(&c))
+	assert.Equal(t, "# TYPE _9lives counter\n_9lives{id=\"9lives\"} 3\n", buf.String())
+
+	r, err := NewReader(FormatProm, strings.NewReader(`
+# HELP requests Requests served.
+# TYPE requests counter
+requests 1.2e3 1700000000000
+temperature 21.5
+`))
+	require.NoError(t, err)
+	out, err := readAll(t, r)
+	require.NoError(t, err)
+	assert.Equal(t, []metric.Metric{counter("requests", 1200), gauge("temperature", 21.5)}, out)
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   string
+	}{
+		{name: "json not an array", format: FormatJSON, input: `{"id":"a"}`, want: "expected a JSON array"},
+		{name: "json no value", format: FormatJSON, input: `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge"}]`, want: `record 2: gauge "b" has no value`},
+		{name: "ndjson unknown type", format: FormatNDJSON, input: "{\"id\":\"a\",\"type\":\"histogram\",\"value\":1}\n", want: `record 1: metric "a" has unknown type "histogram"`},
+		{name: "csv header", format: FormatCSV, input: "name,type,value\na,gauge,1\n", want: "expected the header"},
+		{name: "csv counter value", format: FormatCSV, input: "id,type,value\na,counter,1.5\n", want: `record 1: bad value of counter "a"`},
+		{name: "prom label", format: FormatProm, input: "a{job=\"x\"} 1\n", want: `record 1: unsupported label "job"`},
+		{name: "prom histogram", format: FormatProm, input: "# TYPE a histogram\na 1\n", want: `record 1: metric "a" has unsupported type "histogram"`},
+		{name: "prom counter value", format: FormatProm, input: "# TYPE a counter\na 1.5\n", want: `record 1: bad value of counter "a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(tt.format, strings.NewReader(tt.input))
+			require.NoError(t, err)
+			_, err = readAll(t, r)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+
+	_, err := NewReader("xml", strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestPrometheusName(t *testing.T) {
+	assert.Equal(t, "http_requests:rate", PrometheusName("http_requests:rate"))
+	assert.Equal(t, "_9lives_a_b", PrometheusName("9lives-a.b"))
+	assert.Equal(t, "_", PrometheusName(""))
+}
@@ -0,0 +1,221 @@
+// Package idempotency provides deduplication of metric batches retried by
+// agents.
+//
+// An agent attaches a unique batch ID to every batch it sends and keeps the
+// same ID for all retries of the batch. The server remembers the result of
+// every recently applied batch in a Cache and returns it for duplicates
+// instead of applying the batch again, so counters are never incremented
+// twice when a response is lost.
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Names of the batch ID header and gRPC metadata key.
+const (
+	HeaderBatchID   = "X-Batch-ID"
+	MetadataBatchID = "x-batch-id"
+)
+
+// Defaults of the Cache.
+const (
+	DefaultTTL      = 10 * time.Minute
+	DefaultCapacity = 10000
+)
+
+// NewBatchID generates a random batch ID.
+func NewBatchID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(b)
+}
+
+type entry[T any] struct {
+	expires time.Time
+	result  T
+	done    chan struct{}
+	elem    *list.Element
+	key     string
+	ok      bool
+}
+
+// Cache remembers the results of recently applied batches. It is safe for
+// concurrent use.
+//
+// A caller that processes a batch first calls Begin. If the batch has already
+// been applied, Begin returns its result. Otherwise the caller becomes the
+// owner of the batch and must call either Complete with the result or Abort
+// if the batch wasn't applied. Concurrent duplicates of a batch that is being
+// processed wait for its owner.
+type Cache[T any] struct {
+	entries  map[string]*entry[T]
+	order    *list.List
+	now      func() time.Time
+	ttl      time.Duration
+	capacity int
+	mu       sync.Mutex
+}
+
+// New creates a Cache.
+//
+// Parameters:
+//   - ttl: How long the result of a batch is remembered. If it is not
+//     positive, DefaultTTL is used.
+//   - capacity: The maximum number of remembered batches. When it is
+//     reached, the oldest batches are forgotten. If it is not positive,
+//     DefaultCapacity is used.
+//
+// Returns:
+//   - A pointer to the newly created Cache.
+func New[T any](ttl time.Duration, capacity int) *Cache[T] {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Cache[T]{
+		entries:  make(map[string]*entry[T]),
+		order:    list.New(),
+		now:      time.Now,
+		ttl:      ttl,
+		capacity: capacity,
+	}
+}
+
+// Key builds the cache key of a batch. Batch IDs are scoped by client, so
+// two clients can't collide or read each other's results.
+func Key(client, batchID string) string {
+	return client + "/" + batchID
+}
+
+// Begin looks up the result of a batch.
+//
+// Parameters:
+//   - ctx: A context.Context that bounds waiting for a concurrent duplicate.
+//   - key: The key of the batch.
+//
+// Returns:
+//   - The result of the batch and true if it has already been applied.
+//   - The zero value and false if the caller became the owner of the batch.
+//   - An error if the context is done while waiting for a concurrent
+//     duplicate.
+func (c *Cache[T]) Begin(ctx context.Context, key string) (T, bool, error) {
+	for {
+		c.mu.Lock()
+		c.expire()
+		e, found := c.entries[key]
+		if !found {
+			c.add(key)
+			c.mu.Unlock()
+			var zero T
+			return zero, false, nil
+		}
+		if e.ok {
+			result := e.result
+			c.mu.Unlock()
+			return result, true, nil
+		}
+		done := e.done
+		c.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			var zero T
+			return zero, false, ctx.Err()
+		}
+	}
+}
+
+// Complete stores the result of an applied batch and wakes up the waiting
+// duplicates. Completing a batch again replaces its result, so an owner can
+// mark the batch applied as soon as it is, e.g. with the zero value, and
+// store the full result once it has one.
+func (c *Cache[T]) Complete(key string, result T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	e.result = result
+	if e.ok {
+		return
+	}
+	e.ok = true
+	e.expires = c.now().Add(c.ttl)
+	close(e.done)
+}
+
+// Abort forgets a batch that wasn't applied, so a retry can apply it. The
+// first waiting duplicate becomes the new owner. A completed batch is kept.
+func (c *Cache[T]) Abort(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.ok {
+		return
+	}
+	c.remove(e)
+	close(e.done)
+}
+
+// Len returns the number of remembered batches, including the batches being
+// processed.
+func (c *Cache[T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache[T]) add(key string) {
+	for len(c.entries) >= c.capacity {
+		oldest := c.order.Front()
+		if oldest == nil {
+			break
+		}
+		e := oldest.Value.(*entry[T])
+		if !e.ok {
+			// Batches being processed are never evicted; the cache may
+			// temporarily grow over its capacity instead.
+			break
+		}
+		c.remove(e)
+	}
+	e := &entry[T]{key: key, done: make(chan struct{})}
+	e.elem = c.order.PushBack(e)
+	c.entries[key] = e
+}
+
+func (c *Cache[T]) remove(e *entry[T]) {
+	c.order.Remove(e.elem)
+	delete(c.entries, e.key)
+}
+
+// expire forgets completed batches whose TTL is over. The list is ordered by
+// the start of processing, so it stops at the first batch that is still
+// remembered.
+func (c *Cache[T]) expire() {
+	now := c.now()
+	for elem := c.order.Front(); elem != nil; {
+		e := elem.Value.(*entry[T])
+		next := elem.Next()
+		if e.ok && !now.Before(e.expires) {
+			c.remove(e)
+		} else if e.ok {
+			break
+		}
+		elem = next
+	}
+}
@@ -0,0 +1,126 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_BeginComplete(t *testing.T) {
+	c := New[string](time.Minute, 10)
+
+	_, done, err := c.Begin(context.TODO(), "a")
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	c.Complete("a", "result")
+
+	result, done, err := c.Begin(context.TODO(), "a")
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "result", result)
+}
+
+func TestCache_CompleteTwice(t *testing.T) {
+	c := New[string](time.Minute, 10)
+	_, done, _ := c.Begin(context.TODO(), "a")
+	require.False(t, done)
+
+	// A batch marked applied is not forgotten by Abort.
+	c.Complete("a", "")
+	c.Abort("a")
+	result, done, err := c.Begin(context.TODO(), "a")
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Empty(t, result)
+
+	c.Complete("a", "result")
+	result, done, err = c.Begin(context.TODO(), "a")
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "result", result)
+}
+
+func TestCache_Abort(t *testing.T) {
+	c := New[string](time.Minute, 10)
+
+	_, done, _ := c.Begin(context.TODO(), "a")
+	require.False(t, done)
+	c.Abort("a")
+
+	_, done, err := c.Begin(context.TODO(), "a")
+	require.NoError(t, err)
+	assert.False(t, done)
+}
+
+func TestCache_WaitForOwner(t *testing.T) {
+	c := New[int](time.Minute, 10)
+	_, done, _ := c.Begin(context.TODO(), "a")
+	require.False(t, done)
+
+	var wg sync.WaitGroup
+	results := make([]int, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, done, err := c.Begin(context.TODO(), "a")
+			assert.NoError(t, err)
+			assert.True(t, done)
+			results[i] = result
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	c.Complete("a", 42)
+	wg.Wait()
+
+	for _, r := range results {
+		assert.Equal(t, 42, r)
+	}
+}
+
+func TestCache_WaitCanceled(t *testing.T) {
+	c := New[int](time.Minute, 10)
+	_, done, _ := c.Begin(context.TODO(), "a")
+	require.False(t, done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := c.Begin(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCache_Expire(t *testing.T) {
+	now := time.Now()
+	c := New[int](time.Minute, 10)
+	c.now = func() time.Time { return now }
+
+	c.Begin(context.TODO(), "a")
+	c.Complete("a", 1)
+	assert.Equal(t, 1, c.Len())
+
+	now = now.Add(time.Minute)
+	_, done, _ := c.Begin(context.TODO(), "a")
+	assert.False(t, done)
+}
+
+func TestCache_Capacity(t *testing.T) {
+	c := New[int](time.Minute, 3)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprint(i)
+		c.Begin(context.TODO(), key)
+		c.Complete(key, i)
+	}
+	assert.Equal(t, 3, c.Len())
+
+	_, done, _ := c.Begin(context.TODO(), "0")
+	assert.False(t, done)
+	result, done, _ := c.Begin(context.TODO(), "4")
+	assert.True(t, done)
+	assert.Equal(t, 4, result)
+}
@@ -0,0 +1,289 @@
+// Package liveness tracks the agents that report metrics to the server and
+// detects the agents that went silent.
+//
+// Every agent increments the PollCount counter on each poll and sends it with
+// every report, so a request that updates PollCount is a report of an agent.
+// Agents are told apart by their client identity. An agent is down if it
+// hasn't reported for a number of report intervals. The report interval is
+// either configured or learned from the gaps between the reports of the agent.
+package liveness
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// PollCountMetric is the counter every agent sends with its reports.
+const PollCountMetric = "PollCount"
+
+// DefaultMisses is the default number of missed reports after which an agent
+// is down.
+const DefaultMisses = 3
+
+// MinInterval is the shortest learned report interval. Reports in quick
+// succession, e.g. a batch followed by a retry, don't make the agent look
+// down a moment later.
+const MinInterval = time.Second
+
+// AlertName is the name of the alerts about agents that are down.
+const AlertName = "AgentDown"
+
+// Labels of the alerts about agents that are down.
+const (
+	LabelAgent   = "agent"
+	LabelAddress = "address"
+)
+
+// Status is the liveness status of an agent.
+type Status string
+
+// Agent statuses.
+const (
+	// StatusUp means the agent reports in time.
+	StatusUp Status = "up"
+	// StatusDown means the agent missed too many reports.
+	StatusDown Status = "down"
+	// StatusUnknown means the report interval of the agent isn't known yet.
+	StatusUnknown Status = "unknown"
+)
+
+// Agent is the liveness of an agent.
+type Agent struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Interval  string    `json:"interval,omitempty"`
+	Status    Status    `json:"status"`
+	PollCount int64     `json:"pollCount"`
+	Reports   int64     `json:"reports"`
+}
+
+type agentState struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	downAt    time.Time
+	address   string
+	interval  time.Duration
+	pollCount int64
+	reports   int64
+	down      bool
+}
+
+// Tracker tracks the reports of agents. It is safe for concurrent use. The
+// methods of a nil Tracker do nothing.
+//
+// Fields:
+//   - Interval: The expected report interval of agents. If it is zero, the
+//     interval of every agent is learned from its reports.
+//   - Misses: The number of missed reports after which an agent is down.
+type Tracker struct {
+	agents   map[string]*agentState
+	Interval time.Duration
+	Misses   int
+	mu       sync.Mutex
+}
+
+// NewTracker creates a Tracker.
+//
+// Parameters:
+//   - interval: The expected report interval of agents, zero to learn it.
+//   - misses: The number of missed reports after which an agent is down.
+//
+// Returns:
+//   - A pointer to the newly created Tracker.
+func NewTracker(interval time.Duration, misses int) *Tracker {
+	if misses <= 0 {
+		misses = DefaultMisses
+	}
+	return &Tracker{
+		Interval: interval,
+		Misses:   misses,
+		agents:   make(map[string]*agentState),
+	}
+}
+
+// Observe records a report of an agent if the metrics update PollCount.
+//
+// Parameters:
+//   - id: The client identity of the agent.
+//   - address: The network address of the agent.
+//   - metrics: The metrics updated by the request.
+//   - now: The time of the request.
+func (t *Tracker) Observe(id, address string, metrics []metric.Metric, now time.Time) {
+	if t == nil || id == "" {
+		return
+	}
+	var (
+		delta int64
+		found bool
+	)
+	for _, m := range metrics {
+		if m.MType == metric.TypeCounter && m.ID == PollCountMetric && m.Delta != nil {
+			delta += *m.Delta
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.agents[id]
+	if !ok {
+		a = &agentState{firstSeen: now}
+		t.agents[id] = a
+	} else if gap := now.Sub(a.lastSeen); gap > 0 {
+		// The learned interval is a moving average, so a single late or
+		// early report doesn't change it much.
+		if a.interval == 0 {
+			a.interval = gap
+		} else {
+			a.interval = (3*a.interval + gap) / 4
+		}
+	}
+	a.lastSeen = now
+	a.address = address
+	a.pollCount += delta
+	a.reports++
+}
+
+// Forget stops tracking an agent, e.g. a decommissioned one.
+//
+// Returns:
+//   - True if the agent was tracked.
+func (t *Tracker) Forget(id string) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.agents[id]
+	delete(t.agents, id)
+	return ok
+}
+
+// Agents returns the liveness of the tracked agents sorted by ID.
+func (t *Tracker) Agents(now time.Time) []Agent {
+	agents := []Agent{}
+	if t == nil {
+		return agents
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, a := range t.agents {
+		agent := Agent{
+			ID:        id,
+			Address:   a.address,
+			FirstSeen: a.firstSeen,
+			LastSeen:  a.lastSeen,
+			PollCount: a.pollCount,
+			Reports:   a.reports,
+			Status:    t.status(a, now),
+		}
+		if interval := t.interval(a); interval > 0 {
+			agent.Interval = interval.String()
+		}
+		agents = append(agents, agent)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents
+}
+
+// Check detects the agents that went down or came back since the previous
+// check.
+//
+// Parameters:
+//   - now: The time of the check.
+//
+// Returns:
+//   - A firing alert for every agent that went down and a resolved alert for
+//     every agent that came back.
+func (t *Tracker) Check(now time.Time) []alert.Alert {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var changed []alert.Alert
+	for id, a := range t.agents {
+		switch down := t.status(a, now) == StatusDown; {
+		case down && !a.down:
+			a.down = true
+			a.downAt = a.lastSeen
+			at := now
+			al := t.alert(id, a, now)
+			al.State = alert.StateFiring
+			al.FiredAt = &at
+			changed = append(changed, al)
+		case !down && a.down:
+			a.down = false
+			at := now
+			al := t.alert(id, a, now)
+			al.State = alert.StateResolved
+			al.ResolvedAt = &at
+			changed = append(changed, al)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Labels[LabelAgent] < changed[j].Labels[LabelAgent] })
+	return changed
+}
+
+// Alerts returns the firing alerts about the agents that are down, as of the
+// last check.
+func (t *Tracker) Alerts(now time.Time) []alert.Alert {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var alerts []alert.Alert
+	for id, a := range t.agents {
+		if a.down {
+			al := t.alert(id, a, now)
+			al.State = alert.StateFiring
+			alerts = append(alerts, al)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Labels[LabelAgent] < alerts[j].Labels[LabelAgent] })
+	return alerts
+}
+
+// alert builds the alert about an agent. The alert becomes active when the
+// agent reported for the last time, so every outage has its own alert.
+func (t *Tracker) alert(id string, a *agentState, now time.Time) alert.Alert {
+	return alert.Alert{
+		Name:        AlertName,
+		Metric:      PollCountMetric,
+		Labels:      map[string]string{LabelAgent: id, LabelAddress: a.address},
+		ActiveAt:    a.downAt,
+		Description: "agent " + id + " stopped reporting",
+		Value:       now.Sub(a.lastSeen).Seconds(),
+	}
+}
+
+func (t *Tracker) interval(a *agentState) time.Duration {
+	if t.Interval > 0 {
+		return t.Interval
+	}
+	if a.interval > 0 && a.interval < MinInterval {
+		return MinInterval
+	}
+	return a.interval
+}
+
+func (t *Tracker) status(a *agentState, now time.Time) Status {
+	interval := t.interval(a)
+	if interval <= 0 {
+		return StatusUnknown
+	}
+	if now.Sub(a.lastSeen) > time.Duration(t.Misses)*interval {
+		return StatusDown
+	}
+	return StatusUp
+}
@@ -0,0 +1,99 @@
+package liveness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func pollCount(delta int64) []metric.Metric {
+	return []metric.Metric{
+		{ID: "Alloc", MType: "gauge", Value: new(float64)},
+		{ID: PollCountMetric, MType: metric.TypeCounter, Delta: &delta},
+	}
+}
+
+func TestTracker_Observe(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := NewTracker(0, 3)
+
+	tr.Observe("ip:10.0.0.1", "10.0.0.1", []metric.Metric{{ID: "Alloc", MType: "gauge", Value: new(float64)}}, start)
+	assert.Empty(t, tr.Agents(start), "report without PollCount must be ignored")
+
+	tr.Observe("ip:10.0.0.1", "10.0.0.1", pollCount(5), start)
+	agents := tr.Agents(start)
+	require.Len(t, agents, 1)
+	assert.Equal(t, StatusUnknown, agents[0].Status, "interval is not learned yet")
+
+	tr.Observe("ip:10.0.0.1", "10.0.0.1", pollCount(5), start.Add(10*time.Second))
+	agents = tr.Agents(start.Add(10 * time.Second))
+	require.Len(t, agents, 1)
+	assert.Equal(t, Agent{
+		ID:        "ip:10.0.0.1",
+		Address:   "10.0.0.1",
+		FirstSeen: start,
+		LastSeen:  start.Add(10 * time.Second),
+		Interval:  "10s",
+		Status:    StatusUp,
+		PollCount: 10,
+		Reports:   2,
+	}, agents[0])
+
+	assert.Equal(t, StatusUp, tr.Agents(start.Add(40 * time.Second))[0].Status)
+	assert.Equal(t, StatusDown, tr.Agents(start.Add(41 * time.Second))[0].Status)
+
+	assert.True(t, tr.Forget("ip:10.0.0.1"))
+	assert.False(t, tr.Forget("ip:10.0.0.1"))
+	assert.Empty(t, tr.Agents(start))
+
+	// Reports in quick succession don't shrink the interval below the minimum.
+	tr.Observe("ip:10.0.0.2", "10.0.0.2", pollCount(1), start)
+	tr.Observe("ip:10.0.0.2", "10.0.0.2", pollCount(1), start.Add(time.Millisecond))
+	agents = tr.Agents(start.Add(time.Second))
+	require.Len(t, agents, 1)
+	assert.Equal(t, "1s", agents[0].Interval)
+	assert.Equal(t, StatusUp, agents[0].Status)
+}
+
+func TestTracker_Check(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := NewTracker(10*time.Second, 2)
+	tr.Observe("key:agent-1", "10.0.0.1", pollCount(1), start)
+	tr.Observe("key:agent-2", "10.0.0.2", pollCount(1), start)
+
+	assert.Empty(t, tr.Check(start.Add(20*time.Second)))
+
+	tr.Observe("key:agent-2", "10.0.0.2", pollCount(1), start.Add(20*time.Second))
+	changed := tr.Check(start.Add(21 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, AlertName, changed[0].Name)
+	assert.Equal(t, alert.StateFiring, changed[0].State)
+	assert.Equal(t, "key:agent-1", changed[0].Labels[LabelAgent])
+	assert.Equal(t, "10.0.0.1", changed[0].Labels[LabelAddress])
+	assert.Equal(t, start, changed[0].ActiveAt)
+	assert.Equal(t, 21.0, changed[0].Value)
+
+	assert.Empty(t, tr.Check(start.Add(25*time.Second)), "firing alert must not be repeated")
+	assert.Len(t, tr.Alerts(start.Add(25*time.Second)), 1)
+
+	tr.Observe("key:agent-1", "10.0.0.1", pollCount(1), start.Add(30*time.Second))
+	changed = tr.Check(start.Add(31 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, alert.StateResolved, changed[0].State)
+	assert.Equal(t, start, changed[0].ActiveAt, "resolved alert must match the fired one")
+	assert.Empty(t, tr.Alerts(start.Add(31*time.Second)))
+}
+
+func TestTracker_Nil(t *testing.T) {
+	var tr *Tracker
+	tr.Observe("key:agent-1", "10.0.0.1", pollCount(1), time.Now())
+	assert.Empty(t, tr.Agents(time.Now()))
+	assert.Empty(t, tr.Check(time.Now()))
+	assert.Empty(t, tr.Alerts(time.Now()))
+	assert.False(t, tr.Forget("key:agent-1"))
+}
@@ -0,0 +1,225 @@
+// Package metadata provides a registry of metric metadata: the type, unit
+// and description of every metric name.
+//
+// Metadata is either registered explicitly (from a file at startup or via
+// the API) or learned from the first update of a name. In strict mode the
+// Registry rejects updates whose type conflicts with the registered type, so
+// the same name can't be used for both a gauge and a counter.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/atomicfile"
+)
+
+// ErrTypeConflict is returned by Check in strict mode if the type of a metric
+// differs from its registered type.
+var ErrTypeConflict = errors.New("metric type conflict")
+
+// Metadata describes a metric name.
+type Metadata struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Validate checks that the metadata has a name and a known type.
+func (m Metadata) Validate() error {
+	if m.Name == "" {
+		return errors.New("empty metric name")
+	}
+	if m.Type != metric.TypeGauge && m.Type != metric.TypeCounter {
+		return fmt.Errorf("bad metric type %q of %s", m.Type, m.Name)
+	}
+	return nil
+}
+
+type entry struct {
+	Metadata
+	learned bool
+}
+
+// Registry stores the metadata of metric names. It is safe for concurrent
+// use.
+type Registry struct {
+	entries map[string]entry
+	path    string
+	mu      sync.RWMutex
+	strict  bool
+}
+
+// NewRegistry creates a Registry.
+//
+// Parameters:
+//   - path: The path of a JSON file with an array of Metadata. If it is not
+//     empty, the file is loaded if it exists, and explicitly registered
+//     metadata is saved to it.
+//   - strict: Whether updates with conflicting types are rejected.
+//
+// Returns:
+//   - A pointer to the newly created Registry.
+//   - An error if the file can't be read or contains invalid metadata.
+func NewRegistry(path string, strict bool) (*Registry, error) {
+	r := &Registry{
+		entries: make(map[string]entry),
+		path:    path,
+		strict:  strict,
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Metadata
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parse metadata file: %w", err)
+	}
+	for _, m := range list {
+		if err = m.Validate(); err != nil {
+			return nil, err
+		}
+		r.entries[m.Name] = entry{Metadata: m}
+	}
+	return r, nil
+}
+
+// Strict reports whether the Registry rejects conflicting types.
+func (r *Registry) Strict() bool {
+	return r.strict
+}
+
+// Get returns the metadata of a name.
+func (r *Registry) Get(name string) (Metadata, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[name]
+	return e.Metadata, ok
+}
+
+// List returns the metadata of all names sorted by name.
+func (r *Registry) List() []Metadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Metadata, 0, len(r.entries))
+	for _, e := range r.entries {
+		list = append(list, e.Metadata)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Put registers metadata explicitly, replacing the metadata of the same
+// names, and saves the registry file.
+//
+// Parameters:
+//   - list: The metadata to register.
+//
+// Returns:
+//   - An error if some metadata is invalid, in which case nothing is
+//     registered, or if the file can't be saved.
+func (r *Registry) Put(list ...Metadata) error {
+	for _, m := range list {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range list {
+		r.entries[m.Name] = entry{Metadata: m}
+	}
+	return r.save()
+}
+
+// Check verifies the types of metrics against the registered metadata and
+// learns the types of unknown names.
+//
+// Parameters:
+//   - metrics: The metrics of an update.
+//
+// Returns:
+//   - An error wrapping ErrTypeConflict in strict mode if the type of a
+//     metric differs from the registered type, in which case nothing is
+//     learned.
+func (r *Registry) Check(metrics []metric.Metric) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	learned := make(map[string]string)
+	for _, m := range metrics {
+		registered, ok := r.entries[m.ID]
+		if !ok {
+			if t, seen := learned[m.ID]; seen && t != m.MType && r.strict {
+				return fmt.Errorf("%w: %s is both %s and %s", ErrTypeConflict, m.ID, t, m.MType)
+			}
+			learned[m.ID] = m.MType
+			continue
+		}
+		if registered.Type != m.MType && r.strict {
+			return fmt.Errorf("%w: %s is registered as %s", ErrTypeConflict, m.ID, registered.Type)
+		}
+	}
+	for name, t := range learned {
+		r.entries[name] = entry{Metadata: Metadata{Name: name, Type: t}, learned: true}
+	}
+	return nil
+}
+
+// Observe learns the types of existing metrics, e.g. the ones loaded from the
+// storage on startup. The first type seen for a name wins.
+func (r *Registry) Observe(metrics []*metric.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range metrics {
+		if _, ok := r.entries[m.ID]; !ok {
+			r.entries[m.ID] = entry{Metadata: Metadata{Name: m.ID, Type: m.MType}, learned: true}
+		}
+	}
+}
+
+// Forget removes the learned type of a deleted metric, so the name can be
+// reused with another type. Explicitly registered metadata is kept.
+func (r *Registry) Forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[name]; ok && e.learned {
+		delete(r.entries, name)
+	}
+}
+
+// save writes the explicitly registered metadata to the registry file. The
+// file is replaced atomically.
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	list := make([]Metadata, 0, len(r.entries))
+	for _, e := range r.entries {
+		if !e.learned {
+			list = append(list, e.Metadata)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(r.path, data)
+}
@@ -0,0 +1,135 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func gauge(id string) metric.Metric {
+	v := 1.0
+	return metric.Metric{ID: id, MType: metric.TypeGauge, Value: &v}
+}
+
+func counter(id string) metric.Metric {
+	d := int64(1)
+	return metric.Metric{ID: id, MType: metric.TypeCounter, Delta: &d}
+}
+
+func TestRegistry_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		strict     bool
+		registered []Metadata
+		batch      []metric.Metric
+		wantErr    bool
+	}{
+		{
+			name:  "test learn unknown names",
+			batch: []metric.Metric{gauge("g1"), counter("c1")},
+		},
+		{
+			name:       "test matching registered type",
+			strict:     true,
+			registered: []Metadata{{Name: "g1", Type: metric.TypeGauge}},
+			batch:      []metric.Metric{gauge("g1")},
+		},
+		{
+			name:       "test conflict with registered type",
+			strict:     true,
+			registered: []Metadata{{Name: "g1", Type: metric.TypeGauge}},
+			batch:      []metric.Metric{counter("g1")},
+			wantErr:    true,
+		},
+		{
+			name:    "test conflict inside batch",
+			strict:  true,
+			batch:   []metric.Metric{gauge("m1"), counter("m1")},
+			wantErr: true,
+		},
+		{
+			name:       "test conflict allowed in lax mode",
+			registered: []Metadata{{Name: "g1", Type: metric.TypeGauge}},
+			batch:      []metric.Metric{counter("g1")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := NewRegistry("", test.strict)
+			require.NoError(t, err)
+			require.NoError(t, r.Put(test.registered...))
+
+			err = r.Check(test.batch)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrTypeConflict)
+				for _, m := range test.batch {
+					if _, ok := r.Get(m.ID); ok {
+						assert.Contains(t, test.registered, Metadata{Name: m.ID, Type: metric.TypeGauge})
+					}
+				}
+				return
+			}
+			require.NoError(t, err)
+			for _, m := range test.batch {
+				_, ok := r.Get(m.ID)
+				assert.True(t, ok)
+			}
+		})
+	}
+}
+
+func TestRegistry_Forget(t *testing.T) {
+	r, err := NewRegistry("", true)
+	require.NoError(t, err)
+	require.NoError(t, r.Put(Metadata{Name: "registered", Type: metric.TypeGauge, Unit: "bytes"}))
+	require.NoError(t, r.Check([]metric.Metric{counter("learned")}))
+
+	r.Forget("learned")
+	r.Forget("registered")
+
+	_, ok := r.Get("learned")
+	assert.False(t, ok)
+	md, ok := r.Get("registered")
+	assert.True(t, ok)
+	assert.Equal(t, "bytes", md.Unit)
+
+	require.NoError(t, r.Check([]metric.Metric{gauge("learned")}))
+}
+
+func TestRegistry_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+
+	r, err := NewRegistry(path, false)
+	require.NoError(t, err)
+	require.NoError(t, r.Check([]metric.Metric{gauge("learned")}))
+	require.NoError(t, r.Put(
+		Metadata{Name: "b", Type: metric.TypeCounter, Description: "Requests"},
+		Metadata{Name: "a", Type: metric.TypeGauge, Unit: "seconds"},
+	))
+
+	reloaded, err := NewRegistry(path, false)
+	require.NoError(t, err)
+	assert.Equal(t, []Metadata{
+		{Name: "a", Type: metric.TypeGauge, Unit: "seconds"},
+		{Name: "b", Type: metric.TypeCounter, Description: "Requests"},
+	}, reloaded.List())
+
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name": "x", "type": "histogram"}]`), 0644))
+	_, err = NewRegistry(path, false)
+	assert.Error(t, err)
+}
+
+func TestRegistry_PutInvalid(t *testing.T) {
+	r, err := NewRegistry("", false)
+	require.NoError(t, err)
+
+	err = r.Put(Metadata{Name: "ok", Type: metric.TypeGauge}, Metadata{Name: "bad", Type: "summary"})
+	assert.Error(t, err)
+	assert.Empty(t, r.List())
+}
@@ -0,0 +1,92 @@
+package metric
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sort orders of a Filter.
+const (
+	SortName = "name" // by name and then type
+	SortType = "type" // by type and then name
+)
+
+// Filter selects a page of metrics for a listing. Pages are keyset based:
+// a page starts right after the last metric of the previous one, so pages
+// don't shift when metrics are added or deleted in between.
+type Filter struct {
+	Type      string // Type of the metrics, empty means all types
+	Prefix    string // Prefix of the metric names
+	Sort      string // SortName or SortType, empty means SortName
+	AfterID   string // Name of the last metric of the previous page, empty means the first page
+	AfterType string // Type of the last metric of the previous page
+	Limit     int    // Max number of metrics, zero means no limit
+	Desc      bool   // Descending order
+}
+
+// Match reports whether a metric matches the type and prefix of the filter.
+//
+// Parameters:
+//   - m: The metric.
+//
+// Returns:
+//   - true if the metric matches.
+func (f Filter) Match(m *Metric) bool {
+	return (f.Type == "" || m.MType == f.Type) && strings.HasPrefix(m.ID, f.Prefix)
+}
+
+// Less reports whether metric a goes before metric b in the order of the
+// filter.
+//
+// Parameters:
+//   - a: The first metric.
+//   - b: The second metric.
+//
+// Returns:
+//   - true if a goes before b.
+func (f Filter) Less(a, b *Metric) bool {
+	return f.less(a.ID, a.MType, b.ID, b.MType)
+}
+
+func (f Filter) less(aID, aType, bID, bType string) bool {
+	first, second := strings.Compare(aID, bID), strings.Compare(aType, bType)
+	if f.Sort == SortType {
+		first, second = second, first
+	}
+	c := first
+	if c == 0 {
+		c = second
+	}
+	if f.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// Apply selects a page from metrics that are not filtered yet. The storages
+// that keep metrics in memory use it to implement ListMetrics.
+//
+// Parameters:
+//   - metrics: All metrics.
+//
+// Returns:
+//   - The metrics of the page in the order of the filter.
+func (f Filter) Apply(metrics []*Metric) []*Metric {
+	page := make([]*Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if !f.Match(m) {
+			continue
+		}
+		if f.AfterID != "" && !f.less(f.AfterID, f.AfterType, m.ID, m.MType) {
+			continue
+		}
+		page = append(page, m)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		return f.Less(page[i], page[j])
+	})
+	if f.Limit > 0 && len(page) > f.Limit {
+		page = page[:f.Limit]
+	}
+	return page
+}
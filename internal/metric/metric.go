@@ -3,6 +3,13 @@ package metric
 
 import (
 	"strconv"
+	"time"
+)
+
+// Metric types.
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
 )
 
 // Metric represents a single metric used in monitoring systems.
@@ -10,10 +17,11 @@ import (
 // This struct encapsulates the properties of a metric, including its unique identifier (name),
 // type, and value.
 type Metric struct {
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	Delta   *int64     `json:"delta,omitempty"`   // значение метрики в случае передачи counter
+	Value   *float64   `json:"value,omitempty"`   // значение метрики в случае передачи gauge
+	Updated *time.Time `json:"updated,omitempty"` // время последнего обновления серии: записи файла хранилища и SnapshotMetrics всех хранилищ, включая Postgres
+	ID      string     `json:"id"`                // имя метрики
+	MType   string     `json:"type"`              // параметр, принимающий значение gauge или counter
 }
 
 // ValueAsString returns the string representation of the metric's value based on its type.
@@ -41,3 +41,24 @@ func TestMetric_ValueAsString(t *testing.T) {
 		})
 	}
 }
+
+func TestFilter_Apply(t *testing.T) {
+	metrics := []*Metric{
+		{ID: "b", MType: "gauge"},
+		{ID: "a", MType: "gauge"},
+		{ID: "a", MType: "counter"},
+		{ID: "c", MType: "counter"},
+	}
+	ids := func(metrics []*Metric) []string {
+		var res []string
+		for _, m := range metrics {
+			res = append(res, m.MType+"/"+m.ID)
+		}
+		return res
+	}
+
+	assert.Equal(t, []string{"counter/a", "gauge/a", "gauge/b", "counter/c"}, ids(Filter{}.Apply(metrics)))
+	assert.Equal(t, []string{"counter/c", "gauge/b"}, ids(Filter{Desc: true, Limit: 2}.Apply(metrics)))
+	assert.Equal(t, []string{"gauge/a", "gauge/b"}, ids(Filter{Sort: SortType, AfterID: "c", AfterType: "counter"}.Apply(metrics)))
+	assert.Equal(t, []string{"gauge/a"}, ids(Filter{Type: "gauge", Prefix: "a"}.Apply(metrics)))
+}
@@ -0,0 +1,292 @@
+// Package notify delivers alert notifications to webhooks.
+//
+// Alerts that change state in the same evaluation are grouped by the values
+// of configured labels, and every group is POSTed as one JSON payload to each
+// webhook. Notifications already sent are not sent again. Failed deliveries
+// are retried with exponential backoff. If a key is configured, the payload
+// is signed with HMAC-SHA256 and the base64 encoded signature is sent in the
+// HeaderSignature header.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/pkg/hash"
+)
+
+// Defaults of the Notifier settings.
+const (
+	DefaultRetries      = 3
+	DefaultBackoff      = time.Second
+	DefaultMaxBackoff   = time.Minute
+	DefaultTimeout      = 10 * time.Second
+	DefaultDedupWindow  = time.Hour
+	DefaultGroupByLabel = alert.LabelAlertName
+)
+
+// HeaderSignature is the header with the base64 encoded HMAC-SHA256 of the
+// payload.
+const HeaderSignature = "X-Signature-SHA256"
+
+// PayloadVersion is the version of the payload format.
+const PayloadVersion = "1"
+
+// Payload is the JSON body POSTed to webhooks.
+type Payload struct {
+	SentAt      time.Time         `json:"sentAt"`
+	GroupLabels map[string]string `json:"groupLabels"`
+	Version     string            `json:"version"`
+	GroupKey    string            `json:"groupKey"`
+	Status      alert.State       `json:"status"`
+	Alerts      []alert.Alert     `json:"alerts"`
+}
+
+// Silencer defines the method for checking whether notifications of an alert
+// are suppressed.
+type Silencer interface {
+	Silenced(a alert.Alert) bool
+}
+
+// Notifier delivers alert notifications to webhooks. It is safe for
+// concurrent use.
+//
+// Fields:
+//   - URLs: The webhook URLs.
+//   - Key: The HMAC key of payload signatures. If it is empty, payloads are
+//     not signed.
+//   - GroupBy: The labels whose values group alerts into one payload.
+//   - Retries: The number of retries of a failed delivery.
+//   - Backoff: The delay before the first retry. It doubles with every
+//     retry up to MaxBackoff.
+//   - DedupWindow: How long sent notifications are remembered.
+//   - Client: The HTTP client used for deliveries.
+//   - Silencer: The silences of alerts. Notifications of silenced alerts are
+//     dropped. It may be nil.
+type Notifier struct {
+	Client      *http.Client
+	Silencer    Silencer
+	sent        map[string]time.Time
+	Key         string
+	URLs        []string
+	GroupBy     []string
+	wg          sync.WaitGroup
+	Retries     int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	DedupWindow time.Duration
+	mu          sync.Mutex
+}
+
+// New creates a Notifier with the default settings.
+//
+// Parameters:
+//   - urls: The webhook URLs.
+//   - key: The HMAC key of payload signatures, may be empty.
+//
+// Returns:
+//   - A pointer to the newly created Notifier.
+func New(urls []string, key string) *Notifier {
+	return &Notifier{
+		URLs:        urls,
+		Key:         key,
+		GroupBy:     []string{DefaultGroupByLabel},
+		Retries:     DefaultRetries,
+		Backoff:     DefaultBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		DedupWindow: DefaultDedupWindow,
+		Client:      &http.Client{Timeout: DefaultTimeout},
+		sent:        make(map[string]time.Time),
+	}
+}
+
+// Notify delivers the alerts asynchronously. Alerts already notified in the
+// same state and silenced alerts are skipped. Use Wait to wait for the
+// deliveries.
+//
+// Parameters:
+//   - ctx: A context.Context that cancels the deliveries and their retries.
+//   - alerts: The alerts that changed state.
+func (n *Notifier) Notify(ctx context.Context, alerts []alert.Alert) {
+	for _, p := range n.payloads(alerts) {
+		data, err := json.Marshal(p)
+		if err != nil {
+			logger.Log.Error("error marshal alert payload", zap.Error(err))
+			continue
+		}
+		for _, url := range n.URLs {
+			n.wg.Add(1)
+			go func(url string) {
+				defer n.wg.Done()
+				if err := n.Send(ctx, url, data); err != nil {
+					logger.Log.Error("error deliver alert notification",
+						zap.String("url", url), zap.String("group", p.GroupKey), zap.Error(err))
+				}
+			}(url)
+		}
+	}
+}
+
+// Wait waits for the deliveries started by Notify.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// payloads deduplicates and groups the alerts.
+func (n *Notifier) payloads(alerts []alert.Alert) []Payload {
+	now := time.Now()
+
+	n.mu.Lock()
+	for fp, at := range n.sent {
+		if now.Sub(at) >= n.DedupWindow {
+			delete(n.sent, fp)
+		}
+	}
+	groups := make(map[string]*Payload)
+	var keys []string
+	for _, a := range alerts {
+		fp := fingerprint(a)
+		if _, ok := n.sent[fp]; ok {
+			continue
+		}
+		if n.Silencer != nil && n.Silencer.Silenced(a) {
+			logger.Log.Info("alert notification silenced", zap.String("alert", a.Name), zap.String("state", string(a.State)))
+			continue
+		}
+		n.sent[fp] = now
+
+		labels := n.groupLabels(a)
+		key := groupKey(labels)
+		p, ok := groups[key]
+		if !ok {
+			p = &Payload{
+				Version:     PayloadVersion,
+				GroupKey:    key,
+				GroupLabels: labels,
+				Status:      alert.StateResolved,
+				SentAt:      now,
+			}
+			groups[key] = p
+			keys = append(keys, key)
+		}
+		if a.State == alert.StateFiring {
+			p.Status = alert.StateFiring
+		}
+		p.Alerts = append(p.Alerts, a)
+	}
+	n.mu.Unlock()
+
+	sort.Strings(keys)
+	payloads := make([]Payload, 0, len(keys))
+	for _, key := range keys {
+		payloads = append(payloads, *groups[key])
+	}
+	return payloads
+}
+
+func (n *Notifier) groupLabels(a alert.Alert) map[string]string {
+	labels := make(map[string]string, len(n.GroupBy))
+	for _, name := range n.GroupBy {
+		if name == alert.LabelAlertName {
+			labels[name] = a.Name
+			continue
+		}
+		labels[name] = a.Labels[name]
+	}
+	return labels
+}
+
+func groupKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// fingerprint identifies a notification of an alert of a series in a state.
+func fingerprint(a alert.Alert) string {
+	return fmt.Sprintf("%s|%s|%s|%d", a.Name, a.Metric, a.State, a.ActiveAt.UnixNano())
+}
+
+// errRetryable marks delivery errors worth retrying.
+var errRetryable = errors.New("retryable delivery error")
+
+// Send POSTs a payload to a webhook, retrying failed deliveries with
+// exponential backoff. Network errors and 5xx and 429 responses are retried.
+//
+// Parameters:
+//   - ctx: A context.Context that cancels the delivery and its retries.
+//   - url: The webhook URL.
+//   - data: The JSON payload.
+//
+// Returns:
+//   - An error if the payload wasn't delivered.
+func (n *Notifier) Send(ctx context.Context, url string, data []byte) error {
+	backoff := n.Backoff
+	var err error
+	for i := 0; i <= n.Retries; i++ {
+		if i > 0 {
+			logger.Log.Info("retry alert notification", zap.String("url", url), zap.Int("attempt", i), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if n.MaxBackoff > 0 && backoff > n.MaxBackoff {
+				backoff = n.MaxBackoff
+			}
+		}
+		err = n.post(ctx, url, data)
+		if err == nil || !errors.Is(err, errRetryable) {
+			return err
+		}
+	}
+	return err
+}
+
+func (n *Notifier) post(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Key != "" {
+		req.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(hash.GetHMACSHA256(n.Key, data)))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errRetryable, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", errRetryable, resp.StatusCode)
+	default:
+		return fmt.Errorf("webhook rejected notification: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/alert"
+)
+
+type receiver struct {
+	payloads   []Payload
+	signatures []string
+	mu         sync.Mutex
+}
+
+func (r *receiver) handler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		var p Payload
+		require.NoError(t, json.Unmarshal(body, &p))
+
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		r.mu.Lock()
+		r.payloads = append(r.payloads, p)
+		if req.Header.Get(HeaderSignature) == base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+			r.signatures = append(r.signatures, "ok")
+		}
+		r.mu.Unlock()
+	}
+}
+
+func testAlert(name string, state alert.State, labels map[string]string) alert.Alert {
+	return alert.Alert{
+		Name:     name,
+		State:    state,
+		Labels:   labels,
+		ActiveAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	var r receiver
+	ts := httptest.NewServer(r.handler(t))
+	defer ts.Close()
+
+	n := New([]string{ts.URL}, "secret")
+	n.GroupBy = []string{"severity"}
+	alerts := []alert.Alert{
+		testAlert("HighCPU", alert.StateFiring, map[string]string{"severity": "page"}),
+		testAlert("LowMemory", alert.StateResolved, map[string]string{"severity": "page"}),
+		testAlert("DiskFull", alert.StateResolved, map[string]string{"severity": "ticket"}),
+	}
+
+	n.Notify(context.Background(), alerts)
+	n.Wait()
+	n.Notify(context.Background(), alerts[:1])
+	n.Wait()
+
+	require.Len(t, r.payloads, 2, "duplicate notification must be skipped")
+	assert.Equal(t, []string{"ok", "ok"}, r.signatures)
+	byKey := make(map[string]Payload)
+	for _, p := range r.payloads {
+		byKey[p.GroupKey] = p
+	}
+	page := byKey["{severity=page}"]
+	assert.Equal(t, alert.StateFiring, page.Status)
+	assert.Len(t, page.Alerts, 2)
+	ticket := byKey["{severity=ticket}"]
+	assert.Equal(t, alert.StateResolved, ticket.Status)
+	assert.Equal(t, "DiskFull", ticket.Alerts[0].Name)
+}
+
+func TestNotifier_SendRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retries      int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{
+			name:         "test retry until success",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			retries:      3,
+			wantAttempts: 3,
+		},
+		{
+			name:         "test give up after retries",
+			statuses:     []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			retries:      2,
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "test no retry of client error",
+			statuses:     []int{http.StatusBadRequest, http.StatusOK},
+			retries:      3,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				i := attempts.Add(1) - 1
+				w.WriteHeader(test.statuses[i])
+			}))
+			defer ts.Close()
+
+			n := New([]string{ts.URL}, "")
+			n.Retries = test.retries
+			n.Backoff = time.Millisecond
+			err := n.Send(context.Background(), ts.URL, []byte(`{}`))
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.wantAttempts, attempts.Load())
+		})
+	}
+}
+
+func TestNotifier_SendCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	n := New([]string{ts.URL}, "")
+	n.Backoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := n.Send(ctx, ts.URL, []byte(`{}`))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+type silencerFunc func(a alert.Alert) bool
+
+func (f silencerFunc) Silenced(a alert.Alert) bool {
+	return f(a)
+}
+
+func TestNotifier_NotifySilenced(t *testing.T) {
+	var r receiver
+	ts := httptest.NewServer(r.handler(t))
+	defer ts.Close()
+
+	silenced := true
+	n := New([]string{ts.URL}, "")
+	n.Silencer = silencerFunc(func(a alert.Alert) bool { return silenced && a.Name == "HighCPU" })
+	alerts := []alert.Alert{
+		testAlert("HighCPU", alert.StateFiring, nil),
+		testAlert("LowMemory", alert.StateFiring, nil),
+	}
+
+	n.Notify(context.Background(), alerts)
+	n.Wait()
+	require.Len(t, r.payloads, 1)
+	assert.Equal(t, "LowMemory", r.payloads[0].Alerts[0].Name)
+
+	silenced = false
+	n.Notify(context.Background(), alerts)
+	n.Wait()
+	require.Len(t, r.payloads, 2, "silenced alert must not be marked as sent")
+	assert.Equal(t, "HighCPU", r.payloads[1].Alerts[0].Name)
+}
@@ -5,18 +5,23 @@ package proto
 import (
 	"context"
 	"errors"
-	"os"
 	"time"
 
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/idempotency"
+	"github.com/Vidkin/metrics/internal/liveness"
 	"github.com/Vidkin/metrics/internal/logger"
+	metricmeta "github.com/Vidkin/metrics/internal/metadata"
 	"github.com/Vidkin/metrics/internal/metric"
 	"github.com/Vidkin/metrics/internal/router"
+	"github.com/Vidkin/metrics/internal/stream"
+	"github.com/Vidkin/metrics/pkg/clientid"
 	"github.com/Vidkin/metrics/proto"
 )
 
@@ -25,10 +30,19 @@ import (
 // updating and dumping metrics to a specified repository.
 type MetricsServer struct {
 	proto.UnimplementedMetricsServer
-	Repository    router.Repository // Repository for storing metrics
-	LastStoreTime time.Time         // Last time metrics were successfully stored
-	RetryCount    int               // Number of retry attempts for database operations
-	StoreInterval int               // Interval for storing metrics
+	Repository      router.Repository    // Repository for storing metrics
+	LastStoreTime   time.Time            // Last time metrics were successfully stored
+	RetryCount      int                  // Number of retry attempts for database operations
+	StoreInterval   int                  // Interval for storing metrics
+	MaxBatchMetrics int                  // Max number of metrics in a single request, zero means no limit
+	Cardinality     *cardinality.Limiter // Limiter of the number of distinct series, nil means no limit
+	Audit           *audit.Auditor       // Audit log of accepted changes, nil means no auditing
+	// Responses of recently applied batches by batch ID, nil means retried batches are not deduplicated
+	Batches  *idempotency.Cache[*proto.UpdateMetricsResponse]
+	Expiry   *router.Sweeper      // Sweeper of expired series, nil means series never expire
+	Metadata *metricmeta.Registry // Registry of metric types, units and descriptions, nil means types are not enforced
+	Agents   *liveness.Tracker    // Liveness tracker of the agents, nil means agents are not tracked
+	Stream   *stream.Hub          // Hub the accepted updates are published to, nil means updates are not streamed
 }
 
 // Dumper defines the methods required for dumping metrics to a storage system.
@@ -65,25 +79,25 @@ func DumpMetric(r router.Repository, m *metric.Metric) error {
 // connection errors by waiting and retrying.
 //
 // Parameters:
-//   - metric: A pointer to the metric.Metric that needs to be dumped.
+//   - met: A pointer to the metric.Metric that needs to be dumped.
 //
 // Returns:
 //   - An error if the dumping operation fails; otherwise, it returns nil.
-func (m *MetricsServer) DumpMetric(metric *metric.Metric) error {
+func (m *MetricsServer) DumpMetric(met *metric.Metric) error {
+	return m.DumpMetrics([]metric.Metric{*met})
+}
+
+// DumpMetrics dumps metrics to the MetricsServer's Repository like DumpMetric,
+// but at once when the Repository supports it, so a batch update is written
+// and synced once.
+func (m *MetricsServer) DumpMetrics(metrics []metric.Metric) error {
 	if m.StoreInterval == 0 {
-		for i := 0; i <= m.RetryCount; i++ {
-			err := DumpMetric(m.Repository, metric)
-			if err != nil {
-				var pathErr *os.PathError
-				if errors.As(err, &pathErr) && i != m.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-				logger.Log.Info("error saving metric", zap.Error(err))
-				return errors.New("error saving metric")
-			}
-			break
+		err := router.RetryFile(m.RetryCount, func() error {
+			return router.DumpMetrics(m.Repository, metrics)
+		})
+		if err != nil {
+			logger.Log.Info("error saving metric", zap.Error(err))
+			return errors.New("error saving metric")
 		}
 	}
 	return nil
@@ -104,6 +118,11 @@ func (m *MetricsServer) UpdateMetrics(ctx context.Context, in *proto.UpdateMetri
 	var response proto.UpdateMetricsResponse
 	var metrics []metric.Metric
 
+	if m.MaxBatchMetrics > 0 && len(in.Metrics) > m.MaxBatchMetrics {
+		logger.Log.Info(`too many metrics in batch`, zap.Int("count", len(in.Metrics)))
+		return nil, status.Errorf(codes.InvalidArgument, `too many metrics in batch`)
+	}
+
 	for _, protoMetric := range in.Metrics {
 		var me metric.Metric
 		if protoMetric.Type == proto.Metric_GAUGE {
@@ -125,28 +144,86 @@ func (m *MetricsServer) UpdateMetrics(ctx context.Context, in *proto.UpdateMetri
 		metrics = append(metrics, me)
 	}
 
-	for i := 0; i <= m.RetryCount; i++ {
-		err := m.Repository.UpdateMetrics(ctx, &metrics)
+	// A batch that has been applied but whose response is not known, e.g.
+	// because reading back the metrics failed, is replayed: it isn't applied
+	// again and is answered with the current values.
+	batchID := batchIDFromContext(ctx)
+	var batchKey string
+	replay := false
+	if batchID != "" {
+		batchKey = idempotency.Key(clientid.FromContext(ctx), batchID)
+	}
+	if m.Batches != nil && batchKey != "" {
+		cached, done, err := m.Batches.Begin(ctx, batchKey)
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != m.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
+			logger.Log.Info(`error wait for duplicate batch`, zap.Error(err))
+			return nil, status.Errorf(codes.Unavailable, `error wait for duplicate batch`)
+		}
+		if done {
+			logger.Log.Info(`duplicate batch`, zap.String("batchID", batchID))
+			if cached != nil {
+				return cached, nil
 			}
+			replay = true
+		}
+		defer m.Batches.Abort(batchKey)
+	}
+
+	if m.Metadata != nil {
+		if err := m.Metadata.Check(metrics); err != nil {
+			logger.Log.Info(`metric type conflict`, zap.Error(err))
+			return nil, status.Errorf(codes.InvalidArgument, `metric type conflict`)
+		}
+	}
+
+	var admission cardinality.Admission
+	if m.Cardinality != nil {
+		var err error
+		admission, err = m.Cardinality.Admit(clientid.FromContext(ctx), metrics)
+		if err != nil {
+			logger.Log.Info(`series limit exceeded`, zap.Error(err))
+			return nil, status.Errorf(codes.ResourceExhausted, `series limit exceeded`)
+		}
+		metrics = admission.Metrics
+	}
+
+	var before map[string]*metric.Metric
+	if m.Audit != nil {
+		before = audit.Snapshot(ctx, m.Repository, metrics)
+	}
+	applied := !replay
+	if !replay {
+		err := router.Retry(m.RetryCount, func() (err error) {
+			applied, err = router.UpdateMetricsOnce(m.Repository, ctx, batchKey, &metrics)
+			return err
+		})
+		if err != nil {
+			m.Cardinality.Rollback(admission)
 			logger.Log.Info(`can't update metrics in database`, zap.Error(err))
 			return nil, status.Errorf(codes.Internal, `can't update metrics in database`)
 		}
-		break
+		if m.Batches != nil && batchKey != "" {
+			m.Batches.Complete(batchKey, nil)
+		}
 	}
 
-	for _, me := range metrics {
-		if err := m.DumpMetric(&me); err != nil {
+	if applied {
+		m.Expiry.Refresh(metrics)
+		m.Agents.Observe(clientid.FromContext(ctx), clientid.RemoteAddrFromContext(ctx), metrics, time.Now())
+		m.Stream.Publish(clientid.FromContext(ctx), metrics, time.Now())
+		if m.Audit != nil {
+			entries := audit.UpdateEntries(time.Now(), clientid.FromContext(ctx), clientid.RemoteAddrFromContext(ctx), before, metrics)
+			if err := m.Audit.Log(entries...); err != nil {
+				logger.Log.Error("error log audit entries", zap.Error(err))
+			}
+		}
+
+		if err := m.DumpMetrics(metrics); err != nil {
 			logger.Log.Info(`error saving metrics`, zap.Error(err))
 			return nil, status.Errorf(codes.Internal, `error saving metrics`)
 		}
+	} else {
+		logger.Log.Info(`batch has already been applied`, zap.String("batchID", batchID))
 	}
 
 	for _, met := range metrics {
@@ -154,34 +231,129 @@ func (m *MetricsServer) UpdateMetrics(ctx context.Context, in *proto.UpdateMetri
 			updated *metric.Metric
 			err     error
 		)
-		for r := 0; r <= m.RetryCount; r++ {
+		err = router.Retry(m.RetryCount, func() (err error) {
 			updated, err = m.Repository.GetMetric(ctx, met.MType, met.ID)
-			if err != nil {
-				var pgErr *pgconn.PgError
-				if errors.As(err, &pgErr) {
-					if pgerrcode.IsConnectionException(pgErr.Code) && r != m.RetryCount {
-						logger.Log.Info("repository connection error", zap.Error(err))
-						time.Sleep(time.Duration(1+r*2) * time.Second)
-						continue
-					}
-				}
-				logger.Log.Info("error get updated metric", zap.Error(err))
-				return nil, status.Errorf(codes.Internal, `error get updated metric`)
-			}
-			prMetric := &proto.Metric{
-				Id: updated.ID,
-			}
-			if updated.MType == router.MetricTypeGauge {
-				prMetric.Type = proto.Metric_GAUGE
-				prMetric.Value = *updated.Value
-			} else {
-				prMetric.Type = proto.Metric_COUNTER
-				prMetric.Delta = *updated.Delta
-			}
-			response.Metrics = append(response.Metrics, prMetric)
-			break
+			return err
+		})
+		if err != nil {
+			logger.Log.Info("error get updated metric", zap.Error(err))
+			return nil, status.Errorf(codes.Internal, `error get updated metric`)
+		}
+		prMetric := &proto.Metric{
+			Id: updated.ID,
+		}
+		if updated.MType == router.MetricTypeGauge {
+			prMetric.Type = proto.Metric_GAUGE
+			prMetric.Value = *updated.Value
+		} else {
+			prMetric.Type = proto.Metric_COUNTER
+			prMetric.Delta = *updated.Delta
 		}
+		response.Metrics = append(response.Metrics, prMetric)
+	}
+
+	if m.Batches != nil && batchKey != "" {
+		m.Batches.Complete(batchKey, &response)
+	}
+	return &response, nil
+}
+
+// batchIDFromContext returns the batch ID sent by the agent in the incoming
+// metadata, or an empty string if there is none.
+func batchIDFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
 	}
+	values := md.Get(idempotency.MetadataBatchID)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
 
+// GetMetadata handles the gRPC request to read metric metadata.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the operation.
+//   - in: A pointer to the proto.GetMetadataRequest with the names to read.
+//     If no names are set, the metadata of all names is returned.
+//
+// Returns:
+//   - A pointer to the proto.GetMetadataResponse with the metadata of the
+//     known names, or an error if the registry is disabled.
+func (m *MetricsServer) GetMetadata(_ context.Context, in *proto.GetMetadataRequest) (*proto.GetMetadataResponse, error) {
+	if m.Metadata == nil {
+		return nil, status.Errorf(codes.Unimplemented, `metadata registry is disabled`)
+	}
+
+	var response proto.GetMetadataResponse
+	if len(in.Names) == 0 {
+		for _, md := range m.Metadata.List() {
+			response.Metadata = append(response.Metadata, metadataToProto(md))
+		}
+		return &response, nil
+	}
+	for _, name := range in.Names {
+		if md, ok := m.Metadata.Get(name); ok {
+			response.Metadata = append(response.Metadata, metadataToProto(md))
+		}
+	}
 	return &response, nil
 }
+
+// PutMetadata handles the gRPC request to register metric metadata.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the operation.
+//   - in: A pointer to the proto.PutMetadataRequest with the metadata to
+//     register.
+//
+// Returns:
+//   - A pointer to the proto.PutMetadataResponse with the registered
+//     metadata, or an error if the metadata is invalid or can't be saved.
+func (m *MetricsServer) PutMetadata(_ context.Context, in *proto.PutMetadataRequest) (*proto.PutMetadataResponse, error) {
+	if m.Metadata == nil {
+		return nil, status.Errorf(codes.Unimplemented, `metadata registry is disabled`)
+	}
+
+	list := make([]metricmeta.Metadata, 0, len(in.Metadata))
+	for _, pm := range in.Metadata {
+		md := metricmeta.Metadata{
+			Name:        pm.Name,
+			Unit:        pm.Unit,
+			Description: pm.Description,
+		}
+		switch pm.Type {
+		case proto.Metric_GAUGE:
+			md.Type = router.MetricTypeGauge
+		case proto.Metric_COUNTER:
+			md.Type = router.MetricTypeCounter
+		}
+		if err := md.Validate(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		list = append(list, md)
+	}
+	if err := m.Metadata.Put(list...); err != nil {
+		logger.Log.Info(`error save metadata`, zap.Error(err))
+		return nil, status.Errorf(codes.Internal, `error save metadata`)
+	}
+	return &proto.PutMetadataResponse{Metadata: in.Metadata}, nil
+}
+
+// metadataToProto converts metric metadata to its protobuf representation.
+func metadataToProto(md metricmeta.Metadata) *proto.MetricMetadata {
+	pm := &proto.MetricMetadata{
+		Name:        md.Name,
+		Unit:        md.Unit,
+		Description: md.Description,
+	}
+	switch md.Type {
+	case router.MetricTypeGauge:
+		pm.Type = proto.Metric_GAUGE
+	case router.MetricTypeCounter:
+		pm.Type = proto.Metric_COUNTER
+	}
+	return pm
+}
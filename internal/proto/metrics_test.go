@@ -15,10 +15,14 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	pb "google.golang.org/protobuf/proto"
 
+	"github.com/Vidkin/metrics/internal/idempotency"
+	metricmeta "github.com/Vidkin/metrics/internal/metadata"
 	"github.com/Vidkin/metrics/internal/repository/mock"
 	"github.com/Vidkin/metrics/internal/repository/storage"
 	"github.com/Vidkin/metrics/internal/router"
@@ -29,15 +33,16 @@ import (
 
 func TestMetricsServer_UpdateMetrics(t *testing.T) {
 	type params struct {
-		MockRepository *mock.MockRepository
-		Repository     router.Repository
-		LastStoreTime  time.Time
-		TrustedSubnet  string
-		Key            string
-		ClientKey      string
-		ServerAddress  string
-		RetryCount     int
-		StoreInterval  int
+		MockRepository  *mock.MockRepository
+		Repository      router.Repository
+		LastStoreTime   time.Time
+		TrustedSubnet   string
+		Key             string
+		ClientKey       string
+		ServerAddress   string
+		RetryCount      int
+		StoreInterval   int
+		MaxBatchMetrics int
 	}
 	tests := []struct {
 		params    *params
@@ -46,6 +51,7 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 		updateErr error
 		getErr    error
 		name      string
+		code      codes.Code
 		wantErr   bool
 	}{
 		{
@@ -93,6 +99,37 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "update metrics too many metrics",
+			params: &params{
+				Repository: &storage.FileStorage{
+					FileStoragePath: filepath.Join(os.TempDir(), "metricsTestFile.test"),
+					Gauge:           make(map[string]float64),
+					Counter:         make(map[string]int64),
+				},
+				LastStoreTime:   time.Now(),
+				RetryCount:      2,
+				StoreInterval:   10,
+				MaxBatchMetrics: 1,
+				ServerAddress:   "127.0.0.1:8080",
+			},
+			in: &proto.UpdateMetricsRequest{
+				Metrics: []*proto.Metric{
+					{
+						Delta: 12,
+						Id:    "c1",
+						Type:  proto.Metric_COUNTER,
+					},
+					{
+						Value: 12.2,
+						Id:    "g1",
+						Type:  proto.Metric_GAUGE,
+					},
+				},
+			},
+			code:    codes.InvalidArgument,
+			wantErr: true,
+		},
 		{
 			name: "update metrics ok with hash",
 			params: &params{
@@ -493,10 +530,11 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ms := &MetricsServer{
-				Repository:    tt.params.Repository,
-				LastStoreTime: tt.params.LastStoreTime,
-				RetryCount:    tt.params.RetryCount,
-				StoreInterval: tt.params.StoreInterval,
+				Repository:      tt.params.Repository,
+				LastStoreTime:   tt.params.LastStoreTime,
+				RetryCount:      tt.params.RetryCount,
+				StoreInterval:   tt.params.StoreInterval,
+				MaxBatchMetrics: tt.params.MaxBatchMetrics,
 			}
 			s := grpc.NewServer(
 				grpc.ChainUnaryInterceptor(
@@ -561,9 +599,96 @@ func TestMetricsServer_UpdateMetrics(t *testing.T) {
 				}
 				_, err = clientGRPC.UpdateMetrics(ctx, tt.in)
 				require.Error(t, err)
+				if tt.code != codes.OK {
+					assert.Equal(t, tt.code, status.Code(err))
+				}
 			}
 
 			s.Stop()
 		})
 	}
 }
+
+func TestMetricsServer_UpdateMetricsDuplicateBatch(t *testing.T) {
+	repository := router.NewMemoryStorage()
+	server := &MetricsServer{
+		Repository:    repository,
+		LastStoreTime: time.Now(),
+		StoreInterval: 300,
+		Batches:       idempotency.New[*proto.UpdateMetricsResponse](time.Minute, 10),
+	}
+	in := &proto.UpdateMetricsRequest{
+		Metrics: []*proto.Metric{{Id: "c1", Delta: 5, Type: proto.Metric_COUNTER}},
+	}
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs(idempotency.MetadataBatchID, "batch1"))
+	first, err := server.UpdateMetrics(ctx, in)
+	require.NoError(t, err)
+	retry, err := server.UpdateMetrics(ctx, in)
+	require.NoError(t, err)
+	assert.True(t, pb.Equal(first, retry))
+	assert.Equal(t, int64(5), repository.Counter["c1"])
+
+	_, err = server.UpdateMetrics(context.TODO(), in)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), repository.Counter["c1"])
+}
+
+func TestMetricsServer_UpdateMetricsAppliedBatchFails(t *testing.T) {
+	// The memory storage can't dump metrics, so with StoreInterval 0 every
+	// batch fails after it has been applied.
+	repository := router.NewMemoryStorage()
+	server := &MetricsServer{
+		Repository:    repository,
+		LastStoreTime: time.Now(),
+		Batches:       idempotency.New[*proto.UpdateMetricsResponse](time.Minute, 10),
+	}
+	in := &proto.UpdateMetricsRequest{
+		Metrics: []*proto.Metric{{Id: "c1", Delta: 5, Type: proto.Metric_COUNTER}},
+	}
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs(idempotency.MetadataBatchID, "batch1"))
+	_, err := server.UpdateMetrics(ctx, in)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, int64(5), repository.Counter["c1"])
+
+	// The retry isn't applied again and is answered with the current values.
+	retry, err := server.UpdateMetrics(ctx, in)
+	require.NoError(t, err)
+	require.Len(t, retry.Metrics, 1)
+	assert.Equal(t, int64(5), retry.Metrics[0].Delta)
+	assert.Equal(t, int64(5), repository.Counter["c1"])
+}
+
+func TestMetricsServer_Metadata(t *testing.T) {
+	registry, err := metricmeta.NewRegistry("", true)
+	require.NoError(t, err)
+	repository := router.NewMemoryStorage()
+	server := &MetricsServer{
+		Repository:    repository,
+		LastStoreTime: time.Now(),
+		StoreInterval: 300,
+		Metadata:      registry,
+	}
+
+	_, err = server.PutMetadata(context.TODO(), &proto.PutMetadataRequest{
+		Metadata: []*proto.MetricMetadata{{Name: "heap", Type: proto.Metric_GAUGE, Unit: "bytes"}},
+	})
+	require.NoError(t, err)
+	_, err = server.PutMetadata(context.TODO(), &proto.PutMetadataRequest{
+		Metadata: []*proto.MetricMetadata{{Name: "bad"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.UpdateMetrics(context.TODO(), &proto.UpdateMetricsRequest{
+		Metrics: []*proto.Metric{{Id: "heap", Delta: 1, Type: proto.Metric_COUNTER}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, repository.Counter)
+
+	resp, err := server.GetMetadata(context.TODO(), &proto.GetMetadataRequest{Names: []string{"heap", "unknown"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Metadata, 1)
+	assert.Equal(t, "bytes", resp.Metadata[0].Unit)
+	assert.Equal(t, proto.Metric_GAUGE, resp.Metadata[0].Type)
+}
@@ -0,0 +1,419 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenDuration
+	tokenName
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenLBrace
+	tokenRBrace
+	tokenString
+)
+
+type token struct {
+	text string
+	kind tokenKind
+	pos  int
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c == '?' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c) || c == '.' || c == ':'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// lex splits an expression into tokens.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			start := i
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				j := i + 1
+				if j < len(s) && (s[j] == '+' || s[j] == '-') {
+					j++
+				}
+				if j < len(s) && isDigit(s[j]) {
+					for i = j; i < len(s) && isDigit(s[i]); i++ {
+					}
+				}
+			}
+			kind := tokenNumber
+			if i < len(s) && 'a' <= s[i] && s[i] <= 'z' {
+				// A number with a unit is a duration, e.g. "5m" or "1h30m".
+				kind = tokenDuration
+				for i < len(s) && (isDigit(s[i]) || s[i] == '.' || ('a' <= s[i] && s[i] <= 'z')) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: kind, text: s[start:i], pos: start})
+		case isNameStart(c):
+			start := i
+			for i < len(s) {
+				if isNameChar(s[i]) {
+					i++
+					continue
+				}
+				// A * directly after a name is a glob if the name ends
+				// with it, e.g. "CPU*" vs "TotalMemory*100".
+				if s[i] == '*' && (i+1 == len(s) || strings.IndexByte(" \t\n\r),{<>=!", s[i+1]) >= 0) {
+					i++
+					continue
+				}
+				break
+			}
+			tokens = append(tokens, token{kind: tokenName, text: s[start:i], pos: start})
+		case strings.IndexByte("+-*/%", c) >= 0:
+			tokens = append(tokens, token{kind: tokenOp, text: s[i : i+1], pos: i})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '{':
+			tokens = append(tokens, token{kind: tokenLBrace, text: "{", pos: i})
+			i++
+		case c == '}':
+			tokens = append(tokens, token{kind: tokenRBrace, text: "}", pos: i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			// The operators are =, ==, =~, !=, !~, <, <=, > and >=.
+			n := 1
+			if i+1 < len(s) && (s[i+1] == '=' || (s[i+1] == '~' && (c == '=' || c == '!'))) {
+				n = 2
+			}
+			if c == '!' && n == 1 {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: s[i : i+n], pos: i})
+			i += n
+		case c == '"':
+			start := i
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			text, err := strconv.Unquote(s[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s at %d", s[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses an expression.
+//
+// Parameters:
+//   - s: The expression, e.g. "(TotalMemory - FreeMemory) / TotalMemory * 100".
+//
+// Returns:
+//   - The parsed expression.
+//   - An error if the expression is malformed.
+func Parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad expression %q: %w", s, err)
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseComparison()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad expression %q: %w", s, err)
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func isComparison(t token) bool {
+	if t.kind != tokenOp {
+		return false
+	}
+	switch t.text {
+	case "==", "!=", ">", "<", ">=", "<=":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	x, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); isComparison(t); t = p.peek() {
+		p.next()
+		y, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.text, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	x, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokenOp && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.next()
+		y, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.text, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokenOp && (t.text == "*" || t.text == "/" || t.text == "%"); t = p.peek() {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: t.text, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if t := p.peek(); t.kind == tokenOp && (t.text == "-" || t.text == "+") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return x, nil
+		}
+		return &unaryExpr{op: t.text, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.pos)
+		}
+		return &numberLit{v: v}, nil
+	case tokenLParen:
+		p.next()
+		x, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenRParen {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return x, nil
+	case tokenName:
+		p.next()
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t.text)
+	case tokenLBrace:
+		return p.parseSelector("")
+	}
+	return nil, p.unexpected()
+}
+
+// parseSelector parses the label matchers of a selector, if any, e.g.
+// `{type="gauge", unit=~"bytes|B"}`.
+func (p *parser) parseSelector(name string) (*selector, error) {
+	sel := &selector{name: name, glob: strings.ContainsAny(name, "*?")}
+	if p.peek().kind != tokenLBrace {
+		return sel, nil
+	}
+	p.next()
+	for p.peek().kind != tokenRBrace {
+		if len(sel.matchers) > 0 {
+			if p.peek().kind != tokenComma {
+				return nil, p.unexpected()
+			}
+			p.next()
+		}
+		label := p.peek()
+		if label.kind != tokenName || strings.ContainsAny(label.text, "*?") {
+			return nil, fmt.Errorf("label matcher takes a label name: %w", p.unexpected())
+		}
+		p.next()
+		op := p.peek()
+		if op.kind != tokenOp || (op.text != "=" && op.text != "!=" && op.text != "=~" && op.text != "!~") {
+			return nil, fmt.Errorf("label matcher takes =, !=, =~ or !~: %w", p.unexpected())
+		}
+		p.next()
+		value := p.peek()
+		if value.kind != tokenString {
+			return nil, fmt.Errorf("label matcher takes a quoted value: %w", p.unexpected())
+		}
+		p.next()
+		m := &matcher{label: label.text, op: op.text, value: value.text}
+		if op.text == "=~" || op.text == "!~" {
+			re, err := regexp.Compile("^(?:" + value.text + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("bad regular expression %q at %d: %w", value.text, value.pos, err)
+			}
+			m.re = re
+		}
+		sel.matchers = append(sel.matchers, m)
+	}
+	p.next()
+	if name == "" && len(sel.matchers) == 0 {
+		return nil, fmt.Errorf("selector {} has no matchers")
+	}
+	return sel, nil
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	if _, ok := rangeFuncs[name.text]; ok {
+		return p.parseRangeCall(name)
+	}
+	want := 1
+	switch _, ok := aggregations[name.text]; {
+	case name.text == "topk":
+		want = 2
+	case !ok:
+		return nil, fmt.Errorf("unknown function %s at %d", name.text, name.pos)
+	}
+	p.next()
+	var args []Expr
+	for p.peek().kind != tokenRParen {
+		if len(args) > 0 {
+			if p.peek().kind != tokenComma {
+				return nil, p.unexpected()
+			}
+			p.next()
+		}
+		arg, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if len(args) != want {
+		return nil, fmt.Errorf("function %s takes %d argument(s), got %d", name.text, want, len(args))
+	}
+	if name.text == "topk" {
+		return &topkCall{k: args[0], x: args[1]}, nil
+	}
+	return &call{fn: name.text, args: args}, nil
+}
+
+// parseRangeCall parses a call of a function over a window, e.g.
+// "rate(PollCount, 5m)". Without a window, e.g. "rate(PollCount)", the
+// function uses the last two samples.
+func (p *parser) parseRangeCall(name token) (Expr, error) {
+	p.next()
+	t := p.peek()
+	if t.kind != tokenName && t.kind != tokenLBrace {
+		return nil, fmt.Errorf("function %s takes a metric selector: %w", name.text, p.unexpected())
+	}
+	text := ""
+	if t.kind == tokenName {
+		p.next()
+		text = t.text
+	}
+	sel, err := p.parseSelector(text)
+	if err != nil {
+		return nil, err
+	}
+	c := &rangeCall{fn: name.text, sel: sel}
+	if p.peek().kind == tokenComma {
+		p.next()
+		if p.peek().kind != tokenDuration {
+			return nil, fmt.Errorf("function %s takes a window: %w", name.text, p.unexpected())
+		}
+		t = p.next()
+		c.window, err = time.ParseDuration(t.text)
+		if err != nil || c.window <= 0 {
+			return nil, fmt.Errorf("bad window %q at %d", t.text, t.pos)
+		}
+	}
+	if p.peek().kind != tokenRParen {
+		return nil, p.unexpected()
+	}
+	p.next()
+	return c, nil
+}
@@ -0,0 +1,658 @@
+// Package query provides a small expression language over metrics.
+//
+// An expression combines metrics, numbers and functions with the arithmetic
+// operators +, -, *, / and % and the comparison operators ==, !=, >, <, >=
+// and <=, e.g. "(TotalMemory - FreeMemory) / TotalMemory * 100 > 90".
+//
+// A metric name evaluates to the value of the metric, a scalar. A name with
+// the glob characters * and ? selects all matching metrics, a vector, e.g.
+// "sum(CPUutilization*)". A * is a glob only at the end of a name followed by
+// a space, a comma, a parenthesis, a brace, a comparison or the end of the
+// expression; elsewhere it is the multiplication operator, so
+// "TotalMemory*100" multiplies. Label matchers in braces select metrics by
+// their labels, e.g. `CPU*{type="gauge"}` or `{unit=~"bytes|B"}`; a selector
+// with matchers is always a vector. Every metric has the label "type", and
+// the label "unit" if it is registered in the metadata. The matchers are =,
+// !=, =~ and !~; regular expressions match whole values.
+//
+// Arithmetic between a vector and a scalar applies to every sample of the
+// vector. Arithmetic between two vectors applies to the samples with the same
+// name. A comparison filters instead: it keeps the samples for which it
+// holds, and a comparison of two scalars returns the left one if it holds or
+// an empty vector otherwise.
+//
+// The aggregation functions sum, avg, min, max and count reduce a vector to a
+// scalar; all but count have no data for an empty vector. topk(k, v) returns
+// the k samples of v with the largest values. The functions rate and
+// increase compute the per-second rate and the increase of counters over a
+// window, e.g. "rate(PollCount, 5m)", or between the last two samples if the
+// window is omitted. For a name with globs, they return a vector.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// ErrNoData means that an expression can't be evaluated because a metric it
+// refers to doesn't exist or an aggregation has no samples.
+var ErrNoData = errors.New("no data")
+
+// Labels of the metrics.
+const (
+	LabelType = "type"
+	LabelUnit = "unit"
+)
+
+// ValueType is the type of the value of an expression.
+type ValueType string
+
+// Value types.
+const (
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "vector"
+)
+
+// Sample is a value of a metric in a vector.
+type Sample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Name   string            `json:"name"`
+	Value  float64           `json:"value"`
+}
+
+// Value is the value of an expression: a scalar or a vector of samples.
+type Value struct {
+	Type   ValueType
+	Vector []Sample
+	Scalar float64
+}
+
+// Empty reports whether the value is a vector without samples, e.g. the
+// value of a comparison that doesn't hold.
+func (v Value) Empty() bool {
+	return v.Type == ValueTypeVector && len(v.Vector) == 0
+}
+
+func scalar(v float64) Value {
+	return Value{Type: ValueTypeScalar, Scalar: v}
+}
+
+func vector(samples []Sample) Value {
+	if samples == nil {
+		samples = []Sample{}
+	}
+	return Value{Type: ValueTypeVector, Vector: samples}
+}
+
+// RateSource provides the changes of counters over time windows. A zero
+// window means the change between the last two samples.
+type RateSource interface {
+	Increase(name string, window time.Duration, now time.Time) (float64, error)
+	Rate(name string, window time.Duration, now time.Time) (float64, error)
+	Names() []string
+}
+
+// Env holds the metric values an expression is evaluated against.
+//
+// Fields:
+//   - Rates: The source of the rate and increase functions. If it is nil,
+//     the functions have no data.
+//   - Now: The end of the windows of the rate and increase functions.
+type Env struct {
+	Now    time.Time
+	Rates  RateSource
+	values map[string]float64
+	labels map[string]map[string]string
+	names  []string
+}
+
+// NewEnv creates an Env with the values of metrics and the current time. If
+// a gauge and a counter have the same name, the gauge is used.
+func NewEnv(metrics []*metric.Metric) *Env {
+	env := &Env{
+		Now:    time.Now(),
+		values: make(map[string]float64, len(metrics)),
+		labels: make(map[string]map[string]string, len(metrics)),
+	}
+	for _, m := range metrics {
+		if m.Delta != nil {
+			env.Set(m.ID, float64(*m.Delta))
+			env.SetLabel(m.ID, LabelType, m.MType)
+		}
+	}
+	for _, m := range metrics {
+		if m.Value != nil {
+			env.Set(m.ID, *m.Value)
+			env.SetLabel(m.ID, LabelType, m.MType)
+		}
+	}
+	return env
+}
+
+// Set sets the value of a metric.
+func (env *Env) Set(name string, v float64) {
+	if _, ok := env.values[name]; !ok {
+		i := sort.SearchStrings(env.names, name)
+		env.names = append(env.names, "")
+		copy(env.names[i+1:], env.names[i:])
+		env.names[i] = name
+	}
+	env.values[name] = v
+}
+
+// SetLabel sets a label of a metric.
+func (env *Env) SetLabel(name, label, value string) {
+	if env.labels[name] == nil {
+		env.labels[name] = make(map[string]string)
+	}
+	env.labels[name][label] = value
+}
+
+// Delete deletes a metric.
+func (env *Env) Delete(name string) {
+	if _, ok := env.values[name]; !ok {
+		return
+	}
+	delete(env.values, name)
+	delete(env.labels, name)
+	i := sort.SearchStrings(env.names, name)
+	env.names = append(env.names[:i], env.names[i+1:]...)
+}
+
+// Get returns the value of a metric.
+func (env *Env) Get(name string) (float64, bool) {
+	v, ok := env.values[name]
+	return v, ok
+}
+
+// Match returns the samples of the metrics whose names match a glob pattern,
+// sorted by name.
+func (env *Env) Match(pattern string) []Sample {
+	var samples []Sample
+	for _, name := range env.names {
+		if ok, _ := path.Match(pattern, name); ok {
+			samples = append(samples, Sample{Name: name, Value: env.values[name], Labels: env.labels[name]})
+		}
+	}
+	return samples
+}
+
+// Expr is a parsed expression.
+type Expr interface {
+	// Eval evaluates the expression.
+	Eval(env *Env) (Value, error)
+	// String returns the expression in its canonical form.
+	String() string
+}
+
+// Selectors returns the metric selectors of an expression in the order they
+// appear in it, e.g. ["TotalMemory", "FreeMemory"].
+func Selectors(e Expr) []string {
+	var selectors []string
+	var walk func(e Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *selector:
+			selectors = append(selectors, e.String())
+		case *unaryExpr:
+			walk(e.x)
+		case *binaryExpr:
+			walk(e.x)
+			walk(e.y)
+		case *call:
+			for _, arg := range e.args {
+				walk(arg)
+			}
+		case *rangeCall:
+			walk(e.sel)
+		}
+	}
+	walk(e)
+	return selectors
+}
+
+// Operator precedences, from the lowest.
+const (
+	precComparison = iota + 1
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPrimary
+)
+
+// precedence returns the precedence of the top-level operator of an
+// expression.
+func precedence(e Expr) int {
+	switch e := e.(type) {
+	case *binaryExpr:
+		return binaryPrecedence(e.op)
+	case *unaryExpr:
+		return precUnary
+	default:
+		return precPrimary
+	}
+}
+
+func binaryPrecedence(op string) int {
+	switch op {
+	case "+", "-":
+		return precAdditive
+	case "*", "/", "%":
+		return precMultiplicative
+	default:
+		return precComparison
+	}
+}
+
+type numberLit struct {
+	v float64
+}
+
+func (n *numberLit) Eval(*Env) (Value, error) {
+	return scalar(n.v), nil
+}
+
+func (n *numberLit) String() string {
+	return strconv.FormatFloat(n.v, 'g', -1, 64)
+}
+
+// matcher matches a label of the metrics.
+type matcher struct {
+	re    *regexp.Regexp
+	label string
+	op    string
+	value string
+}
+
+func (m *matcher) matches(labels map[string]string) bool {
+	v := labels[m.label]
+	switch m.op {
+	case "=":
+		return v == m.value
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+func (m *matcher) String() string {
+	return m.label + m.op + strconv.Quote(m.value)
+}
+
+type selector struct {
+	name     string
+	matchers []*matcher
+	glob     bool
+}
+
+// vector reports whether the selector selects a vector.
+func (s *selector) vector() bool {
+	return s.glob || len(s.matchers) > 0
+}
+
+func (s *selector) Eval(env *Env) (Value, error) {
+	if !s.vector() {
+		v, ok := env.Get(s.name)
+		if !ok {
+			return Value{}, fmt.Errorf("%w: metric %s not found", ErrNoData, s.name)
+		}
+		return scalar(v), nil
+	}
+
+	pattern := s.name
+	if pattern == "" {
+		pattern = "*"
+	}
+	var samples []Sample
+	for _, sample := range env.Match(pattern) {
+		if s.matches(sample.Labels) {
+			samples = append(samples, sample)
+		}
+	}
+	return vector(samples), nil
+}
+
+func (s *selector) matches(labels map[string]string) bool {
+	for _, m := range s.matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *selector) String() string {
+	if len(s.matchers) == 0 {
+		return s.name
+	}
+	matchers := make([]string, 0, len(s.matchers))
+	for _, m := range s.matchers {
+		matchers = append(matchers, m.String())
+	}
+	return s.name + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+type unaryExpr struct {
+	x  Expr
+	op string
+}
+
+func (u *unaryExpr) Eval(env *Env) (Value, error) {
+	v, err := u.x.Eval(env)
+	if err != nil {
+		return Value{}, err
+	}
+	return apply(v, scalar(-1), "*"), nil
+}
+
+func (u *unaryExpr) String() string {
+	if precedence(u.x) < precUnary {
+		return u.op + "(" + u.x.String() + ")"
+	}
+	return u.op + u.x.String()
+}
+
+type binaryExpr struct {
+	x, y Expr
+	op   string
+}
+
+func (b *binaryExpr) Eval(env *Env) (Value, error) {
+	x, err := b.x.Eval(env)
+	if err != nil {
+		return Value{}, err
+	}
+	y, err := b.y.Eval(env)
+	if err != nil {
+		return Value{}, err
+	}
+	return apply(x, y, b.op), nil
+}
+
+func (b *binaryExpr) String() string {
+	prec := binaryPrecedence(b.op)
+	x, y := b.x.String(), b.y.String()
+	if precedence(b.x) < prec {
+		x = "(" + x + ")"
+	}
+	// The operators are left-associative, so a right operand with the same
+	// precedence needs parentheses.
+	if precedence(b.y) <= prec {
+		y = "(" + y + ")"
+	}
+	return x + " " + b.op + " " + y
+}
+
+// apply applies a binary operator to two values. An arithmetic operator
+// computes new values, a comparison keeps the values for which it holds.
+func apply(x, y Value, op string) Value {
+	cmp := binaryPrecedence(op) == precComparison
+	switch {
+	case x.Type == ValueTypeScalar && y.Type == ValueTypeScalar:
+		if !cmp {
+			return scalar(arith(op, x.Scalar, y.Scalar))
+		}
+		if compare(op, x.Scalar, y.Scalar) {
+			return x
+		}
+		return vector(nil)
+	case x.Type == ValueTypeVector && y.Type == ValueTypeScalar:
+		var samples []Sample
+		for _, s := range x.Vector {
+			if !cmp {
+				samples = append(samples, Sample{Name: s.Name, Labels: s.Labels, Value: arith(op, s.Value, y.Scalar)})
+			} else if compare(op, s.Value, y.Scalar) {
+				samples = append(samples, s)
+			}
+		}
+		return vector(samples)
+	case x.Type == ValueTypeScalar && y.Type == ValueTypeVector:
+		var samples []Sample
+		for _, s := range y.Vector {
+			if !cmp {
+				samples = append(samples, Sample{Name: s.Name, Labels: s.Labels, Value: arith(op, x.Scalar, s.Value)})
+			} else if compare(op, x.Scalar, s.Value) {
+				samples = append(samples, s)
+			}
+		}
+		return vector(samples)
+	default:
+		right := make(map[string]float64, len(y.Vector))
+		for _, s := range y.Vector {
+			right[s.Name] = s.Value
+		}
+		var samples []Sample
+		for _, s := range x.Vector {
+			v, ok := right[s.Name]
+			if !ok {
+				continue
+			}
+			if !cmp {
+				samples = append(samples, Sample{Name: s.Name, Labels: s.Labels, Value: arith(op, s.Value, v)})
+			} else if compare(op, s.Value, v) {
+				samples = append(samples, s)
+			}
+		}
+		return vector(samples)
+	}
+}
+
+func arith(op string, x, y float64) float64 {
+	switch op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		return x / y
+	case "%":
+		return math.Mod(x, y)
+	}
+	return math.NaN()
+}
+
+func compare(op string, x, y float64) bool {
+	switch op {
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	case ">":
+		return x > y
+	case "<":
+		return x < y
+	case ">=":
+		return x >= y
+	case "<=":
+		return x <= y
+	}
+	return false
+}
+
+// aggregation reduces the values of a vector to a scalar.
+type aggregation func(values []float64) (float64, bool)
+
+var aggregations = map[string]aggregation{
+	"sum": func(values []float64) (float64, bool) {
+		if len(values) == 0 {
+			return 0, false
+		}
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum, true
+	},
+	"avg": func(values []float64) (float64, bool) {
+		if len(values) == 0 {
+			return 0, false
+		}
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), true
+	},
+	"min": func(values []float64) (float64, bool) {
+		if len(values) == 0 {
+			return 0, false
+		}
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return m, true
+	},
+	"max": func(values []float64) (float64, bool) {
+		if len(values) == 0 {
+			return 0, false
+		}
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return m, true
+	},
+	"count": func(values []float64) (float64, bool) {
+		return float64(len(values)), true
+	},
+}
+
+type call struct {
+	fn   string
+	args []Expr
+}
+
+func (c *call) Eval(env *Env) (Value, error) {
+	v, err := c.args[0].Eval(env)
+	if err != nil {
+		return Value{}, err
+	}
+	values := []float64{v.Scalar}
+	if v.Type == ValueTypeVector {
+		values = make([]float64, 0, len(v.Vector))
+		for _, s := range v.Vector {
+			values = append(values, s.Value)
+		}
+	}
+	result, ok := aggregations[c.fn](values)
+	if !ok {
+		return Value{}, fmt.Errorf("%w: %s of no samples", ErrNoData, c.fn)
+	}
+	return scalar(result), nil
+}
+
+func (c *call) String() string {
+	args := make([]string, 0, len(c.args))
+	for _, arg := range c.args {
+		args = append(args, arg.String())
+	}
+	return c.fn + "(" + strings.Join(args, ", ") + ")"
+}
+
+// topkCall selects the samples with the largest values.
+type topkCall struct {
+	k Expr
+	x Expr
+}
+
+func (c *topkCall) Eval(env *Env) (Value, error) {
+	k, err := c.k.Eval(env)
+	if err != nil {
+		return Value{}, err
+	}
+	if k.Type != ValueTypeScalar {
+		return Value{}, fmt.Errorf("topk takes a scalar k, got a vector")
+	}
+	v, err := c.x.Eval(env)
+	if err != nil {
+		return Value{}, err
+	}
+	if v.Type == ValueTypeScalar {
+		return Value{}, fmt.Errorf("topk takes a vector, got a scalar")
+	}
+	if math.IsNaN(k.Scalar) || math.IsInf(k.Scalar, 0) || k.Scalar < 0 {
+		return Value{}, fmt.Errorf("topk takes a non-negative k, got %g", k.Scalar)
+	}
+	samples := append([]Sample(nil), v.Vector...)
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Value > samples[j].Value })
+	// k is compared before the conversion, which overflows for a huge k.
+	if k.Scalar < float64(len(samples)) {
+		samples = samples[:int(k.Scalar)]
+	}
+	return vector(samples), nil
+}
+
+func (c *topkCall) String() string {
+	return "topk(" + c.k.String() + ", " + c.x.String() + ")"
+}
+
+// rangeFunc computes a change of a counter over a window.
+type rangeFunc func(src RateSource, name string, window time.Duration, now time.Time) (float64, error)
+
+var rangeFuncs = map[string]rangeFunc{
+	"rate":     RateSource.Rate,
+	"increase": RateSource.Increase,
+}
+
+// rangeCall computes a change of counters over a window. A zero window means
+// the change between the last two samples.
+type rangeCall struct {
+	sel    *selector
+	fn     string
+	window time.Duration
+}
+
+func (c *rangeCall) Eval(env *Env) (Value, error) {
+	if env.Rates == nil {
+		return Value{}, fmt.Errorf("%w: %s is not available", ErrNoData, c.fn)
+	}
+	f := rangeFuncs[c.fn]
+	if !c.sel.vector() {
+		v, err := f(env.Rates, c.sel.name, c.window, env.Now)
+		if err != nil {
+			return Value{}, fmt.Errorf("%w: %s of %s: %v", ErrNoData, c.fn, c.sel.name, err)
+		}
+		return scalar(v), nil
+	}
+
+	pattern := c.sel.name
+	if pattern == "" {
+		pattern = "*"
+	}
+	var samples []Sample
+	for _, name := range env.Rates.Names() {
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		labels := env.labels[name]
+		if !c.sel.matches(labels) {
+			continue
+		}
+		if v, err := f(env.Rates, name, c.window, env.Now); err == nil {
+			samples = append(samples, Sample{Name: name, Labels: labels, Value: v})
+		}
+	}
+	return vector(samples), nil
+}
+
+func (c *rangeCall) String() string {
+	if c.window == 0 {
+		return c.fn + "(" + c.sel.String() + ")"
+	}
+	return c.fn + "(" + c.sel.String() + ", " + c.window.String() + ")"
+}
@@ -0,0 +1,233 @@
+package query
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func testEnv() *Env {
+	gauge := func(id string, v float64) *metric.Metric {
+		return &metric.Metric{ID: id, MType: "gauge", Value: &v}
+	}
+	counter := func(id string, d int64) *metric.Metric {
+		return &metric.Metric{ID: id, MType: "counter", Delta: &d}
+	}
+	return NewEnv([]*metric.Metric{
+		gauge("TotalMemory", 200),
+		gauge("FreeMemory", 50),
+		gauge("CPUutilization1", 10),
+		gauge("CPUutilization2", 30),
+		gauge("CPUutilization3", 20),
+		counter("PollCount", 7),
+		counter("TotalMemory", 1),
+	})
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{name: "test precedence", expr: "(TotalMemory - FreeMemory) / TotalMemory * 100", want: "(TotalMemory - FreeMemory) / TotalMemory * 100"},
+		{name: "test glob", expr: "sum(CPUutilization*)", want: "sum(CPUutilization*)"},
+		{name: "test multiplication without spaces", expr: "TotalMemory*100", want: "TotalMemory * 100"},
+		{name: "test glob followed by operator", expr: "CPU* - 1", want: "CPU* - 1"},
+		{name: "test unary minus", expr: "-PollCount + 1.5e1", want: "-PollCount + 15"},
+		{name: "test unknown function", expr: "median(CPU*)", wantErr: true},
+		{name: "test unbalanced parenthesis", expr: "(TotalMemory - FreeMemory", wantErr: true},
+		{name: "test trailing operator", expr: "TotalMemory -", wantErr: true},
+		{name: "test bad character", expr: "TotalMemory # 1", wantErr: true},
+		{name: "test too many arguments", expr: "sum(CPU*, 1)", wantErr: true},
+		{name: "test empty", expr: "", wantErr: true},
+		{name: "test rate", expr: "rate(PollCount, 5m) * 60", want: "rate(PollCount, 5m0s) * 60"},
+		{name: "test increase of glob", expr: "sum(increase(Requests*, 1h30m))", want: "sum(increase(Requests*, 1h30m0s))"},
+		{name: "test rate without window", expr: "rate(PollCount)", want: "rate(PollCount)"},
+		{name: "test rate of expression", expr: "rate(PollCount * 2, 5m)", wantErr: true},
+		{name: "test bad window", expr: "rate(PollCount, 5x)", wantErr: true},
+		{name: "test stray duration", expr: "PollCount + 5m", wantErr: true},
+		{name: "test comparison", expr: "TotalMemory-FreeMemory>=100*2", want: "TotalMemory - FreeMemory >= 100 * 2"},
+		{name: "test glob followed by comparison", expr: "CPU*>90", want: "CPU* > 90"},
+		{name: "test right associativity parentheses", expr: "TotalMemory - (FreeMemory - 1)", want: "TotalMemory - (FreeMemory - 1)"},
+		{name: "test unary minus of sum", expr: "-(TotalMemory + 1)", want: "-(TotalMemory + 1)"},
+		{name: "test label matchers", expr: `CPU*{type="gauge",unit=~"%|percent"}`, want: `CPU*{type="gauge", unit=~"%|percent"}`},
+		{name: "test matchers without name", expr: `count({type!="counter"})`, want: `count({type!="counter"})`},
+		{name: "test rate of matchers", expr: `rate({type="counter"}, 1m)`, want: `rate({type="counter"}, 1m0s)`},
+		{name: "test topk", expr: "topk(2, CPU* * 2)", want: "topk(2, CPU* * 2)"},
+		{name: "test topk without k", expr: "topk(CPU*)", wantErr: true},
+		{name: "test empty matchers", expr: "{}", wantErr: true},
+		{name: "test matcher without quotes", expr: "CPU*{type=gauge}", wantErr: true},
+		{name: "test bad regular expression", expr: `CPU*{type=~"("}`, wantErr: true},
+		{name: "test unterminated string", expr: `CPU*{type="gauge}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := Parse(test.expr)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, e.String())
+		})
+	}
+}
+
+func TestExpr_Eval(t *testing.T) {
+	gauge := map[string]string{LabelType: "gauge"}
+	tests := []struct {
+		name    string
+		expr    string
+		want    Value
+		wantErr error
+	}{
+		{name: "test memory percent", expr: "(TotalMemory - FreeMemory) / TotalMemory * 100", want: scalar(75)},
+		{name: "test sum", expr: "sum(CPUutilization*)", want: scalar(60)},
+		{name: "test avg", expr: "avg(CPUutilization?)", want: scalar(20)},
+		{name: "test min max", expr: "max(CPUutilization*) - min(CPUutilization*)", want: scalar(20)},
+		{name: "test count of none", expr: "count(Disk*)", want: scalar(0)},
+		{name: "test sum of none", expr: "sum(Disk*)", wantErr: ErrNoData},
+		{name: "test counter", expr: "PollCount % 4", want: scalar(3)},
+		{name: "test vector and scalar", expr: "CPUutilization* / 10", want: vector([]Sample{
+			{Name: "CPUutilization1", Labels: gauge, Value: 1},
+			{Name: "CPUutilization2", Labels: gauge, Value: 3},
+			{Name: "CPUutilization3", Labels: gauge, Value: 2},
+		})},
+		{name: "test vector and vector", expr: "CPUutilization* - CPUutilization?", want: vector([]Sample{
+			{Name: "CPUutilization1", Labels: gauge, Value: 0},
+			{Name: "CPUutilization2", Labels: gauge, Value: 0},
+			{Name: "CPUutilization3", Labels: gauge, Value: 0},
+		})},
+		{name: "test missing metric", expr: "Missing + 1", wantErr: ErrNoData},
+		{name: "test avg of none", expr: "avg(Disk*)", wantErr: ErrNoData},
+		{name: "test scalar comparison holds", expr: "(TotalMemory - FreeMemory) / TotalMemory * 100 > 70", want: scalar(75)},
+		{name: "test scalar comparison fails", expr: "TotalMemory < 100", want: vector(nil)},
+		{name: "test vector comparison", expr: "CPUutilization* >= 20", want: vector([]Sample{
+			{Name: "CPUutilization2", Labels: gauge, Value: 30},
+			{Name: "CPUutilization3", Labels: gauge, Value: 20},
+		})},
+		{name: "test scalar and vector comparison", expr: "15 > CPUutilization*", want: vector([]Sample{
+			{Name: "CPUutilization1", Labels: gauge, Value: 10},
+		})},
+		{name: "test topk", expr: "topk(2, CPUutilization*)", want: vector([]Sample{
+			{Name: "CPUutilization2", Labels: gauge, Value: 30},
+			{Name: "CPUutilization3", Labels: gauge, Value: 20},
+		})},
+		{name: "test topk of scalar", expr: "topk(1, TotalMemory)", wantErr: errors.New("topk takes a vector, got a scalar")},
+		{name: "test topk with huge k", expr: "topk(1e300, CPUutilization*)", want: vector([]Sample{
+			{Name: "CPUutilization2", Labels: gauge, Value: 30},
+			{Name: "CPUutilization3", Labels: gauge, Value: 20},
+			{Name: "CPUutilization1", Labels: gauge, Value: 10},
+		})},
+		{name: "test topk with NaN k", expr: "topk(0/0, CPUutilization*)", wantErr: errors.New("topk takes a non-negative k, got NaN")},
+		{name: "test topk with infinite k", expr: "topk(1/0, CPUutilization*)", wantErr: errors.New("topk takes a non-negative k, got +Inf")},
+		{name: "test topk with negative k", expr: "topk(-1, CPUutilization*)", wantErr: errors.New("topk takes a non-negative k, got -1")},
+		{name: "test type matcher", expr: `{type="counter"}`, want: vector([]Sample{
+			{Name: "PollCount", Labels: map[string]string{LabelType: "counter"}, Value: 7},
+		})},
+		{name: "test regular expression matcher", expr: `sum({type=~"g.*"})`, want: scalar(310)},
+		{name: "test negative matcher", expr: `count(CPU*{type!~"gauge"})`, want: scalar(0)},
+		{name: "test missing label", expr: `count(CPU*{unit=""})`, want: scalar(3)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := Parse(test.expr)
+			require.NoError(t, err)
+			got, err := e.Eval(testEnv())
+			if test.wantErr != nil {
+				if errors.Is(test.wantErr, ErrNoData) {
+					assert.ErrorIs(t, err, test.wantErr)
+				} else {
+					assert.EqualError(t, err, test.wantErr.Error())
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestExpr_EvalDivisionByZero(t *testing.T) {
+	e, err := Parse("TotalMemory / (FreeMemory - 50)")
+	require.NoError(t, err)
+	got, err := e.Eval(testEnv())
+	require.NoError(t, err)
+	assert.True(t, math.IsInf(got.Scalar, 1))
+}
+
+type rateSource map[string]float64
+
+func (r rateSource) Rate(name string, _ time.Duration, _ time.Time) (float64, error) {
+	v, ok := r[name]
+	if !ok {
+		return 0, errors.New("not enough samples")
+	}
+	return v, nil
+}
+
+func (r rateSource) Increase(name string, window time.Duration, now time.Time) (float64, error) {
+	v, err := r.Rate(name, window, now)
+	return v * window.Seconds(), err
+}
+
+func (r rateSource) Names() []string {
+	return []string{"Requests1", "Requests2", "Requests3"}
+}
+
+func TestExpr_EvalRate(t *testing.T) {
+	env := testEnv()
+	env.Rates = rateSource{"PollCount": 0.5, "Requests1": 1, "Requests3": 2}
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    Value
+		wantErr error
+	}{
+		{name: "test rate", expr: "rate(PollCount, 5m)", want: scalar(0.5)},
+		{name: "test increase", expr: "increase(PollCount, 1m)", want: scalar(30)},
+		{name: "test glob", expr: "rate(Requests*, 5m)", want: vector([]Sample{
+			{Name: "Requests1", Value: 1},
+			{Name: "Requests3", Value: 2},
+		})},
+		{name: "test sum of glob", expr: "sum(increase(Requests*, 10s))", want: scalar(30)},
+		{name: "test no samples", expr: "rate(Requests2, 5m)", wantErr: ErrNoData},
+		{name: "test matchers", expr: `rate({type="counter"}, 5m)`, want: vector(nil)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := Parse(test.expr)
+			require.NoError(t, err)
+			got, err := e.Eval(env)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	e, err := Parse("rate(PollCount, 5m)")
+	require.NoError(t, err)
+	_, err = e.Eval(testEnv())
+	assert.ErrorIs(t, err, ErrNoData, "rates are not available without a source")
+}
+
+func TestSelectors(t *testing.T) {
+	e, err := Parse(`sum(CPU*{type="gauge"}) / TotalMemory > rate(PollCount)`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`CPU*{type="gauge"}`, "TotalMemory", "PollCount"}, Selectors(e))
+}
@@ -0,0 +1,197 @@
+// Package rate computes the per-second rate and the increase of counters
+// over a time window.
+//
+// The repository only stores the running total of a counter, so the totals
+// are sampled on a schedule and kept in a History for the retention period.
+// A total lower than the previous one means that the counter was reset, e.g.
+// after a restart of the server or a deletion of the series; the increase
+// then counts from zero, so resets don't produce negative rates.
+package rate
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRetention is the default period samples are kept for.
+const DefaultRetention = time.Hour
+
+// ErrNoData means the window has less than two samples of the counter.
+var ErrNoData = errors.New("not enough samples")
+
+// Sample is a total of a counter at a point in time.
+type Sample struct {
+	At    time.Time
+	Value float64
+}
+
+// Result is the change of a counter over a window.
+//
+// Fields:
+//   - From, To: The times of the first and the last sample in the window.
+//   - Increase: The increase of the counter between the samples.
+//   - Rate: The per-second rate of the increase.
+//   - Resets: The number of resets of the counter between the samples.
+//   - Samples: The number of samples in the window.
+type Result struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Increase float64   `json:"increase"`
+	Rate     float64   `json:"rate"`
+	Resets   int       `json:"resets"`
+	Samples  int       `json:"samples"`
+}
+
+// History keeps samples of counters. It is safe for concurrent use.
+//
+// Fields:
+//   - Retention: How long samples are kept. It limits the window.
+type History struct {
+	series    map[string][]Sample
+	Retention time.Duration
+	mu        sync.RWMutex
+}
+
+// NewHistory creates a History.
+//
+// Parameters:
+//   - retention: How long samples are kept.
+//
+// Returns:
+//   - A pointer to the newly created History.
+func NewHistory(retention time.Duration) *History {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &History{
+		Retention: retention,
+		series:    make(map[string][]Sample),
+	}
+}
+
+// Record adds a sample of a counter and drops its samples older than the
+// retention period.
+//
+// Parameters:
+//   - name: The name of the counter.
+//   - value: The total of the counter.
+//   - at: The time of the sample. A sample that isn't newer than the last
+//     sample of the counter is ignored.
+func (h *History) Record(name string, value float64, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.series[name]
+	if len(samples) > 0 && !at.After(samples[len(samples)-1].At) {
+		return
+	}
+	samples = append(samples, Sample{At: at, Value: value})
+	h.series[name] = samples[h.expired(samples, at):]
+}
+
+// Prune drops the samples older than the retention period and forgets the
+// counters left without samples.
+func (h *History) Prune(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, samples := range h.series {
+		samples = samples[h.expired(samples, now):]
+		if len(samples) == 0 {
+			delete(h.series, name)
+			continue
+		}
+		h.series[name] = samples
+	}
+}
+
+// Delete forgets the samples of a counter, e.g. after it was deleted.
+func (h *History) Delete(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.series, name)
+}
+
+// expired returns the number of leading samples older than the retention
+// period.
+func (h *History) expired(samples []Sample, now time.Time) int {
+	cutoff := now.Add(-h.Retention)
+	return sort.Search(len(samples), func(i int) bool { return !samples[i].At.Before(cutoff) })
+}
+
+// Names returns the names of the counters with samples, sorted.
+func (h *History) Names() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.series))
+	for name := range h.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Samples returns a copy of the samples of a counter, oldest first.
+func (h *History) Samples(name string) []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]Sample(nil), h.series[name]...)
+}
+
+// Compute computes the change of a counter over a window.
+//
+// Parameters:
+//   - name: The name of the counter.
+//   - window: The length of the window that ends at now. If it is zero, the
+//     window spans the last two samples up to now.
+//   - now: The end of the window.
+//
+// Returns:
+//   - The change of the counter.
+//   - ErrNoData if the window has less than two samples of the counter.
+func (h *History) Compute(name string, window time.Duration, now time.Time) (Result, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := h.series[name]
+	last := sort.Search(len(samples), func(i int) bool { return samples[i].At.After(now) })
+	first := max(last-2, 0)
+	if window > 0 {
+		from := now.Add(-window)
+		first = sort.Search(len(samples), func(i int) bool { return !samples[i].At.Before(from) })
+	}
+	samples = samples[first:last]
+	if len(samples) < 2 {
+		return Result{}, ErrNoData
+	}
+
+	res := Result{
+		From:    samples[0].At,
+		To:      samples[len(samples)-1].At,
+		Samples: len(samples),
+	}
+	for i := 1; i < len(samples); i++ {
+		delta := samples[i].Value - samples[i-1].Value
+		if delta < 0 {
+			res.Resets++
+			delta = samples[i].Value
+		}
+		res.Increase += delta
+	}
+	if seconds := res.To.Sub(res.From).Seconds(); seconds > 0 {
+		res.Rate = res.Increase / seconds
+	}
+	return res, nil
+}
+
+// Increase returns the increase of a counter over a window, see Compute.
+func (h *History) Increase(name string, window time.Duration, now time.Time) (float64, error) {
+	res, err := h.Compute(name, window, now)
+	return res.Increase, err
+}
+
+// Rate returns the per-second rate of a counter over a window, see Compute.
+func (h *History) Rate(name string, window time.Duration, now time.Time) (float64, error) {
+	res, err := h.Compute(name, window, now)
+	return res.Rate, err
+}
@@ -0,0 +1,81 @@
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory_Compute(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(time.Hour)
+	// The counter is reset between the third and the fourth sample.
+	for i, v := range []float64{100, 110, 130, 5, 25} {
+		h.Record("requests", v, start.Add(time.Duration(i)*10*time.Second))
+	}
+	now := start.Add(40 * time.Second)
+
+	res, err := h.Compute("requests", 5*time.Minute, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{
+		From:     start,
+		To:       now,
+		Increase: 10 + 20 + 5 + 20,
+		Rate:     55.0 / 40,
+		Resets:   1,
+		Samples:  5,
+	}, res)
+
+	res, err = h.Compute("requests", 15*time.Second, now)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, res.Increase)
+	assert.Equal(t, 2.0, res.Rate)
+	assert.Zero(t, res.Resets)
+
+	// Without a window, the last two samples up to now are used.
+	res, err = h.Compute("requests", 0, now.Add(-10*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, res.Increase)
+	assert.Equal(t, 1, res.Resets)
+
+	_, err = h.Compute("requests", 5*time.Second, now)
+	assert.ErrorIs(t, err, ErrNoData)
+	_, err = h.Compute("requests", 0, start)
+	assert.ErrorIs(t, err, ErrNoData)
+	_, err = h.Compute("missing", 5*time.Minute, now)
+	assert.ErrorIs(t, err, ErrNoData)
+
+	v, err := h.Rate("requests", 15*time.Second, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, v)
+	v, err = h.Increase("requests", 15*time.Second, now)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, v)
+}
+
+func TestHistory_Retention(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(time.Minute)
+	h.Record("a", 1, start)
+	h.Record("b", 1, start)
+	h.Record("a", 2, start.Add(30*time.Second))
+	h.Record("a", 3, start.Add(90*time.Second))
+	h.Record("a", 4, start.Add(90*time.Second))
+
+	res, err := h.Compute("a", time.Hour, start.Add(90*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Samples, "samples older than the retention must be dropped")
+	assert.Equal(t, 1.0, res.Increase, "samples that aren't newer must be ignored")
+	assert.Equal(t, []string{"a", "b"}, h.Names())
+
+	h.Prune(start.Add(90 * time.Second))
+	assert.Equal(t, []string{"a"}, h.Names())
+
+	assert.Equal(t, []Sample{{At: start.Add(30 * time.Second), Value: 2}, {At: start.Add(90 * time.Second), Value: 3}}, h.Samples("a"))
+	assert.Empty(t, h.Samples("missing"))
+
+	h.Delete("a")
+	assert.Empty(t, h.Names())
+}
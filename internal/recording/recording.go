@@ -0,0 +1,135 @@
+// Package recording provides recording rules that compute new series from
+// existing ones.
+//
+// A recording rule names a gauge and a query expression, e.g. MemUsedPct =
+// "(TotalMemory - FreeMemory) / TotalMemory * 100" or CPUutilization =
+// "sum(CPUutilization*)". Rules are evaluated in order on a schedule, so a
+// rule can use the results of the rules above it. Dashboards and alerts can
+// then use the precomputed values.
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/query"
+)
+
+// nameRegexp matches the names of recorded series. They must be usable in
+// expressions, so glob characters are not allowed.
+var nameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:]*$`)
+
+// Rule is a recording rule.
+type Rule struct {
+	Name   string `json:"name"`
+	Expr   string `json:"expr"`
+	parsed query.Expr
+}
+
+// Parsed returns the parsed expression of the rule. It is only set for
+// rules returned by ParseRules and LoadRules.
+func (r Rule) Parsed() query.Expr {
+	return r.parsed
+}
+
+// ParseRules parses a JSON array of rules.
+//
+// Parameters:
+//   - data: The JSON array.
+//
+// Returns:
+//   - The parsed rules.
+//   - An error if the JSON is malformed, a name is invalid or duplicated or
+//     an expression is malformed.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parse recording rules: %w", err)
+	}
+	names := make(map[string]struct{}, len(rules))
+	for i := range rules {
+		if !nameRegexp.MatchString(rules[i].Name) {
+			return nil, fmt.Errorf("recording rule %d has bad name %q", i, rules[i].Name)
+		}
+		if _, ok := names[rules[i].Name]; ok {
+			return nil, fmt.Errorf("duplicate recording rule %s", rules[i].Name)
+		}
+		names[rules[i].Name] = struct{}{}
+
+		expr, err := query.Parse(rules[i].Expr)
+		if err != nil {
+			return nil, fmt.Errorf("recording rule %s: %w", rules[i].Name, err)
+		}
+		rules[i].parsed = expr
+	}
+	return rules, nil
+}
+
+// LoadRules reads the rules from a JSON file, see ParseRules.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// Eval evaluates the rules in an environment and sets the results in it.
+//
+// A rule whose expression has no data, e.g. because a metric doesn't exist
+// yet, is skipped without an error. The previously recorded values are not
+// visible to the rules, so a rule only sees the results of the rules above
+// it and "sum(CPUutilization*)" recorded as CPUutilization doesn't count
+// itself.
+//
+// Parameters:
+//   - rules: The rules parsed by ParseRules or LoadRules.
+//   - env: The environment with all metrics of the repository.
+//
+// Returns:
+//   - The gauges computed by the rules that have data, in the order of the
+//     rules.
+//   - The errors of the rules that can't be recorded, joined, or nil.
+func Eval(rules []Rule, env *query.Env) ([]metric.Metric, error) {
+	for _, r := range rules {
+		env.Delete(r.Name)
+	}
+	var (
+		recorded []metric.Metric
+		errs     []error
+	)
+	for _, r := range rules {
+		v, err := r.parsed.Eval(env)
+		if err != nil {
+			if !errors.Is(err, query.ErrNoData) {
+				errs = append(errs, fmt.Errorf("recording rule %s: %w", r.Name, err))
+			}
+			continue
+		}
+
+		value := v.Scalar
+		if v.Type == query.ValueTypeVector {
+			if len(v.Vector) == 0 {
+				continue
+			}
+			if len(v.Vector) > 1 {
+				errs = append(errs, fmt.Errorf("recording rule %s: expression returns %d series, want 1", r.Name, len(v.Vector)))
+				continue
+			}
+			value = v.Vector[0].Value
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			errs = append(errs, fmt.Errorf("recording rule %s: result %v is not a number", r.Name, value))
+			continue
+		}
+
+		env.Set(r.Name, value)
+		recorded = append(recorded, metric.Metric{ID: r.Name, MType: metric.TypeGauge, Value: &value})
+	}
+	return recorded, errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package recording
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/query"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "test valid", data: `[{"name": "MemUsedPct", "expr": "(TotalMemory - FreeMemory) / TotalMemory * 100"}]`},
+		{name: "test bad json", data: `{`, wantErr: true},
+		{name: "test glob name", data: `[{"name": "CPU*", "expr": "1"}]`, wantErr: true},
+		{name: "test empty name", data: `[{"expr": "1"}]`, wantErr: true},
+		{name: "test duplicate", data: `[{"name": "A", "expr": "1"}, {"name": "A", "expr": "2"}]`, wantErr: true},
+		{name: "test bad expr", data: `[{"name": "A", "expr": "sum("}]`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := ParseRules([]byte(test.data))
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, rules, 1)
+			assert.NotNil(t, rules[0].Parsed())
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	rules, err := ParseRules([]byte(`[
+		{"name": "MemUsedPct", "expr": "(TotalMemory - FreeMemory) / TotalMemory * 100"},
+		{"name": "MemUsedRatio", "expr": "MemUsedPct / 100"},
+		{"name": "CPUutilization", "expr": "sum(CPUutilization*)"},
+		{"name": "DiskUsed", "expr": "UsedDisk / TotalDisk"},
+		{"name": "CPUs", "expr": "CPUutilization? * 2"},
+		{"name": "Infinite", "expr": "TotalMemory / 0"}
+	]`))
+	require.NoError(t, err)
+
+	gauge := func(id string, v float64) *metric.Metric {
+		return &metric.Metric{ID: id, MType: "gauge", Value: &v}
+	}
+	recorded, err := Eval(rules, query.NewEnv([]*metric.Metric{
+		gauge("TotalMemory", 200),
+		gauge("FreeMemory", 50),
+		gauge("CPUutilization1", 10),
+		gauge("CPUutilization2", 30),
+	}))
+	assert.ErrorContains(t, err, "CPUs: expression returns 2 series")
+	assert.ErrorContains(t, err, "Infinite: result +Inf is not a number")
+	assert.NotContains(t, err.Error(), "DiskUsed", "missing data is not an error")
+
+	got := make(map[string]float64)
+	for _, m := range recorded {
+		assert.Equal(t, metric.TypeGauge, m.MType)
+		got[m.ID] = *m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"MemUsedPct":     75,
+		"MemUsedRatio":   0.75,
+		"CPUutilization": 40,
+	}, got)
+}
@@ -116,6 +116,23 @@ func (mr *MockRepositoryMockRecorder) GetMetrics(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockRepository)(nil).GetMetrics), arg0)
 }
 
+// ListMetrics mocks base method.
+func (m *MockRepository) ListMetrics(arg0 context.Context, arg1 metric.Filter) ([]*metric.Metric, error) {
+	//m.ctrl.T.Helper()
+	//ret := m.ctrl.Call(m, "ListMetrics", arg0, arg1)
+	//ret0, _ := ret[0].([]*metric.Metric)
+	//ret1, _ := ret[1].(error)
+	//return ret0, ret1
+	return nil, nil
+}
+
+// ListMetrics indicates an expected call of ListMetrics.
+func (mr *MockRepositoryMockRecorder) ListMetrics(arg0, arg1 interface{}) *gomock.Call {
+	//mr.mock.ctrl.T.Helper()
+	//return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMetrics", reflect.TypeOf((*MockRepository)(nil).ListMetrics), arg0, arg1)
+	return nil
+}
+
 // UpdateMetric mocks base method.
 func (m *MockRepository) UpdateMetric(arg0 context.Context, arg1 *metric.Metric) error {
 	m.ctrl.T.Helper()
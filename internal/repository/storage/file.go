@@ -4,14 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/Vidkin/metrics/internal/logger"
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/silence"
+	"github.com/Vidkin/metrics/pkg/atomicfile"
 )
 
 type FileStorage struct {
@@ -21,9 +25,19 @@ type FileStorage struct {
 	GaugeMetrics    []*me.Metric
 	CounterMetrics  []*me.Metric
 	AllMetrics      []*me.Metric
+	updated         updateTimes
+	silences        silenceSet
+	walPath         string
+	loadedPath      string
+	walRecords      int
+	silencesLoaded  bool
 	mu              sync.RWMutex
 }
 
+// SilencesFileSuffix is appended to FileStoragePath to get the path of the
+// file with the silences.
+const SilencesFileSuffix = ".silences"
+
 func (f *FileStorage) UpdateMetric(_ context.Context, metric *me.Metric) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -36,6 +50,7 @@ func (f *FileStorage) UpdateMetric(_ context.Context, metric *me.Metric) error {
 	default:
 		return errors.New("unknown metric type")
 	}
+	f.updated.touch(metric.MType, metric.ID, time.Now())
 	return nil
 }
 
@@ -43,6 +58,7 @@ func (f *FileStorage) UpdateMetrics(_ context.Context, metrics *[]me.Metric) err
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	now := time.Now()
 	for _, metric := range *metrics {
 		switch metric.MType {
 		case MetricTypeGauge:
@@ -52,6 +68,7 @@ func (f *FileStorage) UpdateMetrics(_ context.Context, metrics *[]me.Metric) err
 		default:
 			return errors.New("unknown metric type")
 		}
+		f.updated.touch(metric.MType, metric.ID, now)
 	}
 	return nil
 }
@@ -68,6 +85,87 @@ func (f *FileStorage) DeleteMetric(_ context.Context, mType string, name string)
 	default:
 		return errors.New("unknown metric type")
 	}
+	f.updated.forget(mType, name)
+	return nil
+}
+
+func (f *FileStorage) GetUpdateTimes(_ context.Context) ([]SeriesTime, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.updated.collect(f.Gauge, f.Counter, time.Now()), nil
+}
+
+func (f *FileStorage) SaveSilence(_ context.Context, s silence.Silence) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.loadSilences(); err != nil {
+		return err
+	}
+	f.silences.save(s)
+	return f.dumpSilences()
+}
+
+func (f *FileStorage) DeleteSilence(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.loadSilences(); err != nil {
+		return err
+	}
+	if err := f.silences.delete(id); err != nil {
+		return err
+	}
+	return f.dumpSilences()
+}
+
+func (f *FileStorage) GetSilences(_ context.Context) ([]silence.Silence, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.loadSilences(); err != nil {
+		return nil, err
+	}
+	return f.silences.list(), nil
+}
+
+// loadSilences reads the silences file once. A missing file means there are
+// no silences. It must be called with the mutex held.
+func (f *FileStorage) loadSilences() error {
+	if f.silencesLoaded {
+		return nil
+	}
+	data, err := os.ReadFile(f.FileStoragePath + SilencesFileSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Log.Info("error read silences file", zap.Error(err))
+		return err
+	}
+	if len(data) != 0 {
+		var list []silence.Silence
+		if err = json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		for _, s := range list {
+			f.silences.save(s)
+		}
+	}
+	f.silencesLoaded = true
+	return nil
+}
+
+// dumpSilences replaces the silences file atomically. It must be called
+// with the mutex held.
+func (f *FileStorage) dumpSilences() error {
+	b, err := json.Marshal(f.silences.list())
+	if err != nil {
+		logger.Log.Info("error marshal silences", zap.Error(err))
+		return err
+	}
+	if err = atomicfile.WriteFile(f.FileStoragePath+SilencesFileSuffix, b); err != nil {
+		logger.Log.Info("error write silences file", zap.Error(err))
+		return err
+	}
 	return nil
 }
 
@@ -80,7 +178,7 @@ func (f *FileStorage) GetMetric(_ context.Context, mType string, name string) (*
 	case MetricTypeGauge:
 		v, ok := f.Gauge[name]
 		if !ok {
-			return nil, errors.New("metric not found")
+			return nil, ErrMetricNotFound
 		}
 		metric.ID = name
 		metric.MType = MetricTypeGauge
@@ -88,7 +186,7 @@ func (f *FileStorage) GetMetric(_ context.Context, mType string, name string) (*
 	case MetricTypeCounter:
 		v, ok := f.Counter[name]
 		if !ok {
-			return nil, errors.New("metric not found")
+			return nil, ErrMetricNotFound
 		}
 		metric.ID = name
 		metric.MType = MetricTypeCounter
@@ -112,6 +210,12 @@ func (f *FileStorage) GetMetrics(ctx context.Context) ([]*me.Metric, error) {
 	return f.AllMetrics, nil
 }
 
+func (f *FileStorage) ListMetrics(_ context.Context, filter me.Filter) ([]*me.Metric, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return listMetrics(f.Gauge, f.Counter, filter), nil
+}
+
 func (f *FileStorage) GetGauges(_ context.Context) ([]*me.Metric, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
@@ -142,114 +246,190 @@ func (f *FileStorage) GetCounters(_ context.Context) ([]*me.Metric, error) {
 	return f.CounterMetrics, nil
 }
 
+// Dump appends the value of a metric to the write-ahead log and syncs it,
+// so the update survives a crash. The value stored for the series is
+// logged with its update time, so for a counter it is its total rather than
+// the delta of the update; the given metric is logged only if the series
+// isn't stored. Every WALCompactRecords records the log is compacted into a
+// new snapshot.
 func (f *FileStorage) Dump(metric *me.Metric) error {
-	file, err := os.OpenFile(f.FileStoragePath, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil && err != io.EOF {
-		logger.Log.Info("error open file", zap.Error(err))
+	if metric == nil {
+		return errors.New("nil metric")
+	}
+	return f.DumpMetrics([]me.Metric{*metric})
+}
+
+// DumpMetrics appends the values of metrics to the write-ahead log like
+// Dump, but with a single write and sync for all of them, so a batch update
+// costs one fsync.
+func (f *FileStorage) DumpMetrics(metrics []me.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.openWAL(); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
-	if err != nil {
-		logger.Log.Info("error read file", zap.Error(err))
+	records := make([]me.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		records = append(records, f.walRecord(metric))
+	}
+	if err := f.appendWAL(records...); err != nil {
 		return err
 	}
 
-	var metrics []me.Metric
-	if len(data) != 0 {
-		if err = json.Unmarshal(data, &metrics); err != nil {
-			return err
+	if f.walRecords >= WALCompactRecords {
+		if err := f.compact(); err != nil {
+			logger.Log.Info("error compact wal", zap.Error(err))
 		}
 	}
+	return nil
+}
 
-	found := false
-	for index, met := range metrics {
-		if met.ID == metric.ID && met.MType == metric.MType {
-			if met.MType == MetricTypeCounter {
-				metrics[index].Delta = metric.Delta
-			}
-			if met.MType == MetricTypeGauge {
-				metrics[index].Value = metric.Value
-			}
-			found = true
-			break
+// walRecord returns the record the write-ahead log keeps for an update of
+// a metric: the value stored for the series with its update time. It must
+// be called with the mutex held.
+func (f *FileStorage) walRecord(metric me.Metric) me.Metric {
+	record := metric
+	switch metric.MType {
+	case MetricTypeGauge:
+		if v, ok := f.Gauge[metric.ID]; ok {
+			record.Value = &v
+		}
+	case MetricTypeCounter:
+		if v, ok := f.Counter[metric.ID]; ok {
+			record.Delta = &v
 		}
 	}
-
-	if !found {
-		metrics = append(metrics, *metric)
+	if at, ok := f.updated[seriesKey(metric.MType, metric.ID)]; ok {
+		record.Updated = &at
 	}
+	return record
+}
 
-	b, err := json.Marshal(metrics)
-	if err != nil {
-		logger.Log.Info("error marshal metrics", zap.Error(err))
-		return err
-	}
+// FullDump writes a snapshot of all metrics and starts a new write-ahead
+// log. The snapshot is written to a temporary file that is synced and
+// renamed over the storage file, so a crash never leaves it partially
+// written.
+func (f *FileStorage) FullDump() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.compact()
+}
 
-	err = os.WriteFile(f.FileStoragePath, b, 0666)
+// Load reads the storage file and replays the write-ahead log over it. A
+// missing or empty file holds no metrics. A last log record cut short by a
+// crash is ignored. The loaded series keep the update times of their
+// records; series of files of older versions count as updated now.
+//
+// Load fails with ErrDamaged if any other record is bad, see Recover.
+func (f *FileStorage) Load(_ context.Context) error {
+	c, report, err := f.read()
 	if err != nil {
-		logger.Log.Info("error write file", zap.Error(err))
 		return err
 	}
+	if len(c.damaged) > 0 {
+		return fmt.Errorf("%w: %s (%d problems)", ErrDamaged, report.Problems[0], len(report.Problems))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apply(c)
 	return nil
 }
 
-func (f *FileStorage) FullDump() error {
-	file, err := os.OpenFile(f.FileStoragePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0666)
+// Recover reads the storage file and replays the write-ahead log over it
+// like Load, but it skips bad records instead of failing. The damaged files
+// are copied next to them with QuarantineSuffix and replaced with a new
+// snapshot of the recovered metrics.
+//
+// Returns:
+//   - The report of the recovery: the number of recovered metrics, the bad
+//     records and the paths of the copies of the damaged files.
+//   - An error if the files can't be read, or the damaged files can't be
+//     copied or replaced. The recovered metrics are loaded even then.
+func (f *FileStorage) Recover(_ context.Context) (FileReport, error) {
+	c, report, err := f.read()
 	if err != nil {
-		logger.Log.Info("error open file", zap.Error(err))
-		return err
+		return report, err
 	}
-	defer file.Close()
 
-	gauge, err := f.GetGauges(context.TODO())
-	if err != nil {
-		logger.Log.Info("error get gauges", zap.Error(err))
-		return err
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apply(c)
+	if len(c.damaged) == 0 {
+		return report, nil
 	}
-	counter, err := f.GetCounters(context.TODO())
-	if err != nil {
-		logger.Log.Info("error get counters", zap.Error(err))
-		return err
+	if report.Quarantined, err = quarantine(c.damaged, time.Now()); err != nil {
+		logger.Log.Info("error quarantine damaged file", zap.Error(err))
+		return report, err
 	}
-	metrics := append(gauge, counter...)
-
-	b, err := json.Marshal(metrics)
-	if err != nil {
-		logger.Log.Info("error marshal metrics", zap.Error(err))
-		return err
+	if err = f.compact(); err != nil {
+		return report, err
 	}
-	_, err = file.Write(b)
-	if err != nil {
-		logger.Log.Info("error write file", zap.Error(err))
-		return err
-	}
-	return nil
+	return report, nil
 }
 
-func (f *FileStorage) Load(ctx context.Context) error {
-	file, err := os.OpenFile(f.FileStoragePath, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		logger.Log.Info("error open file", zap.Error(err))
-		return err
+// read reads the storage file and the write-ahead log. The storage file is
+// created by the first dump, but its directory must exist.
+func (f *FileStorage) read() (storageContents, FileReport, error) {
+	if _, err := os.Stat(filepath.Dir(f.FileStoragePath)); err != nil {
+		logger.Log.Info("error read file", zap.Error(err))
+		return storageContents{}, FileReport{}, err
 	}
-	defer file.Close()
-
-	data, err := io.ReadAll(file)
+	c, report, err := readStorage(f.FileStoragePath)
 	if err != nil {
 		logger.Log.Info("error read file", zap.Error(err))
-		return err
-	}
-	var metrics []me.Metric
-	if err := json.Unmarshal(data, &metrics); err != nil {
-		return err
 	}
+	return c, report, err
+}
 
-	for _, metric := range metrics {
-		if err := f.UpdateMetric(ctx, &metric); err != nil {
-			return err
-		}
+// apply sets the metrics read from the files. It must be called with the
+// mutex held.
+func (f *FileStorage) apply(c storageContents) {
+	now := time.Now()
+	for name, v := range c.gauges {
+		f.Gauge[name] = v
+		f.updated.touch(MetricTypeGauge, name, c.updated.at(MetricTypeGauge, name, now))
 	}
-	return nil
+	for name, v := range c.counters {
+		f.Counter[name] = v
+		f.updated.touch(MetricTypeCounter, name, c.updated.at(MetricTypeCounter, name, now))
+	}
+	// The log is checked again before the next append.
+	f.walPath = ""
+	f.loadedPath = f.FileStoragePath
+}
+
+// FileReport is the result of a check or a recovery of a storage file.
+//
+// Fields:
+//   - Metrics: The number of valid metrics in the file and its write-ahead
+//     log.
+//   - Problems: The problems found, one per bad record.
+//   - Quarantined: The paths of the copies of the damaged files kept by a
+//     recovery.
+type FileReport struct {
+	Problems    []string `json:"problems,omitempty"`
+	Quarantined []string `json:"quarantined,omitempty"`
+	Metrics     int      `json:"metrics"`
+}
+
+// VerifyFile checks the integrity of a storage file and its write-ahead log
+// without loading them: every record must match its checksum and hold a
+// metric with a name, a known type and a value of its type, no metric may
+// appear twice in the file and the file must hold as many records as its
+// header says. A missing or empty file holds no metrics; a log of another
+// storage file is stale and not checked.
+//
+// Parameters:
+//   - path: The path of the storage file.
+//
+// Returns:
+//   - The report of the check.
+//   - An error if the files can't be read.
+func VerifyFile(path string) (FileReport, error) {
+	_, report, err := readStorage(path)
+	return report, err
 }
@@ -2,13 +2,17 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/silence"
 )
 
 func TestFileStorage_UpdateMetric(t *testing.T) {
@@ -588,3 +592,302 @@ func TestFileStorage_Load(t *testing.T) {
 		})
 	}
 }
+
+func TestFileStorage_LoadUpdateTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	floatValue := 1.5
+	intValue := int64(2)
+	f := &FileStorage{Gauge: make(map[string]float64), Counter: make(map[string]int64), FileStoragePath: path}
+	assert.NoError(t, f.UpdateMetric(context.TODO(), &me.Metric{ID: "Alloc", MType: MetricTypeGauge, Value: &floatValue}))
+	assert.NoError(t, f.UpdateMetric(context.TODO(), &me.Metric{ID: "PollCount", MType: MetricTypeCounter, Delta: &intValue}))
+
+	// The snapshot and the log keep the update times of the series.
+	snapshotTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	walTime := snapshotTime.Add(time.Minute)
+	f.updated.touch(MetricTypeGauge, "Alloc", snapshotTime)
+	f.updated.touch(MetricTypeCounter, "PollCount", snapshotTime)
+	assert.NoError(t, f.FullDump())
+	f.updated.touch(MetricTypeCounter, "PollCount", walTime)
+	assert.NoError(t, f.Dump(&me.Metric{ID: "PollCount", MType: MetricTypeCounter, Delta: &intValue}))
+
+	loaded := &FileStorage{Gauge: make(map[string]float64), Counter: make(map[string]int64), FileStoragePath: path}
+	assert.NoError(t, loaded.Load(context.TODO()))
+	series, err := loaded.GetUpdateTimes(context.TODO())
+	assert.NoError(t, err)
+	times := make(map[string]time.Time)
+	for _, st := range series {
+		times[st.ID] = st.Updated
+	}
+	assert.True(t, snapshotTime.Equal(times["Alloc"]), times["Alloc"])
+	assert.True(t, walTime.Equal(times["PollCount"]), times["PollCount"])
+
+	// Files of older versions have no update times, their series count as
+	// updated now.
+	assert.NoError(t, os.Remove(path+WALFileSuffix))
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`), 0666))
+	before := time.Now()
+	legacy := &FileStorage{Gauge: make(map[string]float64), Counter: make(map[string]int64), FileStoragePath: path}
+	assert.NoError(t, legacy.Load(context.TODO()))
+	series, err = legacy.GetUpdateTimes(context.TODO())
+	assert.NoError(t, err)
+	if assert.Len(t, series, 1) {
+		assert.False(t, series[0].Updated.Before(before))
+	}
+}
+
+func TestFileStorage_Silences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	now := time.Now().UTC().Truncate(time.Second)
+	s1 := silence.Silence{
+		ID:       "s1",
+		Matchers: []silence.Matcher{{Name: "metric", Value: "CPU*"}},
+		StartsAt: now,
+		EndsAt:   now.Add(time.Hour),
+		Comment:  "maintenance",
+	}
+	s2 := silence.Silence{ID: "s2", StartsAt: now.Add(time.Minute), EndsAt: now.Add(time.Hour)}
+
+	f := &FileStorage{FileStoragePath: path}
+	assert.NoError(t, f.SaveSilence(context.TODO(), s1))
+	assert.NoError(t, f.SaveSilence(context.TODO(), s2))
+	assert.NoError(t, f.DeleteSilence(context.TODO(), "s2"))
+	assert.ErrorIs(t, f.DeleteSilence(context.TODO(), "s2"), silence.ErrNotFound)
+
+	restarted := &FileStorage{FileStoragePath: path}
+	silences, err := restarted.GetSilences(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []silence.Silence{s1}, silences)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "metrics file must not be touched")
+
+	bad := &FileStorage{FileStoragePath: filepath.Join(t.TempDir(), "bad.json")}
+	assert.NoError(t, os.WriteFile(bad.FileStoragePath+SilencesFileSuffix, []byte("{"), 0666))
+	_, err = bad.GetSilences(context.TODO())
+	assert.Error(t, err)
+}
+
+func TestVerifyFile(t *testing.T) {
+	dir := t.TempDir()
+	report, err := VerifyFile(filepath.Join(dir, "missing.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{}, report)
+
+	path := filepath.Join(dir, "metrics.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"summary","value":1},{"id":1}]`), 0666))
+	report, err = VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Metrics)
+	assert.Len(t, report.Problems, 2)
+	assert.Contains(t, report.Problems[0], `record 2: metric "b" has unknown type "summary"`)
+
+	// A truncated file of an older version is read up to the damage.
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"id":"a","type":"gauge","value":1},{"id":"b"`), 0666))
+	report, err = VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 1, Problems: []string{"record 2: unexpected EOF"}}, report)
+
+	snapshot := []byte(`[{"id":"a","type":"gauge","value":1}]`)
+	assert.NoError(t, os.WriteFile(path, snapshot, 0666))
+	var wal []byte
+	for _, v := range []any{headerOf(snapshot), me.Metric{ID: "b", MType: MetricTypeCounter, Delta: new(int64)}, me.Metric{ID: "c", MType: MetricTypeCounter}} {
+		wal, err = appendRecord(wal, v)
+		assert.NoError(t, err)
+	}
+	wal = append(wal, "00000000 {\"id\":\"d\",\"type\":\"gauge\",\"value\":1}\n{\"id\":\"e\""...)
+	assert.NoError(t, os.WriteFile(path+WALFileSuffix, wal, 0666))
+	report, err = VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 2, Problems: []string{
+		`wal record 2: counter "c" has no value`,
+		"wal record 3: checksum mismatch",
+		"wal record 4: incomplete",
+	}}, report)
+}
+
+func TestFileStorage_WAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	newStorage := func() *FileStorage {
+		return &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	}
+	floatValue := 1.5
+	intValue := int64(5)
+	gauge := &me.Metric{ID: "Alloc", MType: MetricTypeGauge, Value: &floatValue}
+	counter := &me.Metric{ID: "PollCount", MType: MetricTypeCounter, Delta: &intValue}
+
+	f := newStorage()
+	assert.NoError(t, f.Load(context.TODO()))
+	for _, m := range []*me.Metric{gauge, counter, counter} {
+		assert.NoError(t, f.UpdateMetric(context.TODO(), m))
+		assert.NoError(t, f.Dump(m))
+	}
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "updates must only be logged")
+
+	// A crash loses no dumped update, and counters are logged as totals.
+	restarted := newStorage()
+	assert.NoError(t, restarted.Load(context.TODO()))
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, restarted.Gauge)
+	assert.Equal(t, map[string]int64{"PollCount": 10}, restarted.Counter)
+
+	// A record cut short by a crash is ignored and overwritten.
+	wal, err := os.OpenFile(path+WALFileSuffix, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.NoError(t, err)
+	_, err = wal.WriteString(`{"delta":99,"id":"Poll`)
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+	restarted = newStorage()
+	assert.NoError(t, restarted.Load(context.TODO()))
+	assert.Equal(t, map[string]int64{"PollCount": 10}, restarted.Counter)
+	assert.NoError(t, restarted.UpdateMetric(context.TODO(), counter))
+	assert.NoError(t, restarted.Dump(counter))
+	report, err := VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 2}, report)
+
+	// A compaction writes the snapshot and empties the log.
+	assert.NoError(t, restarted.FullDump())
+	c, report, err := readStorage(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 2}, report)
+	assert.Equal(t, map[string]int64{"PollCount": 15}, c.counters)
+	data, err := os.ReadFile(path + WALFileSuffix)
+	assert.NoError(t, err)
+	log, err := parseWAL(data)
+	assert.NoError(t, err)
+	assert.Empty(t, log.Records)
+}
+
+func TestFileStorage_DumpMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	floatValue := 1.5
+	intValue := int64(5)
+	metrics := []me.Metric{
+		{ID: "Alloc", MType: MetricTypeGauge, Value: &floatValue},
+		{ID: "PollCount", MType: MetricTypeCounter, Delta: &intValue},
+	}
+
+	f := &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	assert.NoError(t, f.Load(context.TODO()))
+	assert.NoError(t, f.UpdateMetrics(context.TODO(), &metrics))
+	assert.NoError(t, f.UpdateMetrics(context.TODO(), &metrics))
+	assert.NoError(t, f.DumpMetrics(metrics))
+	assert.Equal(t, 2, f.walRecords)
+
+	// The batch is logged with the stored values of its series.
+	data, err := os.ReadFile(path + WALFileSuffix)
+	assert.NoError(t, err)
+	log, err := parseWAL(data)
+	assert.NoError(t, err)
+	assert.Len(t, log.Records, 2)
+
+	restarted := &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	assert.NoError(t, restarted.Load(context.TODO()))
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, restarted.Gauge)
+	assert.Equal(t, map[string]int64{"PollCount": 10}, restarted.Counter)
+}
+
+func TestFileStorage_DumpWithoutLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	newStorage := func() *FileStorage {
+		return &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	}
+	floatValue := 1.5
+	old := &me.Metric{ID: "Old", MType: MetricTypeGauge, Value: &floatValue}
+	updated := &me.Metric{ID: "New", MType: MetricTypeGauge, Value: &floatValue}
+
+	f := newStorage()
+	assert.NoError(t, f.UpdateMetric(context.TODO(), old))
+	assert.NoError(t, f.FullDump())
+
+	// A storage that didn't load the file replaces it on the first dump,
+	// so the series of the old snapshot don't come back.
+	notLoaded := newStorage()
+	assert.NoError(t, notLoaded.UpdateMetric(context.TODO(), updated))
+	assert.NoError(t, notLoaded.Dump(updated))
+
+	restarted := newStorage()
+	assert.NoError(t, restarted.Load(context.TODO()))
+	assert.Equal(t, map[string]float64{"New": 1.5}, restarted.Gauge)
+}
+
+func TestFileStorage_CompactCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	floatValue := 1.5
+	gauge := &me.Metric{ID: "Alloc", MType: MetricTypeGauge, Value: &floatValue}
+	f := &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	assert.NoError(t, f.UpdateMetric(context.TODO(), gauge))
+	assert.NoError(t, f.Dump(gauge))
+	oldWAL, err := os.ReadFile(path + WALFileSuffix)
+	assert.NoError(t, err)
+
+	// A crash after the new snapshot is renamed into place but before the
+	// new log is started leaves the old log, which is stale.
+	assert.NoError(t, f.DeleteMetric(context.TODO(), MetricTypeGauge, "Alloc"))
+	assert.NoError(t, f.FullDump())
+	assert.NoError(t, os.WriteFile(path+WALFileSuffix, oldWAL, 0666))
+
+	restarted := &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	assert.NoError(t, restarted.Load(context.TODO()))
+	assert.Empty(t, restarted.Gauge)
+
+	// The temporary file of a snapshot never replaces the storage file.
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	bad := &FileStorage{FileStoragePath: filepath.Join(t.TempDir(), "missing", "metrics.json")}
+	assert.Error(t, bad.Load(context.TODO()))
+}
+
+func TestFileStorage_Recover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	newStorage := func() *FileStorage {
+		return &FileStorage{FileStoragePath: path, Gauge: make(map[string]float64), Counter: make(map[string]int64)}
+	}
+	f := newStorage()
+	f.Gauge["Alloc"] = 1.5
+	f.Gauge["Buck"] = 2
+	f.Counter["PollCount"] = 7
+	assert.NoError(t, f.FullDump())
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	// A record damaged in place and a truncated last record.
+	damaged := []byte(strings.Replace(string(data), `"Alloc"`, `"Alxoc"`, 1))
+	damaged = damaged[:len(damaged)-5]
+	assert.NoError(t, os.WriteFile(path, damaged, 0666))
+
+	restarted := newStorage()
+	err = restarted.Load(context.TODO())
+	assert.True(t, errors.Is(err, ErrDamaged), err)
+	assert.Empty(t, restarted.Gauge)
+
+	report, err := restarted.Recover(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Metrics)
+	assert.Equal(t, []string{"record 1: checksum mismatch", "record 3: checksum mismatch"}, report.Problems)
+	assert.Equal(t, map[string]float64{"Buck": 2}, restarted.Gauge)
+	assert.Empty(t, restarted.Counter)
+
+	// The damaged file is kept and replaced with the recovered metrics.
+	assert.Len(t, report.Quarantined, 1)
+	assert.True(t, strings.HasPrefix(report.Quarantined[0], path+QuarantineSuffix))
+	kept, err := os.ReadFile(report.Quarantined[0])
+	assert.NoError(t, err)
+	assert.Equal(t, damaged, kept)
+	report, err = VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 1}, report)
+
+	// Whole records cut off are detected by the count in the header.
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines[:3], "")), 0666))
+	report, err = VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 2, Problems: []string{"3 records expected, 2 found"}}, report)
+
+	// Recovering an intact file changes nothing.
+	assert.NoError(t, f.FullDump())
+	report, err = newStorage().Recover(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, FileReport{Metrics: 3}, report)
+}
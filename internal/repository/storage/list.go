@@ -0,0 +1,22 @@
+package storage
+
+import (
+	me "github.com/Vidkin/metrics/internal/metric"
+)
+
+// listMetrics selects a page of the metrics of the memory and file storages.
+// It must be called with the mutex of the storage held.
+func listMetrics(gauges map[string]float64, counters map[string]int64, filter me.Filter) []*me.Metric {
+	metrics := make([]*me.Metric, 0, len(gauges)+len(counters))
+	if filter.Type == "" || filter.Type == MetricTypeGauge {
+		for k, v := range gauges {
+			metrics = append(metrics, &me.Metric{ID: k, Value: &v, MType: MetricTypeGauge})
+		}
+	}
+	if filter.Type == "" || filter.Type == MetricTypeCounter {
+		for k, v := range counters {
+			metrics = append(metrics, &me.Metric{ID: k, Delta: &v, MType: MetricTypeCounter})
+		}
+	}
+	return filter.Apply(metrics)
+}
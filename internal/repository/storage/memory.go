@@ -4,21 +4,29 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/silence"
 )
 
 const (
-	MetricTypeCounter = "counter"
-	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = me.TypeCounter
+	MetricTypeGauge   = me.TypeGauge
 )
 
+// ErrMetricNotFound means the repository has no metric with the type and
+// name. The Postgres storage returns sql.ErrNoRows instead.
+var ErrMetricNotFound = errors.New("metric not found")
+
 type MemoryStorage struct {
 	Gauge          map[string]float64
 	Counter        map[string]int64
 	GaugeMetrics   []*me.Metric
 	CounterMetrics []*me.Metric
 	AllMetrics     []*me.Metric
+	updated        updateTimes
+	silences       silenceSet
 	mu             sync.RWMutex
 }
 
@@ -34,6 +42,7 @@ func (m *MemoryStorage) UpdateMetric(_ context.Context, metric *me.Metric) error
 	default:
 		return errors.New("unknown metric type")
 	}
+	m.updated.touch(metric.MType, metric.ID, time.Now())
 	return nil
 }
 
@@ -41,6 +50,7 @@ func (m *MemoryStorage) UpdateMetrics(_ context.Context, metrics *[]me.Metric) e
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	now := time.Now()
 	for _, metric := range *metrics {
 		switch metric.MType {
 		case MetricTypeGauge:
@@ -50,6 +60,7 @@ func (m *MemoryStorage) UpdateMetrics(_ context.Context, metrics *[]me.Metric) e
 		default:
 			return errors.New("unknown metric type")
 		}
+		m.updated.touch(metric.MType, metric.ID, now)
 	}
 	return nil
 }
@@ -66,9 +77,39 @@ func (m *MemoryStorage) DeleteMetric(_ context.Context, mType string, name strin
 	default:
 		return errors.New("unknown metric type")
 	}
+	m.updated.forget(mType, name)
 	return nil
 }
 
+func (m *MemoryStorage) GetUpdateTimes(_ context.Context) ([]SeriesTime, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.updated.collect(m.Gauge, m.Counter, time.Now()), nil
+}
+
+func (m *MemoryStorage) SaveSilence(_ context.Context, s silence.Silence) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.silences.save(s)
+	return nil
+}
+
+func (m *MemoryStorage) DeleteSilence(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.silences.delete(id)
+}
+
+func (m *MemoryStorage) GetSilences(_ context.Context) ([]silence.Silence, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.silences.list(), nil
+}
+
 func (m *MemoryStorage) GetMetric(_ context.Context, mType string, name string) (*me.Metric, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -78,7 +119,7 @@ func (m *MemoryStorage) GetMetric(_ context.Context, mType string, name string)
 	case MetricTypeGauge:
 		v, ok := m.Gauge[name]
 		if !ok {
-			return nil, errors.New("metric not found")
+			return nil, ErrMetricNotFound
 		}
 		metric.ID = name
 		metric.MType = MetricTypeGauge
@@ -86,7 +127,7 @@ func (m *MemoryStorage) GetMetric(_ context.Context, mType string, name string)
 	case MetricTypeCounter:
 		v, ok := m.Counter[name]
 		if !ok {
-			return nil, errors.New("metric not found")
+			return nil, ErrMetricNotFound
 		}
 		metric.ID = name
 		metric.MType = MetricTypeCounter
@@ -110,6 +151,12 @@ func (m *MemoryStorage) GetMetrics(ctx context.Context) ([]*me.Metric, error) {
 	return m.AllMetrics, nil
 }
 
+func (m *MemoryStorage) ListMetrics(_ context.Context, filter me.Filter) ([]*me.Metric, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return listMetrics(m.Gauge, m.Counter, filter), nil
+}
+
 func (m *MemoryStorage) GetGauges(_ context.Context) ([]*me.Metric, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -3,10 +3,12 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/silence"
 )
 
 func TestMemoryStorage_UpdateMetric(t *testing.T) {
@@ -371,3 +373,118 @@ func TestMemoryStorage_UpdateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryStorage_GetUpdateTimes(t *testing.T) {
+	floatValue := 16.4
+	intValue := int64(12)
+	m := &MemoryStorage{
+		Gauge:   make(map[string]float64),
+		Counter: make(map[string]int64),
+	}
+	m.Gauge["untracked"] = 1
+
+	before := time.Now()
+	assert.NoError(t, m.UpdateMetric(context.TODO(), &me.Metric{ID: "gaugeTest", MType: MetricTypeGauge, Value: &floatValue}))
+	assert.NoError(t, m.UpdateMetrics(context.TODO(), &[]me.Metric{{ID: "counterTest", MType: MetricTypeCounter, Delta: &intValue}}))
+
+	series, err := m.GetUpdateTimes(context.TODO())
+	assert.NoError(t, err)
+	assert.Len(t, series, 3)
+	for _, st := range series {
+		assert.False(t, st.Updated.Before(before), st.ID)
+	}
+
+	assert.NoError(t, m.DeleteMetric(context.TODO(), MetricTypeGauge, "gaugeTest"))
+	series, err = m.GetUpdateTimes(context.TODO())
+	assert.NoError(t, err)
+	assert.Len(t, series, 2)
+	assert.NotContains(t, m.updated, seriesKey(MetricTypeGauge, "gaugeTest"))
+}
+
+func TestMemoryStorage_ListMetrics(t *testing.T) {
+	m := &MemoryStorage{
+		Gauge:   map[string]float64{"HeapAlloc": 1, "HeapInuse": 2, "Alloc": 3, "Heap_X": 4},
+		Counter: map[string]int64{"HeapAlloc": 5, "PollCount": 6},
+	}
+	names := func(metrics []*me.Metric) []string {
+		var res []string
+		for _, mt := range metrics {
+			res = append(res, mt.MType+"/"+mt.ID)
+		}
+		return res
+	}
+
+	page, err := m.ListMetrics(context.TODO(), me.Filter{Prefix: "Heap", Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"counter/HeapAlloc", "gauge/HeapAlloc"}, names(page))
+	page, err = m.ListMetrics(context.TODO(), me.Filter{Prefix: "Heap", Limit: 2, AfterID: "HeapAlloc", AfterType: MetricTypeGauge})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gauge/HeapInuse", "gauge/Heap_X"}, names(page))
+
+	page, err = m.ListMetrics(context.TODO(), me.Filter{Type: MetricTypeCounter})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"counter/HeapAlloc", "counter/PollCount"}, names(page))
+	assert.Equal(t, int64(5), *page[0].Delta)
+
+	page, err = m.ListMetrics(context.TODO(), me.Filter{Sort: me.SortType, Desc: true, Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gauge/Heap_X", "gauge/HeapInuse", "gauge/HeapAlloc"}, names(page))
+}
+
+func TestMemoryStorage_Silences(t *testing.T) {
+	m := &MemoryStorage{}
+	now := time.Now()
+	s1 := silence.Silence{ID: "s1", StartsAt: now.Add(time.Minute), EndsAt: now.Add(time.Hour)}
+	s2 := silence.Silence{ID: "s2", StartsAt: now, EndsAt: now.Add(time.Hour)}
+
+	assert.NoError(t, m.SaveSilence(context.TODO(), s1))
+	assert.NoError(t, m.SaveSilence(context.TODO(), s2))
+	silences, err := m.GetSilences(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []silence.Silence{s2, s1}, silences)
+
+	assert.NoError(t, m.DeleteSilence(context.TODO(), "s1"))
+	assert.ErrorIs(t, m.DeleteSilence(context.TODO(), "s1"), silence.ErrNotFound)
+	silences, err = m.GetSilences(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []silence.Silence{s2}, silences)
+}
+
+func TestMemoryStorage_SnapshotRestore(t *testing.T) {
+	floatValue := 2.5
+	intValue := int64(3)
+	m := &MemoryStorage{
+		Gauge:   map[string]float64{"Alloc": 1},
+		Counter: map[string]int64{"PollCount": 10},
+	}
+
+	snapshot, err := m.SnapshotMetrics(context.TODO())
+	assert.NoError(t, err)
+	assert.Len(t, snapshot, 2)
+
+	// Later updates don't change the snapshot.
+	assert.NoError(t, m.UpdateMetric(context.TODO(), &me.Metric{ID: "Alloc", MType: MetricTypeGauge, Value: &floatValue}))
+	assert.Equal(t, 1.0, *snapshot[0].Value)
+
+	// Restoring replaces all metrics and sets the counters.
+	assert.NoError(t, m.RestoreMetrics(context.TODO(), []me.Metric{
+		{ID: "HeapInuse", MType: MetricTypeGauge, Value: &floatValue},
+		{ID: "PollCount", MType: MetricTypeCounter, Delta: &intValue},
+		{ID: "NoValue", MType: MetricTypeGauge},
+	}))
+	assert.Equal(t, map[string]float64{"HeapInuse": 2.5}, m.Gauge)
+	assert.Equal(t, map[string]int64{"PollCount": 3}, m.Counter)
+	assert.Len(t, m.updated, 2)
+
+	// The series keep their update times through a snapshot and a restore.
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m.updated.touch(MetricTypeGauge, "HeapInuse", updated)
+	snapshot, err = m.SnapshotMetrics(context.TODO())
+	assert.NoError(t, err)
+	restored := make([]me.Metric, len(snapshot))
+	for i, metric := range snapshot {
+		restored[i] = *metric
+	}
+	assert.NoError(t, m.RestoreMetrics(context.TODO(), restored))
+	assert.Equal(t, updated, m.updated[seriesKey(MetricTypeGauge, "HeapInuse")])
+}
@@ -0,0 +1,13 @@
+CREATE TABLE audit_log (
+    entry_id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    client VARCHAR NOT NULL,
+    remote_addr VARCHAR NOT NULL,
+    operation VARCHAR NOT NULL,
+    metric_type VARCHAR NOT NULL,
+    metric_name VARCHAR NOT NULL,
+    old_value VARCHAR,
+    new_value VARCHAR
+);
+
+CREATE INDEX audit_log_metric_idx ON audit_log (metric_type, metric_name);
@@ -0,0 +1 @@
+DROP TABLE applied_batches;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE applied_batches (
+    batch_id VARCHAR PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX applied_batches_applied_at_idx ON applied_batches (applied_at);
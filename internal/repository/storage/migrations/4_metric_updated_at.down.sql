@@ -0,0 +1,3 @@
+ALTER TABLE gauge DROP COLUMN updated_at;
+
+ALTER TABLE counter DROP COLUMN updated_at;
@@ -0,0 +1,3 @@
+ALTER TABLE gauge ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE counter ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1 @@
+DROP TABLE silences;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE silences (
+    silence_id VARCHAR PRIMARY KEY,
+    matchers JSONB NOT NULL,
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_by VARCHAR NOT NULL DEFAULT '',
+    comment VARCHAR NOT NULL DEFAULT ''
+);
@@ -0,0 +1,3 @@
+DROP INDEX gauge_metric_name_idx;
+
+DROP INDEX counter_metric_name_idx;
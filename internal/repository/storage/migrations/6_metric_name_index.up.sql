@@ -0,0 +1,3 @@
+CREATE INDEX gauge_metric_name_idx ON gauge (metric_name COLLATE "C");
+
+CREATE INDEX counter_metric_name_idx ON counter (metric_name COLLATE "C");
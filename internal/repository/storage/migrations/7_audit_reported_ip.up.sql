@@ -0,0 +1 @@
+ALTER TABLE audit_log ADD COLUMN reported_ip VARCHAR;
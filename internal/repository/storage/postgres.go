@@ -4,7 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -12,16 +16,22 @@ import (
 
 	"github.com/Vidkin/metrics/internal/logger"
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/silence"
 )
 
 //go:embed migrations/*.sql
 var Migrations embed.FS
 
+// DefaultBatchRetention is how long applied batch IDs are kept if
+// PostgresStorage.BatchRetention is not set.
+const DefaultBatchRetention = 24 * time.Hour
+
 type PostgresStorage struct {
 	Conn           *sql.DB
 	GaugeMetrics   []*me.Metric
 	CounterMetrics []*me.Metric
 	AllMetrics     []*me.Metric
+	BatchRetention time.Duration
 }
 
 func (p *PostgresStorage) UpdateMetric(ctx context.Context, metric *me.Metric) error {
@@ -36,7 +46,7 @@ func (p *PostgresStorage) UpdateMetric(ctx context.Context, metric *me.Metric) e
 			logger.Log.Info("error get gauge metric", zap.Error(err))
 			return err
 		}
-		_, err = p.Conn.ExecContext(ctx, "UPDATE gauge SET metric_value=$1 WHERE metric_name=$2", *metric.Value, metric.ID)
+		_, err = p.Conn.ExecContext(ctx, "UPDATE gauge SET metric_value=$1, updated_at=now() WHERE metric_name=$2", *metric.Value, metric.ID)
 		return err
 	case MetricTypeCounter:
 		_, err := p.GetMetric(ctx, metric.MType, metric.ID)
@@ -48,7 +58,7 @@ func (p *PostgresStorage) UpdateMetric(ctx context.Context, metric *me.Metric) e
 			logger.Log.Info("error get counter metric", zap.Error(err))
 			return err
 		}
-		_, err = p.Conn.ExecContext(ctx, "UPDATE counter SET metric_value=metric_value+$1 WHERE metric_name=$2", *metric.Delta, metric.ID)
+		_, err = p.Conn.ExecContext(ctx, "UPDATE counter SET metric_value=metric_value+$1, updated_at=now() WHERE metric_name=$2", *metric.Delta, metric.ID)
 		return err
 	default:
 		return errors.New("unknown metric type")
@@ -62,6 +72,61 @@ func (p *PostgresStorage) UpdateMetrics(ctx context.Context, metrics *[]me.Metri
 		return err
 	}
 	defer tx.Rollback()
+	if err = updateMetricsTx(ctx, tx, metrics); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateMetricsOnce applies a batch of metrics unless a batch with the same
+// key has already been applied. The key, built with idempotency.Key, scopes
+// the batch ID by client. It is recorded in the same transaction as the
+// metrics, so a batch is applied at most once even across server restarts.
+// Keys older than BatchRetention are removed.
+//
+// It returns false if the batch has already been applied.
+func (p *PostgresStorage) UpdateMetricsOnce(ctx context.Context, batchKey string, metrics *[]me.Metric) (bool, error) {
+	tx, err := p.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Info("error begin tx", zap.Error(err))
+		return false, err
+	}
+	defer tx.Rollback()
+
+	retention := p.BatchRetention
+	if retention <= 0 {
+		retention = DefaultBatchRetention
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM applied_batches WHERE applied_at < $1", time.Now().Add(-retention))
+	if err != nil {
+		logger.Log.Info("error delete expired batches", zap.Error(err))
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO applied_batches (batch_id) VALUES ($1) ON CONFLICT (batch_id) DO NOTHING", batchKey)
+	if err != nil {
+		logger.Log.Info("error insert batch id", zap.Error(err))
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	if err = updateMetricsTx(ctx, tx, metrics); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func updateMetricsTx(ctx context.Context, tx *sql.Tx, metrics *[]me.Metric) error {
+	var err error
 	for _, metric := range *metrics {
 		switch metric.MType {
 		case MetricTypeGauge:
@@ -80,7 +145,7 @@ func (p *PostgresStorage) UpdateMetrics(ctx context.Context, metrics *[]me.Metri
 					return err
 				}
 			}
-			_, err = tx.ExecContext(ctx, "UPDATE gauge SET metric_value=$1 WHERE metric_id=$2", *metric.Value, metricID)
+			_, err = tx.ExecContext(ctx, "UPDATE gauge SET metric_value=$1, updated_at=now() WHERE metric_id=$2", *metric.Value, metricID)
 			if err != nil {
 				logger.Log.Info("error update gauge metric", zap.Error(err))
 				return err
@@ -101,7 +166,7 @@ func (p *PostgresStorage) UpdateMetrics(ctx context.Context, metrics *[]me.Metri
 					return err
 				}
 			}
-			_, err = tx.ExecContext(ctx, "UPDATE counter SET metric_value=metric_value+$1 WHERE metric_id=$2", *metric.Delta, metricID)
+			_, err = tx.ExecContext(ctx, "UPDATE counter SET metric_value=metric_value+$1, updated_at=now() WHERE metric_id=$2", *metric.Delta, metricID)
 			if err != nil {
 				logger.Log.Info("error update counter metric", zap.Error(err))
 				return err
@@ -111,7 +176,7 @@ func (p *PostgresStorage) UpdateMetrics(ctx context.Context, metrics *[]me.Metri
 			return errors.New("unknown metric type")
 		}
 	}
-	return tx.Commit()
+	return nil
 }
 
 func (p *PostgresStorage) DeleteMetric(ctx context.Context, mType string, name string) error {
@@ -263,6 +328,176 @@ func (p *PostgresStorage) GetCounters(ctx context.Context) ([]*me.Metric, error)
 	return p.CounterMetrics, nil
 }
 
+// ListMetrics selects a page of metrics with a single query, so the filter,
+// the order and the limit are applied by the database. Names are compared
+// byte-wise with the "C" collation, the same order the other storages use.
+func (p *PostgresStorage) ListMetrics(ctx context.Context, filter me.Filter) ([]*me.Metric, error) {
+	var (
+		selects []string
+		where   []string
+		args    []any
+	)
+	if filter.Type == "" || filter.Type == MetricTypeGauge {
+		selects = append(selects, "SELECT metric_name, 'gauge' AS metric_type, metric_value AS gauge_value, NULL::BIGINT AS counter_value FROM gauge")
+	}
+	if filter.Type == "" || filter.Type == MetricTypeCounter {
+		selects = append(selects, "SELECT metric_name, 'counter' AS metric_type, NULL::DOUBLE PRECISION AS gauge_value, metric_value AS counter_value FROM counter")
+	}
+	if len(selects) == 0 {
+		return nil, nil
+	}
+
+	name, mType := `metric_name COLLATE "C"`, `metric_type COLLATE "C"`
+	first, second := name, mType
+	if filter.Sort == me.SortType {
+		first, second = mType, name
+	}
+	op, order := ">", "ASC"
+	if filter.Desc {
+		op, order = "<", "DESC"
+	}
+
+	if filter.Prefix != "" {
+		args = append(args, likePrefix(filter.Prefix))
+		where = append(where, fmt.Sprintf("%s LIKE $%d", name, len(args)))
+	}
+	if filter.AfterID != "" {
+		afterFirst, afterSecond := filter.AfterID, filter.AfterType
+		if filter.Sort == me.SortType {
+			afterFirst, afterSecond = afterSecond, afterFirst
+		}
+		args = append(args, afterFirst, afterSecond)
+		where = append(where, fmt.Sprintf("(%s, %s) %s ($%d, $%d)", first, second, op, len(args)-1, len(args)))
+	}
+
+	query := "SELECT metric_name, metric_type, gauge_value, counter_value FROM (" +
+		strings.Join(selects, " UNION ALL ") + ") m"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, %s %s", first, order, second, order)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := p.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Info("error list metrics", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []*me.Metric
+	for rows.Next() {
+		var m me.Metric
+		if err = rows.Scan(&m.ID, &m.MType, &m.Value, &m.Delta); err != nil {
+			logger.Log.Info("error scan metric", zap.Error(err))
+			return nil, err
+		}
+		metrics = append(metrics, &m)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Info("error rows", zap.Error(err))
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// likePrefix returns a LIKE pattern that matches the strings with the prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+func (p *PostgresStorage) GetUpdateTimes(ctx context.Context) ([]SeriesTime, error) {
+	var series []SeriesTime
+	for _, mType := range []string{MetricTypeGauge, MetricTypeCounter} {
+		rows, err := p.Conn.QueryContext(ctx, "SELECT metric_name, updated_at FROM "+mType)
+		if err != nil {
+			logger.Log.Info("error select update times", zap.Error(err))
+			return nil, err
+		}
+		for rows.Next() {
+			st := SeriesTime{MType: mType}
+			if err = rows.Scan(&st.ID, &st.Updated); err != nil {
+				rows.Close()
+				logger.Log.Info("error scan update time", zap.Error(err))
+				return nil, err
+			}
+			series = append(series, st)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return series, nil
+}
+
+func (p *PostgresStorage) SaveSilence(ctx context.Context, s silence.Silence) error {
+	matchers, err := json.Marshal(s.Matchers)
+	if err != nil {
+		return err
+	}
+	_, err = p.Conn.ExecContext(ctx,
+		`INSERT INTO silences (silence_id, matchers, starts_at, ends_at, created_at, created_by, comment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (silence_id) DO UPDATE SET matchers = $2, starts_at = $3, ends_at = $4, created_by = $6, comment = $7`,
+		s.ID, matchers, s.StartsAt, s.EndsAt, s.CreatedAt, s.CreatedBy, s.Comment)
+	if err != nil {
+		logger.Log.Info("error save silence", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (p *PostgresStorage) DeleteSilence(ctx context.Context, id string) error {
+	res, err := p.Conn.ExecContext(ctx, "DELETE FROM silences WHERE silence_id = $1", id)
+	if err != nil {
+		logger.Log.Info("error delete silence", zap.Error(err))
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return silence.ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStorage) GetSilences(ctx context.Context) ([]silence.Silence, error) {
+	rows, err := p.Conn.QueryContext(ctx,
+		"SELECT silence_id, matchers, starts_at, ends_at, created_at, created_by, comment FROM silences ORDER BY starts_at, silence_id")
+	if err != nil {
+		logger.Log.Info("error select silences", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	silences := make([]silence.Silence, 0)
+	for rows.Next() {
+		var (
+			s        silence.Silence
+			matchers []byte
+		)
+		if err = rows.Scan(&s.ID, &matchers, &s.StartsAt, &s.EndsAt, &s.CreatedAt, &s.CreatedBy, &s.Comment); err != nil {
+			logger.Log.Info("error scan silence", zap.Error(err))
+			return nil, err
+		}
+		if err = json.Unmarshal(matchers, &s.Matchers); err != nil {
+			return nil, err
+		}
+		silences = append(silences, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
 func (p *PostgresStorage) Ping(ctx context.Context) error {
 	return p.Conn.PingContext(ctx)
 }
@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/silence"
 )
 
 func TestPostgresStorage_Ping(t *testing.T) {
@@ -38,13 +40,15 @@ func TestPostgresStorage_Ping(t *testing.T) {
 		`CREATE TABLE gauge (
 			metric_id SERIAL PRIMARY KEY,
 			metric_name VARCHAR NOT NULL,
-			metric_value DOUBLE PRECISION NOT NULL
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	
 		 CREATE TABLE counter (
 		    metric_id SERIAL PRIMARY KEY,
 		    metric_name VARCHAR NOT NULL,
-		    metric_value BIGINT NOT NULL
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`)
 	if err != nil {
 		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
@@ -172,13 +176,15 @@ func TestPostgresStorage_Metric(t *testing.T) {
 		`CREATE TABLE gauge (
 			metric_id SERIAL PRIMARY KEY,
 			metric_name VARCHAR NOT NULL,
-			metric_value DOUBLE PRECISION NOT NULL
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	
 		 CREATE TABLE counter (
 		    metric_id SERIAL PRIMARY KEY,
 		    metric_name VARCHAR NOT NULL,
-		    metric_value BIGINT NOT NULL
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`)
 	if err != nil {
 		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
@@ -263,13 +269,15 @@ func TestPostgresStorage_DeleteMetric(t *testing.T) {
 		`CREATE TABLE gauge (
 			metric_id SERIAL PRIMARY KEY,
 			metric_name VARCHAR NOT NULL,
-			metric_value DOUBLE PRECISION NOT NULL
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	
 		 CREATE TABLE counter (
 		    metric_id SERIAL PRIMARY KEY,
 		    metric_name VARCHAR NOT NULL,
-		    metric_value BIGINT NOT NULL
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`)
 	if err != nil {
 		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
@@ -352,13 +360,15 @@ func TestPostgresStorage_GetMetric(t *testing.T) {
 		`CREATE TABLE gauge (
 			metric_id SERIAL PRIMARY KEY,
 			metric_name VARCHAR NOT NULL,
-			metric_value DOUBLE PRECISION NOT NULL
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	
 		 CREATE TABLE counter (
 		    metric_id SERIAL PRIMARY KEY,
 		    metric_name VARCHAR NOT NULL,
-		    metric_value BIGINT NOT NULL
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`)
 	if err != nil {
 		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
@@ -432,13 +442,15 @@ func TestPostgresStorage_GetMetrics(t *testing.T) {
 		`CREATE TABLE gauge (
 			metric_id SERIAL PRIMARY KEY,
 			metric_name VARCHAR NOT NULL,
-			metric_value DOUBLE PRECISION NOT NULL
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	
 		 CREATE TABLE counter (
 		    metric_id SERIAL PRIMARY KEY,
 		    metric_name VARCHAR NOT NULL,
-		    metric_value BIGINT NOT NULL
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`)
 	if err != nil {
 		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
@@ -549,13 +561,15 @@ func TestPostgresStorage_UpdateMetrics(t *testing.T) {
 		`CREATE TABLE gauge (
 			metric_id SERIAL PRIMARY KEY,
 			metric_name VARCHAR NOT NULL,
-			metric_value DOUBLE PRECISION NOT NULL
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
 	
 		 CREATE TABLE counter (
 		    metric_id SERIAL PRIMARY KEY,
 		    metric_name VARCHAR NOT NULL,
-		    metric_value BIGINT NOT NULL
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`)
 	if err != nil {
 		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
@@ -598,3 +612,172 @@ func TestPostgresStorage_UpdateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestPostgresStorage_UpdateMetricsOnce(t *testing.T) {
+	dbDSN := "user=postgres password=postgres dbname=postgres host=127.0.0.1 port=5432 sslmode=disable"
+	adminDB, err := sql.Open("pgx", dbDSN)
+	if err != nil {
+		t.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer adminDB.Close()
+
+	var pgStorage PostgresStorage
+	pgStorage.Conn = adminDB
+
+	_, err = adminDB.Exec(
+		`CREATE TABLE gauge (
+			metric_id SERIAL PRIMARY KEY,
+			metric_name VARCHAR NOT NULL,
+			metric_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		 CREATE TABLE counter (
+		    metric_id SERIAL PRIMARY KEY,
+		    metric_name VARCHAR NOT NULL,
+		    metric_value BIGINT NOT NULL,
+		    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		 CREATE TABLE applied_batches (
+		    batch_id VARCHAR PRIMARY KEY,
+		    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`)
+	if err != nil {
+		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
+	}
+
+	defer func() {
+		_, dropErr := adminDB.Exec("DROP TABLE gauge; DROP TABLE counter; DROP TABLE applied_batches;")
+		if dropErr != nil {
+			fmt.Printf("Ошибка удаления таблиц БД: %v\n", dropErr)
+		}
+	}()
+
+	delta := int64(5)
+	metrics := &[]me.Metric{{ID: "counterTest", MType: MetricTypeCounter, Delta: &delta}}
+
+	applied, err := pgStorage.UpdateMetricsOnce(context.TODO(), "batch1", metrics)
+	assert.NoError(t, err)
+	assert.True(t, applied)
+
+	applied, err = pgStorage.UpdateMetricsOnce(context.TODO(), "batch1", metrics)
+	assert.NoError(t, err)
+	assert.False(t, applied)
+
+	m, err := pgStorage.GetMetric(context.TODO(), MetricTypeCounter, "counterTest")
+	assert.NoError(t, err)
+	if assert.NotNil(t, m) {
+		assert.Equal(t, int64(5), *m.Delta)
+	}
+}
+
+func TestPostgresStorage_ListMetrics(t *testing.T) {
+	dbDSN := "user=postgres password=postgres dbname=postgres host=127.0.0.1 port=5432 sslmode=disable"
+	adminDB, err := sql.Open("pgx", dbDSN)
+	if err != nil {
+		t.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer adminDB.Close()
+
+	var pgStorage PostgresStorage
+	pgStorage.Conn = adminDB
+
+	_, err = adminDB.Exec(
+		`CREATE TABLE gauge (
+			metric_id SERIAL PRIMARY KEY,
+			metric_name VARCHAR NOT NULL,
+			metric_value DOUBLE PRECISION NOT NULL
+		);
+		CREATE TABLE counter (
+			metric_id SERIAL PRIMARY KEY,
+			metric_name VARCHAR NOT NULL,
+			metric_value BIGINT NOT NULL
+		);
+		INSERT INTO gauge (metric_name, metric_value) VALUES ('HeapAlloc', 1), ('HeapInuse', 2), ('Alloc', 3), ('Heap_X', 4);
+		INSERT INTO counter (metric_name, metric_value) VALUES ('HeapAlloc', 5), ('PollCount', 6);`)
+	if err != nil {
+		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
+	}
+
+	defer func() {
+		_, dropErr := adminDB.Exec("DROP TABLE gauge; DROP TABLE counter;")
+		if dropErr != nil {
+			fmt.Printf("Ошибка удаления таблиц БД: %v\n", dropErr)
+		}
+	}()
+
+	mem := &MemoryStorage{
+		Gauge:   map[string]float64{"HeapAlloc": 1, "HeapInuse": 2, "Alloc": 3, "Heap_X": 4},
+		Counter: map[string]int64{"HeapAlloc": 5, "PollCount": 6},
+	}
+	filters := []me.Filter{
+		{Prefix: "Heap", Limit: 2},
+		{Prefix: "Heap_"},
+		{Prefix: "Heap", Limit: 2, AfterID: "HeapAlloc", AfterType: MetricTypeGauge},
+		{Type: MetricTypeCounter},
+		{Sort: me.SortType, Desc: true, Limit: 3},
+		{Sort: me.SortType, AfterID: "PollCount", AfterType: MetricTypeCounter},
+	}
+	for _, filter := range filters {
+		want, err := mem.ListMetrics(context.TODO(), filter)
+		assert.NoError(t, err)
+		got, err := pgStorage.ListMetrics(context.TODO(), filter)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, "%+v", filter)
+	}
+}
+
+func TestPostgresStorage_Silences(t *testing.T) {
+	dbDSN := "user=postgres password=postgres dbname=postgres host=127.0.0.1 port=5432 sslmode=disable"
+	adminDB, err := sql.Open("pgx", dbDSN)
+	if err != nil {
+		t.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer adminDB.Close()
+
+	var pgStorage PostgresStorage
+	pgStorage.Conn = adminDB
+
+	_, err = adminDB.Exec(
+		`CREATE TABLE silences (
+			silence_id VARCHAR PRIMARY KEY,
+			matchers JSONB NOT NULL,
+			starts_at TIMESTAMPTZ NOT NULL,
+			ends_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			created_by VARCHAR NOT NULL DEFAULT '',
+			comment VARCHAR NOT NULL DEFAULT ''
+		);`)
+	if err != nil {
+		fmt.Printf("Ошибка создания таблиц БД: %v\n", err)
+	}
+
+	defer func() {
+		_, dropErr := adminDB.Exec("DROP TABLE silences;")
+		if dropErr != nil {
+			fmt.Printf("Ошибка удаления таблиц БД: %v\n", dropErr)
+		}
+	}()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s := silence.Silence{
+		ID:        "s1",
+		Matchers:  []silence.Matcher{{Name: "metric", Value: "CPU*"}},
+		StartsAt:  now,
+		EndsAt:    now.Add(time.Hour),
+		CreatedAt: now,
+		CreatedBy: "admin",
+	}
+	assert.NoError(t, pgStorage.SaveSilence(context.TODO(), s))
+
+	silences, err := pgStorage.GetSilences(context.TODO())
+	assert.NoError(t, err)
+	if assert.Len(t, silences, 1) {
+		assert.Equal(t, s.Matchers, silences[0].Matchers)
+		assert.True(t, s.EndsAt.Equal(silences[0].EndsAt))
+	}
+
+	assert.NoError(t, pgStorage.DeleteSilence(context.TODO(), "s1"))
+	assert.ErrorIs(t, pgStorage.DeleteSilence(context.TODO(), "s1"), silence.ErrNotFound)
+}
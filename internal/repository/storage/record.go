@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"time"
+
+	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/atomicfile"
+)
+
+// Every line of the storage file and of its write-ahead log is a record: the
+// CRC-32C checksum of a JSON value as eight hex digits, a space and the
+// value. A damaged record fails its checksum and is skipped alone, the
+// records around it are still read. The storage file starts with a header
+// record with the number of metric records that follow, so a truncated file
+// is detected too. Storage files of older versions are JSON arrays of
+// metrics, they are still read.
+
+// snapshotFormat identifies storage files of records.
+const snapshotFormat = "metrics/v2"
+
+// QuarantineSuffix is appended, with the time, to the path of a damaged file
+// to get the path of the copy kept when it is recovered.
+const QuarantineSuffix = ".corrupt-"
+
+// ErrDamaged means the storage file or its write-ahead log has bad records.
+var ErrDamaged = errors.New("storage file is damaged")
+
+// errChecksum means a record doesn't match its checksum.
+var errChecksum = errors.New("checksum mismatch")
+
+// castagnoli is the CRC-32C table of the checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshotHeader is the first record of the storage file.
+type snapshotHeader struct {
+	Format  string `json:"format"`
+	Metrics int    `json:"metrics"`
+}
+
+// appendRecord appends the record of a value to buf.
+func appendRecord(buf []byte, v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	buf = fmt.Appendf(buf, "%08x ", crc32.Checksum(b, castagnoli))
+	buf = append(buf, b...)
+	return append(buf, '\n'), nil
+}
+
+// parseRecord checks the checksum of a record and decodes its value into v.
+func parseRecord(line []byte, v any) error {
+	sum, value, ok := bytes.Cut(line, []byte{' '})
+	if !ok || len(sum) != 8 {
+		return errors.New("malformed record")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return errors.New("malformed record")
+	}
+	if crc32.Checksum(value, castagnoli) != uint32(want) {
+		return errChecksum
+	}
+	return json.Unmarshal(value, v)
+}
+
+// encodeSnapshot returns the contents of a storage file with the metrics.
+func encodeSnapshot(metrics []*me.Metric) ([]byte, error) {
+	buf, err := appendRecord(nil, snapshotHeader{Format: snapshotFormat, Metrics: len(metrics)})
+	if err != nil {
+		return nil, err
+	}
+	for _, metric := range metrics {
+		if buf, err = appendRecord(buf, metric); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// checkMetric returns the problem of a bad metric record, or an empty
+// string.
+func checkMetric(metric me.Metric) string {
+	switch {
+	case metric.ID == "":
+		return "empty metric name"
+	case metric.MType == MetricTypeGauge && metric.Value == nil, metric.MType == MetricTypeCounter && metric.Delta == nil:
+		return fmt.Sprintf("%s %q has no value", metric.MType, metric.ID)
+	case metric.MType != MetricTypeGauge && metric.MType != MetricTypeCounter:
+		return fmt.Sprintf("metric %q has unknown type %q", metric.ID, metric.MType)
+	}
+	return ""
+}
+
+// storageContents is what the storage file and its write-ahead log hold.
+//
+// Fields:
+//   - gauges, counters: The metrics of the valid records.
+//   - updated: The update times of the series, if their records have them.
+//   - damaged: The paths of the files with bad records.
+type storageContents struct {
+	gauges   map[string]float64
+	counters map[string]int64
+	updated  updateTimes
+	damaged  []string
+}
+
+// problem adds a problem to the report.
+func problem(report *FileReport, format string, args ...any) {
+	report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+}
+
+// add adds a metric of a record to the contents. A bad metric, or in the
+// storage file a metric that appeared before, is reported instead.
+func (c *storageContents) add(report *FileReport, record string, metric me.Metric, unique bool) {
+	if p := checkMetric(metric); p != "" {
+		problem(report, "%s: %s", record, p)
+		return
+	}
+	if metric.MType == MetricTypeGauge {
+		if _, ok := c.gauges[metric.ID]; ok && unique {
+			problem(report, "%s: %s %q appears twice", record, metric.MType, metric.ID)
+			return
+		}
+		c.gauges[metric.ID] = *metric.Value
+	} else {
+		if _, ok := c.counters[metric.ID]; ok && unique {
+			problem(report, "%s: %s %q appears twice", record, metric.MType, metric.ID)
+			return
+		}
+		c.counters[metric.ID] = *metric.Delta
+	}
+	if metric.Updated != nil {
+		c.updated.touch(metric.MType, metric.ID, *metric.Updated)
+	} else {
+		c.updated.forget(metric.MType, metric.ID)
+	}
+}
+
+// readSnapshot reads the records of a storage file.
+func (c *storageContents) readSnapshot(data []byte, report *FileReport) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return
+	}
+	if trimmed[0] == '[' {
+		c.readLegacySnapshot(trimmed, report)
+		return
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'})
+	var header snapshotHeader
+	if err := parseRecord(lines[0], &header); err != nil {
+		problem(report, "header: %v", err)
+		header.Metrics = -1
+	} else if header.Format != snapshotFormat {
+		problem(report, "header: unknown format %q", header.Format)
+		header.Metrics = -1
+	}
+	for i, line := range lines[1:] {
+		var metric me.Metric
+		if err := parseRecord(line, &metric); err != nil {
+			problem(report, "record %d: %v", i+1, err)
+			continue
+		}
+		c.add(report, fmt.Sprintf("record %d", i+1), metric, true)
+	}
+	if header.Metrics >= 0 && header.Metrics != len(lines)-1 {
+		problem(report, "%d records expected, %d found", header.Metrics, len(lines)-1)
+	}
+}
+
+// readLegacySnapshot reads a storage file of an older version, a JSON array
+// of metrics. A record of a wrong type is skipped, but a syntax error ends
+// the array.
+func (c *storageContents) readLegacySnapshot(data []byte, report *FileReport) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		problem(report, "record 1: %v", err)
+		return
+	}
+	i := 0
+	for dec.More() {
+		i++
+		var metric me.Metric
+		if err := dec.Decode(&metric); err != nil {
+			problem(report, "record %d: %v", i, err)
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				continue
+			}
+			return
+		}
+		c.add(report, fmt.Sprintf("record %d", i), metric, true)
+	}
+	if _, err := dec.Token(); err != nil {
+		problem(report, "record %d: %v", i+1, err)
+	}
+}
+
+// readWAL reads the records of the write-ahead log of a storage file. A log
+// of another storage file is stale and not read. A last record cut short by
+// a crash is reported, but it was never acknowledged, so it doesn't make the
+// log damaged.
+func (c *storageContents) readWAL(data, snapshot []byte, report *FileReport) bool {
+	if len(data) == 0 {
+		return false
+	}
+	log, err := parseWAL(data)
+	if err != nil {
+		problem(report, "wal header: %v", err)
+		return true
+	}
+	if log.Header != headerOf(snapshot) {
+		return false
+	}
+	damaged := false
+	for i, record := range log.Records {
+		var metric me.Metric
+		if err = parseRecord(record, &metric); err != nil {
+			problem(report, "wal record %d: %v", i+1, err)
+			damaged = true
+			continue
+		}
+		before := len(report.Problems)
+		c.add(report, fmt.Sprintf("wal record %d", i+1), metric, false)
+		damaged = damaged || len(report.Problems) > before
+	}
+	if log.Torn {
+		problem(report, "wal record %d: incomplete", len(log.Records)+1)
+	}
+	return damaged
+}
+
+// readStorage reads the valid records of a storage file and its write-ahead
+// log and reports the bad ones. A missing file holds no records.
+func readStorage(path string) (storageContents, FileReport, error) {
+	c := storageContents{gauges: make(map[string]float64), counters: make(map[string]int64)}
+	var report FileReport
+	snapshot, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return c, report, err
+	}
+	c.readSnapshot(snapshot, &report)
+	if len(report.Problems) > 0 {
+		c.damaged = append(c.damaged, path)
+	}
+
+	data, err := os.ReadFile(path + WALFileSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return c, report, err
+	}
+	if c.readWAL(data, snapshot, &report) {
+		c.damaged = append(c.damaged, path+WALFileSuffix)
+	}
+	report.Metrics = len(c.gauges) + len(c.counters)
+	return c, report, nil
+}
+
+// quarantine keeps copies of damaged files next to them, named after the
+// time they were found.
+func quarantine(paths []string, at time.Time) ([]string, error) {
+	copies := make([]string, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return copies, err
+		}
+		name := path + QuarantineSuffix + at.UTC().Format("20060102T150405Z")
+		if err = atomicfile.WriteFile(name, data); err != nil {
+			return copies, err
+		}
+		copies = append(copies, name)
+	}
+	return copies, nil
+}
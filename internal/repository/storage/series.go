@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"time"
+
+	me "github.com/Vidkin/metrics/internal/metric"
+)
+
+// SeriesTime is the time a series was last updated.
+type SeriesTime struct {
+	Updated time.Time
+	ID      string
+	MType   string
+}
+
+// updateTimes tracks the last update time of series in the memory and file
+// storages. It must be guarded by the mutex of the storage.
+type updateTimes map[string]time.Time
+
+func seriesKey(mType, name string) string {
+	return mType + "/" + name
+}
+
+func (u *updateTimes) touch(mType, name string, at time.Time) {
+	if *u == nil {
+		*u = make(updateTimes)
+	}
+	(*u)[seriesKey(mType, name)] = at
+}
+
+// at returns the update time of a series, or now if it is unknown.
+func (u updateTimes) at(mType, name string, now time.Time) time.Time {
+	if at, ok := u[seriesKey(mType, name)]; ok {
+		return at
+	}
+	return now
+}
+
+func (u updateTimes) forget(mType, name string) {
+	delete(u, seriesKey(mType, name))
+}
+
+// collect returns the update times of all series. Series whose update time
+// is unknown, e.g. because they were put into the maps directly, are treated
+// as updated now.
+func (u *updateTimes) collect(gauges map[string]float64, counters map[string]int64, now time.Time) []SeriesTime {
+	series := make([]SeriesTime, 0, len(gauges)+len(counters))
+	add := func(mType, name string) {
+		at, ok := (*u)[seriesKey(mType, name)]
+		if !ok {
+			u.touch(mType, name, now)
+			at = now
+		}
+		series = append(series, SeriesTime{Updated: at, ID: name, MType: mType})
+	}
+	for name := range gauges {
+		add(MetricTypeGauge, name)
+	}
+	for name := range counters {
+		add(MetricTypeCounter, name)
+	}
+	return series
+}
+
+// stamp sets the update times of the metrics whose series have one.
+func (u updateTimes) stamp(metrics []*me.Metric) {
+	for _, m := range metrics {
+		if at, ok := u[seriesKey(m.MType, m.ID)]; ok {
+			m.Updated = &at
+		}
+	}
+}
+
+// updatedAt returns the update time of a metric, or now if it has none, e.g.
+// because it was read from a file of an older version.
+func updatedAt(m me.Metric, now time.Time) time.Time {
+	if m.Updated != nil {
+		return *m.Updated
+	}
+	return now
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/Vidkin/metrics/internal/silence"
+)
+
+// silenceSet holds the silences of the memory and file storages. It must be
+// guarded by the mutex of the storage.
+type silenceSet map[string]silence.Silence
+
+func (s *silenceSet) save(sl silence.Silence) {
+	if *s == nil {
+		*s = make(silenceSet)
+	}
+	(*s)[sl.ID] = sl
+}
+
+func (s silenceSet) delete(id string) error {
+	if _, ok := s[id]; !ok {
+		return silence.ErrNotFound
+	}
+	delete(s, id)
+	return nil
+}
+
+// list returns the silences sorted by start time and ID.
+func (s silenceSet) list() []silence.Silence {
+	list := make([]silence.Silence, 0, len(s))
+	for _, sl := range s {
+		list = append(list, sl)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].StartsAt.Equal(list[j].StartsAt) {
+			return list[i].StartsAt.Before(list[j].StartsAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
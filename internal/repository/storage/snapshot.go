@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	me "github.com/Vidkin/metrics/internal/metric"
+)
+
+// snapshotMaps copies the metrics of the memory and file storages with the
+// update times of their series. It must be called with the mutex of the
+// storage held.
+func snapshotMaps(gauges map[string]float64, counters map[string]int64, updated updateTimes) []*me.Metric {
+	metrics := listMetrics(gauges, counters, me.Filter{})
+	updated.stamp(metrics)
+	return metrics
+}
+
+// restoreMaps replaces the metrics of the memory and file storages. The
+// restored series keep their update times, metrics without one count as
+// updated now. Metrics without a value are skipped. It must be called with
+// the mutex of the storage held for writing.
+func restoreMaps(gauges map[string]float64, counters map[string]int64, updated *updateTimes, metrics []me.Metric) {
+	clear(gauges)
+	clear(counters)
+	*updated = nil
+	now := time.Now()
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == MetricTypeGauge && metric.Value != nil:
+			gauges[metric.ID] = *metric.Value
+		case metric.MType == MetricTypeCounter && metric.Delta != nil:
+			counters[metric.ID] = *metric.Delta
+		default:
+			continue
+		}
+		updated.touch(metric.MType, metric.ID, updatedAt(metric, now))
+	}
+}
+
+// SnapshotMetrics returns all metrics as of a single point in time: the
+// storage is locked once while they are copied.
+func (m *MemoryStorage) SnapshotMetrics(_ context.Context) ([]*me.Metric, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return snapshotMaps(m.Gauge, m.Counter, m.updated), nil
+}
+
+// RestoreMetrics replaces all metrics with the given ones at once. Counters
+// are set to the given totals.
+func (m *MemoryStorage) RestoreMetrics(_ context.Context, metrics []me.Metric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	restoreMaps(m.Gauge, m.Counter, &m.updated, metrics)
+	return nil
+}
+
+// SnapshotMetrics returns all metrics as of a single point in time: the
+// storage is locked once while they are copied.
+func (f *FileStorage) SnapshotMetrics(_ context.Context) ([]*me.Metric, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return snapshotMaps(f.Gauge, f.Counter, f.updated), nil
+}
+
+// RestoreMetrics replaces all metrics with the given ones at once. Counters
+// are set to the given totals. The file is not written, see FullDump.
+func (f *FileStorage) RestoreMetrics(_ context.Context, metrics []me.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	restoreMaps(f.Gauge, f.Counter, &f.updated, metrics)
+	return nil
+}
+
+// SnapshotMetrics returns all metrics with their update times as of a single
+// point in time: the gauges and the counters are read in one REPEATABLE READ
+// transaction, so updates committed while they are read are not seen.
+func (p *PostgresStorage) SnapshotMetrics(ctx context.Context) ([]*me.Metric, error) {
+	tx, err := p.Conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		logger.Log.Info("error begin tx", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var metrics []*me.Metric
+	gauges, err := tx.QueryContext(ctx, "SELECT metric_name, metric_value, updated_at FROM gauge")
+	if err != nil {
+		logger.Log.Info("error get gauges", zap.Error(err))
+		return nil, err
+	}
+	defer gauges.Close()
+	for gauges.Next() {
+		m := me.Metric{MType: MetricTypeGauge}
+		if err = gauges.Scan(&m.ID, &m.Value, &m.Updated); err != nil {
+			logger.Log.Info("error scan gauge metric", zap.Error(err))
+			return nil, err
+		}
+		metrics = append(metrics, &m)
+	}
+	if err = gauges.Err(); err != nil {
+		logger.Log.Info("error rows", zap.Error(err))
+		return nil, err
+	}
+
+	counters, err := tx.QueryContext(ctx, "SELECT metric_name, metric_value, updated_at FROM counter")
+	if err != nil {
+		logger.Log.Info("error get counters", zap.Error(err))
+		return nil, err
+	}
+	defer counters.Close()
+	for counters.Next() {
+		m := me.Metric{MType: MetricTypeCounter}
+		if err = counters.Scan(&m.ID, &m.Delta, &m.Updated); err != nil {
+			logger.Log.Info("error scan counter metric", zap.Error(err))
+			return nil, err
+		}
+		metrics = append(metrics, &m)
+	}
+	if err = counters.Err(); err != nil {
+		logger.Log.Info("error rows", zap.Error(err))
+		return nil, err
+	}
+	return metrics, tx.Commit()
+}
+
+// RestoreMetrics replaces all metrics with the given ones in one
+// transaction. Counters are set to the given totals. The restored series
+// keep their update times, metrics without one count as updated now.
+func (p *PostgresStorage) RestoreMetrics(ctx context.Context, metrics []me.Metric) error {
+	tx, err := p.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Info("error begin tx", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"gauge", "counter"} {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			logger.Log.Info("error delete metrics", zap.String("table", table), zap.Error(err))
+			return err
+		}
+	}
+	// The tables are empty, so adding the counters sets them.
+	if err = updateMetricsTx(ctx, tx, &metrics); err != nil {
+		return err
+	}
+	for _, metric := range metrics {
+		if metric.Updated == nil || (metric.MType != MetricTypeGauge && metric.MType != MetricTypeCounter) {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE "+metric.MType+" SET updated_at=$1 WHERE metric_name=$2", *metric.Updated, metric.ID)
+		if err != nil {
+			logger.Log.Info("error restore update time", zap.Error(err))
+			return err
+		}
+	}
+	return tx.Commit()
+}
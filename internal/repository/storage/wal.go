@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	me "github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/atomicfile"
+)
+
+// The file storage keeps its metrics in two files: the snapshot at
+// FileStoragePath, the records of all metrics, and the write-ahead log next
+// to it, which FileStorage.Dump appends every update to. The log starts with
+// a header record naming the snapshot it applies to, followed by one metric
+// record per update, each the value of its series after the update.
+// Replaying the log over the snapshot restores the state of the last update.
+//
+// FileStorage.FullDump compacts the two: it writes a new snapshot to a
+// temporary file, syncs it and renames it into place, which makes the old
+// log stale because it names the old snapshot, and then starts a new log. A
+// crash at any step leaves either the old snapshot with its log or the new
+// snapshot, never a partial file.
+
+// WALFileSuffix is appended to FileStoragePath to get the path of the
+// write-ahead log.
+const WALFileSuffix = ".wal"
+
+// WALCompactRecords is the number of records in the write-ahead log after
+// which FileStorage.Dump compacts it into a new snapshot.
+const WALCompactRecords = 10000
+
+// walHeader is the first record of the write-ahead log. It identifies the
+// snapshot the log applies to by its checksum and size.
+type walHeader struct {
+	Snapshot uint32 `json:"snapshot"`
+	Size     int64  `json:"size"`
+}
+
+// headerOf returns the header of the write-ahead log of a snapshot.
+func headerOf(snapshot []byte) walHeader {
+	return walHeader{Snapshot: crc32.Checksum(snapshot, castagnoli), Size: int64(len(snapshot))}
+}
+
+// walLog is a parsed write-ahead log.
+//
+// Fields:
+//   - Header: The header of the log.
+//   - Records: The records of the log, one per line.
+//   - Size: The size of the complete lines of the log. A line cut short by a
+//     crash is not counted.
+//   - Torn: Whether the last line was cut short by a crash.
+type walLog struct {
+	Records [][]byte
+	Header  walHeader
+	Size    int64
+	Torn    bool
+}
+
+// parseWAL splits a write-ahead log into its header and records. It fails if
+// the log has no valid header.
+func parseWAL(data []byte) (walLog, error) {
+	var log walLog
+	if end := bytes.LastIndexByte(data, '\n'); end+1 < len(data) {
+		log.Torn = true
+		data = data[:end+1]
+	}
+	log.Size = int64(len(data))
+	header, rest, _ := bytes.Cut(data, []byte{'\n'})
+	if err := parseRecord(header, &log.Header); err != nil {
+		return walLog{}, err
+	}
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte{'\n'})
+		log.Records = append(log.Records, line)
+	}
+	return log, nil
+}
+
+// resetWAL starts an empty write-ahead log for a snapshot. It must be called
+// with the mutex held.
+func (f *FileStorage) resetWAL(snapshot []byte) error {
+	b, err := appendRecord(nil, headerOf(snapshot))
+	if err != nil {
+		return err
+	}
+	if err = atomicfile.WriteFile(f.FileStoragePath+WALFileSuffix, b); err != nil {
+		logger.Log.Info("error write wal", zap.Error(err))
+		return err
+	}
+	f.walPath = f.FileStoragePath
+	f.walRecords = 0
+	return nil
+}
+
+// openWAL makes the write-ahead log ready for appending: a log of the
+// current snapshot is kept, without a line cut short by a crash, any other
+// log is replaced by an empty one. If the metrics weren't loaded from the
+// storage file, e.g. the server started without restoring them, the file is
+// compacted first: the log only holds the updated series, so appending to
+// it would bring back every series of the old snapshot on the next load. It
+// must be called with the mutex held.
+func (f *FileStorage) openWAL() error {
+	if f.walPath == f.FileStoragePath {
+		return nil
+	}
+	if f.loadedPath != f.FileStoragePath {
+		return f.compact()
+	}
+	snapshot, err := os.ReadFile(f.FileStoragePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Log.Info("error read file", zap.Error(err))
+		return err
+	}
+	data, err := os.ReadFile(f.FileStoragePath + WALFileSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Log.Info("error read wal", zap.Error(err))
+		return err
+	}
+	log, err := parseWAL(data)
+	if err != nil || log.Header != headerOf(snapshot) {
+		return f.resetWAL(snapshot)
+	}
+	if log.Torn {
+		if err = os.Truncate(f.FileStoragePath+WALFileSuffix, log.Size); err != nil {
+			logger.Log.Info("error truncate wal", zap.Error(err))
+			return err
+		}
+	}
+	f.walPath = f.FileStoragePath
+	f.walRecords = len(log.Records)
+	return nil
+}
+
+// appendWAL appends records to the write-ahead log with a single write and
+// syncs it. It must be called with the mutex held.
+func (f *FileStorage) appendWAL(records ...me.Metric) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var (
+		b   []byte
+		err error
+	)
+	for i := range records {
+		if b, err = appendRecord(b, &records[i]); err != nil {
+			logger.Log.Info("error marshal metric", zap.Error(err))
+			return err
+		}
+	}
+	file, err := os.OpenFile(f.FileStoragePath+WALFileSuffix, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		logger.Log.Info("error open wal", zap.Error(err))
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Write(b); err != nil {
+		logger.Log.Info("error write wal", zap.Error(err))
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		logger.Log.Info("error sync wal", zap.Error(err))
+		return err
+	}
+	f.walRecords += len(records)
+	return nil
+}
+
+// compact writes a snapshot of all metrics and starts a new write-ahead log.
+// It must be called with the mutex held.
+func (f *FileStorage) compact() error {
+	snapshot, err := encodeSnapshot(snapshotMaps(f.Gauge, f.Counter, f.updated))
+	if err != nil {
+		logger.Log.Info("error marshal metrics", zap.Error(err))
+		return err
+	}
+	old, err := os.ReadFile(f.FileStoragePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Log.Info("error read file", zap.Error(err))
+		return err
+	}
+	// Renaming a snapshot with new contents makes the old log stale, but an
+	// identical snapshot would keep it valid, so then only the log is reset.
+	if err != nil || headerOf(old) != headerOf(snapshot) {
+		if err = atomicfile.WriteFile(f.FileStoragePath, snapshot); err != nil {
+			logger.Log.Info("error write file", zap.Error(err))
+			return err
+		}
+	}
+	if err = f.resetWAL(snapshot); err != nil {
+		return err
+	}
+	f.loadedPath = f.FileStoragePath
+	return nil
+}
@@ -0,0 +1,82 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/liveness"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// ParamAgentID is the name of the URL parameter with the agent ID.
+const ParamAgentID = "agentID"
+
+// NewAgentTracker creates a liveness.Tracker with the agent settings of the
+// server.
+//
+// Parameters:
+//   - serverConfig: The server configuration with the agent settings.
+//
+// Returns:
+//   - A pointer to the newly created Tracker, or nil if agents are not
+//     tracked.
+func NewAgentTracker(serverConfig *config.ServerConfig) *liveness.Tracker {
+	if serverConfig.AgentMisses <= 0 {
+		return nil
+	}
+	return liveness.NewTracker(time.Duration(serverConfig.AgentInterval)*time.Second, serverConfig.AgentMisses)
+}
+
+// observeAgent records the report of the agent that sent the request.
+func (mr *MetricRouter) observeAgent(req *http.Request, metrics []metric.Metric) {
+	mr.Agents.Observe(clientid.FromRequest(req), clientid.RemoteAddr(req), metrics, time.Now())
+}
+
+// AgentsHandler handles HTTP GET requests to the "/api/v1/agents" endpoint.
+// It writes the liveness of the agents that reported metrics as a JSON array.
+// The "status" query parameter filters the agents by status.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) AgentsHandler(res http.ResponseWriter, req *http.Request) {
+	agents := mr.Agents.Agents(time.Now())
+
+	if status := liveness.Status(req.URL.Query().Get("status")); status != "" {
+		filtered := agents[:0]
+		for _, a := range agents {
+			if a.Status == status {
+				filtered = append(filtered, a)
+			}
+		}
+		agents = filtered
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(agents); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
+
+// DeleteAgentHandler handles HTTP DELETE requests to the
+// "/api/v1/agents/{agentID}" endpoint. It stops tracking a decommissioned
+// agent, so it is no longer reported as down.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) DeleteAgentHandler(res http.ResponseWriter, req *http.Request) {
+	if !mr.Agents.Forget(chi.URLParam(req, ParamAgentID)) {
+		http.Error(res, "agent not found", http.StatusNotFound)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
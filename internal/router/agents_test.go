@@ -0,0 +1,64 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/liveness"
+)
+
+func TestAgentsHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300, AgentMisses: 3}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	require.NotNil(t, metricRouter.Agents)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/1", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, body := testRequest(t, ts, http.MethodGet, "/api/v1/agents", false)
+	assert.Equal(t, "[]\n", body, "update without PollCount is not a report")
+
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/updates/",
+		`[{"id": "PollCount", "type": "counter", "delta": 5}, {"id": "Alloc", "type": "gauge", "value": 2}]`, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/counter/PollCount/5", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/agents", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var agents []liveness.Agent
+	require.NoError(t, json.Unmarshal([]byte(body), &agents))
+	require.Len(t, agents, 1)
+	assert.Equal(t, "ip:127.0.0.1", agents[0].ID)
+	assert.Equal(t, "127.0.0.1", agents[0].Address)
+	assert.Equal(t, int64(10), agents[0].PollCount)
+	assert.Equal(t, int64(2), agents[0].Reports)
+	assert.Equal(t, liveness.StatusUp, agents[0].Status)
+
+	_, body = testRequest(t, ts, http.MethodGet, "/api/v1/agents?status=down", false)
+	assert.Equal(t, "[]\n", body)
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/v1/agents/ip:127.0.0.1", false)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/v1/agents/ip:127.0.0.1", false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestNewAgentTracker(t *testing.T) {
+	assert.Nil(t, NewAgentTracker(&config.ServerConfig{}))
+
+	tracker := NewAgentTracker(&config.ServerConfig{AgentInterval: 10, AgentMisses: 2})
+	require.NotNil(t, tracker)
+	assert.Equal(t, 2, tracker.Misses)
+	assert.Equal(t, "10s", tracker.Interval.String())
+}
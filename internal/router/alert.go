@@ -0,0 +1,101 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/notify"
+)
+
+// NewAlertEngine creates an alert.Engine with the rules from the rules file
+// of the server.
+//
+// Parameters:
+//   - serverConfig: The server configuration with the alerting settings.
+//
+// Returns:
+//   - A pointer to the newly created Engine, or nil if no rules file is set.
+//   - An error if the rules file can't be read or is malformed.
+func NewAlertEngine(serverConfig *config.ServerConfig) (*alert.Engine, error) {
+	if serverConfig.AlertRulesFile == "" {
+		return nil, nil
+	}
+	rules, err := alert.LoadRules(serverConfig.AlertRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return alert.NewEngine(rules), nil
+}
+
+// NewNotifier creates a notify.Notifier with the webhook settings of the
+// server.
+//
+// Parameters:
+//   - serverConfig: The server configuration with the alerting settings.
+//
+// Returns:
+//   - A pointer to the newly created Notifier, or nil if no webhooks are set.
+func NewNotifier(serverConfig *config.ServerConfig) *notify.Notifier {
+	urls := splitList(serverConfig.AlertWebhooks)
+	if len(urls) == 0 {
+		return nil
+	}
+	n := notify.New(urls, serverConfig.AlertWebhookKey)
+	if groupBy := splitList(serverConfig.AlertGroupBy); len(groupBy) > 0 {
+		n.GroupBy = groupBy
+	}
+	if serverConfig.AlertRetries >= 0 {
+		n.Retries = serverConfig.AlertRetries
+	}
+	return n
+}
+
+// splitList splits a comma-separated list and drops empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// AlertsHandler handles HTTP GET requests to the "/api/v1/alerts" endpoint.
+// It writes the pending, firing and recently resolved alerts, followed by the
+// alerts about the agents that are down, as a JSON array. The "state" query
+// parameter filters the alerts by state.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) AlertsHandler(res http.ResponseWriter, req *http.Request) {
+	alerts := []alert.Alert{}
+	if mr.Alerts != nil {
+		alerts = mr.Alerts.Alerts()
+	}
+	alerts = append(alerts, mr.Agents.Alerts(time.Now())...)
+
+	if state := alert.State(req.URL.Query().Get("state")); state != "" {
+		filtered := alerts[:0]
+		for _, a := range alerts {
+			if a.State == state {
+				filtered = append(filtered, a)
+			}
+		}
+		alerts = filtered
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(alerts); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/config"
+)
+
+func TestAlertsHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "HighCPU", "expr": "CPU > 90"},
+		{"name": "LowMemory", "expr": "FreeMemory < 10 for 5m"}
+	]`), 0644))
+
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["CPU"] = 95
+	serverRepository.Gauge["FreeMemory"] = 5
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300, AlertRulesFile: path}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	engine, err := NewAlertEngine(&serverConfig)
+	require.NoError(t, err)
+	metricRouter.Alerts = engine
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	metrics, err := serverRepository.GetMetrics(context.Background())
+	require.NoError(t, err)
+	engine.Eval(time.Now(), metrics)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/alerts", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var alerts []alert.Alert
+	require.NoError(t, json.Unmarshal([]byte(body), &alerts))
+	require.Len(t, alerts, 2)
+	assert.Equal(t, alert.StateFiring, alerts[0].State)
+	assert.Equal(t, alert.StatePending, alerts[1].State)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/alerts?state=pending", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &alerts))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "LowMemory", alerts[0].Name)
+
+	metricRouter.Alerts = nil
+	_, body = testRequest(t, ts, http.MethodGet, "/api/v1/alerts", false)
+	assert.Equal(t, "[]\n", body)
+}
+
+func TestNewAlertEngine(t *testing.T) {
+	engine, err := NewAlertEngine(&config.ServerConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, engine)
+
+	_, err = NewAlertEngine(&config.ServerConfig{AlertRulesFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
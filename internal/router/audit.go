@@ -0,0 +1,119 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/repository/storage"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// NewAuditor creates an audit.Auditor that writes to the sinks configured
+// for the server: a rotated file, the audit_log table of the Postgres
+// storage, or both.
+//
+// Parameters:
+//   - serverConfig: The server configuration with the audit settings.
+//   - repository: The metrics repository. It must be a Postgres storage if
+//     the Postgres audit sink is enabled.
+//
+// Returns:
+//   - A pointer to the newly created Auditor, or nil if no audit sink is
+//     configured.
+//   - An error if a sink can't be created.
+func NewAuditor(serverConfig *config.ServerConfig, repository Repository) (*audit.Auditor, error) {
+	var sinks audit.MultiSink
+
+	if serverConfig.AuditFile != "" {
+		fileSink, err := audit.NewFileSink(serverConfig.AuditFile, serverConfig.AuditMaxSize, serverConfig.AuditMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, fileSink)
+	}
+
+	if serverConfig.AuditDB {
+		pgStorage, ok := repository.(*storage.PostgresStorage)
+		if !ok {
+			return nil, errors.Join(sinks.Close(), errors.New("audit to database requires postgres storage"))
+		}
+		sinks = append(sinks, &audit.PostgresSink{Conn: pgStorage.Conn})
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return audit.NewAuditor(sinks[0], audit.DefaultBufferSize), nil
+	default:
+		return audit.NewAuditor(sinks, audit.DefaultBufferSize), nil
+	}
+}
+
+// auditSnapshot reads the values of the metrics before they are updated.
+// It returns nil if auditing is disabled.
+func (mr *MetricRouter) auditSnapshot(ctx context.Context, metrics []metric.Metric) map[string]*metric.Metric {
+	if mr.Audit == nil {
+		return nil
+	}
+	return audit.Snapshot(ctx, mr.Repository, metrics)
+}
+
+// auditUpdate records the applied metrics in the audit log.
+func (mr *MetricRouter) auditUpdate(req *http.Request, before map[string]*metric.Metric, metrics []metric.Metric) {
+	if mr.Audit == nil {
+		return
+	}
+	entries := audit.UpdateEntries(time.Now(), clientid.FromRequest(req), clientid.PeerAddr(req), before, metrics)
+	reportIP(req, entries)
+	if err := mr.Audit.Log(entries...); err != nil {
+		logger.Log.Error("error log audit entries", zap.Error(err))
+	}
+}
+
+// auditDelete records the deleted metrics in the audit log.
+func (mr *MetricRouter) auditDelete(req *http.Request, deleted []*metric.Metric) {
+	if mr.Audit == nil || len(deleted) == 0 {
+		return
+	}
+	now := time.Now()
+	client, remoteAddr := clientid.FromRequest(req), clientid.PeerAddr(req)
+	entries := make([]audit.Entry, 0, len(deleted))
+	for _, m := range deleted {
+		entries = append(entries, audit.DeleteEntry(now, client, remoteAddr, m, m.MType, m.ID))
+	}
+	reportIP(req, entries)
+	if err := mr.Audit.Log(entries...); err != nil {
+		logger.Log.Error("error log audit entries", zap.Error(err))
+	}
+}
+
+// auditRestore records the series changed by a snapshot restore in the audit
+// log.
+func (mr *MetricRouter) auditRestore(req *http.Request, replaced []*metric.Metric, restored []metric.Metric) {
+	if mr.Audit == nil {
+		return
+	}
+	entries := audit.RestoreEntries(time.Now(), clientid.FromRequest(req), clientid.PeerAddr(req), replaced, restored)
+	reportIP(req, entries)
+	if err := mr.Audit.Log(entries...); err != nil {
+		logger.Log.Error("error log audit entries", zap.Error(err))
+	}
+}
+
+// reportIP records the real IP address reported by the client of the request
+// in the audit entries, next to the address of the connection.
+func reportIP(req *http.Request, entries []audit.Entry) {
+	reported := clientid.ReportedIP(req)
+	for i := range entries {
+		entries[i].ReportedIP = reported
+	}
+}
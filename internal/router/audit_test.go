@@ -0,0 +1,105 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+func TestNewAuditor(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		auditor, err := NewAuditor(&config.ServerConfig{}, NewMemoryStorage())
+		require.NoError(t, err)
+		assert.Nil(t, auditor)
+	})
+
+	t.Run("database audit without postgres", func(t *testing.T) {
+		_, err := NewAuditor(&config.ServerConfig{AuditDB: true}, NewMemoryStorage())
+		assert.Error(t, err)
+	})
+
+	t.Run("bad file path", func(t *testing.T) {
+		_, err := NewAuditor(&config.ServerConfig{AuditFile: filepath.Join(t.TempDir(), "missing", "audit.log")}, NewMemoryStorage())
+		assert.Error(t, err)
+	})
+}
+
+func TestAuditUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	serverRepository := NewMemoryStorage()
+	serverRepository.Counter["c1"] = 10
+	serverConfig := config.ServerConfig{StoreInterval: 300, AuditFile: path}
+	auditor, err := NewAuditor(&serverConfig, serverRepository)
+	require.NoError(t, err)
+
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	metricRouter.Audit = auditor
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/counter/c1/5", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/", strings.NewReader(`{"id": "g1", "type": "gauge", "value": 1.5}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(clientid.HeaderRealIP, "10.0.0.1")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/updates/", `[{"id": "g1", "type": "gauge", "value": 2.5}]`, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/value/gauge/g1", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, auditor.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e audit.Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		entries = append(entries, e)
+	}
+	require.Len(t, entries, 4)
+
+	assert.Equal(t, "c1", entries[0].ID)
+	assert.Equal(t, "10", *entries[0].OldValue)
+	assert.Equal(t, "15", *entries[0].NewValue)
+	assert.Equal(t, "127.0.0.1", entries[0].RemoteAddr)
+	assert.Equal(t, "ip:127.0.0.1", entries[0].Client)
+	assert.Empty(t, entries[0].ReportedIP)
+
+	assert.Equal(t, "g1", entries[1].ID)
+	assert.Nil(t, entries[1].OldValue)
+	assert.Equal(t, "1.5", *entries[1].NewValue)
+	// The reported address is recorded, but doesn't replace the address of
+	// the connection or the identity of the client.
+	assert.Equal(t, "127.0.0.1", entries[1].RemoteAddr)
+	assert.Equal(t, "10.0.0.1", entries[1].ReportedIP)
+	assert.Equal(t, "ip:127.0.0.1", entries[1].Client)
+
+	assert.Equal(t, "1.5", *entries[2].OldValue)
+	assert.Equal(t, "2.5", *entries[2].NewValue)
+
+	assert.Equal(t, audit.OpDelete, entries[3].Op)
+	assert.Equal(t, "2.5", *entries[3].OldValue)
+	assert.Nil(t, entries[3].NewValue)
+}
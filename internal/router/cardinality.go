@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// DefaultTopContributors is the number of clients reported by
+// CardinalityHandler when the "top" query parameter is not set.
+const DefaultTopContributors = 10
+
+// NewCardinalityLimiter creates a cardinality.Limiter configured with the
+// series limits of the server and registers the series already stored in
+// the repository.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the repository read.
+//   - repository: The repository holding the already stored series.
+//   - serverConfig: The server configuration with the series limits.
+//
+// Returns:
+//   - A pointer to the newly created Limiter, or nil if no series limit is
+//     configured.
+//   - An error if the limit policy is invalid or the stored series can't be
+//     read.
+func NewCardinalityLimiter(ctx context.Context, repository Repository, serverConfig *config.ServerConfig) (*cardinality.Limiter, error) {
+	if serverConfig.MaxSeries <= 0 && serverConfig.MaxClientSeries <= 0 {
+		return nil, nil
+	}
+
+	policy, err := cardinality.ParsePolicy(serverConfig.SeriesPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := repository.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := cardinality.New(serverConfig.MaxSeries, serverConfig.MaxClientSeries, policy)
+	limiter.Load(metrics)
+	return limiter, nil
+}
+
+// admitMetrics checks the metrics sent with the request against the
+// cardinality limits. If no limiter is configured, all metrics are admitted.
+// If the admitted metrics can't be stored, the admission must be undone with
+// mr.Cardinality.Rollback.
+func (mr *MetricRouter) admitMetrics(req *http.Request, metrics []metric.Metric) (cardinality.Admission, error) {
+	if mr.Cardinality == nil {
+		return cardinality.Admission{Metrics: metrics}, nil
+	}
+	return mr.Cardinality.Admit(clientid.FromRequest(req), metrics)
+}
+
+// CardinalityHandler handles HTTP GET requests to the
+// "/api/v1/admin/cardinality" endpoint. It writes the current number of
+// series, the configured limits and the clients that created the largest
+// number of series as JSON. The number of reported clients can be set with
+// the "top" query parameter.
+//
+// If no series limit is configured, only the total number of series read
+// from the repository is reported.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) CardinalityHandler(res http.ResponseWriter, req *http.Request) {
+	top := DefaultTopContributors
+	if topParam := req.URL.Query().Get("top"); topParam != "" {
+		var err error
+		top, err = strconv.Atoi(topParam)
+		if err != nil || top < 0 {
+			http.Error(res, "bad top value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var stats cardinality.Stats
+	if mr.Cardinality != nil {
+		stats = mr.Cardinality.Stats(top)
+	} else {
+		var (
+			metrics []*metric.Metric
+			err     error
+		)
+		err = Retry(mr.RetryCount, func() (err error) {
+			metrics, err = mr.Repository.GetMetrics(req.Context())
+			return err
+		})
+		if err != nil {
+			logger.Log.Info("error get metrics", zap.Error(err))
+			http.Error(res, "error get metrics", http.StatusInternalServerError)
+			return
+		}
+		stats = cardinality.Stats{Series: len(metrics), TopClients: []cardinality.ClientStat{}}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(stats); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,144 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/repository/mock"
+)
+
+func TestCardinalityLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		url        string
+		wantStatus int
+	}{
+		{
+			name:       "test update known series",
+			policy:     "reject",
+			url:        "/update/gauge/known/1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "test reject new series",
+			policy:     "reject",
+			url:        "/update/gauge/new/1",
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "test drop new series",
+			policy:     "drop",
+			url:        "/update/gauge/new/1",
+			wantStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverRepository := NewMemoryStorage()
+			serverRepository.Gauge["known"] = 0
+			chiRouter := chi.NewRouter()
+			serverConfig := config.ServerConfig{StoreInterval: 300, MaxSeries: 1, SeriesPolicy: test.policy}
+			metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+			limiter, err := NewCardinalityLimiter(context.Background(), serverRepository, &serverConfig)
+			require.NoError(t, err)
+			require.NotNil(t, limiter)
+			metricRouter.Cardinality = limiter
+			ts := httptest.NewServer(metricRouter.Router)
+			defer ts.Close()
+
+			resp, _ := testRequest(t, ts, http.MethodPost, test.url, false)
+			assert.Equal(t, test.wantStatus, resp.StatusCode)
+			assert.Len(t, serverRepository.Gauge, 1)
+		})
+	}
+}
+
+func TestCardinalityLimits_WriteError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	repo := mock.NewMockRepository(ctrl)
+	repo.EXPECT().GetMetrics(gomock.Any()).Return(nil, nil).AnyTimes()
+	repo.EXPECT().UpdateMetric(gomock.Any(), gomock.Any()).Return(errors.New("can't write metric"))
+
+	serverConfig := config.ServerConfig{StoreInterval: 300, MaxSeries: 1}
+	metricRouter := NewMetricRouter(chi.NewRouter(), repo, &serverConfig)
+	limiter, err := NewCardinalityLimiter(context.Background(), repo, &serverConfig)
+	require.NoError(t, err)
+	metricRouter.Cardinality = limiter
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/g1/1", false)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, 0, limiter.Stats(0).Series)
+}
+
+func TestCardinalityHandler(t *testing.T) {
+	t.Run("with limiter", func(t *testing.T) {
+		serverRepository := NewMemoryStorage()
+		chiRouter := chi.NewRouter()
+		serverConfig := config.ServerConfig{StoreInterval: 300, MaxClientSeries: 10}
+		metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+		limiter, err := NewCardinalityLimiter(context.Background(), serverRepository, &serverConfig)
+		require.NoError(t, err)
+		metricRouter.Cardinality = limiter
+		ts := httptest.NewServer(metricRouter.Router)
+		defer ts.Close()
+
+		resp, _ := testJSONRequest(t, ts, http.MethodPost, "/updates/",
+			`[{"id": "g1", "type": "gauge", "value": 1}, {"id": "c1", "type": "counter", "delta": 1}]`, "application/json")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/admin/cardinality?top=1", false)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var stats cardinality.Stats
+		require.NoError(t, json.Unmarshal([]byte(body), &stats))
+		assert.Equal(t, 2, stats.Series)
+		assert.Equal(t, 10, stats.MaxSeriesPerClient)
+		require.Len(t, stats.TopClients, 1)
+		assert.Equal(t, 2, stats.TopClients[0].Series)
+	})
+
+	t.Run("without limiter", func(t *testing.T) {
+		serverRepository := NewMemoryStorage()
+		serverRepository.Gauge["g1"] = 1
+		chiRouter := chi.NewRouter()
+		serverConfig := config.ServerConfig{StoreInterval: 300}
+		metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+		ts := httptest.NewServer(metricRouter.Router)
+		defer ts.Close()
+
+		resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/admin/cardinality", false)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var stats cardinality.Stats
+		require.NoError(t, json.Unmarshal([]byte(body), &stats))
+		assert.Equal(t, 1, stats.Series)
+	})
+
+	t.Run("bad top", func(t *testing.T) {
+		serverRepository := NewMemoryStorage()
+		chiRouter := chi.NewRouter()
+		serverConfig := config.ServerConfig{StoreInterval: 300}
+		metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+		ts := httptest.NewServer(metricRouter.Router)
+		defer ts.Close()
+
+		resp, _ := testRequest(t, ts, http.MethodGet, "/api/v1/admin/cardinality?top=abc", false)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
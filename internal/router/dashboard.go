@@ -0,0 +1,398 @@
+package router
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/rate"
+)
+
+// Query parameters of the dashboard.
+const (
+	ParamFilter  = "filter"
+	ParamType    = "type"
+	ParamSort    = "sort"
+	ParamOrder   = "order"
+	ParamRefresh = "refresh"
+	ParamFormat  = "format"
+)
+
+// DefaultDashboardRefresh is the default auto-refresh interval of the
+// dashboard pages, in seconds.
+const DefaultDashboardRefresh = 10
+
+// dashboardSamples is the number of recent samples listed on a metric page.
+const dashboardSamples = 20
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+var dashboardTemplates = template.Must(template.ParseFS(dashboardFS, "dashboard/*.html"))
+
+// dashboardStatic serves the stylesheet of the dashboard.
+var dashboardStatic = func() http.Handler {
+	sub, err := fs.Sub(dashboardFS, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/dashboard/static/", http.FileServer(http.FS(sub)))
+}()
+
+// dashboardColumn is a sortable column of the metrics table.
+type dashboardColumn struct {
+	Title string
+	URL   string
+	Arrow string
+}
+
+// dashboardRow is a row of the metrics table.
+type dashboardRow struct {
+	Sparkline   template.HTML
+	Name        string
+	Type        string
+	Value       string
+	Unit        string
+	Description string
+	URL         string
+	value       float64
+}
+
+// dashboardIndex is the data of the metrics table page.
+type dashboardIndex struct {
+	Title   string
+	Filter  string
+	Type    string
+	Sort    string
+	Order   string
+	Columns []dashboardColumn
+	Rows    []dashboardRow
+	Total   int
+	Refresh int
+}
+
+// dashboardSample is a sample listed on a metric page.
+type dashboardSample struct {
+	At    string
+	Value string
+}
+
+// dashboardMetric is the data of a metric page.
+type dashboardMetric struct {
+	Rate        *rate.Result
+	Sparkline   template.HTML
+	Title       string
+	Name        string
+	Type        string
+	Value       string
+	Unit        string
+	Description string
+	RateWindow  string
+	Min         string
+	Max         string
+	From        string
+	To          string
+	Samples     []dashboardSample
+	Refresh     int
+}
+
+// dashboardRefresh returns the auto-refresh interval set by the "refresh"
+// query parameter. Zero disables the refresh.
+func dashboardRefresh(req *http.Request) int {
+	refresh, err := strconv.Atoi(req.URL.Query().Get(ParamRefresh))
+	if err != nil || refresh < 0 {
+		return DefaultDashboardRefresh
+	}
+	return refresh
+}
+
+// historyOf returns the sampled history of a metric.
+func (mr *MetricRouter) historyOf(mType, name string) []rate.Sample {
+	switch {
+	case mType == MetricTypeCounter && mr.Rates != nil:
+		return mr.Rates.Samples(name)
+	case mType == MetricTypeGauge && mr.Gauges != nil:
+		return mr.Gauges.Samples(name)
+	}
+	return nil
+}
+
+// renderDashboard writes the metrics table page. The "filter" query parameter
+// filters the metrics by a glob pattern, or by a substring of the name if it
+// has no glob characters; "type" filters them by type. "sort" sorts them by
+// "name", "type" or "value" in the "order" "asc" or "desc".
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+//   - metrics: The visible metrics.
+func (mr *MetricRouter) renderDashboard(res http.ResponseWriter, req *http.Request, metrics []*metric.Metric) {
+	q := req.URL.Query()
+	page := dashboardIndex{
+		Title:   "Metrics",
+		Filter:  strings.TrimSpace(q.Get(ParamFilter)),
+		Type:    q.Get(ParamType),
+		Sort:    q.Get(ParamSort),
+		Order:   q.Get(ParamOrder),
+		Refresh: dashboardRefresh(req),
+		Total:   len(metrics),
+	}
+	if page.Sort != "type" && page.Sort != "value" {
+		page.Sort = "name"
+	}
+	if page.Order != "desc" {
+		page.Order = "asc"
+	}
+
+	for _, me := range metrics {
+		if page.Type != "" && me.MType != page.Type {
+			continue
+		}
+		if !matchFilter(page.Filter, me.ID) {
+			continue
+		}
+		md := mr.metadataOf(me.ID)
+		row := dashboardRow{
+			Name:        me.ID,
+			Type:        me.MType,
+			Value:       me.ValueAsString(),
+			Unit:        md.Unit,
+			Description: md.Description,
+			URL:         "/dashboard/" + url.PathEscape(me.MType) + "/" + url.PathEscape(me.ID),
+			Sparkline:   sparkline(mr.historyOf(me.MType, me.ID), 120, 24),
+		}
+		if me.Value != nil {
+			row.value = *me.Value
+		} else if me.Delta != nil {
+			row.value = float64(*me.Delta)
+		}
+		page.Rows = append(page.Rows, row)
+	}
+
+	sort.SliceStable(page.Rows, func(i, j int) bool {
+		a, b := page.Rows[i], page.Rows[j]
+		if page.Order == "desc" {
+			a, b = b, a
+		}
+		switch page.Sort {
+		case "type":
+			if a.Type != b.Type {
+				return a.Type < b.Type
+			}
+		case "value":
+			if a.value != b.value {
+				return a.value < b.value
+			}
+		}
+		return a.Name < b.Name
+	})
+
+	for _, column := range []string{"name", "type", "value"} {
+		order, arrow := "asc", ""
+		if column == page.Sort {
+			if page.Order == "asc" {
+				order, arrow = "desc", " ▲"
+			} else {
+				arrow = " ▼"
+			}
+		}
+		link := url.Values{}
+		link.Set(ParamSort, column)
+		link.Set(ParamOrder, order)
+		if page.Filter != "" {
+			link.Set(ParamFilter, page.Filter)
+		}
+		if page.Type != "" {
+			link.Set(ParamType, page.Type)
+		}
+		link.Set(ParamRefresh, strconv.Itoa(page.Refresh))
+		page.Columns = append(page.Columns, dashboardColumn{
+			Title: strings.ToUpper(column[:1]) + column[1:],
+			URL:   "/?" + link.Encode(),
+			Arrow: arrow,
+		})
+	}
+
+	renderTemplate(res, "index.html", page)
+}
+
+// matchFilter reports whether a metric name matches a filter of the
+// dashboard.
+func matchFilter(filter, name string) bool {
+	if filter == "" {
+		return true
+	}
+	if strings.ContainsAny(filter, "*?[") {
+		ok, _ := path.Match(filter, name)
+		return ok
+	}
+	return strings.Contains(strings.ToLower(name), strings.ToLower(filter))
+}
+
+// MetricPageHandler handles HTTP GET requests to the
+// "/dashboard/{metricType}/{metricName}" endpoint. It writes an HTML page with
+// the current value of a metric, a chart of its sampled history and, for a
+// counter, its rate over the default rate window.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) MetricPageHandler(res http.ResponseWriter, req *http.Request) {
+	metricType := chi.URLParam(req, ParamMetricType)
+	metricName := chi.URLParam(req, ParamMetricName)
+
+	if metricType != MetricTypeGauge && metricType != MetricTypeCounter {
+		http.Error(res, "Bad metric type!", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		me  *metric.Metric
+		err error
+	)
+	err = Retry(mr.RetryCount, func() (err error) {
+		me, err = mr.Repository.GetMetric(req.Context(), metricType, metricName)
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("metric not found", zap.Error(err))
+		http.Error(res, "metric not found", http.StatusNotFound)
+		return
+	}
+	if mr.Expiry.IsStale(me.MType, me.ID) {
+		http.Error(res, "metric not found", http.StatusNotFound)
+		return
+	}
+
+	md := mr.metadataOf(me.ID)
+	page := dashboardMetric{
+		Title:       me.ID,
+		Name:        me.ID,
+		Type:        me.MType,
+		Value:       me.ValueAsString(),
+		Unit:        md.Unit,
+		Description: md.Description,
+		Refresh:     dashboardRefresh(req),
+	}
+
+	now := time.Now()
+	if me.MType == MetricTypeCounter && mr.Rates != nil {
+		if result, err := mr.Rates.Compute(me.ID, DefaultRateWindow, now); err == nil {
+			page.Rate = &result
+			page.RateWindow = DefaultRateWindow.String()
+		}
+	}
+
+	history := mr.historyOf(me.MType, me.ID)
+	page.Sparkline = sparkline(history, 640, 160)
+	if page.Sparkline != "" {
+		lo, hi := history[0].Value, history[0].Value
+		for _, s := range history {
+			lo, hi = math.Min(lo, s.Value), math.Max(hi, s.Value)
+		}
+		page.Min = strconv.FormatFloat(lo, 'g', -1, 64)
+		page.Max = strconv.FormatFloat(hi, 'g', -1, 64)
+		page.From = history[0].At.Format(time.TimeOnly)
+		page.To = history[len(history)-1].At.Format(time.TimeOnly)
+		for i := len(history) - 1; i >= 0 && len(page.Samples) < dashboardSamples; i-- {
+			page.Samples = append(page.Samples, dashboardSample{
+				At:    history[i].At.Format(time.DateTime),
+				Value: strconv.FormatFloat(history[i].Value, 'g', -1, 64),
+			})
+		}
+	}
+
+	renderTemplate(res, "metric.html", page)
+}
+
+// DashboardStaticHandler handles HTTP GET requests to the
+// "/dashboard/static/*" endpoint. It serves the embedded assets of the
+// dashboard.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) DashboardStaticHandler(res http.ResponseWriter, req *http.Request) {
+	if !strings.HasSuffix(req.URL.Path, ".css") {
+		http.NotFound(res, req)
+		return
+	}
+	dashboardStatic.ServeHTTP(res, req)
+}
+
+// renderTemplate executes a dashboard template into a buffer first, so a
+// failing template doesn't produce a partial page.
+func renderTemplate(res http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		logger.Log.Info("error render dashboard", zap.Error(err))
+		http.Error(res, "error render dashboard", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := res.Write(buf.Bytes()); err != nil {
+		logger.Log.Info("error write response data", zap.Error(err))
+	}
+}
+
+// sparkline draws samples as an inline SVG line chart scaled to the range of
+// their values and times.
+//
+// Parameters:
+//   - samples: The samples, oldest first.
+//   - width, height: The size of the chart in pixels.
+//
+// Returns:
+//   - The SVG element, or an empty string if there are less than two
+//     samples.
+func sparkline(samples []rate.Sample, width, height int) template.HTML {
+	if len(samples) < 2 {
+		return ""
+	}
+	lo, hi := samples[0].Value, samples[0].Value
+	for _, s := range samples {
+		lo, hi = math.Min(lo, s.Value), math.Max(hi, s.Value)
+	}
+	if math.IsNaN(lo) || math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		return ""
+	}
+	from := samples[0].At
+	span := samples[len(samples)-1].At.Sub(from).Seconds()
+
+	// A margin of one pixel keeps the stroke inside the chart.
+	w, h := float64(width), float64(height)-2
+	var points strings.Builder
+	for i, s := range samples {
+		x := w * float64(i) / float64(len(samples)-1)
+		if span > 0 {
+			x = w * s.At.Sub(from).Seconds() / span
+		}
+		y := 1 + h/2
+		if hi > lo {
+			y = 1 + h - h*(s.Value-lo)/(hi-lo)
+		}
+		if i > 0 {
+			points.WriteByte(' ')
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f", x, y)
+	}
+	// The SVG only contains formatted numbers, so it is safe to embed.
+	return template.HTML(fmt.Sprintf(
+		`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none" role="img"><polyline points="%s"/></svg>`,
+		width, height, width, height, points.String()))
+}
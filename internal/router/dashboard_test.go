@@ -0,0 +1,111 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/rate"
+)
+
+func TestDashboard(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["CPUutilization1"] = 30
+	serverRepository.Gauge["CPUutilization2"] = 10
+	serverRepository.Gauge["FreeMemory"] = 50
+	serverRepository.Counter["PollCount"] = 7
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	metricRouter.Gauges = rate.NewHistory(time.Hour)
+	metricRouter.Rates = rate.NewHistory(time.Hour)
+	now := time.Now()
+	for i, v := range []float64{10, 40, 30} {
+		metricRouter.Gauges.Record("CPUutilization1", v, now.Add(time.Duration(i-3)*time.Second))
+	}
+	metricRouter.Rates.Record("PollCount", 1, now.Add(-time.Minute))
+	metricRouter.Rates.Record("PollCount", 7, now)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `<meta http-equiv="refresh" content="10">`)
+	assert.Contains(t, body, `href="/dashboard/gauge/CPUutilization1"`)
+	assert.Contains(t, body, `<svg class="sparkline"`)
+	assert.Contains(t, body, "4 of 4 metrics")
+	assert.Less(t, strings.Index(body, ">CPUutilization1<"), strings.Index(body, ">PollCount<"), "sorted by name")
+
+	_, body = testRequest(t, ts, http.MethodGet, "/?sort=value&order=desc&refresh=0", false)
+	assert.NotContains(t, body, `http-equiv="refresh"`)
+	assert.Less(t, strings.Index(body, ">FreeMemory<"), strings.Index(body, ">CPUutilization1<"))
+	assert.Less(t, strings.Index(body, ">CPUutilization1<"), strings.Index(body, ">CPUutilization2<"))
+
+	_, body = testRequest(t, ts, http.MethodGet, "/?filter=cpu", false)
+	assert.Contains(t, body, "2 of 4 metrics")
+	assert.NotContains(t, body, ">FreeMemory<")
+	_, body = testRequest(t, ts, http.MethodGet, "/?filter=*2", false)
+	assert.Contains(t, body, "1 of 4 metrics")
+	_, body = testRequest(t, ts, http.MethodGet, "/?type=counter", false)
+	assert.Contains(t, body, "1 of 4 metrics")
+	assert.Contains(t, body, ">PollCount<")
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/dashboard/gauge/CPUutilization1", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<h1>CPUutilization1")
+	assert.Contains(t, body, `<svg class="sparkline" width="640"`)
+	assert.Contains(t, body, "10 – 40")
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/dashboard/counter/PollCount", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "/s over 5m0s, increase 6")
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/dashboard/gauge/FreeMemory", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "No history yet")
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/dashboard/gauge/Missing", false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodGet, "/dashboard/summary/CPUutilization1", false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/dashboard/static/style.css", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "svg.sparkline")
+	resp, _ = testRequest(t, ts, http.MethodGet, "/dashboard/static/index.html", false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDashboard_EscapesNames(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["<script>"] = 1
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	_, body := testRequest(t, ts, http.MethodGet, "/", false)
+	assert.NotContains(t, body, "<script>")
+	assert.Contains(t, body, "&lt;script&gt;")
+}
+
+func TestSparkline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Empty(t, sparkline([]rate.Sample{{At: start, Value: 1}}, 100, 22))
+	assert.Equal(t,
+		`<svg class="sparkline" width="100" height="22" viewBox="0 0 100 22" preserveAspectRatio="none" role="img"><polyline points="0.0,21.0 25.0,1.0 100.0,11.0"/></svg>`,
+		string(sparkline([]rate.Sample{
+			{At: start, Value: 0},
+			{At: start.Add(time.Second), Value: 2},
+			{At: start.Add(4 * time.Second), Value: 1},
+		}, 100, 22)))
+	assert.Contains(t, string(sparkline([]rate.Sample{{At: start, Value: 5}, {At: start, Value: 5}}, 10, 12)),
+		`points="0.0,6.0 10.0,6.0"`, "a flat line is drawn in the middle")
+}
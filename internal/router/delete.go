@@ -0,0 +1,268 @@
+package router
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/repository/storage"
+)
+
+// Query parameters of the bulk delete.
+//
+// Parameters:
+//   - QueryPrefix: Delete the metrics whose names start with the value.
+//   - QueryGlob: Delete the metrics whose names match the shell pattern
+//     (see path.Match).
+//   - QueryType: Restrict the bulk delete to one metric type.
+const (
+	QueryPrefix = "prefix"
+	QueryGlob   = "glob"
+	QueryType   = "type"
+)
+
+// FullDump persists all metrics of the repository if the StoreInterval is
+// set to zero. Unlike DumpMetric, it also persists deletions. The method
+// retries the dumping operation up to the configured RetryCount in case of
+// transient errors.
+//
+// Returns:
+//   - An error if the dumping operation fails; otherwise, it returns nil.
+func (mr *MetricRouter) FullDump() error {
+	if mr.StoreInterval != 0 {
+		return nil
+	}
+	dumper, ok := mr.Repository.(Dumper)
+	if !ok {
+		return errors.New("provided Repository does not implement Dumper")
+	}
+	if err := RetryFile(mr.RetryCount, dumper.FullDump); err != nil {
+		logger.Log.Info("error saving metrics", zap.Error(err))
+		return errors.New("error saving metrics")
+	}
+	return nil
+}
+
+// DeleteMetricHandler handles HTTP DELETE requests to delete a specific
+// metric identified by its type and name. It responds with 404 Not Found if
+// the metric doesn't exist.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) DeleteMetricHandler(res http.ResponseWriter, req *http.Request) {
+	metricType := chi.URLParam(req, ParamMetricType)
+	metricName := chi.URLParam(req, ParamMetricName)
+
+	if metricType != MetricTypeGauge && metricType != MetricTypeCounter {
+		http.Error(res, "Bad metric type!", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		me  *metric.Metric
+		err error
+	)
+	err = Retry(mr.RetryCount, func() (err error) {
+		me, err = mr.Repository.GetMetric(req.Context(), metricType, metricName)
+		return err
+	})
+	if err != nil {
+		if isMetricNotFound(err) {
+			logger.Log.Info("metric not found", zap.Error(err))
+			http.Error(res, "metric not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.Info("error get metric", zap.Error(err))
+		http.Error(res, "error get metric", http.StatusInternalServerError)
+		return
+	}
+	if me == nil {
+		http.Error(res, "metric not found", http.StatusNotFound)
+		return
+	}
+
+	if err = mr.deleteMetrics(req, []*metric.Metric{me}); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+}
+
+// DeleteMetricsHandler handles HTTP DELETE requests to delete several metrics
+// at once. The metrics are selected either by a name prefix or glob in the
+// query (optionally restricted by type), or by a JSON array of metrics with
+// their IDs and types in the request body. Metrics that don't exist are
+// skipped. The deleted metrics with their last values are written to the
+// response in JSON format.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) DeleteMetricsHandler(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	prefix, glob, metricType := query.Get(QueryPrefix), query.Get(QueryGlob), query.Get(QueryType)
+
+	if metricType != "" && metricType != MetricTypeGauge && metricType != MetricTypeCounter {
+		http.Error(res, "bad metric type", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		selected []*metric.Metric
+		err      error
+	)
+	switch {
+	case prefix != "" && glob != "":
+		http.Error(res, "only one of prefix and glob allowed", http.StatusBadRequest)
+		return
+	case prefix != "" || glob != "":
+		if _, err = path.Match(glob, ""); err != nil {
+			http.Error(res, "bad glob pattern", http.StatusBadRequest)
+			return
+		}
+		selected, err = mr.selectMetrics(req, func(m *metric.Metric) bool {
+			if metricType != "" && m.MType != metricType {
+				return false
+			}
+			if prefix != "" {
+				return strings.HasPrefix(m.ID, prefix)
+			}
+			ok, _ := path.Match(glob, m.ID)
+			return ok
+		})
+		if err != nil {
+			http.Error(res, "error get metrics", http.StatusInternalServerError)
+			return
+		}
+	default:
+		if req.Header.Get("Content-Type") != "application/json" {
+			http.Error(res, "only application/json content-type allowed", http.StatusBadRequest)
+			return
+		}
+		var metrics []metric.Metric
+		if err = json.NewDecoder(req.Body).Decode(&metrics); err != nil {
+			http.Error(res, "can't decode request body", http.StatusBadRequest)
+			return
+		}
+		for _, m := range metrics {
+			if m.MType != MetricTypeGauge && m.MType != MetricTypeCounter {
+				http.Error(res, "bad metric type", http.StatusBadRequest)
+				return
+			}
+			var current *metric.Metric
+			err = Retry(mr.RetryCount, func() (err error) {
+				current, err = mr.Repository.GetMetric(req.Context(), m.MType, m.ID)
+				return err
+			})
+			if isMetricNotFound(err) || (err == nil && current == nil) {
+				continue
+			}
+			if err != nil {
+				logger.Log.Info("error get metric", zap.Error(err))
+				http.Error(res, "error get metric", http.StatusInternalServerError)
+				return
+			}
+			selected = append(selected, current)
+		}
+	}
+
+	if err = mr.deleteMetrics(req, selected); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if selected == nil {
+		selected = []*metric.Metric{}
+	}
+	data, err := json.Marshal(selected)
+	if err != nil {
+		logger.Log.Info("error marshal json response", zap.Error(err))
+		http.Error(res, "error marshal json response", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if _, err = res.Write(data); err != nil {
+		logger.Log.Info("error write response data", zap.Error(err))
+	}
+}
+
+// isMetricNotFound reports whether a repository error means that the metric
+// doesn't exist.
+func isMetricNotFound(err error) bool {
+	return errors.Is(err, storage.ErrMetricNotFound) || errors.Is(err, sql.ErrNoRows)
+}
+
+// selectMetrics returns copies of the metrics of the repository accepted by
+// the filter, sorted by type and name.
+func (mr *MetricRouter) selectMetrics(req *http.Request, filter func(m *metric.Metric) bool) ([]*metric.Metric, error) {
+	var (
+		metrics []*metric.Metric
+		err     error
+	)
+	err = Retry(mr.RetryCount, func() (err error) {
+		metrics, err = mr.Repository.GetMetrics(req.Context())
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get metrics", zap.Error(err))
+		return nil, err
+	}
+
+	var selected []*metric.Metric
+	for _, m := range metrics {
+		if filter(m) {
+			c := *m
+			selected = append(selected, &c)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].MType != selected[j].MType {
+			return selected[i].MType < selected[j].MType
+		}
+		return selected[i].ID < selected[j].ID
+	})
+	return selected, nil
+}
+
+// deleteMetrics deletes the metrics from the repository, records the
+// deletions in the audit log and persists them.
+func (mr *MetricRouter) deleteMetrics(req *http.Request, metrics []*metric.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	deleted := make([]*metric.Metric, 0, len(metrics))
+	defer func() {
+		mr.auditDelete(req, deleted)
+	}()
+
+	for _, m := range metrics {
+		err := Retry(mr.RetryCount, func() error {
+			return mr.Repository.DeleteMetric(req.Context(), m.MType, m.ID)
+		})
+		if err != nil {
+			logger.Log.Info("error delete metric", zap.Error(err))
+			return errors.New("error delete metric")
+		}
+		deleted = append(deleted, m)
+		if mr.Cardinality != nil {
+			mr.Cardinality.Forget(m.MType, m.ID)
+		}
+		if mr.Metadata != nil {
+			mr.Metadata.Forget(m.ID)
+		}
+	}
+
+	return mr.FullDump()
+}
@@ -0,0 +1,164 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+)
+
+func TestDeleteMetricHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	serverRepository := NewFileStorage(path)
+	serverRepository.Gauge["g1"] = 1.5
+	serverRepository.Gauge["g2"] = 2.5
+	serverRepository.Counter["c1"] = 10
+	require.NoError(t, serverRepository.FullDump())
+
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 0}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		statusCode int
+	}{
+		{name: "delete gauge ok", url: "/value/gauge/g1", statusCode: http.StatusOK},
+		{name: "delete missing gauge", url: "/value/gauge/g1", statusCode: http.StatusNotFound},
+		{name: "delete bad type", url: "/value/unknown/g1", statusCode: http.StatusBadRequest},
+		{name: "delete counter ok", url: "/value/counter/c1", statusCode: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, http.MethodDelete, test.url, false)
+			defer resp.Body.Close()
+			assert.Equal(t, test.statusCode, resp.StatusCode)
+		})
+	}
+
+	persisted := NewFileStorage(path)
+	require.NoError(t, persisted.Load(context.Background()))
+	assert.Equal(t, map[string]float64{"g2": 2.5}, persisted.Gauge)
+	assert.Empty(t, persisted.Counter)
+}
+
+func TestDeleteMetricsHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		url         string
+		body        string
+		contentType string
+		respBody    string
+		remaining   []string
+		statusCode  int
+	}{
+		{
+			name:       "delete by prefix",
+			url:        "/value/?prefix=host1_",
+			respBody:   `[{"id": "host1_req", "type": "counter", "delta": 3}, {"id": "host1_cpu", "type": "gauge", "value": 1}]`,
+			remaining:  []string{"host2_cpu"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "delete by prefix and type",
+			url:        "/value/?prefix=host1_&type=counter",
+			respBody:   `[{"id": "host1_req", "type": "counter", "delta": 3}]`,
+			remaining:  []string{"host1_cpu", "host2_cpu"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "delete by glob",
+			url:        "/value/?glob=host*_cpu",
+			respBody:   `[{"id": "host1_cpu", "type": "gauge", "value": 1}, {"id": "host2_cpu", "type": "gauge", "value": 2}]`,
+			remaining:  []string{"host1_req"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:        "delete by json body",
+			url:         "/value/",
+			body:        `[{"id": "host2_cpu", "type": "gauge"}, {"id": "missing", "type": "counter"}]`,
+			contentType: "application/json",
+			respBody:    `[{"id": "host2_cpu", "type": "gauge", "value": 2}]`,
+			remaining:   []string{"host1_cpu", "host1_req"},
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:       "nothing matches",
+			url:        "/value/?prefix=host3_",
+			respBody:   `[]`,
+			remaining:  []string{"host1_cpu", "host1_req", "host2_cpu"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "bad glob",
+			url:        "/value/?glob=[",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "prefix and glob",
+			url:        "/value/?prefix=a&glob=b",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bad type",
+			url:        "/value/?prefix=a&type=unknown",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:        "bad content type",
+			url:         "/value/",
+			body:        `[]`,
+			contentType: "text/plain",
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "bad metric type in body",
+			url:         "/value/",
+			body:        `[{"id": "host2_cpu", "type": "unknown"}]`,
+			contentType: "application/json",
+			statusCode:  http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clear(serverRepository.Gauge)
+			clear(serverRepository.Counter)
+			serverRepository.Gauge["host1_cpu"] = 1
+			serverRepository.Gauge["host2_cpu"] = 2
+			serverRepository.Counter["host1_req"] = 3
+
+			resp, respBody := testJSONRequest(t, ts, http.MethodDelete, test.url, test.body, test.contentType)
+			defer resp.Body.Close()
+			require.Equal(t, test.statusCode, resp.StatusCode)
+			if test.statusCode != http.StatusOK {
+				return
+			}
+			assert.JSONEq(t, test.respBody, respBody)
+
+			metrics, err := serverRepository.GetMetrics(resp.Request.Context())
+			require.NoError(t, err)
+			var remaining []string
+			for _, m := range metrics {
+				remaining = append(remaining, m.ID)
+			}
+			assert.ElementsMatch(t, test.remaining, remaining)
+		})
+	}
+}
@@ -27,7 +27,7 @@ func ExampleMetricRouter_RootHandler() {
 	serverRepository.Gauge["testGauge"] = 1.25
 	serverRepository.Counter["testCounter"] = 1
 
-	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/", bytes.NewBuffer([]byte{}))
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/?format=text", bytes.NewBuffer([]byte{}))
 	req.Header.Set("Content-Type", "text/plain")
 	req.Header.Set("Accept-Encoding", "")
 	req.Header.Set("Content-Encoding", "")
@@ -0,0 +1,192 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/expiry"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metadata"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/repository/storage"
+)
+
+// ExpiryClient is the client identity recorded in the audit log for series
+// deleted by the Sweeper.
+const ExpiryClient = "system:expiry"
+
+// UpdateTimer defines the method for reading the last update times of
+// series. Repositories must implement it to support expiry of stale series.
+type UpdateTimer interface {
+	GetUpdateTimes(ctx context.Context) ([]storage.SeriesTime, error)
+}
+
+// Sweeper finds series that haven't been updated for longer than their TTL
+// and either deletes them or marks them stale. It is safe for concurrent
+// use.
+//
+// Fields:
+//   - Repository: The metrics repository. It must implement UpdateTimer.
+//   - Policy: The TTL policy of series.
+//   - Action: What happens to expired series.
+//   - Cardinality: A limiter of the number of distinct series that must
+//     forget deleted series. It may be nil.
+//   - Audit: An audit log of deletions. It may be nil.
+//   - Metadata: A metadata registry that must forget the learned types of
+//     deleted series. It may be nil.
+//   - RetryCount: The number of times to retry repository operations in case
+//     of transient errors.
+type Sweeper struct {
+	Repository  Repository
+	Policy      *expiry.Policy
+	Cardinality *cardinality.Limiter
+	Audit       *audit.Auditor
+	Metadata    *metadata.Registry
+	stale       map[string]struct{}
+	Action      expiry.Action
+	RetryCount  int
+	mu          sync.RWMutex
+}
+
+// NewSweeper creates a Sweeper with the TTL settings of the server.
+//
+// Parameters:
+//   - repository: The metrics repository.
+//   - serverConfig: The server configuration with the TTL settings.
+//
+// Returns:
+//   - A pointer to the newly created Sweeper, or nil if no series can expire.
+//   - An error if the settings are malformed or the repository doesn't track
+//     update times.
+func NewSweeper(repository Repository, serverConfig *config.ServerConfig) (*Sweeper, error) {
+	policy, err := expiry.NewPolicy(time.Duration(serverConfig.MetricTTL)*time.Second, serverConfig.MetricTTLRules)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	action, err := expiry.ParseAction(serverConfig.MetricTTLAction)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := repository.(UpdateTimer); !ok {
+		return nil, errors.New("provided Repository does not implement UpdateTimer")
+	}
+	return &Sweeper{
+		Repository: repository,
+		Policy:     policy,
+		Action:     action,
+		RetryCount: serverConfig.RetryCount,
+		stale:      make(map[string]struct{}),
+	}, nil
+}
+
+// Sweep finds expired series and applies the action to them.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the sweep.
+//
+// Returns:
+//   - The number of deleted series.
+//   - An error if the update times can't be read or a series can't be
+//     deleted.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	series, err := s.Repository.(UpdateTimer).GetUpdateTimes(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var expired []storage.SeriesTime
+	for _, st := range series {
+		if s.Policy.Expired(st.ID, st.Updated, now) {
+			expired = append(expired, st)
+		}
+	}
+
+	if s.Action == expiry.ActionStale {
+		stale := make(map[string]struct{}, len(expired))
+		for _, st := range expired {
+			stale[audit.Key(st.MType, st.ID)] = struct{}{}
+		}
+		s.mu.Lock()
+		s.stale = stale
+		s.mu.Unlock()
+		return 0, nil
+	}
+
+	deleted := 0
+	var entries []audit.Entry
+	defer func() {
+		if s.Audit != nil && len(entries) > 0 {
+			if err := s.Audit.Log(entries...); err != nil {
+				logger.Log.Error("error log audit entries", zap.Error(err))
+			}
+		}
+	}()
+	for _, st := range expired {
+		old, _ := s.Repository.GetMetric(ctx, st.MType, st.ID)
+		if err = s.delete(ctx, st); err != nil {
+			return deleted, err
+		}
+		deleted++
+		logger.Log.Info("expired series deleted", zap.String("type", st.MType), zap.String("id", st.ID))
+		if s.Cardinality != nil {
+			s.Cardinality.Forget(st.MType, st.ID)
+		}
+		if s.Metadata != nil {
+			s.Metadata.Forget(st.ID)
+		}
+		if old != nil {
+			entries = append(entries, audit.DeleteEntry(now, ExpiryClient, "", old, st.MType, st.ID))
+		}
+	}
+	return deleted, nil
+}
+
+func (s *Sweeper) delete(ctx context.Context, st storage.SeriesTime) error {
+	err := Retry(s.RetryCount, func() error {
+		return s.Repository.DeleteMetric(ctx, st.MType, st.ID)
+	})
+	if err != nil {
+		logger.Log.Info("error delete expired series", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// IsStale reports whether the series was marked stale by the last sweep.
+// It returns false for a nil Sweeper.
+func (s *Sweeper) IsStale(mType, name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.stale[audit.Key(mType, name)]
+	return ok
+}
+
+// Refresh clears the stale mark of updated series, so they are visible again
+// before the next sweep. It does nothing for a nil Sweeper.
+func (s *Sweeper) Refresh(metrics []metric.Metric) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stale) == 0 {
+		return
+	}
+	for _, m := range metrics {
+		delete(s.stale, audit.Key(m.MType, m.ID))
+	}
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/expiry"
+)
+
+func TestNewSweeper(t *testing.T) {
+	sweeper, err := NewSweeper(NewMemoryStorage(), &config.ServerConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, sweeper)
+
+	_, err = NewSweeper(NewMemoryStorage(), &config.ServerConfig{MetricTTLRules: "bad"})
+	assert.Error(t, err)
+
+	_, err = NewSweeper(NewMemoryStorage(), &config.ServerConfig{MetricTTL: 10, MetricTTLAction: "archive"})
+	assert.Error(t, err)
+
+	sweeper, err = NewSweeper(NewMemoryStorage(), &config.ServerConfig{MetricTTL: 10})
+	require.NoError(t, err)
+	assert.Equal(t, expiry.ActionDelete, sweeper.Action)
+}
+
+func TestSweeper_Delete(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["old_cpu"] = 1
+	serverRepository.Counter["old_req"] = 2
+	serverRepository.Gauge["new_cpu"] = 3
+
+	sweeper, err := NewSweeper(serverRepository, &config.ServerConfig{MetricTTLRules: "old_*=1ns"})
+	require.NoError(t, err)
+
+	deleted, err := sweeper.Sweep(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, map[string]float64{"new_cpu": 3}, serverRepository.Gauge)
+	assert.Empty(t, serverRepository.Counter)
+}
+
+func TestSweeper_Stale(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["old_cpu"] = 1
+	serverRepository.Gauge["new_cpu"] = 3
+
+	serverConfig := config.ServerConfig{StoreInterval: 300, MetricTTLRules: "old_*=1ns", MetricTTLAction: "stale"}
+	sweeper, err := NewSweeper(serverRepository, &serverConfig)
+	require.NoError(t, err)
+
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	metricRouter.Expiry = sweeper
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	deleted, err := sweeper.Sweep(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+	assert.Len(t, serverRepository.Gauge, 2)
+	assert.True(t, sweeper.IsStale(MetricTypeGauge, "old_cpu"))
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/?format=text", false)
+	resp.Body.Close()
+	assert.Equal(t, "new_cpu = 3\n", body)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/metrics", false)
+	resp.Body.Close()
+	assert.NotContains(t, body, "old_cpu")
+	assert.Contains(t, body, "new_cpu 3")
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/query?expr=old_cpu", false)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics", false)
+	resp.Body.Close()
+	assert.Contains(t, body, `"id":"old_cpu","type":"gauge","stale":true`)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/export?format=ndjson", false)
+	resp.Body.Close()
+	assert.Contains(t, body, "old_cpu", "an export is a complete copy")
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/old_cpu/2", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, sweeper.IsStale(MetricTypeGauge, "old_cpu"))
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/?format=text", false)
+	resp.Body.Close()
+	assert.Contains(t, body, "old_cpu = 2\n")
+}
@@ -0,0 +1,238 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/export"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// TransferBatchSize is the number of metrics the export endpoint reads, and
+// the import endpoint writes, at a time.
+const TransferBatchSize = 500
+
+// importResponse is the response of the import endpoint.
+type importResponse struct {
+	Imported int `json:"imported"`
+}
+
+// transferFormat returns the format of an export or import request, set by
+// the "format" query parameter shared with the dashboard. It defaults to
+// JSON.
+func transferFormat(req *http.Request) (string, error) {
+	format := req.URL.Query().Get(ParamFormat)
+	if format == "" {
+		return export.FormatJSON, nil
+	}
+	if export.ContentType(format) == "" {
+		return "", export.ErrUnknownFormat
+	}
+	return format, nil
+}
+
+// ExportHandler handles HTTP GET requests to the "/api/v1/export" endpoint.
+// It streams every stored metric in the format set by the "format" query
+// parameter: "json" (the default), "ndjson", "csv" or "prom". The metrics are
+// read from the repository in batches of TransferBatchSize ordered by name,
+// and each batch is flushed to the client, so the response is sent with
+// chunked encoding and the export is never held in memory as a whole. If the
+// repository fails once the response has started, the connection is aborted
+// so the client doesn't take a truncated export for a complete one. Series
+// marked stale by the expiry sweeper are exported too, as they are still
+// stored.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) ExportHandler(res http.ResponseWriter, req *http.Request) {
+	format, err := transferFormat(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res.Header().Set("Content-Type", export.ContentType(format))
+	w, err := export.NewWriter(format, res)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc := http.NewResponseController(res)
+	filter := metric.Filter{Sort: metric.SortName, Limit: TransferBatchSize}
+	started := false
+	for {
+		var metrics []*metric.Metric
+		err = Retry(mr.RetryCount, func() (err error) {
+			metrics, err = mr.Repository.ListMetrics(req.Context(), filter)
+			return err
+		})
+		if err != nil {
+			logger.Log.Info("error list metrics", zap.Error(err))
+			if started {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(res, "error list metrics", http.StatusInternalServerError)
+			return
+		}
+
+		for _, m := range metrics {
+			if err = w.Write(m); err != nil {
+				logger.Log.Info("error write export", zap.Error(err))
+				panic(http.ErrAbortHandler)
+			}
+		}
+		started = true
+		if len(metrics) < TransferBatchSize {
+			break
+		}
+		if err = rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			logger.Log.Info("error flush export", zap.Error(err))
+			return
+		}
+		last := metrics[len(metrics)-1]
+		filter.AfterID, filter.AfterType = last.ID, last.MType
+	}
+
+	if err = w.Close(); err != nil {
+		logger.Log.Info("error write export", zap.Error(err))
+	}
+}
+
+// ImportHandler handles HTTP POST requests to the "/api/v1/import" endpoint.
+// It reads metrics in the format set by the "format" query parameter, the
+// same formats as the export endpoint, and writes them to the repository in
+// batches of TransferBatchSize, or MaxBatchMetrics if it is smaller, as they
+// arrive, so a chunked upload is never held in memory as a whole.
+//
+// Gauges are set to the imported values. Counters are set to the imported
+// totals: the delta written is the difference to the total the counter had
+// when the import started, so importing the same export twice doesn't
+// double the counters, and increments that arrive during the import are
+// kept. The imported metrics pass the same type and series limit checks as
+// updates.
+//
+// The response is a JSON object with the number of imported metrics. If the
+// input is malformed, the batches before the bad record stay imported, and
+// the error tells how many metrics that were.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) ImportHandler(res http.ResponseWriter, req *http.Request) {
+	format, err := transferFormat(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r, err := export.NewReader(format, req.Body)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			logger.Log.Info("can't close request body", zap.Error(err))
+		}
+	}(req.Body)
+
+	var counters []*metric.Metric
+	err = Retry(mr.RetryCount, func() (err error) {
+		counters, err = mr.Repository.GetCounters(req.Context())
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get counters", zap.Error(err))
+		http.Error(res, "error get counters", http.StatusInternalServerError)
+		return
+	}
+	totals := make(map[string]int64, len(counters))
+	for _, c := range counters {
+		totals[c.ID] = *c.Delta
+	}
+
+	batchSize := TransferBatchSize
+	if mr.MaxBatchMetrics > 0 && mr.MaxBatchMetrics < batchSize {
+		batchSize = mr.MaxBatchMetrics
+	}
+	imported := 0
+	batch := make([]metric.Metric, 0, batchSize)
+	for {
+		m, err := r.Read()
+		if err != nil && !errors.Is(err, io.EOF) {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(res, "request body too large, "+strconv.Itoa(imported)+" metrics imported", http.StatusRequestEntityTooLarge)
+				return
+			}
+			logger.Log.Info("bad import", zap.Error(err))
+			http.Error(res, err.Error()+", "+strconv.Itoa(imported)+" metrics imported", http.StatusBadRequest)
+			return
+		}
+		if err == nil {
+			if m.MType == MetricTypeCounter {
+				delta := *m.Delta - totals[m.ID]
+				totals[m.ID] = *m.Delta
+				m.Delta = &delta
+			}
+			batch = append(batch, m)
+		}
+		if len(batch) == batchSize || (errors.Is(err, io.EOF) && len(batch) > 0) {
+			status, err := mr.importBatch(req, batch)
+			if err != nil {
+				http.Error(res, err.Error()+", "+strconv.Itoa(imported)+" metrics imported", status)
+				return
+			}
+			imported += len(batch)
+			batch = batch[:0]
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(importResponse{Imported: imported}); err != nil {
+		logger.Log.Info("error encode response", zap.Error(err))
+	}
+}
+
+// importBatch writes a batch of imported metrics to the repository. It
+// returns the status of the error response if the batch is rejected or the
+// repository fails.
+func (mr *MetricRouter) importBatch(req *http.Request, batch []metric.Metric) (int, error) {
+	if err := mr.checkMetadata(batch); err != nil {
+		logger.Log.Info("metric type conflict", zap.Error(err))
+		return http.StatusConflict, errors.New("metric type conflict")
+	}
+	admission, err := mr.admitMetrics(req, batch)
+	if err != nil {
+		logger.Log.Info("series limit exceeded", zap.Error(err))
+		return http.StatusUnprocessableEntity, errors.New("series limit exceeded")
+	}
+	metrics := admission.Metrics
+
+	before := mr.auditSnapshot(req.Context(), metrics)
+	err = Retry(mr.RetryCount, func() error {
+		return mr.Repository.UpdateMetrics(req.Context(), &metrics)
+	})
+	if err != nil {
+		mr.Cardinality.Rollback(admission)
+		logger.Log.Info("error update metrics", zap.Error(err))
+		return http.StatusInternalServerError, errors.New("error update metrics")
+	}
+
+	mr.auditUpdate(req, before, metrics)
+	mr.Expiry.Refresh(metrics)
+	mr.publish(req, metrics)
+	if err = mr.DumpMetrics(metrics); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
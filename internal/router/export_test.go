@@ -0,0 +1,69 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/export"
+)
+
+func TestExportImportHandlers(t *testing.T) {
+	source := NewMemoryStorage()
+	// More metrics than a batch, so the export is read in several pages.
+	for i := 0; i < 2*TransferBatchSize+10; i++ {
+		source.Gauge[fmt.Sprintf("gauge%04d", i)] = float64(i) / 4
+	}
+	source.Counter["PollCount"] = 7
+	source.Counter["Free.Count"] = 3
+	sourceRouter := NewMetricRouter(chi.NewRouter(), source, &config.ServerConfig{StoreInterval: 300})
+	sourceServer := httptest.NewServer(sourceRouter.Router)
+	defer sourceServer.Close()
+
+	for _, format := range export.Formats {
+		t.Run(format, func(t *testing.T) {
+			resp, body := testRequest(t, sourceServer, http.MethodGet, "/api/v1/export?format="+format, false)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, export.ContentType(format), resp.Header.Get("Content-Type"))
+
+			target := NewMemoryStorage()
+			target.Counter["PollCount"] = 5
+			targetRouter := NewMetricRouter(chi.NewRouter(), target, &config.ServerConfig{StoreInterval: 300})
+			targetServer := httptest.NewServer(targetRouter.Router)
+			defer targetServer.Close()
+
+			// Importing twice sets the counters rather than adding to them.
+			for i := 0; i < 2; i++ {
+				resp, imported := testJSONRequest(t, targetServer, http.MethodPost, "/api/v1/import?format="+format, body, export.ContentType(format))
+				require.Equal(t, http.StatusOK, resp.StatusCode, imported)
+				assert.JSONEq(t, fmt.Sprintf(`{"imported":%d}`, 2*TransferBatchSize+12), imported)
+			}
+			assert.Equal(t, source.Gauge, target.Gauge)
+			assert.Equal(t, source.Counter, target.Counter)
+		})
+	}
+}
+
+func TestImportHandler_Errors(t *testing.T) {
+	repo := NewMemoryStorage()
+	metricRouter := NewMetricRouter(chi.NewRouter(), repo, &config.ServerConfig{StoreInterval: 300, MaxBatchMetrics: 1})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testJSONRequest(t, ts, http.MethodPost, "/api/v1/import?format=ndjson",
+		"{\"id\":\"a\",\"type\":\"gauge\",\"value\":1}\n{\"id\":\"b\",\"type\":\"gauge\"}\n", export.ContentType(export.FormatNDJSON))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, `record 2: gauge \"b\" has no value, 1 metrics imported`)
+	assert.Equal(t, map[string]float64{"a": 1}, repo.Gauge)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/export?format=xml", false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/import?format=xml", "", "application/xml")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
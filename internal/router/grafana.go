@@ -0,0 +1,350 @@
+package router
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/rate"
+)
+
+// Types of the targets of a Grafana query.
+const (
+	GrafanaTimeSeries = "timeserie"
+	GrafanaTable      = "table"
+)
+
+// The requests and responses of the Grafana JSON datasource protocol. Times
+// in the responses are Unix milliseconds.
+type (
+	grafanaRange struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	}
+
+	grafanaSearchRequest struct {
+		Target string `json:"target"`
+	}
+
+	grafanaTarget struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId,omitempty"`
+		Type   string `json:"type,omitempty"`
+	}
+
+	grafanaQueryRequest struct {
+		Range         grafanaRange    `json:"range"`
+		Targets       []grafanaTarget `json:"targets"`
+		MaxDataPoints int             `json:"maxDataPoints,omitempty"`
+	}
+
+	grafanaSeries struct {
+		Target     string       `json:"target"`
+		Datapoints [][2]float64 `json:"datapoints"`
+	}
+
+	grafanaColumn struct {
+		Text string `json:"text"`
+		Type string `json:"type"`
+	}
+
+	grafanaTableResult struct {
+		Type    string          `json:"type"`
+		Columns []grafanaColumn `json:"columns"`
+		Rows    [][]any         `json:"rows"`
+	}
+
+	grafanaAnnotationQuery struct {
+		Name   string `json:"name"`
+		Query  string `json:"query,omitempty"`
+		Enable bool   `json:"enable"`
+	}
+
+	grafanaAnnotationRequest struct {
+		Range      grafanaRange           `json:"range"`
+		Annotation grafanaAnnotationQuery `json:"annotation"`
+	}
+
+	grafanaAnnotation struct {
+		Annotation grafanaAnnotationQuery `json:"annotation"`
+		Title      string                 `json:"title"`
+		Text       string                 `json:"text,omitempty"`
+		Tags       []string               `json:"tags,omitempty"`
+		Time       int64                  `json:"time"`
+		TimeEnd    int64                  `json:"timeEnd,omitempty"`
+	}
+)
+
+// decodeGrafanaRequest decodes the JSON body of a Grafana request. It writes
+// the error response and returns false if the body is malformed.
+func decodeGrafanaRequest(res http.ResponseWriter, req *http.Request, v any) bool {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		http.Error(res, "only application/json content-type allowed", http.StatusBadRequest)
+		return false
+	}
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		logger.Log.Info("can't decode request body", zap.Error(err))
+		http.Error(res, "can't decode request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeGrafanaResponse writes the JSON response of a Grafana request.
+func writeGrafanaResponse(res http.ResponseWriter, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+	}
+}
+
+// matchTarget reports whether a metric name matches a target of a Grafana
+// query: the name itself or a glob pattern (see path.Match).
+func matchTarget(target, name string) bool {
+	if target == name {
+		return true
+	}
+	ok, _ := path.Match(target, name)
+	return ok
+}
+
+// grafanaMetrics reads the metrics for a Grafana request, skipping the stale
+// series. It writes the error response and returns false if the repository
+// fails.
+func (mr *MetricRouter) grafanaMetrics(res http.ResponseWriter, req *http.Request) ([]*metric.Metric, bool) {
+	var (
+		metrics []*metric.Metric
+		err     error
+	)
+	err = Retry(mr.RetryCount, func() (err error) {
+		metrics, err = mr.Repository.GetMetrics(req.Context())
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get metrics", zap.Error(err))
+		http.Error(res, "error get metrics", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	visible := make([]*metric.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if !mr.Expiry.IsStale(m.MType, m.ID) {
+			visible = append(visible, m)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		return metric.Filter{}.Less(visible[i], visible[j])
+	})
+	return visible, true
+}
+
+// GrafanaTestHandler handles HTTP GET requests to the "/api/v1/grafana"
+// endpoint, which Grafana calls to test the datasource.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) GrafanaTestHandler(res http.ResponseWriter, _ *http.Request) {
+	writeGrafanaResponse(res, map[string]string{"status": "ok"})
+}
+
+// GrafanaSearchHandler handles HTTP POST requests to the
+// "/api/v1/grafana/search" endpoint. It writes the names of the metrics that
+// match the target of the request, a glob pattern or a substring of the name
+// as on the dashboard, as a JSON array.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) GrafanaSearchHandler(res http.ResponseWriter, req *http.Request) {
+	var body grafanaSearchRequest
+	if !decodeGrafanaRequest(res, req, &body) {
+		return
+	}
+	metrics, ok := mr.grafanaMetrics(res, req)
+	if !ok {
+		return
+	}
+
+	names := []string{}
+	for i, m := range metrics {
+		if (i == 0 || metrics[i-1].ID != m.ID) && matchFilter(body.Target, m.ID) {
+			names = append(names, m.ID)
+		}
+	}
+	writeGrafanaResponse(res, names)
+}
+
+// GrafanaQueryHandler handles HTTP POST requests to the
+// "/api/v1/grafana/query" endpoint. Each target of the request is a metric
+// name or a glob pattern. A "timeserie" target is answered with a series per
+// matching metric with its sampled history in the range of the request, or
+// its current value if it has no history; a "table" target with a table of
+// the current values of the matching metrics.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) GrafanaQueryHandler(res http.ResponseWriter, req *http.Request) {
+	var body grafanaQueryRequest
+	if !decodeGrafanaRequest(res, req, &body) {
+		return
+	}
+	for _, target := range body.Targets {
+		if _, err := path.Match(target.Target, ""); err != nil {
+			http.Error(res, "bad target pattern", http.StatusBadRequest)
+			return
+		}
+		if target.Type != "" && target.Type != GrafanaTimeSeries && target.Type != GrafanaTable {
+			http.Error(res, "unknown target type", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.Range.To.IsZero() {
+		body.Range.To = time.Now()
+	}
+
+	metrics, ok := mr.grafanaMetrics(res, req)
+	if !ok {
+		return
+	}
+
+	response := []any{}
+	for _, target := range body.Targets {
+		if target.Type == GrafanaTable {
+			table := grafanaTableResult{
+				Type:    GrafanaTable,
+				Columns: []grafanaColumn{{Text: "Metric", Type: "string"}, {Text: "Type", Type: "string"}, {Text: "Value", Type: "number"}},
+				Rows:    [][]any{},
+			}
+			for _, m := range metrics {
+				if matchTarget(target.Target, m.ID) {
+					table.Rows = append(table.Rows, []any{m.ID, m.MType, metricValue(m)})
+				}
+			}
+			response = append(response, table)
+			continue
+		}
+
+		for _, m := range metrics {
+			if !matchTarget(target.Target, m.ID) {
+				continue
+			}
+			series := grafanaSeries{Target: m.ID, Datapoints: grafanaDatapoints(mr.historyOf(m.MType, m.ID), body.Range, body.MaxDataPoints)}
+			if len(series.Datapoints) == 0 && !body.Range.From.After(time.Now()) {
+				at := time.Now()
+				if at.After(body.Range.To) {
+					at = body.Range.To
+				}
+				series.Datapoints = grafanaDatapoints([]rate.Sample{{At: at, Value: metricValue(m)}}, body.Range, 0)
+			}
+			response = append(response, series)
+		}
+	}
+	writeGrafanaResponse(res, response)
+}
+
+// metricValue returns the value of a gauge or the total of a counter.
+func metricValue(m *metric.Metric) float64 {
+	if m.Value != nil {
+		return *m.Value
+	}
+	if m.Delta != nil {
+		return float64(*m.Delta)
+	}
+	return math.NaN()
+}
+
+// grafanaDatapoints converts the samples in a range to datapoints. If there
+// are more than maxPoints samples, evenly spaced samples are picked, always
+// including the last one.
+func grafanaDatapoints(samples []rate.Sample, r grafanaRange, maxPoints int) [][2]float64 {
+	var inRange []rate.Sample
+	for _, s := range samples {
+		if s.At.Before(r.From) || s.At.After(r.To) || math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		inRange = append(inRange, s)
+	}
+
+	step := 1
+	if maxPoints > 0 && len(inRange) > maxPoints {
+		step = (len(inRange) + maxPoints - 1) / maxPoints
+	}
+	points := make([][2]float64, 0, len(inRange)/step+1)
+	for i := len(inRange) - 1; i >= 0; i -= step {
+		points = append(points, [2]float64{inRange[i].Value, float64(inRange[i].At.UnixMilli())})
+	}
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+	return points
+}
+
+// GrafanaAnnotationsHandler handles HTTP POST requests to the
+// "/api/v1/grafana/annotations" endpoint. It writes the alerts that fired in
+// the range of the request as annotations that span until the alerts were
+// resolved. The alerts about the agents that are down have no pending state
+// and are annotated from the time they became active. The query of the
+// annotation is a glob pattern that filters the alerts by name.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) GrafanaAnnotationsHandler(res http.ResponseWriter, req *http.Request) {
+	var body grafanaAnnotationRequest
+	if !decodeGrafanaRequest(res, req, &body) {
+		return
+	}
+	if _, err := path.Match(body.Annotation.Query, ""); err != nil {
+		http.Error(res, "bad annotation query", http.StatusBadRequest)
+		return
+	}
+	if body.Range.To.IsZero() {
+		body.Range.To = time.Now()
+	}
+
+	var alerts []alert.Alert
+	if mr.Alerts != nil {
+		alerts = mr.Alerts.Alerts()
+	}
+	alerts = append(alerts, mr.Agents.Alerts(time.Now())...)
+
+	annotations := []grafanaAnnotation{}
+	for _, a := range alerts {
+		firedAt := a.FiredAt
+		if firedAt == nil && a.State == "" {
+			firedAt = &a.ActiveAt
+		}
+		if firedAt == nil || firedAt.Before(body.Range.From) || firedAt.After(body.Range.To) {
+			continue
+		}
+		if body.Annotation.Query != "" && !matchTarget(body.Annotation.Query, a.Name) {
+			continue
+		}
+		annotation := grafanaAnnotation{
+			Annotation: body.Annotation,
+			Title:      a.Name,
+			Text:       a.Description,
+			Time:       firedAt.UnixMilli(),
+		}
+		if a.ResolvedAt != nil {
+			annotation.TimeEnd = a.ResolvedAt.UnixMilli()
+		}
+		for k, v := range a.Labels {
+			annotation.Tags = append(annotation.Tags, k+":"+v)
+		}
+		sort.Strings(annotation.Tags)
+		annotations = append(annotations, annotation)
+	}
+	writeGrafanaResponse(res, annotations)
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/rate"
+)
+
+func TestGrafanaHandlers(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["CPUutilization1"] = 30
+	serverRepository.Gauge["CPUutilization2"] = 10
+	serverRepository.Gauge["FreeMemory"] = 50
+	serverRepository.Counter["PollCount"] = 7
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	metricRouter.Gauges = rate.NewHistory(time.Hour)
+	start := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	for i, v := range []float64{10, 20, 30, 40} {
+		metricRouter.Gauges.Record("CPUutilization1", v, start.Add(time.Duration(i)*time.Minute))
+	}
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+	ms := func(at time.Time) int64 { return at.UnixMilli() }
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/grafana", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+
+	resp, body = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/search", `{"target":"cpu"}`, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `["CPUutilization1","CPUutilization2"]`, body)
+
+	resp, body = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/search", `{"target":""}`, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `["CPUutilization1","CPUutilization2","FreeMemory","PollCount"]`, body)
+
+	query := fmt.Sprintf(`{"range":{"from":%q,"to":%q},"maxDataPoints":2,"targets":[{"target":"CPUutilization1","refId":"A"}]}`,
+		start.Add(30*time.Second).Format(time.RFC3339), start.Add(5*time.Minute).Format(time.RFC3339))
+	resp, body = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/query", query, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, fmt.Sprintf(`[{"target":"CPUutilization1","datapoints":[[20,%d],[40,%d]]}]`,
+		ms(start.Add(time.Minute)), ms(start.Add(3*time.Minute))), body)
+
+	now := time.Now().Truncate(time.Second)
+	query = fmt.Sprintf(`{"range":{"from":%q,"to":%q},"targets":[{"target":"PollCount"},{"target":"CPU*2","type":"table"}]}`,
+		now.Add(-time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))
+	resp, body = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/query", query, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, fmt.Sprintf(`[
+		{"target":"PollCount","datapoints":[[7,%d]]},
+		{"type":"table","columns":[{"text":"Metric","type":"string"},{"text":"Type","type":"string"},{"text":"Value","type":"number"}],"rows":[["CPUutilization2","gauge",10]]}
+	]`, ms(now)), body)
+
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/query", `{"targets":[{"target":"[","type":"timeserie"}]}`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/query", `{"targets":[{"target":"PollCount","type":"heatmap"}]}`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/search", `{`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestGrafanaAnnotationsHandler(t *testing.T) {
+	rules, err := alert.ParseRules([]byte(`[
+		{"name":"HighCPU","expr":"CPUutilization1 > 20","labels":{"severity":"page"},"description":"CPU is high"},
+		{"name":"LowMemory","expr":"FreeMemory < 10"}
+	]`))
+	require.NoError(t, err)
+	engine := alert.NewEngine(rules)
+	firedAt := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	gauge := func(name string, v float64) *metric.Metric {
+		return &metric.Metric{ID: name, MType: MetricTypeGauge, Value: &v}
+	}
+	engine.Eval(firedAt, []*metric.Metric{gauge("CPUutilization1", 30), gauge("FreeMemory", 5)})
+
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, NewMemoryStorage(), &config.ServerConfig{StoreInterval: 300})
+	metricRouter.Alerts = engine
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	request := fmt.Sprintf(`{"range":{"from":%q,"to":%q},"annotation":{"name":"alerts","query":"High*","enable":true}}`,
+		firedAt.Add(-time.Hour).Format(time.RFC3339), time.Now().Add(time.Minute).Format(time.RFC3339))
+	resp, body := testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/annotations", request, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, fmt.Sprintf(`[{"annotation":{"name":"alerts","query":"High*","enable":true},"title":"HighCPU","text":"CPU is high","tags":["severity:page"],"time":%d}]`,
+		firedAt.UnixMilli()), body)
+
+	request = fmt.Sprintf(`{"range":{"from":%q,"to":%q},"annotation":{"name":"alerts"}}`,
+		firedAt.Add(-time.Hour).Format(time.RFC3339), firedAt.Add(-time.Minute).Format(time.RFC3339))
+	_, body = testJSONRequest(t, ts, http.MethodPost, "/api/v1/grafana/annotations", request, "application/json")
+	assert.JSONEq(t, `[]`, body)
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/idempotency"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// BatchUpdater defines the method for applying a batch of metrics at most
+// once. Repositories that implement it record applied batch keys together
+// with the metrics, so duplicates are detected even after a restart.
+type BatchUpdater interface {
+	UpdateMetricsOnce(ctx context.Context, batchKey string, metrics *[]metric.Metric) (bool, error)
+}
+
+// UpdateMetricsOnce applies a batch of metrics to the provided Repository.
+// If the batch has a key and the Repository implements the BatchUpdater
+// interface, the batch is applied at most once. Otherwise, it is applied
+// with UpdateMetrics.
+//
+// Parameters:
+//   - r: A Repository instance to apply the metrics to.
+//   - ctx: A context.Context to control the lifetime of the update.
+//   - batchKey: The key of the batch built with idempotency.Key, or an empty
+//     string if it has no ID.
+//   - metrics: The metrics of the batch.
+//
+// Returns:
+//   - False if the batch has already been applied; otherwise, true.
+//   - An error if the update fails.
+func UpdateMetricsOnce(r Repository, ctx context.Context, batchKey string, metrics *[]metric.Metric) (bool, error) {
+	if updater, ok := r.(BatchUpdater); ok && batchKey != "" {
+		return updater.UpdateMetricsOnce(ctx, batchKey, metrics)
+	}
+	return true, r.UpdateMetrics(ctx, metrics)
+}
+
+// NewBatchCache creates an idempotency.Cache with the TTL and capacity
+// configured for the server.
+func NewBatchCache[T any](serverConfig *config.ServerConfig) *idempotency.Cache[T] {
+	return idempotency.New[T](time.Duration(serverConfig.BatchIDTTL)*time.Second, serverConfig.BatchCacheSize)
+}
+
+// beginBatch starts processing a batch sent with a batch ID. If the batch has
+// already been applied and its response is known, it writes the response and
+// returns done. If the batch has been applied but its response is not known,
+// e.g. because reading back the metrics failed, it returns replay: the
+// caller must not apply the batch again and answers with the current values.
+// The returned key is empty if the batch has no ID.
+func (mr *MetricRouter) beginBatch(res http.ResponseWriter, req *http.Request) (key string, replay bool, done bool) {
+	batchID := req.Header.Get(idempotency.HeaderBatchID)
+	if batchID == "" {
+		return "", false, false
+	}
+	key = idempotency.Key(clientid.FromRequest(req), batchID)
+	if mr.Batches == nil {
+		return key, false, false
+	}
+
+	data, done, err := mr.Batches.Begin(req.Context(), key)
+	if err != nil {
+		logger.Log.Info("error wait for duplicate batch", zap.Error(err))
+		http.Error(res, "error wait for duplicate batch", http.StatusServiceUnavailable)
+		return "", false, true
+	}
+	if !done {
+		return key, false, false
+	}
+
+	logger.Log.Info("duplicate batch", zap.String("batchID", batchID))
+	if data == nil {
+		return key, true, false
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if _, err = res.Write(data); err != nil {
+		logger.Log.Info("error write response data", zap.Error(err))
+	}
+	return "", false, true
+}
+
+// completeBatch stores the response of an applied batch. A nil response
+// only marks the batch applied, duplicates are then answered with the
+// current values of its metrics.
+func (mr *MetricRouter) completeBatch(key string, data []byte) {
+	if key != "" && mr.Batches != nil {
+		mr.Batches.Complete(key, data)
+	}
+}
+
+// abortBatch forgets a batch that wasn't applied. It does nothing for a
+// batch marked applied.
+func (mr *MetricRouter) abortBatch(key string) {
+	if key != "" && mr.Batches != nil {
+		mr.Batches.Abort(key)
+	}
+}
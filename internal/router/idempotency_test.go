@@ -0,0 +1,101 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/idempotency"
+	"github.com/Vidkin/metrics/pkg/clientid"
+	"github.com/Vidkin/metrics/pkg/middleware"
+)
+
+func TestBatchDeduplication(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	// The test server is a trusted proxy, so the clients are told apart by
+	// their real IP addresses.
+	proxies, err := clientid.ParseProxies("127.0.0.0/8")
+	require.NoError(t, err)
+	chiRouter.Use(middleware.RealIP(proxies))
+	serverConfig := config.ServerConfig{StoreInterval: 300}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	send := func(realIP, batchID string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewBufferString(`[{"id": "c1", "type": "counter", "delta": 5}]`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "")
+		req.Header.Set(clientid.HeaderRealIP, realIP)
+		if batchID != "" {
+			req.Header.Set(idempotency.HeaderBatchID, batchID)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, first := send("10.0.0.1", "batch1")
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `[{"id": "c1", "type": "counter", "delta": 5}]`, first)
+
+	status, retry := send("10.0.0.1", "batch1")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, first, retry)
+	assert.Equal(t, int64(5), serverRepository.Counter["c1"])
+
+	status, _ = send("10.0.0.2", "batch1")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(10), serverRepository.Counter["c1"])
+
+	status, _ = send("10.0.0.1", "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = send("10.0.0.1", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(20), serverRepository.Counter["c1"])
+}
+
+func TestBatchDeduplication_AppliedBatchFails(t *testing.T) {
+	// The memory storage can't dump metrics, so with StoreInterval 0 every
+	// batch fails after it has been applied.
+	serverRepository := NewMemoryStorage()
+	metricRouter := NewMetricRouter(chi.NewRouter(), serverRepository, &config.ServerConfig{StoreInterval: 0})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	send := func() (int, string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewBufferString(`[{"id": "c1", "type": "counter", "delta": 5}]`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "")
+		req.Header.Set(idempotency.HeaderBatchID, "batch1")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, _ := send()
+	require.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, int64(5), serverRepository.Counter["c1"])
+
+	// The retry isn't applied again and is answered with the current values.
+	status, retry := send()
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `[{"id": "c1", "type": "counter", "delta": 5}]`, retry)
+	assert.Equal(t, int64(5), serverRepository.Counter["c1"])
+}
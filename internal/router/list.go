@@ -0,0 +1,153 @@
+package router
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// Query parameters of the metrics listing endpoint. The type and prefix
+// parameters are shared with the bulk delete, the sort and order parameters
+// with the dashboard.
+const (
+	ParamLimit  = "limit"
+	ParamCursor = "cursor"
+)
+
+// Page sizes of the metrics listing endpoint.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// listedMetric is a metric in a response of the metrics listing endpoint.
+type listedMetric struct {
+	metric.Metric
+	Stale bool `json:"stale,omitempty"`
+}
+
+// listResponse is a response of the metrics listing endpoint.
+type listResponse struct {
+	Metrics    []listedMetric `json:"metrics"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// encodeCursor returns the cursor of the page that starts after a metric.
+func encodeCursor(m *metric.Metric) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(m.MType + "/" + m.ID))
+}
+
+// decodeCursor returns the type and the name of the metric a cursor points
+// after.
+func decodeCursor(cursor string) (string, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", err
+	}
+	mType, name, ok := strings.Cut(string(b), "/")
+	if !ok || (mType != MetricTypeGauge && mType != MetricTypeCounter) || name == "" {
+		return "", "", errors.New("malformed cursor")
+	}
+	return mType, name, nil
+}
+
+// parseFilter builds the filter of a request to the metrics listing
+// endpoint. It asks for one metric more than the page size, so the handler
+// can tell whether there is a next page.
+func parseFilter(req *http.Request) (metric.Filter, error) {
+	q := req.URL.Query()
+	filter := metric.Filter{
+		Type:   q.Get(QueryType),
+		Prefix: q.Get(QueryPrefix),
+		Sort:   q.Get(ParamSort),
+		Limit:  DefaultListLimit,
+	}
+	if filter.Type != "" && filter.Type != MetricTypeGauge && filter.Type != MetricTypeCounter {
+		return filter, errors.New("unknown metric type")
+	}
+	switch filter.Sort {
+	case "":
+		filter.Sort = metric.SortName
+	case metric.SortName, metric.SortType:
+	default:
+		return filter, errors.New("unknown sort")
+	}
+	switch q.Get(ParamOrder) {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, errors.New("unknown order")
+	}
+	if s := q.Get(ParamLimit); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(MaxListLimit))
+		}
+		filter.Limit = limit
+	}
+	if s := q.Get(ParamCursor); s != "" {
+		mType, name, err := decodeCursor(s)
+		if err != nil {
+			return filter, errors.New("bad cursor")
+		}
+		filter.AfterType, filter.AfterID = mType, name
+	}
+	filter.Limit++
+	return filter, nil
+}
+
+// ListMetricsHandler handles HTTP GET requests to the "/api/v1/metrics"
+// endpoint. It writes a page of the stored metrics as JSON, filtered by the
+// "type" and "prefix" query parameters and ordered by the "sort" ("name" or
+// "type") and "order" ("asc" or "desc") query parameters. The "limit" query
+// parameter sets the page size. If there are more metrics, the response has
+// a "nextCursor" that is passed as the "cursor" query parameter to get the
+// next page. Series marked stale by the expiry sweeper are listed with
+// "stale": true.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) ListMetricsHandler(res http.ResponseWriter, req *http.Request) {
+	filter, err := parseFilter(req)
+	if err != nil {
+		logger.Log.Info("bad list request", zap.Error(err))
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var metrics []*metric.Metric
+	err = Retry(mr.RetryCount, func() (err error) {
+		metrics, err = mr.Repository.ListMetrics(req.Context(), filter)
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error list metrics", zap.Error(err))
+		http.Error(res, "error list metrics", http.StatusInternalServerError)
+		return
+	}
+
+	var response listResponse
+	if len(metrics) == filter.Limit {
+		metrics = metrics[:filter.Limit-1]
+		response.NextCursor = encodeCursor(metrics[len(metrics)-1])
+	}
+	response.Metrics = make([]listedMetric, 0, len(metrics))
+	for _, m := range metrics {
+		response.Metrics = append(response.Metrics, listedMetric{Metric: *m, Stale: mr.Expiry.IsStale(m.MType, m.ID)})
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(response); err != nil {
+		logger.Log.Info("error encode metrics", zap.Error(err))
+	}
+}
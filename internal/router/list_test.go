@@ -0,0 +1,52 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+)
+
+func TestListMetricsHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["HeapAlloc"] = 1
+	serverRepository.Gauge["HeapInuse"] = 2
+	serverRepository.Gauge["HeapSys"] = 3
+	serverRepository.Gauge["Alloc"] = 4
+	serverRepository.Counter["PollCount"] = 5
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/metrics?type=gauge&prefix=Heap&limit=2", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var page listResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	require.NotEmpty(t, page.NextCursor)
+	assert.JSONEq(t, `{"metrics":[{"id":"HeapAlloc","type":"gauge","value":1},{"id":"HeapInuse","type":"gauge","value":2}],"nextCursor":"`+page.NextCursor+`"}`, body)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?type=gauge&prefix=Heap&limit=2&cursor="+page.NextCursor, false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"metrics":[{"id":"HeapSys","type":"gauge","value":3}]}`, body)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?sort=type&order=desc&limit=2", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"metrics":[{"value":3,"id":"HeapSys","type":"gauge"},{"value":2,"id":"HeapInuse","type":"gauge"}]`)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?prefix=Missing", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"metrics":[]}`, body)
+
+	for _, query := range []string{"type=summary", "sort=value", "order=up", "limit=0", "limit=1001", "cursor=%21", "cursor=Zm9v"} {
+		resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?"+query, false)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
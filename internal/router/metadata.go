@@ -0,0 +1,149 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metadata"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// NewMetadataRegistry creates a metadata.Registry configured with the
+// metadata settings of the server. In strict mode it also learns the types
+// of the metrics already stored in the repository, so they can't be
+// overwritten with another type after a restart.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the repository read.
+//   - repository: The repository holding the already stored metrics.
+//   - serverConfig: The server configuration with the metadata settings.
+//
+// Returns:
+//   - A pointer to the newly created Registry.
+//   - An error if the metadata file is invalid or the stored metrics can't
+//     be read.
+func NewMetadataRegistry(ctx context.Context, repository Repository, serverConfig *config.ServerConfig) (*metadata.Registry, error) {
+	registry, err := metadata.NewRegistry(serverConfig.MetadataFile, serverConfig.MetadataStrict)
+	if err != nil {
+		return nil, err
+	}
+	if !registry.Strict() {
+		return registry, nil
+	}
+	metrics, err := repository.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	registry.Observe(metrics)
+	return registry, nil
+}
+
+// checkMetadata checks the types of the metrics against the metadata
+// registry. If no registry is configured, all metrics are accepted.
+func (mr *MetricRouter) checkMetadata(metrics []metric.Metric) error {
+	if mr.Metadata == nil {
+		return nil
+	}
+	return mr.Metadata.Check(metrics)
+}
+
+// metadataOf returns the metadata of a metric name. It returns empty
+// metadata if the name is unknown or no registry is configured.
+func (mr *MetricRouter) metadataOf(name string) metadata.Metadata {
+	if mr.Metadata == nil {
+		return metadata.Metadata{}
+	}
+	md, _ := mr.Metadata.Get(name)
+	return md
+}
+
+// GetMetadataHandler handles HTTP GET requests to the "/api/v1/metadata"
+// endpoint. It writes the metadata of all metric names as a JSON array, or
+// the metadata of a single name if the "name" query parameter is set.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) GetMetadataHandler(res http.ResponseWriter, req *http.Request) {
+	if mr.Metadata == nil {
+		http.Error(res, "metadata registry is disabled", http.StatusNotFound)
+		return
+	}
+
+	var response any = mr.Metadata.List()
+	if name := req.URL.Query().Get("name"); name != "" {
+		md, ok := mr.Metadata.Get(name)
+		if !ok {
+			http.Error(res, "metadata not found", http.StatusNotFound)
+			return
+		}
+		response = md
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(response); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
+
+// PutMetadataHandler handles HTTP PUT requests to the "/api/v1/metadata"
+// endpoint. The request body is either a single metadata object or an array
+// of them. The registered metadata is written back as a JSON array.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) PutMetadataHandler(res http.ResponseWriter, req *http.Request) {
+	if mr.Metadata == nil {
+		http.Error(res, "metadata registry is disabled", http.StatusNotFound)
+		return
+	}
+	if req.Header.Get("Content-Type") != "application/json" {
+		http.Error(res, "only application/json content-type allowed", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(res, "can't read request body", http.StatusBadRequest)
+		return
+	}
+	var list []metadata.Metadata
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var md metadata.Metadata
+		err = json.Unmarshal(trimmed, &md)
+		list = append(list, md)
+	} else {
+		err = json.Unmarshal(body, &list)
+	}
+	if err != nil {
+		http.Error(res, "can't decode request body", http.StatusBadRequest)
+		return
+	}
+
+	for _, md := range list {
+		if err = md.Validate(); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err = mr.Metadata.Put(list...); err != nil {
+		logger.Log.Info("error save metadata", zap.Error(err))
+		http.Error(res, "error save metadata", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(list); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/metadata"
+)
+
+func TestMetadataHandlers(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300, MetadataStrict: true}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	registry, err := NewMetadataRegistry(context.Background(), serverRepository, &serverConfig)
+	require.NoError(t, err)
+	metricRouter.Metadata = registry
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testJSONRequest(t, ts, http.MethodPut, "/api/v1/metadata",
+		`{"name": "heap", "type": "gauge", "unit": "bytes", "description": "Heap size"}`, "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testJSONRequest(t, ts, http.MethodPut, "/api/v1/metadata",
+		`[{"name": "bad", "type": "summary"}]`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/counter/heap/1", false)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/updates/",
+		`[{"id": "m1", "type": "gauge", "value": 1}, {"id": "m1", "type": "counter", "delta": 1}]`, "application/json")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Empty(t, serverRepository.Counter)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/heap/10", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/counter/requests/3", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/metadata", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list []metadata.Metadata
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Equal(t, []metadata.Metadata{
+		{Name: "heap", Type: "gauge", Unit: "bytes", Description: "Heap size"},
+		{Name: "requests", Type: "counter"},
+	}, list)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/metadata?name=unknown", false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/?format=text", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "heap = 10 bytes (Heap size)\n")
+	assert.Contains(t, body, "requests = 3\n")
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/value/counter/requests", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/requests/1", false)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["heap.size"] = 1.5
+	serverRepository.Gauge["dup"] = 2
+	serverRepository.Counter["dup"] = 3
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	registry, err := NewMetadataRegistry(context.Background(), serverRepository, &serverConfig)
+	require.NoError(t, err)
+	metricRouter.Metadata = registry
+	require.NoError(t, metricRouter.Metadata.Put(metadata.Metadata{
+		Name: "heap.size", Type: "gauge", Unit: "bytes", Description: "Heap\nsize",
+	}))
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, PrometheusContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "# TYPE dup gauge\n"+
+		"dup 2\n"+
+		"# TYPE dup_total counter\n"+
+		"dup_total 3\n"+
+		"# HELP heap_size Heap\\nsize [bytes]\n"+
+		"# TYPE heap_size gauge\n"+
+		"heap_size 1.5\n", body)
+}
@@ -9,19 +9,25 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/cardinality"
 	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/idempotency"
+	"github.com/Vidkin/metrics/internal/liveness"
 	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metadata"
 	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/rate"
+	"github.com/Vidkin/metrics/internal/stream"
 	"github.com/Vidkin/metrics/pkg/middleware"
+	"github.com/Vidkin/metrics/pkg/ratelimit"
 )
 
 // Constants for metric parameters and types.
@@ -46,8 +52,8 @@ const (
 	ParamMetricName  = "metricName"
 	ParamMetricValue = "metricValue"
 
-	MetricTypeCounter = "counter"
-	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = metric.TypeCounter
+	MetricTypeGauge   = metric.TypeGauge
 )
 
 // MetricRouter is a struct that manages HTTP routing for metrics-related
@@ -70,12 +76,48 @@ const (
 //   - StoreInterval: An integer that specifies the interval for storing
 //     metrics, which can be used to control when metrics should be dumped
 //     to the repository.
+//   - MaxBatchMetrics: The maximum number of metrics accepted in a single
+//     batch update. Zero means no limit.
+//   - Cardinality: A limiter of the number of distinct series. If it is nil,
+//     the number of series is not limited.
+//   - Audit: An audit log of accepted changes. If it is nil, changes are not
+//     audited.
+//   - Batches: The responses of recently applied batches, by batch ID. If it
+//     is nil, retried batches are not deduplicated.
+//   - Expiry: The sweeper of expired series. Series it marks stale are hidden
+//     from the metrics page. If it is nil, series never expire.
+//   - Metadata: The registry of metric types, units and descriptions. If it
+//     is nil, metric types are not enforced.
+//   - Alerts: The alerting rules engine whose alerts are listed by the API.
+//     If it is nil, no alerts are listed.
+//   - Agents: The liveness tracker of the agents that report metrics. If it
+//     is nil, agents are not tracked.
+//   - Rates: The samples of counters the rates are computed from. If it is
+//     nil, rates are not computed.
+//   - Gauges: The samples of gauges drawn by the dashboard. If it is nil,
+//     the dashboard draws no history of gauges.
+//   - Stream: The hub the accepted updates are published to for the live
+//     stream. If it is nil, updates are not streamed.
+//   - Snapshots: The manager of the snapshots of the repository. If it is
+//     nil, snapshots are disabled.
 type MetricRouter struct {
-	Repository    Repository
-	Router        chi.Router
-	LastStoreTime time.Time
-	RetryCount    int
-	StoreInterval int
+	Repository      Repository
+	Router          chi.Router
+	Cardinality     *cardinality.Limiter
+	Audit           *audit.Auditor
+	Batches         *idempotency.Cache[[]byte]
+	Expiry          *Sweeper
+	Metadata        *metadata.Registry
+	Alerts          *alert.Engine
+	Agents          *liveness.Tracker
+	Rates           *rate.History
+	Gauges          *rate.History
+	Stream          *stream.Hub
+	Snapshots       *SnapshotManager
+	LastStoreTime   time.Time
+	RetryCount      int
+	StoreInterval   int
+	MaxBatchMetrics int
 }
 
 // Repository defines the methods required for a metrics data store.
@@ -93,6 +135,7 @@ type Repository interface {
 	GetMetrics(ctx context.Context) ([]*metric.Metric, error)
 	GetGauges(ctx context.Context) ([]*metric.Metric, error)
 	GetCounters(ctx context.Context) ([]*metric.Metric, error)
+	ListMetrics(ctx context.Context, filter metric.Filter) ([]*metric.Metric, error)
 }
 
 // Dumper defines the methods required for dump metrics
@@ -104,6 +147,12 @@ type Dumper interface {
 	FullDump() error
 }
 
+// BatchDumper is implemented by repositories that can dump several metrics
+// at once, e.g. with a single fsync of a write-ahead log.
+type BatchDumper interface {
+	DumpMetrics(metrics []metric.Metric) error
+}
+
 // Ping checks the availability of the provided Repository by attempting to
 // ping it. If the Repository implements the driver.Pinger interface, it
 // calls the Ping method on it, passing the provided context. If the
@@ -147,6 +196,21 @@ func DumpMetric(r Repository, m *metric.Metric) error {
 	return errors.New("provided Repository does not implement Dumper")
 }
 
+// DumpMetrics dumps metrics to the provided Repository. If the Repository
+// implements BatchDumper, the metrics are dumped at once; otherwise they are
+// dumped one by one with DumpMetric.
+func DumpMetrics(r Repository, metrics []metric.Metric) error {
+	if dumper, ok := r.(BatchDumper); ok {
+		return dumper.DumpMetrics(metrics)
+	}
+	for i := range metrics {
+		if err := DumpMetric(r, &metrics[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close attempts to close the provided Repository if it implements the
 // io.Closer interface. If the Repository does implement the Closer
 // interface, the function calls its Close method to release any resources
@@ -189,9 +253,17 @@ func Close(r Repository) error {
 func NewMetricRouter(router *chi.Mux, repository Repository, serverConfig *config.ServerConfig) *MetricRouter {
 	var mr MetricRouter
 	router.Use(middleware.Logging)
+	router.Use(middleware.Problem(APIPrefix))
 	if serverConfig.TrustedSubnet != "" {
 		router.Use(middleware.TrustedSubnet(serverConfig.TrustedSubnet))
 	}
+	if serverConfig.RateLimit > 0 || serverConfig.MaxBatchSize > 0 {
+		var limiter *ratelimit.Limiter
+		if serverConfig.RateLimit > 0 {
+			limiter = ratelimit.New(serverConfig.RateLimit, serverConfig.RateBurst)
+		}
+		router.Use(middleware.RateLimit(limiter, serverConfig.MaxBatchSize))
+	}
 	if serverConfig.Key != "" {
 		router.Use(middleware.Hash(serverConfig.Key))
 	}
@@ -199,69 +271,134 @@ func NewMetricRouter(router *chi.Mux, repository Repository, serverConfig *confi
 
 	router.Route("/", func(r chi.Router) {
 		r.Get("/", mr.RootHandler)
-		r.Route("/ping", func(r chi.Router) {
-			r.Get("/", mr.PingDBHandler)
-		})
-		r.Route("/value", func(r chi.Router) {
-			r.Post("/", mr.GetMetricValueHandlerJSON)
-			r.Get("/{metricType}/{metricName}", mr.GetMetricValueHandler)
-		})
-		r.Route("/update", func(r chi.Router) {
-			r.Post("/", mr.UpdateMetricHandlerJSON)
-			r.Post("/{metricType}/{metricName}/{metricValue}", mr.UpdateMetricHandler)
+		r.Get("/metrics", mr.PrometheusHandler)
+		r.Route("/dashboard", func(r chi.Router) {
+			r.Get("/static/*", mr.DashboardStaticHandler)
+			r.Get("/{metricType}/{metricName}", mr.MetricPageHandler)
 		})
-		r.Route("/updates", func(r chi.Router) {
-			r.Post("/", mr.UpdateMetricsHandlerJSON)
+		mr.metricRoutes(r)
+		r.Route(APIPrefix, func(r chi.Router) {
+			mr.metricRoutes(r)
+			r.Get("/openapi.json", mr.OpenAPIHandler)
+			r.Get("/admin/cardinality", mr.CardinalityHandler)
+			r.Route("/admin/snapshot", func(r chi.Router) {
+				r.Get("/", mr.SnapshotsHandler)
+				r.Post("/", mr.CreateSnapshotHandler)
+				r.Post("/{snapshotName}/restore", mr.RestoreSnapshotHandler)
+			})
+			r.Get("/metadata", mr.GetMetadataHandler)
+			r.Put("/metadata", mr.PutMetadataHandler)
+			r.Get("/alerts", mr.AlertsHandler)
+			r.Get("/rate/{metricName}", mr.RateHandler)
+			r.Get("/metrics", mr.ListMetricsHandler)
+			r.Get("/query", mr.QueryHandler)
+			r.Get("/stream", mr.StreamHandler)
+			r.Post("/read", mr.RemoteReadHandler)
+			r.Get("/export", mr.ExportHandler)
+			r.Post("/import", mr.ImportHandler)
+			r.Route("/grafana", func(r chi.Router) {
+				r.Get("/", mr.GrafanaTestHandler)
+				r.Post("/search", mr.GrafanaSearchHandler)
+				r.Post("/query", mr.GrafanaQueryHandler)
+				r.Post("/annotations", mr.GrafanaAnnotationsHandler)
+			})
+			r.Route("/agents", func(r chi.Router) {
+				r.Get("/", mr.AgentsHandler)
+				r.Delete("/{agentID}", mr.DeleteAgentHandler)
+			})
+			r.Route("/silences", func(r chi.Router) {
+				r.Get("/", mr.GetSilencesHandler)
+				r.Post("/", mr.CreateSilenceHandler)
+				r.Delete("/{silenceID}", mr.DeleteSilenceHandler)
+			})
 		})
 	})
 	mr.Router = router
 	mr.Repository = repository
 	mr.StoreInterval = (int)(serverConfig.StoreInterval)
 	mr.RetryCount = serverConfig.RetryCount
+	mr.MaxBatchMetrics = serverConfig.MaxBatchMetrics
+	mr.Batches = NewBatchCache[[]byte](serverConfig)
+	mr.Agents = NewAgentTracker(serverConfig)
+	mr.Stream = stream.NewHub(serverConfig.StreamBuffer)
 	mr.LastStoreTime = time.Now()
 	return &mr
 }
 
+// metricRoutes registers the routes that update, read and delete metrics.
+// They are served both under APIPrefix and, for the existing clients, at the
+// root.
+func (mr *MetricRouter) metricRoutes(r chi.Router) {
+	r.Route("/ping", func(r chi.Router) {
+		r.Get("/", mr.PingDBHandler)
+	})
+	r.Route("/value", func(r chi.Router) {
+		r.Post("/", mr.GetMetricValueHandlerJSON)
+		r.Delete("/", mr.DeleteMetricsHandler)
+		r.Get("/{metricType}/{metricName}", mr.GetMetricValueHandler)
+		r.Delete("/{metricType}/{metricName}", mr.DeleteMetricHandler)
+	})
+	r.Route("/update", func(r chi.Router) {
+		r.Post("/", mr.UpdateMetricHandlerJSON)
+		r.Post("/{metricType}/{metricName}/{metricValue}", mr.UpdateMetricHandler)
+	})
+	r.Route("/updates", func(r chi.Router) {
+		r.Post("/", mr.UpdateMetricsHandlerJSON)
+	})
+}
+
 // RootHandler handles HTTP GET requests to the root endpoint ("/") of the
-// metrics API. It retrieves all metrics from the repository and writes
-// them to the HTTP response in a plain text format. The response content
-// type is set to "text/html".
+// metrics API. It retrieves all metrics from the repository and writes the
+// dashboard: an HTML table of the metrics that can be sorted and filtered,
+// with the sampled history of each metric and links to the metric pages.
+// With the "format=text" query parameter, it writes the metrics as plain
+// "name = value" lines instead.
 //
 // Parameters:
 //   - res: An http.ResponseWriter used to construct the HTTP response.
 //   - req: An http.Request containing the details of the incoming request.
 func (mr *MetricRouter) RootHandler(res http.ResponseWriter, req *http.Request) {
-	res.Header().Set("Content-Type", "text/html")
-
 	var (
 		metrics []*metric.Metric
 		err     error
 	)
 
-	for i := 0; i <= mr.RetryCount; i++ {
+	err = Retry(mr.RetryCount, func() (err error) {
 		metrics, err = mr.Repository.GetMetrics(req.Context())
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
-			logger.Log.Info("error get metrics", zap.Error(err))
-			http.Error(res, "error get metrics", http.StatusInternalServerError)
-			return
-		}
-		break
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get metrics", zap.Error(err))
+		http.Error(res, "error get metrics", http.StatusInternalServerError)
+		return
 	}
 
+	visible := metrics[:0]
 	for _, me := range metrics {
+		if !mr.Expiry.IsStale(me.MType, me.ID) {
+			visible = append(visible, me)
+		}
+	}
+	if req.URL.Query().Get(ParamFormat) != "text" {
+		mr.renderDashboard(res, req, visible)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, me := range visible {
+		md := mr.metadataOf(me.ID)
+		var suffix string
+		if md.Unit != "" {
+			suffix += " " + md.Unit
+		}
+		if md.Description != "" {
+			suffix += " (" + md.Description + ")"
+		}
 		if me.MType == MetricTypeGauge {
-			_, _ = io.WriteString(res, fmt.Sprintf("%s = %v\n", me.ID, *me.Value))
+			_, _ = io.WriteString(res, fmt.Sprintf("%s = %v%s\n", me.ID, *me.Value, suffix))
 		}
 		if me.MType == MetricTypeCounter {
-			_, _ = io.WriteString(res, fmt.Sprintf("%s = %d\n", me.ID, *me.Delta))
+			_, _ = io.WriteString(res, fmt.Sprintf("%s = %d%s\n", me.ID, *me.Delta, suffix))
 		}
 	}
 
@@ -306,22 +443,14 @@ func (mr *MetricRouter) GetMetricValueHandler(res http.ResponseWriter, req *http
 		err error
 	)
 
-	for i := 0; i <= mr.RetryCount; i++ {
+	err = Retry(mr.RetryCount, func() (err error) {
 		me, err = mr.Repository.GetMetric(req.Context(), metricType, metricName)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
-			logger.Log.Info("metric not found", zap.Error(err))
-			http.Error(res, "metric not found", http.StatusNotFound)
-			return
-		}
-		break
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("metric not found", zap.Error(err))
+		http.Error(res, "metric not found", http.StatusNotFound)
+		return
 	}
 
 	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -339,25 +468,25 @@ func (mr *MetricRouter) GetMetricValueHandler(res http.ResponseWriter, req *http
 // connection issues.
 //
 // Parameters:
-//   - metric: A pointer to the metric.Metric that needs to be dumped.
+//   - m: A pointer to the metric.Metric that needs to be dumped.
 //
 // Returns:
 //   - An error if the dumping operation fails; otherwise, it returns nil.
-func (mr *MetricRouter) DumpMetric(metric *metric.Metric) error {
+func (mr *MetricRouter) DumpMetric(m *metric.Metric) error {
+	return mr.DumpMetrics([]metric.Metric{*m})
+}
+
+// DumpMetrics persists metrics to the repository like DumpMetric, but dumps
+// them at once when the repository supports it, so a batch update is
+// written and synced once.
+func (mr *MetricRouter) DumpMetrics(metrics []metric.Metric) error {
 	if mr.StoreInterval == 0 {
-		for i := 0; i <= mr.RetryCount; i++ {
-			err := DumpMetric(mr.Repository, metric)
-			if err != nil {
-				var pathErr *os.PathError
-				if errors.As(err, &pathErr) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-				logger.Log.Info("error saving metric", zap.Error(err))
-				return errors.New("error saving metric")
-			}
-			break
+		err := RetryFile(mr.RetryCount, func() error {
+			return DumpMetrics(mr.Repository, metrics)
+		})
+		if err != nil {
+			logger.Log.Info("error saving metric", zap.Error(err))
+			return errors.New("error saving metric")
 		}
 	}
 	return nil
@@ -411,23 +540,39 @@ func (mr *MetricRouter) UpdateMetricHandler(res http.ResponseWriter, req *http.R
 		return
 	}
 
-	for i := 0; i <= mr.RetryCount; i++ {
-		err := mr.Repository.UpdateMetric(req.Context(), &me)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
-			logger.Log.Info("bad metric value", zap.Error(err))
-			http.Error(res, "bad metric value", http.StatusInternalServerError)
-			return
-		}
-		break
+	if err = mr.checkMetadata([]metric.Metric{me}); err != nil {
+		logger.Log.Info("metric type conflict", zap.Error(err))
+		http.Error(res, "metric type conflict", http.StatusConflict)
+		return
+	}
+
+	admission, err := mr.admitMetrics(req, []metric.Metric{me})
+	if err != nil {
+		logger.Log.Info("series limit exceeded", zap.String("metric", me.ID))
+		http.Error(res, "series limit exceeded", http.StatusUnprocessableEntity)
+		return
 	}
+	admitted := admission.Metrics
+	if len(admitted) == 0 {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	before := mr.auditSnapshot(req.Context(), admitted)
+	err = Retry(mr.RetryCount, func() error {
+		return mr.Repository.UpdateMetric(req.Context(), &me)
+	})
+	if err != nil {
+		mr.Cardinality.Rollback(admission)
+		logger.Log.Info("bad metric value", zap.Error(err))
+		http.Error(res, "bad metric value", http.StatusInternalServerError)
+		return
+	}
+
+	mr.auditUpdate(req, before, admitted)
+	mr.Expiry.Refresh(admitted)
+	mr.observeAgent(req, admitted)
+	mr.publish(req, admitted)
 
 	if err := mr.DumpMetric(&me); err != nil {
 		http.Error(res, "error saving metric", http.StatusInternalServerError)
@@ -470,48 +615,53 @@ func (mr *MetricRouter) UpdateMetricHandlerJSON(res http.ResponseWriter, req *ht
 		return
 	}
 
-	for i := 0; i <= mr.RetryCount; i++ {
-		err := mr.Repository.UpdateMetric(req.Context(), &me)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
-			logger.Log.Info("error update metric", zap.Error(err))
-			http.Error(res, "error update metric", http.StatusInternalServerError)
-			return
-		}
-		break
+	if err := mr.checkMetadata([]metric.Metric{me}); err != nil {
+		logger.Log.Info("metric type conflict", zap.Error(err))
+		http.Error(res, "metric type conflict", http.StatusConflict)
+		return
+	}
+
+	admission, err := mr.admitMetrics(req, []metric.Metric{me})
+	if err != nil {
+		logger.Log.Info("series limit exceeded", zap.String("metric", me.ID))
+		http.Error(res, "series limit exceeded", http.StatusUnprocessableEntity)
+		return
+	}
+	admitted := admission.Metrics
+	if len(admitted) == 0 {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	before := mr.auditSnapshot(req.Context(), admitted)
+	err = Retry(mr.RetryCount, func() error {
+		return mr.Repository.UpdateMetric(req.Context(), &me)
+	})
+	if err != nil {
+		mr.Cardinality.Rollback(admission)
+		logger.Log.Info("error update metric", zap.Error(err))
+		http.Error(res, "error update metric", http.StatusInternalServerError)
+		return
 	}
 
+	mr.auditUpdate(req, before, admitted)
+	mr.Expiry.Refresh(admitted)
+	mr.observeAgent(req, admitted)
+	mr.publish(req, admitted)
+
 	if err := mr.DumpMetric(&me); err != nil {
 		http.Error(res, "error saving metric", http.StatusInternalServerError)
 		return
 	}
-	var (
-		actualMetric *metric.Metric
-		err          error
-	)
-	for i := 0; i <= mr.RetryCount; i++ {
+	var actualMetric *metric.Metric
+	err = Retry(mr.RetryCount, func() (err error) {
 		actualMetric, err = mr.Repository.GetMetric(req.Context(), me.MType, me.ID)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
-			logger.Log.Info("error get actual metric value", zap.Error(err))
-			http.Error(res, "error get actual metric value", http.StatusInternalServerError)
-			return
-		}
-		break
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get actual metric value", zap.Error(err))
+		http.Error(res, "error get actual metric value", http.StatusInternalServerError)
+		return
 	}
 
 	res.Header().Set("Content-Type", "application/json")
@@ -556,22 +706,14 @@ func (mr *MetricRouter) GetMetricValueHandlerJSON(res http.ResponseWriter, req *
 		respMetric *metric.Metric
 		err        error
 	)
-	for i := 0; i <= mr.RetryCount; i++ {
+	err = Retry(mr.RetryCount, func() (err error) {
 		respMetric, err = mr.Repository.GetMetric(req.Context(), me.MType, me.ID)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
-			logger.Log.Info("metric not found", zap.Error(err))
-			http.Error(res, "metric not found", http.StatusNotFound)
-			return
-		}
-		break
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("metric not found", zap.Error(err))
+		http.Error(res, "metric not found", http.StatusNotFound)
+		return
 	}
 
 	res.Header().Set("Content-Type", "application/json")
@@ -604,6 +746,11 @@ func (mr *MetricRouter) UpdateMetricsHandlerJSON(res http.ResponseWriter, req *h
 
 	var metrics []metric.Metric
 	if err := json.NewDecoder(req.Body).Decode(&metrics); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(res, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(res, "can't decode request body", http.StatusBadRequest)
 		return
 	}
@@ -614,6 +761,12 @@ func (mr *MetricRouter) UpdateMetricsHandlerJSON(res http.ResponseWriter, req *h
 		}
 	}(req.Body)
 
+	if mr.MaxBatchMetrics > 0 && len(metrics) > mr.MaxBatchMetrics {
+		logger.Log.Info("too many metrics in batch", zap.Int("count", len(metrics)))
+		http.Error(res, "too many metrics in batch", http.StatusRequestEntityTooLarge)
+		return
+	}
+
 	for _, m := range metrics {
 		if (m.Value == nil && m.Delta == nil) || (m.MType != MetricTypeCounter && m.MType != MetricTypeGauge) {
 			http.Error(res, "bad metric", http.StatusBadRequest)
@@ -621,29 +774,56 @@ func (mr *MetricRouter) UpdateMetricsHandlerJSON(res http.ResponseWriter, req *h
 		}
 	}
 
-	for i := 0; i <= mr.RetryCount; i++ {
-		err := mr.Repository.UpdateMetrics(req.Context(), &metrics)
+	batchKey, replay, done := mr.beginBatch(res, req)
+	if done {
+		return
+	}
+	defer mr.abortBatch(batchKey)
+
+	if err := mr.checkMetadata(metrics); err != nil {
+		logger.Log.Info("metric type conflict", zap.Error(err))
+		http.Error(res, "metric type conflict", http.StatusConflict)
+		return
+	}
+
+	admission, err := mr.admitMetrics(req, metrics)
+	if err != nil {
+		logger.Log.Info("series limit exceeded", zap.Error(err))
+		http.Error(res, "series limit exceeded", http.StatusUnprocessableEntity)
+		return
+	}
+	metrics = admission.Metrics
+
+	before := mr.auditSnapshot(req.Context(), metrics)
+	applied := !replay
+	if !replay {
+		err = Retry(mr.RetryCount, func() (err error) {
+			applied, err = UpdateMetricsOnce(mr.Repository, req.Context(), batchKey, &metrics)
+			return err
+		})
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsConnectionException(pgErr.Code) && i != mr.RetryCount {
-					logger.Log.Info("repository connection error", zap.Error(err))
-					time.Sleep(time.Duration(1+i*2) * time.Second)
-					continue
-				}
-			}
+			mr.Cardinality.Rollback(admission)
 			logger.Log.Info("error update metrics", zap.Error(err))
 			http.Error(res, "error update metrics", http.StatusInternalServerError)
 			return
 		}
-		break
+		// From now on a retry of the batch must not apply it again, even if
+		// the rest of the request fails.
+		mr.completeBatch(batchKey, nil)
 	}
 
-	for _, me := range metrics {
-		if err := mr.DumpMetric(&me); err != nil {
+	if applied {
+		mr.auditUpdate(req, before, metrics)
+		mr.Expiry.Refresh(metrics)
+		mr.observeAgent(req, metrics)
+		mr.publish(req, metrics)
+
+		if err := mr.DumpMetrics(metrics); err != nil {
 			http.Error(res, "error saving metric", http.StatusInternalServerError)
 			return
 		}
+	} else {
+		logger.Log.Info("batch has already been applied", zap.String("batchID", req.Header.Get(idempotency.HeaderBatchID)))
 	}
 
 	for i, m := range metrics {
@@ -651,31 +831,29 @@ func (mr *MetricRouter) UpdateMetricsHandlerJSON(res http.ResponseWriter, req *h
 			updated *metric.Metric
 			err     error
 		)
-		for r := 0; r <= mr.RetryCount; r++ {
+		err = Retry(mr.RetryCount, func() (err error) {
 			updated, err = mr.Repository.GetMetric(req.Context(), m.MType, m.ID)
-			if err != nil {
-				var pgErr *pgconn.PgError
-				if errors.As(err, &pgErr) {
-					if pgerrcode.IsConnectionException(pgErr.Code) && r != mr.RetryCount {
-						logger.Log.Info("repository connection error", zap.Error(err))
-						time.Sleep(time.Duration(1+r*2) * time.Second)
-						continue
-					}
-				}
-				logger.Log.Info("error get updated metric", zap.Error(err))
-				http.Error(res, "error get updated metric", http.StatusInternalServerError)
-				return
-			}
-			metrics[i] = *updated
-			break
+			return err
+		})
+		if err != nil {
+			logger.Log.Info("error get updated metric", zap.Error(err))
+			http.Error(res, "error get updated metric", http.StatusInternalServerError)
+			return
 		}
+		metrics[i] = *updated
 	}
-	res.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(res)
-	if err := enc.Encode(metrics); err != nil {
+
+	data, err := json.Marshal(metrics)
+	if err != nil {
 		logger.Log.Info("error encoding response", zap.Error(err))
 		http.Error(res, "error encoding response", http.StatusInternalServerError)
 		return
 	}
-	res.WriteHeader(http.StatusOK)
+	mr.completeBatch(batchKey, data)
+
+	res.Header().Set("Content-Type", "application/json")
+	if _, err = res.Write(data); err != nil {
+		logger.Log.Info("error write response data", zap.Error(err))
+		return
+	}
 }
@@ -257,7 +257,7 @@ func TestRootHandler(t *testing.T) {
 			want: want{
 				statusCode:  http.StatusOK,
 				value:       "param1 = 17.34\nparam2 = 2\n",
-				contentType: "text/html",
+				contentType: "text/plain; charset=utf-8",
 			},
 		},
 		{
@@ -270,7 +270,7 @@ func TestRootHandler(t *testing.T) {
 			want: want{
 				statusCode:  http.StatusOK,
 				value:       "param1 = 17.34\nparam2 = 2\n",
-				contentType: "text/html",
+				contentType: "text/plain; charset=utf-8",
 			},
 		},
 		{
@@ -282,7 +282,7 @@ func TestRootHandler(t *testing.T) {
 			want: want{
 				statusCode:  http.StatusOK,
 				value:       "",
-				contentType: "text/html",
+				contentType: "text/plain; charset=utf-8",
 			},
 		},
 	}
@@ -304,7 +304,7 @@ func TestRootHandler(t *testing.T) {
 				serverRepository.UpdateMetric(ctx, metric)
 			}
 
-			resp, value := testRequest(t, ts, http.MethodGet, "", test.acceptEncoding)
+			resp, value := testRequest(t, ts, http.MethodGet, "/?format=text", test.acceptEncoding)
 			defer resp.Body.Close()
 
 			assert.Equal(t, test.want.statusCode, resp.StatusCode)
@@ -729,6 +729,49 @@ func TestTrustedSubnet(t *testing.T) {
 	})
 }
 
+func TestRateLimit(t *testing.T) {
+	t.Run("too many requests", func(t *testing.T) {
+		serverRepository := NewMemoryStorage()
+		chiRouter := chi.NewRouter()
+		serverConfig := config.ServerConfig{StoreInterval: 300, RateLimit: 0.5, RateBurst: 1}
+		metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+		ts := httptest.NewServer(metricRouter.Router)
+		defer ts.Close()
+
+		resp, _ := testRequest(t, ts, "POST", "/update/gauge/param1/1", false)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, _ = testRequest(t, ts, "POST", "/update/gauge/param1/2", false)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	})
+
+	t.Run("too large body", func(t *testing.T) {
+		serverRepository := NewMemoryStorage()
+		chiRouter := chi.NewRouter()
+		serverConfig := config.ServerConfig{StoreInterval: 300, MaxBatchSize: 10}
+		metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+		ts := httptest.NewServer(metricRouter.Router)
+		defer ts.Close()
+
+		resp, _ := testJSONRequest(t, ts, "POST", "/updates/", `[{"id": "test", "type": "gauge", "value": 13.5}]`, "application/json")
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("too many metrics", func(t *testing.T) {
+		serverRepository := NewMemoryStorage()
+		chiRouter := chi.NewRouter()
+		serverConfig := config.ServerConfig{StoreInterval: 300, MaxBatchMetrics: 1}
+		metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+		ts := httptest.NewServer(metricRouter.Router)
+		defer ts.Close()
+
+		resp, _ := testJSONRequest(t, ts, "POST", "/updates/", `[{"id": "test", "type": "gauge", "value": 13.5}, {"id": "test2", "type": "counter", "delta": 1}]`, "application/json")
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		assert.Empty(t, serverRepository.Gauge)
+	})
+}
+
 func TestGzipCompression(t *testing.T) {
 	serverRepository := NewMemoryStorage()
 	chiRouter := chi.NewRouter()
@@ -19,6 +19,7 @@ import (
 	"github.com/Vidkin/metrics/internal/config"
 	"github.com/Vidkin/metrics/internal/metric"
 	"github.com/Vidkin/metrics/internal/repository/mock"
+	"github.com/Vidkin/metrics/internal/repository/storage"
 )
 
 func (s *MetricRouterTestSuite) TestMetricRouter_GzipMiddleware() {
@@ -250,7 +251,7 @@ func (s *MetricRouterTestSuite) TestMetricRouter_RootHandler() {
 					GetMetrics(gomock.Any()).
 					Return(test.want.response, nil)
 			}
-			resp, respBody := s.RequestTest(http.MethodGet, "/", "", test.contentType, false, false)
+			resp, respBody := s.RequestTest(http.MethodGet, "/?format=text", "", test.contentType, false, false)
 			defer resp.Body.Close()
 			s.Assert().Equal(test.want.statusCode, resp.StatusCode)
 			if test.want.statusCode == http.StatusOK {
@@ -1480,3 +1481,70 @@ func BenchmarkUpdateMetricsHandlerJSON(b *testing.B) {
 	})
 	mockController.Finish()
 }
+
+func (s *MetricRouterTestSuite) TestMetricRouter_DeleteMetricHandler() {
+	tests := []struct {
+		name       string
+		err        error
+		times      int
+		statusCode int
+	}{
+		{
+			name:       "metric not found",
+			err:        storage.ErrMetricNotFound,
+			times:      1,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "db is not available",
+			err:        &pgconn.PgError{Code: pgerrcode.ConnectionException},
+			times:      s.metricRouter.RetryCount + 1,
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "error get metric from db",
+			err:        errors.New("errGetMetric"),
+			times:      1,
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		s.Run(test.name, func() {
+			s.mockRepository.EXPECT().
+				GetMetric(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil, test.err).Times(test.times)
+			resp, _ := s.RequestTest(http.MethodDelete, "/value/gauge/test", "", "text/plain", false, false)
+			defer resp.Body.Close()
+			s.Assert().Equal(test.statusCode, resp.StatusCode)
+		})
+	}
+}
+
+func (s *MetricRouterTestSuite) TestMetricRouter_DeleteMetricsHandler() {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+	}{
+		{
+			name:       "metric not found",
+			err:        storage.ErrMetricNotFound,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "error get metric from db",
+			err:        errors.New("errGetMetric"),
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		s.Run(test.name, func() {
+			s.mockRepository.EXPECT().
+				GetMetric(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil, test.err).Times(1)
+			resp, _ := s.RequestTest(http.MethodDelete, "/value/", `[{"id": "test", "type": "gauge"}]`, "application/json", false, false)
+			defer resp.Body.Close()
+			s.Assert().Equal(test.statusCode, resp.StatusCode)
+		})
+	}
+}
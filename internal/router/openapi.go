@@ -0,0 +1,644 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/export"
+	"github.com/Vidkin/metrics/internal/idempotency"
+	"github.com/Vidkin/metrics/internal/liveness"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metadata"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/query"
+	"github.com/Vidkin/metrics/internal/silence"
+	"github.com/Vidkin/metrics/internal/snapshot"
+	"github.com/Vidkin/metrics/internal/stream"
+	"github.com/Vidkin/metrics/pkg/middleware"
+)
+
+// APIPrefix is the path prefix of the versioned API. Errors of the requests
+// under it are written as problem details, see RFC 7807.
+const APIPrefix = "/api/v1"
+
+// OpenAPI 3 document types. Only the parts used by the API are modelled.
+type (
+	openAPIDocument struct {
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+		Info       openAPIInfo                             `json:"info"`
+		OpenAPI    string                                  `json:"openapi"`
+		Servers    []openAPIServer                         `json:"servers"`
+	}
+
+	openAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	}
+
+	openAPIServer struct {
+		URL string `json:"url"`
+	}
+
+	openAPIComponents struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	}
+
+	openAPIOperation struct {
+		RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*openAPIResponse `json:"responses"`
+		OperationID string                      `json:"operationId"`
+		Summary     string                      `json:"summary"`
+		Tags        []string                    `json:"tags"`
+		Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	}
+
+	openAPIParameter struct {
+		Schema      *openAPISchema `json:"schema"`
+		Name        string         `json:"name"`
+		In          string         `json:"in"`
+		Description string         `json:"description,omitempty"`
+		Required    bool           `json:"required,omitempty"`
+	}
+
+	openAPIRequestBody struct {
+		Content  map[string]openAPIMediaType `json:"content"`
+		Required bool                        `json:"required"`
+	}
+
+	openAPIResponse struct {
+		Content     map[string]openAPIMediaType `json:"content,omitempty"`
+		Description string                      `json:"description"`
+	}
+
+	openAPIMediaType struct {
+		Schema *openAPISchema `json:"schema"`
+	}
+
+	openAPISchema struct {
+		Items                *openAPISchema            `json:"items,omitempty"`
+		AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+		Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+		Ref                  string                    `json:"$ref,omitempty"`
+		Type                 string                    `json:"type,omitempty"`
+		Format               string                    `json:"format,omitempty"`
+		Description          string                    `json:"description,omitempty"`
+		Required             []string                  `json:"required,omitempty"`
+		Enum                 []string                  `json:"enum,omitempty"`
+		OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+		Nullable             bool                      `json:"nullable,omitempty"`
+	}
+)
+
+// openAPIEnums lists the values of the string types with a fixed set of
+// values.
+var openAPIEnums = map[reflect.Type][]string{
+	reflect.TypeOf(alert.State("")):        {string(alert.StatePending), string(alert.StateFiring), string(alert.StateResolved)},
+	reflect.TypeOf(liveness.Status("")):    {string(liveness.StatusUp), string(liveness.StatusDown), string(liveness.StatusUnknown)},
+	reflect.TypeOf(cardinality.Policy("")): {string(cardinality.PolicyReject), string(cardinality.PolicyDrop)},
+	reflect.TypeOf(query.ValueType("")):    {string(query.ValueTypeScalar), string(query.ValueTypeVector)},
+}
+
+// openAPISchemas generates the schemas of the request and response types from
+// their Go types, so the document follows the JSON encoding of the handlers.
+type openAPISchemas map[string]*openAPISchema
+
+// ref registers the schema of the type of v under a name and returns a
+// reference to it.
+func (s openAPISchemas) ref(name string, v any) *openAPISchema {
+	if _, ok := s[name]; !ok {
+		s[name] = schemaOf(reflect.TypeOf(v))
+	}
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// schemaOf returns the schema of the JSON encoding of a Go type.
+func schemaOf(t reflect.Type) *openAPISchema {
+	if values, ok := openAPIEnums[t]; ok {
+		return &openAPISchema{Type: "string", Enum: values}
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(queryValue(0)):
+		return &openAPISchema{
+			Description: "NaN and the infinities are written as the strings \"NaN\", \"+Inf\" and \"-Inf\".",
+			OneOf:       []*openAPISchema{{Type: "number", Format: "double"}, {Type: "string", Enum: []string{"NaN", "+Inf", "-Inf"}}},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaOf(t.Elem())
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+		addFields(s, t)
+		return s
+	}
+	return &openAPISchema{}
+}
+
+// addFields adds the JSON fields of a struct type to an object schema. The
+// fields of embedded structs are promoted, as encoding/json does.
+func addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" {
+			addFields(s, f.Type)
+			continue
+		}
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// buildOpenAPI builds the OpenAPI document of the versioned API. It must be
+// kept in sync with the routes registered in NewMetricRouter; a test checks
+// that every route under APIPrefix is documented.
+func buildOpenAPI() *openAPIDocument {
+	schemas := make(openAPISchemas)
+	problem := schemas.ref("Problem", middleware.ProblemDetails{})
+	metricSchema := schemas.ref("Metric", metric.Metric{})
+	metricsSchema := &openAPISchema{Type: "array", Items: metricSchema}
+	metadataSchema := schemas.ref("Metadata", metadata.Metadata{})
+	silenceSchema := schemas.ref("Silence", silence.Silence{})
+	snapshotSchema := schemas.ref("Snapshot", snapshot.Info{})
+
+	jsonContent := func(s *openAPISchema) map[string]openAPIMediaType {
+		return map[string]openAPIMediaType{"application/json": {Schema: s}}
+	}
+	textContent := map[string]openAPIMediaType{"text/plain": {Schema: &openAPISchema{Type: "string"}}}
+	ok := func(description string, s *openAPISchema) *openAPIResponse {
+		return &openAPIResponse{Description: description, Content: jsonContent(s)}
+	}
+	errorResponse := func(description string) *openAPIResponse {
+		return &openAPIResponse{
+			Description: description,
+			Content:     map[string]openAPIMediaType{middleware.ProblemContentType: {Schema: problem}},
+		}
+	}
+	jsonBody := func(s *openAPISchema) *openAPIRequestBody {
+		return &openAPIRequestBody{Required: true, Content: jsonContent(s)}
+	}
+	pathParam := func(name, description string) openAPIParameter {
+		return openAPIParameter{Name: name, In: "path", Required: true, Description: description, Schema: &openAPISchema{Type: "string"}}
+	}
+	queryParam := func(name, description string, s *openAPISchema) openAPIParameter {
+		if s == nil {
+			s = &openAPISchema{Type: "string"}
+		}
+		return openAPIParameter{Name: name, In: "query", Description: description, Schema: s}
+	}
+	formatParam := queryParam(ParamFormat, "The format of the metrics, JSON by default.", &openAPISchema{Type: "string", Enum: export.Formats})
+	transferContent := func() map[string]openAPIMediaType {
+		content := make(map[string]openAPIMediaType, len(export.Formats))
+		for _, format := range export.Formats {
+			content[export.ContentType(format)] = openAPIMediaType{Schema: &openAPISchema{Type: "string"}}
+		}
+		content[export.ContentType(export.FormatJSON)] = openAPIMediaType{Schema: &openAPISchema{Type: "array", Items: metricSchema}}
+		return content
+	}
+	typeParam := pathParam(ParamMetricType, "The metric type.")
+	typeParam.Schema.Enum = []string{MetricTypeGauge, MetricTypeCounter}
+	nameParam := pathParam(ParamMetricName, "The metric name.")
+	batchParam := openAPIParameter{
+		Name:        idempotency.HeaderBatchID,
+		In:          "header",
+		Description: "The ID of the batch. A retried batch with the same ID is applied once.",
+		Schema:      &openAPISchema{Type: "string"},
+	}
+	metricTypeQuery := queryParam(QueryType, "Restrict the metrics to one type.",
+		&openAPISchema{Type: "string", Enum: []string{MetricTypeGauge, MetricTypeCounter}})
+
+	updateErrors := func(op *openAPIOperation) *openAPIOperation {
+		op.Responses["202"] = &openAPIResponse{Description: "The update was dropped by the series limit."}
+		op.Responses["400"] = errorResponse("The update is malformed.")
+		op.Responses["409"] = errorResponse("The metric type conflicts with the registered metadata.")
+		op.Responses["422"] = errorResponse("The series limit is exceeded.")
+		op.Responses["500"] = errorResponse("The repository failed.")
+		return op
+	}
+
+	paths := map[string]map[string]*openAPIOperation{
+		"/ping": {
+			"get": {
+				OperationID: "ping", Summary: "Check the availability of the repository.", Tags: []string{"service"},
+				Responses: map[string]*openAPIResponse{
+					"200": {Description: "The repository is available."},
+					"500": errorResponse("The repository is unavailable."),
+				},
+			},
+		},
+		"/openapi.json": {
+			"get": {
+				OperationID: "getOpenAPI", Summary: "Get this document.", Tags: []string{"service"},
+				Responses: map[string]*openAPIResponse{"200": ok("The OpenAPI document.", &openAPISchema{Type: "object"})},
+			},
+		},
+		"/update": {
+			"post": updateErrors(&openAPIOperation{
+				OperationID: "updateMetric", Summary: "Update a metric. A counter is incremented by the delta.", Tags: []string{"metrics"},
+				RequestBody: jsonBody(metricSchema),
+				Responses:   map[string]*openAPIResponse{"200": ok("The metric with its new value.", metricSchema)},
+			}),
+		},
+		"/update/{metricType}/{metricName}/{metricValue}": {
+			"post": updateErrors(&openAPIOperation{
+				OperationID: "updateMetricValue", Summary: "Update a metric from the path. A counter is incremented by the value.", Tags: []string{"metrics"},
+				Parameters: []openAPIParameter{typeParam, nameParam, pathParam(ParamMetricValue, "The gauge value or the counter delta.")},
+				Responses:  map[string]*openAPIResponse{"200": {Description: "The metric is updated."}},
+			}),
+		},
+		"/updates": {
+			"post": updateErrors(&openAPIOperation{
+				OperationID: "updateMetrics", Summary: "Update a batch of metrics atomically.", Tags: []string{"metrics"},
+				Parameters:  []openAPIParameter{batchParam},
+				RequestBody: jsonBody(metricsSchema),
+				Responses:   map[string]*openAPIResponse{"200": ok("The metrics with their new values.", metricsSchema)},
+			}),
+		},
+		"/value": {
+			"post": {
+				OperationID: "getMetric", Summary: "Get a metric by the type and the name in the body.", Tags: []string{"metrics"},
+				RequestBody: jsonBody(metricSchema),
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The metric.", metricSchema),
+					"400": errorResponse("The request is malformed."),
+					"404": errorResponse("The metric is not found."),
+				},
+			},
+			"delete": {
+				OperationID: "deleteMetrics", Summary: "Delete the metrics selected by a prefix or a glob, or listed in the body.", Tags: []string{"metrics"},
+				Parameters: []openAPIParameter{
+					queryParam(QueryPrefix, "Delete the metrics whose names start with the prefix.", nil),
+					queryParam(QueryGlob, "Delete the metrics whose names match the glob pattern.", nil),
+					metricTypeQuery,
+				},
+				RequestBody: &openAPIRequestBody{Content: jsonContent(metricsSchema)},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The deleted metrics with their last values.", metricsSchema),
+					"400": errorResponse("The request is malformed."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/value/{metricType}/{metricName}": {
+			"get": {
+				OperationID: "getMetricValue", Summary: "Get the value of a metric as text.", Tags: []string{"metrics"},
+				Parameters: []openAPIParameter{typeParam, nameParam},
+				Responses: map[string]*openAPIResponse{
+					"200": {Description: "The value of the metric.", Content: textContent},
+					"400": errorResponse("The metric type is unknown."),
+					"404": errorResponse("The metric is not found."),
+				},
+			},
+			"delete": {
+				OperationID: "deleteMetric", Summary: "Delete a metric.", Tags: []string{"metrics"},
+				Parameters: []openAPIParameter{typeParam, nameParam},
+				Responses: map[string]*openAPIResponse{
+					"200": {Description: "The metric is deleted."},
+					"400": errorResponse("The metric type is unknown."),
+					"404": errorResponse("The metric is not found."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/metrics": {
+			"get": {
+				OperationID: "listMetrics", Summary: "List a page of the metrics.", Tags: []string{"metrics"},
+				Parameters: []openAPIParameter{
+					metricTypeQuery,
+					queryParam(QueryPrefix, "List the metrics whose names start with the prefix.", nil),
+					queryParam(ParamSort, "The sort order.", &openAPISchema{Type: "string", Enum: []string{metric.SortName, metric.SortType}}),
+					queryParam(ParamOrder, "The sort direction.", &openAPISchema{Type: "string", Enum: []string{"asc", "desc"}}),
+					queryParam(ParamLimit, "The page size.", &openAPISchema{Type: "integer", Format: "int32"}),
+					queryParam(ParamCursor, "The nextCursor of the previous page.", nil),
+				},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The page of the metrics.", schemas.ref("MetricsPage", listResponse{})),
+					"400": errorResponse("The request is malformed."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/query": {
+			"get": {
+				OperationID: "query", Summary: "Evaluate a query expression against the current metrics.", Tags: []string{"query"},
+				Parameters: []openAPIParameter{queryParam(ParamExpr, "The expression.", nil)},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The result: a number for a scalar, an array of samples for a vector.", schemas.ref("QueryResult", queryResponse{})),
+					"400": errorResponse("The expression is malformed."),
+					"404": errorResponse("The expression selects no metrics."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/rate/{metricName}": {
+			"get": {
+				OperationID: "getRate", Summary: "Get the rate and the increase of a counter.", Tags: []string{"query"},
+				Parameters: []openAPIParameter{nameParam, queryParam("window", "The window, 5m by default.", nil)},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The rate of the counter.", schemas.ref("Rate", rateResponse{})),
+					"400": errorResponse("The window is malformed or too long."),
+					"404": errorResponse("There are not enough samples of the counter."),
+					"501": errorResponse("Rates are not computed."),
+				},
+			},
+		},
+		"/stream": {
+			"get": {
+				OperationID: "stream", Summary: "Stream the accepted updates as server-sent events.", Tags: []string{"query"},
+				Parameters: []openAPIParameter{queryParam(ParamMatch, "Stream the metrics whose names match the glob pattern.", nil)},
+				Responses: map[string]*openAPIResponse{
+					"200": {
+						Description: `"update" events with an update as JSON, and "dropped" events with the number of updates dropped for a slow client.`,
+						Content: map[string]openAPIMediaType{"text/event-stream": {
+							Schema: &openAPISchema{Type: "array", Items: schemas.ref("StreamEvent", stream.Event{})},
+						}},
+					},
+					"400": errorResponse("The pattern is malformed."),
+				},
+			},
+		},
+		"/metadata": {
+			"get": {
+				OperationID: "getMetadata", Summary: "Get the registered metadata.", Tags: []string{"metadata"},
+				Parameters: []openAPIParameter{queryParam("name", "Get the metadata of one metric name.", nil)},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The metadata, an object if the name is set.", &openAPISchema{OneOf: []*openAPISchema{
+						{Type: "array", Items: metadataSchema}, metadataSchema,
+					}}),
+					"404": errorResponse("The metadata or the registry is not found."),
+				},
+			},
+			"put": {
+				OperationID: "putMetadata", Summary: "Register metadata.", Tags: []string{"metadata"},
+				RequestBody: jsonBody(&openAPISchema{OneOf: []*openAPISchema{{Type: "array", Items: metadataSchema}, metadataSchema}}),
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The registered metadata.", &openAPISchema{Type: "array", Items: metadataSchema}),
+					"400": errorResponse("The metadata is malformed or conflicts with the registered one."),
+					"404": errorResponse("The registry is disabled."),
+					"500": errorResponse("The metadata can't be saved."),
+				},
+			},
+		},
+		"/admin/cardinality": {
+			"get": {
+				OperationID: "getCardinality", Summary: "Get the series usage and the top clients.", Tags: []string{"admin"},
+				Parameters: []openAPIParameter{queryParam("top", "The number of top clients.", &openAPISchema{Type: "integer", Format: "int32"})},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The series usage.", schemas.ref("CardinalityStats", cardinality.Stats{})),
+					"400": errorResponse("The top value is malformed."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/admin/snapshot": {
+			"get": {
+				OperationID: "listSnapshots", Summary: "List the snapshots, the newest first.", Tags: []string{"admin"},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The snapshots.", &openAPISchema{Type: "array", Items: snapshotSchema}),
+					"404": errorResponse("Snapshots are disabled."),
+					"500": errorResponse("The snapshot directory can't be read."),
+				},
+			},
+			"post": {
+				OperationID: "createSnapshot", Summary: "Take a consistent snapshot of the metrics.", Tags: []string{"admin"},
+				Responses: map[string]*openAPIResponse{
+					"201": ok("The snapshot is written.", snapshotSchema),
+					"404": errorResponse("Snapshots are disabled."),
+					"500": errorResponse("The snapshot can't be taken."),
+				},
+			},
+		},
+		"/admin/snapshot/{snapshotName}/restore": {
+			"post": {
+				OperationID: "restoreSnapshot", Summary: "Replace all metrics with a snapshot.", Tags: []string{"admin"},
+				Parameters: []openAPIParameter{pathParam(ParamSnapshotName, "The snapshot name.")},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The snapshot is restored.", snapshotSchema),
+					"404": errorResponse("Snapshots are disabled or the snapshot is not found."),
+					"500": errorResponse("The snapshot can't be restored."),
+				},
+			},
+		},
+		"/alerts": {
+			"get": {
+				OperationID: "listAlerts", Summary: "List the alerts.", Tags: []string{"alerts"},
+				Parameters: []openAPIParameter{queryParam("state", "Restrict the alerts to one state.", schemaOf(reflect.TypeOf(alert.State(""))))},
+				Responses:  map[string]*openAPIResponse{"200": ok("The alerts.", &openAPISchema{Type: "array", Items: schemas.ref("Alert", alert.Alert{})})},
+			},
+		},
+		"/silences": {
+			"get": {
+				OperationID: "listSilences", Summary: "List the silences.", Tags: []string{"alerts"},
+				Parameters: []openAPIParameter{queryParam("active", "List the active silences only.", &openAPISchema{Type: "boolean"})},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The silences.", &openAPISchema{Type: "array", Items: silenceSchema}),
+					"500": errorResponse("The silences can't be read."),
+					"501": errorResponse("The storage doesn't support silences."),
+				},
+			},
+			"post": {
+				OperationID: "createSilence", Summary: "Create a silence. The duration may be set instead of endsAt.", Tags: []string{"alerts"},
+				RequestBody: jsonBody(schemas.ref("SilenceRequest", silenceRequest{})),
+				Responses: map[string]*openAPIResponse{
+					"201": ok("The created silence.", silenceSchema),
+					"400": errorResponse("The silence is malformed."),
+					"500": errorResponse("The silence can't be saved."),
+					"501": errorResponse("The storage doesn't support silences."),
+				},
+			},
+		},
+		"/silences/{silenceID}": {
+			"delete": {
+				OperationID: "deleteSilence", Summary: "Delete a silence.", Tags: []string{"alerts"},
+				Parameters: []openAPIParameter{pathParam(ParamSilenceID, "The silence ID.")},
+				Responses: map[string]*openAPIResponse{
+					"204": {Description: "The silence is deleted."},
+					"404": errorResponse("The silence is not found."),
+					"500": errorResponse("The silence can't be deleted."),
+					"501": errorResponse("The storage doesn't support silences."),
+				},
+			},
+		},
+		"/grafana": {
+			"get": {
+				OperationID: "grafanaTest", Summary: "Test the Grafana JSON datasource.", Tags: []string{"grafana"},
+				Responses: map[string]*openAPIResponse{"200": ok("The datasource is available.", &openAPISchema{Type: "object"})},
+			},
+		},
+		"/grafana/search": {
+			"post": {
+				OperationID: "grafanaSearch", Summary: "Find the metric names that match a glob pattern or a substring.", Tags: []string{"grafana"},
+				RequestBody: jsonBody(schemas.ref("GrafanaSearchRequest", grafanaSearchRequest{})),
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The metric names.", &openAPISchema{Type: "array", Items: &openAPISchema{Type: "string"}}),
+					"400": errorResponse("The request is malformed."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/grafana/query": {
+			"post": {
+				OperationID: "grafanaQuery", Summary: "Get the history or the current values of the metrics that match the targets.", Tags: []string{"grafana"},
+				RequestBody: jsonBody(schemas.ref("GrafanaQueryRequest", grafanaQueryRequest{})),
+				Responses: map[string]*openAPIResponse{
+					"200": ok("A series per matching metric for a timeserie target, a table for a table target.", &openAPISchema{
+						Type: "array",
+						Items: &openAPISchema{OneOf: []*openAPISchema{
+							schemas.ref("GrafanaSeries", grafanaSeries{}),
+							schemas.ref("GrafanaTable", grafanaTableResult{}),
+						}},
+					}),
+					"400": errorResponse("The request is malformed."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/grafana/annotations": {
+			"post": {
+				OperationID: "grafanaAnnotations", Summary: "Get the alerts that fired in a range as annotations.", Tags: []string{"grafana"},
+				RequestBody: jsonBody(schemas.ref("GrafanaAnnotationRequest", grafanaAnnotationRequest{})),
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The annotations.", &openAPISchema{Type: "array", Items: schemas.ref("GrafanaAnnotation", grafanaAnnotation{})}),
+					"400": errorResponse("The request is malformed."),
+				},
+			},
+		},
+		"/read": {
+			"post": {
+				OperationID: "remoteRead", Summary: "Read the history of the metrics with the Prometheus remote read protocol.", Tags: []string{"prometheus"},
+				RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{RemoteReadContentType: {
+					Schema: &openAPISchema{Type: "string", Format: "binary", Description: "A snappy compressed ReadRequest of the remote read protocol."},
+				}}},
+				Responses: map[string]*openAPIResponse{
+					"200": {Description: "The matching series.", Content: map[string]openAPIMediaType{RemoteReadContentType: {
+						Schema: &openAPISchema{Type: "string", Format: "binary", Description: "A snappy compressed ReadResponse of the remote read protocol."},
+					}}},
+					"400": errorResponse("The request is malformed."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/export": {
+			"get": {
+				OperationID: "exportMetrics", Summary: "Stream every metric in a format.", Tags: []string{"transfer"},
+				Parameters: []openAPIParameter{formatParam},
+				Responses: map[string]*openAPIResponse{
+					"200": {Description: "The metrics, sent with chunked encoding.", Content: transferContent()},
+					"400": errorResponse("The format is unknown."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/import": {
+			"post": {
+				OperationID: "importMetrics", Summary: "Set the metrics from an export.", Tags: []string{"transfer"},
+				Parameters:  []openAPIParameter{formatParam},
+				RequestBody: &openAPIRequestBody{Required: true, Content: transferContent()},
+				Responses: map[string]*openAPIResponse{
+					"200": ok("The number of imported metrics.", schemas.ref("ImportResponse", importResponse{})),
+					"400": errorResponse("The format is unknown or the input is malformed."),
+					"409": errorResponse("A metric conflicts with the type registered in the metadata."),
+					"413": errorResponse("The request body is too large."),
+					"422": errorResponse("The series limit is exceeded."),
+					"500": errorResponse("The repository failed."),
+				},
+			},
+		},
+		"/agents": {
+			"get": {
+				OperationID: "listAgents", Summary: "List the liveness of the agents.", Tags: []string{"agents"},
+				Parameters: []openAPIParameter{queryParam("status", "Restrict the agents to one status.", schemaOf(reflect.TypeOf(liveness.Status(""))))},
+				Responses:  map[string]*openAPIResponse{"200": ok("The agents.", &openAPISchema{Type: "array", Items: schemas.ref("Agent", liveness.Agent{})})},
+			},
+		},
+		"/agents/{agentID}": {
+			"delete": {
+				OperationID: "deleteAgent", Summary: "Forget an agent.", Tags: []string{"agents"},
+				Parameters: []openAPIParameter{pathParam(ParamAgentID, "The agent ID.")},
+				Responses: map[string]*openAPIResponse{
+					"204": {Description: "The agent is forgotten."},
+					"404": errorResponse("The agent is not found."),
+				},
+			},
+		},
+	}
+
+	return &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title: "Metrics API",
+			Description: "The API of the metrics server. Errors are written as problem details (RFC 7807). " +
+				"The unversioned /update/, /updates/, /value/ and /ping routes are kept as aliases with plain text errors.",
+			Version: "1.0.0",
+		},
+		Servers:    []openAPIServer{{URL: APIPrefix}},
+		Paths:      paths,
+		Components: openAPIComponents{Schemas: schemas},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// OpenAPIHandler handles HTTP GET requests to the "/api/v1/openapi.json"
+// endpoint. It writes the OpenAPI 3 document of the versioned API.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) OpenAPIHandler(res http.ResponseWriter, _ *http.Request) {
+	openAPIOnce.Do(func() {
+		var err error
+		if openAPIJSON, err = json.Marshal(buildOpenAPI()); err != nil {
+			logger.Log.Error("error marshal openapi document", zap.Error(err))
+		}
+	})
+	if openAPIJSON == nil {
+		http.Error(res, "error marshal openapi document", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if _, err := res.Write(openAPIJSON); err != nil {
+		logger.Log.Info("error write openapi document", zap.Error(err))
+	}
+}
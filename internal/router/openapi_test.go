@@ -0,0 +1,130 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/pkg/middleware"
+)
+
+func TestOpenAPIHandler_MatchesRoutes(t *testing.T) {
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, NewMemoryStorage(), &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/openapi.json", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal([]byte(body), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	routes := make(map[string]bool)
+	err := chi.Walk(chiRouter, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, APIPrefix+"/") {
+			return nil
+		}
+		route = strings.TrimSuffix(strings.TrimPrefix(route, APIPrefix), "/")
+		routes[strings.ToLower(method)+" "+route] = true
+		return nil
+	})
+	require.NoError(t, err)
+
+	documented := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			documented[method+" "+path] = true
+			assert.NotEmpty(t, op.OperationID, path)
+			for code, r := range op.Responses {
+				if code >= "400" {
+					assert.Contains(t, r.Content, middleware.ProblemContentType, "%s %s %s", method, path, code)
+				}
+			}
+		}
+	}
+	assert.Equal(t, routes, documented)
+
+	for name, schema := range doc.Components.Schemas {
+		assert.NotEmpty(t, schema.Properties, name)
+	}
+	assert.Contains(t, doc.Components.Schemas["Metric"].Properties, "delta")
+	assert.Equal(t, []string{"id", "type"}, doc.Components.Schemas["Metric"].Required)
+}
+
+func TestProblemDetails(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["FreeMemory"] = 50
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		acceptEncoding bool
+		want           middleware.ProblemDetails
+	}{
+		{
+			name:   "test handler error",
+			method: http.MethodGet,
+			path:   "/api/v1/value/summary/FreeMemory",
+			want:   middleware.ProblemDetails{Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest, Detail: "Bad metric type!", Instance: "/api/v1/value/summary/FreeMemory"},
+		},
+		{
+			name:           "test compressed error",
+			method:         http.MethodGet,
+			path:           "/api/v1/value/gauge/Missing",
+			acceptEncoding: true,
+			want:           middleware.ProblemDetails{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "metric not found", Instance: "/api/v1/value/gauge/Missing"},
+		},
+		{
+			name:   "test unknown route",
+			method: http.MethodGet,
+			path:   "/api/v1/missing",
+			want:   middleware.ProblemDetails{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "404 page not found", Instance: "/api/v1/missing"},
+		},
+		{
+			name:   "test method not allowed",
+			method: http.MethodPut,
+			path:   "/api/v1/updates",
+			want:   middleware.ProblemDetails{Type: "about:blank", Title: "Method Not Allowed", Status: http.StatusMethodNotAllowed, Instance: "/api/v1/updates"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ts.URL+test.path, nil)
+			require.NoError(t, err)
+			if test.acceptEncoding {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, test.want.Status, resp.StatusCode)
+			assert.Equal(t, middleware.ProblemContentType, resp.Header.Get("Content-Type"))
+			var got middleware.ProblemDetails
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/value/gauge/FreeMemory", false)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "50", body)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/value/summary/FreeMemory", false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Bad metric type!\n", body)
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/export"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// PrometheusContentType is the content type of the Prometheus text
+// exposition format.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusHandler handles HTTP GET requests to the "/metrics" endpoint. It
+// writes all metrics in the Prometheus text exposition format. The "# HELP"
+// and "# TYPE" lines are taken from the metadata registry.
+//
+// Metric names are sanitized to the Prometheus name syntax. If a name is
+// used by both a gauge and a counter, the counter is exposed with the
+// "_total" suffix, so the two series don't collide.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) PrometheusHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		metrics []*metric.Metric
+		err     error
+	)
+
+	err = Retry(mr.RetryCount, func() (err error) {
+		metrics, err = mr.Repository.GetMetrics(req.Context())
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get metrics", zap.Error(err))
+		http.Error(res, "error get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	visible := metrics[:0]
+	for _, me := range metrics {
+		if !mr.Expiry.IsStale(me.MType, me.ID) {
+			visible = append(visible, me)
+		}
+	}
+	names := prometheusNames(visible)
+
+	var sb strings.Builder
+	for _, me := range visible {
+		name, ok := names[me]
+		if !ok {
+			continue
+		}
+
+		md := mr.metadataOf(me.ID)
+		help := md.Description
+		if md.Unit != "" {
+			help = strings.TrimSpace(help + " [" + md.Unit + "]")
+		}
+		if help != "" {
+			fmt.Fprintf(&sb, "# HELP %s %s\n", name, escapeHelp(help))
+		}
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, me.MType)
+		switch me.MType {
+		case MetricTypeGauge:
+			fmt.Fprintf(&sb, "%s %s\n", name, strconv.FormatFloat(*me.Value, 'g', -1, 64))
+		case MetricTypeCounter:
+			fmt.Fprintf(&sb, "%s %d\n", name, *me.Delta)
+		}
+	}
+
+	res.Header().Set("Content-Type", PrometheusContentType)
+	if _, err = res.Write([]byte(sb.String())); err != nil {
+		logger.Log.Info("error write response data", zap.Error(err))
+	}
+}
+
+// prometheusNames sorts the metrics by name and assigns them their
+// Prometheus names. If a name is used by both a gauge and a counter, the
+// counter gets the "_total" suffix. Metrics whose names still collide are
+// left out of the result.
+func prometheusNames(metrics []*metric.Metric) map[*metric.Metric]string {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
+		}
+		// Gauges first, so on a name clash the counter gets the suffix.
+		return metrics[i].MType > metrics[j].MType
+	})
+
+	names := make(map[*metric.Metric]string, len(metrics))
+	used := make(map[string]struct{}, len(metrics))
+	for _, me := range metrics {
+		name := export.PrometheusName(me.ID)
+		if _, ok := used[name]; ok && me.MType == MetricTypeCounter {
+			name += "_total"
+		}
+		if _, ok := used[name]; ok {
+			logger.Log.Info("duplicate prometheus metric name", zap.String("name", name))
+			continue
+		}
+		used[name] = struct{}{}
+		names[me] = name
+	}
+	return names
+}
+
+// escapeHelp escapes a help text as required by the exposition format.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/query"
+)
+
+// ParamExpr is the query parameter with the expression of the query
+// endpoint.
+const ParamExpr = "expr"
+
+// queryValue is a value in a response of the query endpoint. NaN and the
+// infinities are not valid JSON numbers, so they are written as the strings
+// "NaN", "+Inf" and "-Inf".
+type queryValue float64
+
+func (v queryValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return json.Marshal(f)
+}
+
+// querySample is a sample in a response of the query endpoint.
+type querySample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Name   string            `json:"name"`
+	Value  queryValue        `json:"value"`
+}
+
+// queryResponse is the body of a response of the query endpoint. Result is a
+// number for a scalar and an array of samples for a vector.
+type queryResponse struct {
+	Result     any             `json:"result"`
+	Expr       string          `json:"expr"`
+	ResultType query.ValueType `json:"resultType"`
+}
+
+// QueryHandler handles HTTP GET requests to the "/api/v1/query" endpoint. It
+// evaluates the expression in the "expr" query parameter against the
+// metrics of the repository, see package query, and writes the result as
+// JSON. Stale series are not visible to the expression, and the "unit" label
+// of the metrics is taken from the metadata registry.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) QueryHandler(res http.ResponseWriter, req *http.Request) {
+	s := req.URL.Query().Get(ParamExpr)
+	if s == "" {
+		http.Error(res, "expression is required", http.StatusBadRequest)
+		return
+	}
+	expr, err := query.Parse(s)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var metrics []*metric.Metric
+	err = Retry(mr.RetryCount, func() (err error) {
+		metrics, err = mr.Repository.GetMetrics(req.Context())
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get metrics", zap.Error(err))
+		http.Error(res, "error get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	visible := metrics[:0]
+	for _, me := range metrics {
+		if !mr.Expiry.IsStale(me.MType, me.ID) {
+			visible = append(visible, me)
+		}
+	}
+	env := query.NewEnv(visible)
+	for _, me := range visible {
+		if unit := mr.metadataOf(me.ID).Unit; unit != "" {
+			env.SetLabel(me.ID, query.LabelUnit, unit)
+		}
+	}
+	if mr.Rates != nil {
+		env.Rates = mr.Rates
+	}
+
+	v, err := expr.Eval(env)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, query.ErrNoData) {
+			status = http.StatusNotFound
+		}
+		http.Error(res, err.Error(), status)
+		return
+	}
+
+	response := queryResponse{Expr: expr.String(), ResultType: v.Type, Result: queryValue(v.Scalar)}
+	if v.Type == query.ValueTypeVector {
+		samples := make([]querySample, 0, len(v.Vector))
+		for _, sample := range v.Vector {
+			samples = append(samples, querySample{Name: sample.Name, Labels: sample.Labels, Value: queryValue(sample.Value)})
+		}
+		response.Result = samples
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(response); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
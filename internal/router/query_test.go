@@ -0,0 +1,93 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/metadata"
+)
+
+func TestQueryHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["TotalMemory"] = 200
+	serverRepository.Gauge["FreeMemory"] = 50
+	serverRepository.Gauge["CPUutilization1"] = 10
+	serverRepository.Gauge["CPUutilization2"] = 30
+	serverRepository.Counter["PollCount"] = 7
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		expr       string
+		want       string
+		wantStatus int
+	}{
+		{
+			name:       "test scalar",
+			expr:       "(TotalMemory - FreeMemory) / TotalMemory * 100",
+			wantStatus: http.StatusOK,
+			want:       `{"result":75,"expr":"(TotalMemory - FreeMemory) / TotalMemory * 100","resultType":"scalar"}`,
+		},
+		{
+			name:       "test vector",
+			expr:       `CPU*{type="gauge"} > 20`,
+			wantStatus: http.StatusOK,
+			want:       `{"result":[{"labels":{"type":"gauge"},"name":"CPUutilization2","value":30}],"expr":"CPU*{type=\"gauge\"} > 20","resultType":"vector"}`,
+		},
+		{
+			name:       "test comparison that doesn't hold",
+			expr:       "PollCount > 10",
+			wantStatus: http.StatusOK,
+			want:       `{"result":[],"expr":"PollCount > 10","resultType":"vector"}`,
+		},
+		{
+			name:       "test infinity",
+			expr:       "TotalMemory / 0",
+			wantStatus: http.StatusOK,
+			want:       `{"result":"+Inf","expr":"TotalMemory / 0","resultType":"scalar"}`,
+		},
+		{name: "test no data", expr: "Missing + 1", wantStatus: http.StatusNotFound},
+		{name: "test bad expression", expr: "TotalMemory +", wantStatus: http.StatusBadRequest},
+		{name: "test no expression", expr: "", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/query?expr="+url.QueryEscape(test.expr), false)
+			require.Equal(t, test.wantStatus, resp.StatusCode)
+			if test.want != "" {
+				assert.JSONEq(t, test.want, body)
+			}
+		})
+	}
+}
+
+func TestQueryHandler_Unit(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["TotalMemory"] = 200
+	serverRepository.Gauge["CPUutilization1"] = 10
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300, MetadataStrict: true}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	registry, err := NewMetadataRegistry(context.Background(), serverRepository, &serverConfig)
+	require.NoError(t, err)
+	metricRouter.Metadata = registry
+	require.NoError(t, metricRouter.Metadata.Put(metadata.Metadata{Name: "TotalMemory", Type: MetricTypeGauge, Unit: "bytes"}))
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/query?expr="+url.QueryEscape(`{unit="bytes"}`), false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"result":[{"labels":{"type":"gauge","unit":"bytes"},"name":"TotalMemory","value":200}],"expr":"{unit=\"bytes\"}","resultType":"vector"}`, body)
+}
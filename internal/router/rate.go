@@ -0,0 +1,141 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/rate"
+)
+
+// DefaultRateWindow is the window of the rate endpoint if none is given.
+const DefaultRateWindow = 5 * time.Minute
+
+// RateSampler samples the totals of counters into a rate.History. It also
+// samples the values of gauges, so the dashboard can draw their history.
+//
+// Fields:
+//   - Repository: The metrics repository.
+//   - History: The samples of counters.
+//   - Gauges: The samples of gauges.
+//   - RetryCount: The number of times to retry repository operations in case
+//     of transient errors.
+type RateSampler struct {
+	Repository Repository
+	History    *rate.History
+	Gauges     *rate.History
+	RetryCount int
+}
+
+// NewRateSampler creates a RateSampler with the rate settings of the server.
+//
+// Parameters:
+//   - repository: The metrics repository.
+//   - serverConfig: The server configuration with the rate settings.
+//
+// Returns:
+//   - A pointer to the newly created RateSampler, or nil if rates are not
+//     computed.
+func NewRateSampler(repository Repository, serverConfig *config.ServerConfig) *RateSampler {
+	if serverConfig.RateRetention <= 0 {
+		return nil
+	}
+	retention := time.Duration(serverConfig.RateRetention) * time.Second
+	return &RateSampler{
+		Repository: repository,
+		History:    rate.NewHistory(retention),
+		Gauges:     rate.NewHistory(retention),
+		RetryCount: serverConfig.RetryCount,
+	}
+}
+
+// Sample records the current totals of all counters and values of all
+// gauges.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the sampling.
+//
+// Returns:
+//   - An error if the counters can't be read.
+func (s *RateSampler) Sample(ctx context.Context) error {
+	var (
+		metrics []*metric.Metric
+		err     error
+	)
+	err = Retry(s.RetryCount, func() (err error) {
+		metrics, err = s.Repository.GetMetrics(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, m := range metrics {
+		switch {
+		case m.Delta != nil:
+			s.History.Record(m.ID, float64(*m.Delta), now)
+		case m.Value != nil:
+			s.Gauges.Record(m.ID, *m.Value, now)
+		}
+	}
+	s.History.Prune(now)
+	s.Gauges.Prune(now)
+	return nil
+}
+
+// rateResponse is the body of a response of the rate endpoint.
+type rateResponse struct {
+	rate.Result
+	Name   string `json:"name"`
+	Window string `json:"window"`
+}
+
+// RateHandler handles HTTP GET requests to the "/api/v1/rate/{metricName}"
+// endpoint. It writes the per-second rate and the increase of a counter over
+// the window set by the "window" query parameter, 5m by default, as JSON.
+// Resets of the counter are counted from zero.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) RateHandler(res http.ResponseWriter, req *http.Request) {
+	if mr.Rates == nil {
+		http.Error(res, "rates are not computed", http.StatusNotImplemented)
+		return
+	}
+
+	window := DefaultRateWindow
+	if w := req.URL.Query().Get("window"); w != "" {
+		var err error
+		window, err = time.ParseDuration(w)
+		if err != nil || window <= 0 {
+			http.Error(res, "bad window", http.StatusBadRequest)
+			return
+		}
+		if window > mr.Rates.Retention {
+			http.Error(res, "window is longer than the retention of samples", http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := chi.URLParam(req, ParamMetricName)
+	result, err := mr.Rates.Compute(name, window, time.Now())
+	if err != nil {
+		http.Error(res, "not enough samples of the counter in the window", http.StatusNotFound)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(rateResponse{Result: result, Name: name, Window: window.String()}); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
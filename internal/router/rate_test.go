@@ -0,0 +1,60 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+)
+
+func TestRateHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300, RateRetention: 3600}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	sampler := NewRateSampler(serverRepository, &serverConfig)
+	require.NotNil(t, sampler)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodGet, "/api/v1/rate/PollCount", false)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	metricRouter.Rates = sampler.History
+
+	serverRepository.Counter["PollCount"] = 100
+	require.NoError(t, sampler.Sample(context.Background()))
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/rate/PollCount", false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "one sample is not enough")
+
+	time.Sleep(10 * time.Millisecond)
+	serverRepository.Counter["PollCount"] = 20
+	require.NoError(t, sampler.Sample(context.Background()))
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/rate/PollCount?window=1m", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var got rateResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &got))
+	assert.Equal(t, "PollCount", got.Name)
+	assert.Equal(t, "1m0s", got.Window)
+	assert.Equal(t, 20.0, got.Increase, "reset must count from zero")
+	assert.Equal(t, 1, got.Resets)
+	assert.Equal(t, 2, got.Samples)
+	assert.Greater(t, got.Rate, 0.0)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/rate/PollCount?window=bad", false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/rate/PollCount?window=2h", false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestNewRateSampler(t *testing.T) {
+	assert.Nil(t, NewRateSampler(NewMemoryStorage(), &config.ServerConfig{}))
+}
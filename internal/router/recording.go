@@ -0,0 +1,172 @@
+package router
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metadata"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/query"
+	"github.com/Vidkin/metrics/internal/rate"
+	"github.com/Vidkin/metrics/internal/recording"
+	"github.com/Vidkin/metrics/internal/stream"
+)
+
+// RecordingClient is the client identity recorded in the audit log for
+// series written by the Recorder.
+const RecordingClient = "system:recording"
+
+// Recorder evaluates recording rules and writes their results to the
+// repository.
+//
+// Fields:
+//   - Repository: The metrics repository.
+//   - Rules: The recording rules.
+//   - Audit: An audit log of the written series. It may be nil.
+//   - Cardinality: A limiter of the number of distinct series that the
+//     written series are admitted by, as the series sent by clients. It may
+//     be nil.
+//   - Metadata: A registry of metric metadata the types of the written
+//     series are checked against. It may be nil.
+//   - Expiry: The sweeper of expired series that must refresh the written
+//     series. It may be nil.
+//   - Rates: The history of counters used by the rate and increase
+//     functions. If it is nil, the functions have no data.
+//   - Stream: The hub the written series are published to. It may be nil.
+//   - RetryCount: The number of times to retry repository operations in case
+//     of transient errors.
+type Recorder struct {
+	Repository  Repository
+	Audit       *audit.Auditor
+	Cardinality *cardinality.Limiter
+	Metadata    *metadata.Registry
+	Expiry      *Sweeper
+	Rates       *rate.History
+	Stream      *stream.Hub
+	Rules       []recording.Rule
+	RetryCount  int
+}
+
+// NewRecorder creates a Recorder with the rules from the recording rules
+// file of the server.
+//
+// Parameters:
+//   - repository: The metrics repository.
+//   - serverConfig: The server configuration with the recording settings.
+//
+// Returns:
+//   - A pointer to the newly created Recorder, or nil if no rules file is
+//     set.
+//   - An error if the rules file can't be read or is malformed.
+func NewRecorder(repository Repository, serverConfig *config.ServerConfig) (*Recorder, error) {
+	if serverConfig.RecordingFile == "" {
+		return nil, nil
+	}
+	rules, err := recording.LoadRules(serverConfig.RecordingFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		Repository: repository,
+		Rules:      rules,
+		RetryCount: serverConfig.RetryCount,
+	}, nil
+}
+
+// Record evaluates the rules against the stored metrics and writes the
+// results with Repository.UpdateMetric. The results are checked against the
+// metadata registry and the cardinality limits the same way as the updates
+// sent by clients. The rules that fail and the results that are rejected are
+// logged and don't stop the others.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the evaluation.
+//
+// Returns:
+//   - The written series.
+//   - An error if the metrics can't be read or a series can't be written.
+func (r *Recorder) Record(ctx context.Context) ([]metric.Metric, error) {
+	metrics, err := r.Repository.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	env := query.NewEnv(metrics)
+	if r.Rates != nil {
+		env.Rates = r.Rates
+	}
+	recorded, err := recording.Eval(r.Rules, env)
+	if err != nil {
+		logger.Log.Info("error evaluate recording rules", zap.Error(err))
+	}
+	if len(recorded) == 0 {
+		return nil, nil
+	}
+
+	var before map[string]*metric.Metric
+	if r.Audit != nil {
+		before = audit.Snapshot(ctx, r.Repository, recorded)
+	}
+	written := make([]metric.Metric, 0, len(recorded))
+	for i := range recorded {
+		ok, err := r.update(ctx, &recorded[i])
+		if err != nil {
+			return written, err
+		}
+		if ok {
+			written = append(written, recorded[i])
+		}
+	}
+	if len(written) == 0 {
+		return nil, nil
+	}
+
+	r.Expiry.Refresh(written)
+	r.Stream.Publish(RecordingClient, written, time.Now())
+	if r.Audit != nil {
+		entries := audit.UpdateEntries(time.Now(), RecordingClient, "", before, written)
+		if err = r.Audit.Log(entries...); err != nil {
+			logger.Log.Error("error log audit entries", zap.Error(err))
+		}
+	}
+	return written, nil
+}
+
+// update checks a recorded series against the metadata registry and the
+// cardinality limits and writes it to the repository. It reports whether
+// the series has been written.
+func (r *Recorder) update(ctx context.Context, m *metric.Metric) (bool, error) {
+	if r.Metadata != nil {
+		if err := r.Metadata.Check([]metric.Metric{*m}); err != nil {
+			logger.Log.Info("recorded series type conflict", zap.Error(err))
+			return false, nil
+		}
+	}
+	var admission cardinality.Admission
+	if r.Cardinality != nil {
+		var err error
+		admission, err = r.Cardinality.Admit(RecordingClient, []metric.Metric{*m})
+		if err != nil {
+			logger.Log.Info("recorded series limit exceeded", zap.String("metric", m.ID))
+			return false, nil
+		}
+		if len(admission.Metrics) == 0 {
+			return false, nil
+		}
+	}
+
+	err := Retry(r.RetryCount, func() error {
+		return r.Repository.UpdateMetric(ctx, m)
+	})
+	if err != nil {
+		r.Cardinality.Rollback(admission)
+		logger.Log.Info("error write recorded series", zap.Error(err))
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/metadata"
+)
+
+func TestRecorder_Record(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "MemUsedPct", "expr": "(TotalMemory - FreeMemory) / TotalMemory * 100"},
+		{"name": "CPUutilization", "expr": "sum(CPUutilization*)"}
+	]`), 0644))
+
+	serverRepository := NewMemoryStorage()
+	recorder, err := NewRecorder(serverRepository, &config.ServerConfig{RecordingFile: path})
+	require.NoError(t, err)
+	require.NotNil(t, recorder)
+
+	recorded, err := recorder.Record(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, recorded, "no data to record yet")
+
+	serverRepository.Gauge["TotalMemory"] = 200
+	serverRepository.Gauge["FreeMemory"] = 50
+	serverRepository.Gauge["CPUutilization1"] = 10
+	serverRepository.Gauge["CPUutilization2"] = 30
+	recorded, err = recorder.Record(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, recorded, 2)
+	assert.Equal(t, 75.0, serverRepository.Gauge["MemUsedPct"])
+	assert.Equal(t, 40.0, serverRepository.Gauge["CPUutilization"])
+
+	serverRepository.Gauge["CPUutilization2"] = 50
+	_, err = recorder.Record(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 60.0, serverRepository.Gauge["CPUutilization"], "recorded series must not feed its own glob")
+}
+
+func TestRecorder_Record_Checks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "MemUsedPct", "expr": "(TotalMemory - FreeMemory) / TotalMemory * 100"},
+		{"name": "FreeMemoryPct", "expr": "FreeMemory / TotalMemory * 100"},
+		{"name": "CPUutilization", "expr": "sum(CPUutilization*)"}
+	]`), 0644))
+
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["TotalMemory"] = 200
+	serverRepository.Gauge["FreeMemory"] = 50
+	serverRepository.Gauge["CPUutilization1"] = 10
+	serverConfig := config.ServerConfig{RecordingFile: path, MaxSeries: 4, MetadataStrict: true}
+	recorder, err := NewRecorder(serverRepository, &serverConfig)
+	require.NoError(t, err)
+	recorder.Cardinality, err = NewCardinalityLimiter(context.Background(), serverRepository, &serverConfig)
+	require.NoError(t, err)
+	recorder.Metadata, err = NewMetadataRegistry(context.Background(), serverRepository, &serverConfig)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Metadata.Put(metadata.Metadata{Name: "MemUsedPct", Type: MetricTypeCounter}))
+
+	recorded, err := recorder.Record(context.Background())
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, "FreeMemoryPct", recorded[0].ID)
+	assert.NotContains(t, serverRepository.Gauge, "MemUsedPct", "the type conflicts with the metadata")
+	assert.NotContains(t, serverRepository.Gauge, "CPUutilization", "the series is over the limit")
+	assert.Equal(t, 4, recorder.Cardinality.Stats(0).Series)
+}
+
+func TestNewRecorder(t *testing.T) {
+	recorder, err := NewRecorder(NewMemoryStorage(), &config.ServerConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, recorder)
+
+	_, err = NewRecorder(NewMemoryStorage(), &config.ServerConfig{RecordingFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
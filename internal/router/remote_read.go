@@ -0,0 +1,206 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"go.uber.org/zap"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/proto"
+)
+
+// Labels of the series served by the remote read endpoint. The metric name
+// label holds the Prometheus name of a metric, as on the "/metrics"
+// endpoint; the id label holds its name as stored.
+const (
+	LabelMetricName = "__name__"
+	LabelID         = "id"
+	LabelType       = "type"
+	LabelUnit       = "unit"
+)
+
+// RemoteReadContentType is the content type of the remote read requests and
+// responses. Their bodies are snappy compressed.
+const RemoteReadContentType = "application/x-protobuf"
+
+// labelMatcher is a compiled label matcher of a remote read query.
+type labelMatcher struct {
+	re    *regexp.Regexp
+	name  string
+	value string
+	typ   proto.LabelMatcher_Type
+}
+
+// newLabelMatcher compiles a label matcher. Regular expressions are anchored
+// at both ends, as in Prometheus.
+func newLabelMatcher(m *proto.LabelMatcher) (labelMatcher, error) {
+	lm := labelMatcher{typ: m.GetType(), name: m.GetName(), value: m.GetValue()}
+	if lm.typ == proto.LabelMatcher_RE || lm.typ == proto.LabelMatcher_NRE {
+		re, err := regexp.Compile("^(?:" + lm.value + ")$")
+		if err != nil {
+			return lm, err
+		}
+		lm.re = re
+	}
+	return lm, nil
+}
+
+// matches reports whether a set of labels matches. A missing label matches
+// as an empty value.
+func (lm labelMatcher) matches(labels map[string]string) bool {
+	v := labels[lm.name]
+	switch lm.typ {
+	case proto.LabelMatcher_NEQ:
+		return v != lm.value
+	case proto.LabelMatcher_RE:
+		return lm.re.MatchString(v)
+	case proto.LabelMatcher_NRE:
+		return !lm.re.MatchString(v)
+	default:
+		return v == lm.value
+	}
+}
+
+// seriesLabels returns the labels of the series of a metric.
+func (mr *MetricRouter) seriesLabels(m *metric.Metric, name string) map[string]string {
+	labels := map[string]string{
+		LabelMetricName: name,
+		LabelID:         m.ID,
+		LabelType:       m.MType,
+	}
+	if unit := mr.metadataOf(m.ID).Unit; unit != "" {
+		labels[LabelUnit] = unit
+	}
+	return labels
+}
+
+// remoteReadSamples returns the samples of a metric between two times in
+// Unix milliseconds: its sampled history, or its current value if it has no
+// history and the range includes the current time.
+func (mr *MetricRouter) remoteReadSamples(m *metric.Metric, start, end int64) []*proto.Sample {
+	var samples []*proto.Sample
+	for _, s := range mr.historyOf(m.MType, m.ID) {
+		if at := s.At.UnixMilli(); at >= start && at <= end {
+			samples = append(samples, &proto.Sample{Value: s.Value, Timestamp: at})
+		}
+	}
+	if len(samples) == 0 {
+		if now := time.Now().UnixMilli(); now >= start && now <= end {
+			samples = append(samples, &proto.Sample{Value: metricValue(m), Timestamp: now})
+		}
+	}
+	return samples
+}
+
+// RemoteReadHandler handles HTTP POST requests to the "/api/v1/read"
+// endpoint, which implements the Prometheus remote read protocol, so the
+// server can be added to the "remote_read" section of a Prometheus
+// configuration.
+//
+// The request and the response are snappy compressed protobuf messages.
+// Each metric is a series labeled with its Prometheus name ("__name__"), its
+// stored name ("id"), its type ("type") and, if it has one in the metadata
+// registry, its unit ("unit"). The label matchers of a query select the
+// series; the samples come from the history kept for the dashboard and the
+// rates, or are the current values if a metric has no history. Only the
+// "SAMPLES" response type is supported.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) RemoteReadHandler(res http.ResponseWriter, req *http.Request) {
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		logger.Log.Info("error read request body", zap.Error(err))
+		http.Error(res, "error read request body", http.StatusBadRequest)
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		logger.Log.Info("can't decompress request body", zap.Error(err))
+		http.Error(res, "can't decompress request body", http.StatusBadRequest)
+		return
+	}
+	var readReq proto.ReadRequest
+	if err = protobuf.Unmarshal(data, &readReq); err != nil {
+		logger.Log.Info("can't decode request body", zap.Error(err))
+		http.Error(res, "can't decode request body", http.StatusBadRequest)
+		return
+	}
+
+	if types := readReq.GetAcceptedResponseTypes(); len(types) > 0 {
+		supported := false
+		for _, t := range types {
+			supported = supported || t == proto.ReadRequest_SAMPLES
+		}
+		if !supported {
+			http.Error(res, "only the SAMPLES response type is supported", http.StatusBadRequest)
+			return
+		}
+	}
+	matchers := make([][]labelMatcher, len(readReq.GetQueries()))
+	for i, q := range readReq.GetQueries() {
+		for _, m := range q.GetMatchers() {
+			lm, err := newLabelMatcher(m)
+			if err != nil {
+				http.Error(res, "bad label matcher: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			matchers[i] = append(matchers[i], lm)
+		}
+	}
+
+	metrics, ok := mr.grafanaMetrics(res, req)
+	if !ok {
+		return
+	}
+	names := prometheusNames(metrics)
+
+	var readRes proto.ReadResponse
+	for i, q := range readReq.GetQueries() {
+		result := &proto.QueryResult{}
+	series:
+		for _, m := range metrics {
+			name, ok := names[m]
+			if !ok {
+				continue
+			}
+			labels := mr.seriesLabels(m, name)
+			for _, lm := range matchers[i] {
+				if !lm.matches(labels) {
+					continue series
+				}
+			}
+			samples := mr.remoteReadSamples(m, q.GetStartTimestampMs(), q.GetEndTimestampMs())
+			if len(samples) == 0 {
+				continue
+			}
+			ts := &proto.TimeSeries{Samples: samples}
+			for k, v := range labels {
+				ts.Labels = append(ts.Labels, &proto.Label{Name: k, Value: v})
+			}
+			sort.Slice(ts.Labels, func(i, j int) bool { return ts.Labels[i].Name < ts.Labels[j].Name })
+			result.Timeseries = append(result.Timeseries, ts)
+		}
+		readRes.Results = append(readRes.Results, result)
+	}
+
+	data, err = protobuf.Marshal(&readRes)
+	if err != nil {
+		logger.Log.Info("error encode response", zap.Error(err))
+		http.Error(res, "error encode response", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", RemoteReadContentType)
+	res.Header().Set("Content-Encoding", "snappy")
+	if _, err = res.Write(snappy.Encode(nil, data)); err != nil {
+		logger.Log.Info("error write response data", zap.Error(err))
+	}
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/rate"
+	"github.com/Vidkin/metrics/proto"
+)
+
+func TestRemoteReadHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	serverRepository.Gauge["CPUutilization1"] = 30
+	serverRepository.Gauge["Free.Memory"] = 50
+	serverRepository.Counter["PollCount"] = 7
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	metricRouter.Gauges = rate.NewHistory(time.Hour)
+	start := time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond)
+	for i, v := range []float64{10, 20, 30} {
+		metricRouter.Gauges.Record("CPUutilization1", v, start.Add(time.Duration(i)*time.Minute))
+	}
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	read := func(t *testing.T, readReq *proto.ReadRequest) (*http.Response, *proto.ReadResponse) {
+		data, err := protobuf.Marshal(readReq)
+		require.NoError(t, err)
+		resp, body := testJSONRequest(t, ts, http.MethodPost, "/api/v1/read", string(snappy.Encode(nil, data)), RemoteReadContentType)
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		assert.Equal(t, RemoteReadContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "snappy", resp.Header.Get("Content-Encoding"))
+		data, err = snappy.Decode(nil, []byte(body))
+		require.NoError(t, err)
+		var readRes proto.ReadResponse
+		require.NoError(t, protobuf.Unmarshal(data, &readRes))
+		return resp, &readRes
+	}
+	labels := func(ts *proto.TimeSeries) map[string]string {
+		m := make(map[string]string)
+		for _, l := range ts.GetLabels() {
+			m[l.GetName()] = l.GetValue()
+		}
+		return m
+	}
+
+	_, readRes := read(t, &proto.ReadRequest{Queries: []*proto.Query{
+		{
+			StartTimestampMs: start.Add(30 * time.Second).UnixMilli(),
+			EndTimestampMs:   start.Add(5 * time.Minute).UnixMilli(),
+			Matchers:         []*proto.LabelMatcher{{Type: proto.LabelMatcher_EQ, Name: LabelMetricName, Value: "CPUutilization1"}},
+		},
+		{
+			StartTimestampMs: start.UnixMilli(),
+			EndTimestampMs:   time.Now().Add(time.Minute).UnixMilli(),
+			Matchers: []*proto.LabelMatcher{
+				{Type: proto.LabelMatcher_RE, Name: LabelMetricName, Value: "Free.*|Poll.*"},
+				{Type: proto.LabelMatcher_NEQ, Name: LabelType, Value: MetricTypeCounter},
+			},
+		},
+	}})
+	require.NotNil(t, readRes)
+	require.Len(t, readRes.GetResults(), 2)
+
+	series := readRes.GetResults()[0].GetTimeseries()
+	require.Len(t, series, 1)
+	assert.Equal(t, map[string]string{LabelMetricName: "CPUutilization1", LabelID: "CPUutilization1", LabelType: MetricTypeGauge}, labels(series[0]))
+	assert.Equal(t, LabelMetricName, series[0].GetLabels()[0].GetName())
+	require.Len(t, series[0].GetSamples(), 2)
+	assert.Equal(t, 20.0, series[0].GetSamples()[0].GetValue())
+	assert.Equal(t, start.Add(time.Minute).UnixMilli(), series[0].GetSamples()[0].GetTimestamp())
+	assert.Equal(t, 30.0, series[0].GetSamples()[1].GetValue())
+
+	series = readRes.GetResults()[1].GetTimeseries()
+	require.Len(t, series, 1)
+	assert.Equal(t, map[string]string{LabelMetricName: "Free_Memory", LabelID: "Free.Memory", LabelType: MetricTypeGauge}, labels(series[0]))
+	require.Len(t, series[0].GetSamples(), 1)
+	assert.Equal(t, 50.0, series[0].GetSamples()[0].GetValue())
+
+	_, readRes = read(t, &proto.ReadRequest{Queries: []*proto.Query{{
+		StartTimestampMs: start.Add(-time.Hour).UnixMilli(),
+		EndTimestampMs:   start.UnixMilli(),
+		Matchers:         []*proto.LabelMatcher{{Type: proto.LabelMatcher_NRE, Name: LabelMetricName, Value: "CPU.*"}},
+	}}})
+	require.NotNil(t, readRes)
+	assert.Empty(t, readRes.GetResults()[0].GetTimeseries())
+
+	resp, _ := read(t, &proto.ReadRequest{Queries: []*proto.Query{{
+		Matchers: []*proto.LabelMatcher{{Type: proto.LabelMatcher_RE, Name: LabelMetricName, Value: "("}},
+	}}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = read(t, &proto.ReadRequest{AcceptedResponseTypes: []proto.ReadRequest_ResponseType{proto.ReadRequest_STREAMED_XOR_CHUNKS}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/read", "not snappy", RemoteReadContentType)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+)
+
+// Retry calls f until it succeeds or fails with an error other than a
+// connection error of the Postgres storage. It retries at most count times,
+// waiting 1, 3, 5... seconds between the calls.
+//
+// Parameters:
+//   - count: The maximum number of retries.
+//   - f: The repository operation.
+//
+// Returns:
+//   - The error of the last call of f, or nil if it succeeded.
+func Retry(count int, f func() error) error {
+	return retryIf(count, func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code)
+	}, f)
+}
+
+// RetryFile is like Retry, but retries the calls that fail with an
+// *os.PathError, i.e. the operations of the file storage.
+func RetryFile(count int, f func() error) error {
+	return retryIf(count, func(err error) bool {
+		var pathErr *os.PathError
+		return errors.As(err, &pathErr)
+	}, f)
+}
+
+func retryIf(count int, retryable func(err error) bool, f func() error) error {
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= count || !retryable(err) {
+			return err
+		}
+		logger.Log.Info("repository connection error", zap.Error(err))
+		time.Sleep(time.Duration(1+i*2) * time.Second)
+	}
+}
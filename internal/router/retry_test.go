@@ -0,0 +1,52 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	connErr := &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+	otherErr := errors.New("other")
+
+	calls := 0
+	err := Retry(1, func() error {
+		calls++
+		if calls == 1 {
+			return connErr
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = Retry(3, func() error {
+		calls++
+		return otherErr
+	})
+	assert.ErrorIs(t, err, otherErr)
+	assert.Equal(t, 1, calls, "only connection errors are retried")
+
+	calls = 0
+	err = Retry(0, func() error {
+		calls++
+		return connErr
+	})
+	assert.ErrorIs(t, err, connErr)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err = RetryFile(1, func() error {
+		calls++
+		return &os.PathError{Op: "open", Path: "metrics.json", Err: os.ErrNotExist}
+	})
+	var pathErr *os.PathError
+	assert.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, 2, calls)
+}
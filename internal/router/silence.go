@@ -0,0 +1,204 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/silence"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// ParamSilenceID is the name of the URL parameter with the silence ID.
+const ParamSilenceID = "silenceID"
+
+// SilenceStore defines the methods for persisting silences. Repositories
+// must implement it to support silences.
+type SilenceStore interface {
+	SaveSilence(ctx context.Context, s silence.Silence) error
+	DeleteSilence(ctx context.Context, id string) error
+	GetSilences(ctx context.Context) ([]silence.Silence, error)
+}
+
+// Silencer checks alerts against the silences stored in a repository. It
+// implements notify.Silencer.
+//
+// Fields:
+//   - Repository: The metrics repository. If it doesn't implement
+//     SilenceStore, no alert is silenced.
+type Silencer struct {
+	Repository Repository
+}
+
+// Silenced reports whether an active silence matches the alert. If the
+// silences can't be read, the alert isn't silenced, so no notification is
+// lost.
+func (s *Silencer) Silenced(a alert.Alert) bool {
+	store, ok := s.Repository.(SilenceStore)
+	if !ok {
+		return false
+	}
+	silences, err := store.GetSilences(context.Background())
+	if err != nil {
+		logger.Log.Error("error get silences", zap.Error(err))
+		return false
+	}
+	id, ok := silence.Silenced(silences, a, time.Now())
+	if ok {
+		logger.Log.Info("alert is silenced", zap.String("alert", a.Name), zap.String("silence", id))
+	}
+	return ok
+}
+
+// silenceRequest is the body of a silence creation request. Instead of
+// EndsAt, the duration of the silence may be set.
+type silenceRequest struct {
+	silence.Silence
+	Duration string `json:"duration,omitempty"`
+}
+
+// CreateSilenceHandler handles HTTP POST requests to the "/api/v1/silences"
+// endpoint. It creates a silence from the JSON body and writes it back with
+// the assigned ID. A missing start time means now, a missing creator means
+// the client that sent the request.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) CreateSilenceHandler(res http.ResponseWriter, req *http.Request) {
+	store, ok := mr.Repository.(SilenceStore)
+	if !ok {
+		http.Error(res, "silences are not supported by the storage", http.StatusNotImplemented)
+		return
+	}
+	if req.Header.Get("Content-Type") != "application/json" {
+		http.Error(res, "only application/json content-type allowed", http.StatusBadRequest)
+		return
+	}
+
+	var body silenceRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(res, "can't decode request body", http.StatusBadRequest)
+		return
+	}
+	s := body.Silence
+	now := time.Now().UTC()
+	s.ID = silence.NewID()
+	s.CreatedAt = now
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	if body.Duration != "" {
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			http.Error(res, "bad silence duration", http.StatusBadRequest)
+			return
+		}
+		s.EndsAt = s.StartsAt.Add(d)
+	}
+	if s.CreatedBy == "" {
+		s.CreatedBy = clientid.FromRequest(req)
+	}
+	if err := s.Validate(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := Retry(mr.RetryCount, func() error {
+		return store.SaveSilence(req.Context(), s)
+	})
+	if err != nil {
+		logger.Log.Info("error save silence", zap.Error(err))
+		http.Error(res, "error save silence", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(res).Encode(s); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+	}
+}
+
+// GetSilencesHandler handles HTTP GET requests to the "/api/v1/silences"
+// endpoint. It writes the silences as a JSON array. If the "active" query
+// parameter is "true", only the silences in effect are written.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) GetSilencesHandler(res http.ResponseWriter, req *http.Request) {
+	store, ok := mr.Repository.(SilenceStore)
+	if !ok {
+		http.Error(res, "silences are not supported by the storage", http.StatusNotImplemented)
+		return
+	}
+
+	var (
+		silences []silence.Silence
+		err      error
+	)
+	err = Retry(mr.RetryCount, func() (err error) {
+		silences, err = store.GetSilences(req.Context())
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error get silences", zap.Error(err))
+		http.Error(res, "error get silences", http.StatusInternalServerError)
+		return
+	}
+
+	if req.URL.Query().Get("active") == "true" {
+		now := time.Now()
+		active := silences[:0]
+		for _, s := range silences {
+			if s.Active(now) {
+				active = append(active, s)
+			}
+		}
+		silences = active
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(silences); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
+
+// DeleteSilenceHandler handles HTTP DELETE requests to the
+// "/api/v1/silences/{silenceID}" endpoint. It deletes the silence, so the
+// matching alerts are notified again.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) DeleteSilenceHandler(res http.ResponseWriter, req *http.Request) {
+	store, ok := mr.Repository.(SilenceStore)
+	if !ok {
+		http.Error(res, "silences are not supported by the storage", http.StatusNotImplemented)
+		return
+	}
+
+	id := chi.URLParam(req, ParamSilenceID)
+	err := Retry(mr.RetryCount, func() error {
+		return store.DeleteSilence(req.Context(), id)
+	})
+	if errors.Is(err, silence.ErrNotFound) {
+		http.Error(res, "silence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log.Info("error delete silence", zap.Error(err))
+		http.Error(res, "error delete silence", http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/alert"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/silence"
+)
+
+func TestSilenceHandlers(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	serverConfig := config.ServerConfig{StoreInterval: 300}
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &serverConfig)
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testJSONRequest(t, ts, http.MethodPost, "/api/v1/silences",
+		`{"matchers": [{"name": "metric", "value": "CPU*"}], "duration": "1h", "comment": "maintenance"}`, "application/json")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created silence.Silence
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.NotEmpty(t, created.CreatedBy)
+	assert.Equal(t, time.Hour, created.EndsAt.Sub(created.StartsAt))
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/silences",
+		`{"matchers": [{"name": "alertname", "value": "Disk"}], "startsAt": "`+future+`", "duration": "1h"}`, "application/json")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/silences",
+		`{"matchers": [], "duration": "1h"}`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/silences",
+		`{"matchers": [{"name": "metric", "value": "x"}], "duration": "forever"}`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var silences []silence.Silence
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/silences", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &silences))
+	assert.Len(t, silences, 2)
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/silences?active=true", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &silences))
+	require.Len(t, silences, 1)
+	assert.Equal(t, created.ID, silences[0].ID)
+
+	silencer := &Silencer{Repository: serverRepository}
+	assert.True(t, silencer.Silenced(alert.Alert{Name: "HighCPU", Metric: "CPUutilization1"}))
+	assert.False(t, silencer.Silenced(alert.Alert{Name: "Disk", Metric: "FreeDisk"}))
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/v1/silences/"+created.ID, false)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/v1/silences/"+created.ID, false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.False(t, silencer.Silenced(alert.Alert{Name: "HighCPU", Metric: "CPUutilization1"}))
+}
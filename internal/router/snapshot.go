@@ -0,0 +1,281 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/cardinality"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/internal/snapshot"
+)
+
+// ParamSnapshotName is the name of the URL parameter with the snapshot name.
+const ParamSnapshotName = "snapshotName"
+
+// Snapshotter defines the methods for reading and replacing all metrics at
+// once. Repositories must implement it to support snapshots.
+type Snapshotter interface {
+	SnapshotMetrics(ctx context.Context) ([]*metric.Metric, error)
+	RestoreMetrics(ctx context.Context, metrics []metric.Metric) error
+}
+
+// SnapshotManager takes consistent snapshots of the repository into a
+// snapshot.Store and restores them. It is safe for concurrent use.
+//
+// Fields:
+//   - Repository: The metrics repository. It must implement Snapshotter.
+//   - Store: The store of the snapshot files.
+//   - Cardinality: A limiter of the number of distinct series that must
+//     track the restored series. It may be nil.
+//   - Expiry: The sweeper of expired series that is run after a restore, so
+//     the restored series that have already expired are marked stale or
+//     deleted at once. It may be nil.
+//   - RetryCount: The number of times to retry repository operations in case
+//     of transient errors.
+//   - StoreInterval: The interval of storing metrics. If it is zero, the
+//     restored metrics are dumped immediately.
+type SnapshotManager struct {
+	Repository    Repository
+	Store         *snapshot.Store
+	Cardinality   *cardinality.Limiter
+	Expiry        *Sweeper
+	RetryCount    int
+	StoreInterval int
+}
+
+// NewSnapshotManager creates a SnapshotManager with the snapshot settings of
+// the server.
+//
+// Parameters:
+//   - repository: The metrics repository.
+//   - serverConfig: The server configuration with the snapshot settings.
+//
+// Returns:
+//   - A pointer to the newly created SnapshotManager, or nil if no snapshot
+//     directory is configured.
+//   - An error if the directory can't be created or the repository doesn't
+//     support snapshots.
+func NewSnapshotManager(repository Repository, serverConfig *config.ServerConfig) (*SnapshotManager, error) {
+	if serverConfig.SnapshotDir == "" {
+		return nil, nil
+	}
+	if _, ok := repository.(Snapshotter); !ok {
+		return nil, errors.New("provided Repository does not implement Snapshotter")
+	}
+	store, err := snapshot.NewStore(serverConfig.SnapshotDir, serverConfig.SnapshotRetain)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotManager{
+		Repository:    repository,
+		Store:         store,
+		RetryCount:    serverConfig.RetryCount,
+		StoreInterval: (int)(serverConfig.StoreInterval),
+	}, nil
+}
+
+// snapshotMetrics reads all metrics of the repository at once.
+func (s *SnapshotManager) snapshotMetrics(ctx context.Context) ([]*metric.Metric, error) {
+	var (
+		metrics []*metric.Metric
+		err     error
+	)
+	err = Retry(s.RetryCount, func() (err error) {
+		metrics, err = s.Repository.(Snapshotter).SnapshotMetrics(ctx)
+		return err
+	})
+	if err != nil {
+		logger.Log.Info("error snapshot metrics", zap.Error(err))
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// Take writes a snapshot of the repository and deletes the oldest snapshots
+// beyond the retention count.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the repository read.
+//
+// Returns:
+//   - The description of the written snapshot.
+//   - An error if the repository can't be read or the snapshot can't be
+//     written.
+func (s *SnapshotManager) Take(ctx context.Context) (snapshot.Info, error) {
+	metrics, err := s.snapshotMetrics(ctx)
+	if err != nil {
+		return snapshot.Info{}, err
+	}
+	return s.Store.Write(metrics, time.Now())
+}
+
+// Restored describes a restored snapshot.
+//
+// Fields:
+//   - Info: The description of the snapshot.
+//   - Replaced: The metrics the repository held before the restore.
+//   - Metrics: The restored metrics.
+type Restored struct {
+	Info     snapshot.Info
+	Replaced []*metric.Metric
+	Metrics  []metric.Metric
+}
+
+// Restore replaces all metrics of the repository with the metrics of a
+// snapshot. Counters are set to their totals in the snapshot and the series
+// keep their update times, so they expire as if they had never been
+// removed.
+//
+// Parameters:
+//   - ctx: A context.Context to control the lifetime of the restore.
+//   - name: The name of the snapshot.
+//
+// Returns:
+//   - The description of the restore. It is also returned with the error if
+//     the repository has been replaced, but the restored metrics can't be
+//     dumped to the storage file.
+//   - snapshot.ErrNotFound if there is no snapshot with the name, or an error
+//     if the snapshot can't be read, the repository can't be replaced or the
+//     restored metrics can't be dumped.
+func (s *SnapshotManager) Restore(ctx context.Context, name string) (Restored, error) {
+	info, metrics, err := s.Store.Read(name)
+	if err != nil {
+		return Restored{}, err
+	}
+
+	old, err := s.snapshotMetrics(ctx)
+	if err != nil {
+		return Restored{}, err
+	}
+
+	err = Retry(s.RetryCount, func() error {
+		return s.Repository.(Snapshotter).RestoreMetrics(ctx, metrics)
+	})
+	if err != nil {
+		logger.Log.Info("error restore metrics", zap.Error(err))
+		return Restored{}, err
+	}
+	restored := Restored{Info: info, Replaced: old, Metrics: metrics}
+
+	if s.Cardinality != nil {
+		for _, m := range old {
+			s.Cardinality.Forget(m.MType, m.ID)
+		}
+		loaded := make([]*metric.Metric, len(metrics))
+		for i := range metrics {
+			loaded[i] = &metrics[i]
+		}
+		s.Cardinality.Load(loaded)
+	}
+	if s.Expiry != nil {
+		if _, err = s.Expiry.Sweep(ctx); err != nil {
+			logger.Log.Info("error sweep restored metrics", zap.Error(err))
+		}
+	}
+
+	if s.StoreInterval == 0 {
+		if dumper, ok := s.Repository.(Dumper); ok {
+			if err = dumper.FullDump(); err != nil {
+				logger.Log.Info("error dump restored metrics", zap.Error(err))
+				return restored, err
+			}
+		}
+	}
+	logger.Log.Info("snapshot restored", zap.String("snapshot", name), zap.Int("metrics", info.Metrics))
+	return restored, nil
+}
+
+// CreateSnapshotHandler handles HTTP POST requests to the
+// "/api/v1/admin/snapshot" endpoint. It writes a consistent snapshot of the
+// repository to the snapshot directory and writes its description as JSON.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) CreateSnapshotHandler(res http.ResponseWriter, req *http.Request) {
+	if mr.Snapshots == nil {
+		http.Error(res, "snapshots are disabled", http.StatusNotFound)
+		return
+	}
+	info, err := mr.Snapshots.Take(req.Context())
+	if err != nil {
+		logger.Log.Info("error take snapshot", zap.Error(err))
+		http.Error(res, "error take snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(res).Encode(info); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+	}
+}
+
+// SnapshotsHandler handles HTTP GET requests to the "/api/v1/admin/snapshot"
+// endpoint. It writes the descriptions of the snapshots, the newest first,
+// as a JSON array.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) SnapshotsHandler(res http.ResponseWriter, _ *http.Request) {
+	if mr.Snapshots == nil {
+		http.Error(res, "snapshots are disabled", http.StatusNotFound)
+		return
+	}
+	infos, err := mr.Snapshots.Store.List()
+	if err != nil {
+		logger.Log.Info("error list snapshots", zap.Error(err))
+		http.Error(res, "error list snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(infos); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
+
+// RestoreSnapshotHandler handles HTTP POST requests to the
+// "/api/v1/admin/snapshot/{snapshotName}/restore" endpoint. It replaces all
+// metrics of the repository with the metrics of the snapshot, records the
+// changes in the audit log and writes the description of the snapshot as
+// JSON.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) RestoreSnapshotHandler(res http.ResponseWriter, req *http.Request) {
+	if mr.Snapshots == nil {
+		http.Error(res, "snapshots are disabled", http.StatusNotFound)
+		return
+	}
+	restored, err := mr.Snapshots.Restore(req.Context(), chi.URLParam(req, ParamSnapshotName))
+	if restored.Info.Name != "" {
+		mr.auditRestore(req, restored.Replaced, restored.Metrics)
+	}
+	if errors.Is(err, snapshot.ErrNotFound) {
+		http.Error(res, "snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Log.Info("error restore snapshot", zap.Error(err))
+		http.Error(res, "error restore snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(restored.Info); err != nil {
+		logger.Log.Info("error encoding response", zap.Error(err))
+		http.Error(res, "error encoding response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/audit"
+	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/snapshot"
+)
+
+func TestSnapshotHandlers(t *testing.T) {
+	repo := NewMemoryStorage()
+	repo.Gauge["Alloc"] = 1.5
+	repo.Counter["PollCount"] = 7
+	cfg := &config.ServerConfig{StoreInterval: 300, SnapshotDir: t.TempDir(), SnapshotRetain: 2, MaxSeries: 10}
+	snapshots, err := NewSnapshotManager(repo, cfg)
+	require.NoError(t, err)
+	metricRouter := NewMetricRouter(chi.NewRouter(), repo, cfg)
+	metricRouter.Cardinality, err = NewCardinalityLimiter(context.Background(), repo, cfg)
+	require.NoError(t, err)
+	snapshots.Cardinality = metricRouter.Cardinality
+	metricRouter.Snapshots = snapshots
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, body := testJSONRequest(t, ts, http.MethodPost, "/api/v1/admin/snapshot", "", "application/json")
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	var taken snapshot.Info
+	require.NoError(t, json.Unmarshal([]byte(body), &taken))
+	assert.Equal(t, 2, taken.Metrics)
+
+	repo.Gauge["Alloc"] = 3
+	repo.Gauge["HeapInuse"] = 4
+	repo.Counter["PollCount"] = 20
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/admin/snapshot", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var infos []snapshot.Info
+	require.NoError(t, json.Unmarshal([]byte(body), &infos))
+	require.Len(t, infos, 1)
+	assert.Equal(t, taken.Name, infos[0].Name)
+
+	resp, body = testJSONRequest(t, ts, http.MethodPost, "/api/v1/admin/snapshot/"+taken.Name+"/restore", "", "application/json")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, repo.Gauge)
+	assert.Equal(t, map[string]int64{"PollCount": 7}, repo.Counter)
+	assert.Equal(t, 2, metricRouter.Cardinality.Stats(0).Series)
+
+	resp, _ = testJSONRequest(t, ts, http.MethodPost, "/api/v1/admin/snapshot/metrics-20000101T000000.000Z.json/restore", "", "application/json")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSnapshotHandlers_RestoreAudit(t *testing.T) {
+	// The storage file can't be written, so the dump after the restore fails.
+	repo := NewFileStorage(filepath.Join(t.TempDir(), "missing", "metrics.json"))
+	repo.Gauge["Alloc"] = 1.5
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &config.ServerConfig{SnapshotDir: t.TempDir(), AuditFile: auditPath}
+	snapshots, err := NewSnapshotManager(repo, cfg)
+	require.NoError(t, err)
+	auditor, err := NewAuditor(cfg, repo)
+	require.NoError(t, err)
+	metricRouter := NewMetricRouter(chi.NewRouter(), repo, cfg)
+	metricRouter.Snapshots = snapshots
+	metricRouter.Audit = auditor
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	taken, err := snapshots.Take(context.Background())
+	require.NoError(t, err)
+	repo.Gauge["Alloc"] = 3
+	repo.Gauge["HeapInuse"] = 4
+
+	resp, _ := testJSONRequest(t, ts, http.MethodPost, "/api/v1/admin/snapshot/"+taken.Name+"/restore", "", "application/json")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, repo.Gauge)
+	require.NoError(t, auditor.Close())
+
+	data, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var entries [2]audit.Entry
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &entries[i]))
+	}
+	assert.Equal(t, audit.OpRestore, entries[0].Op)
+	assert.Equal(t, "Alloc", entries[0].ID)
+	assert.Equal(t, "3", *entries[0].OldValue)
+	assert.Equal(t, "1.5", *entries[0].NewValue)
+	assert.Equal(t, audit.OpDelete, entries[1].Op)
+	assert.Equal(t, "HeapInuse", entries[1].ID)
+}
+
+func TestSnapshotHandlers_Disabled(t *testing.T) {
+	metricRouter := NewMetricRouter(chi.NewRouter(), NewMemoryStorage(), &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testJSONRequest(t, ts, http.MethodPost, "/api/v1/admin/snapshot", "", "application/json")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/v1/admin/snapshot", false)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -3,8 +3,6 @@ package router
 import (
 	"context"
 	"database/sql"
-	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -88,19 +86,15 @@ func NewRepository(cfg *config.ServerConfig) (Repository, error) {
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancel()
 
-			for i := 0; i <= cfg.RetryCount; i++ {
-				err := fileStorage.Load(ctx)
-				if err != nil {
-					var pathErr *os.PathError
-					if errors.As(err, &pathErr) && i != cfg.RetryCount {
-						logger.Log.Info("repository connection error", zap.Error(err))
-						time.Sleep(time.Duration(1+i*2) * time.Second)
-						continue
-					}
-					logger.Log.Info("error load saved metrics", zap.Error(err))
-				}
-				break
+			var report storage.FileReport
+			err := RetryFile(cfg.RetryCount, func() (err error) {
+				report, err = fileStorage.Recover(ctx)
+				return err
+			})
+			if err != nil {
+				logger.Log.Error("error load saved metrics", zap.Error(err))
 			}
+			logRecovery(cfg.FileStoragePath, report)
 		}
 		return fileStorage, nil
 	}
@@ -108,3 +102,19 @@ func NewRepository(cfg *config.ServerConfig) (Repository, error) {
 	memStorage := NewMemoryStorage()
 	return memStorage, nil
 }
+
+// logRecovery logs the report of the metrics restored from the storage file
+// at startup. Bad records are logged as errors, with the copies of the
+// damaged files that were kept.
+func logRecovery(path string, report storage.FileReport) {
+	if len(report.Problems) > 0 {
+		logger.Log.Error("storage file has bad records, they were skipped",
+			zap.String("path", path),
+			zap.Int("recovered", report.Metrics),
+			zap.Int("skipped", len(report.Problems)),
+			zap.Strings("problems", report.Problems),
+			zap.Strings("quarantined", report.Quarantined))
+		return
+	}
+	logger.Log.Info("metrics restored", zap.String("path", path), zap.Int("metrics", report.Metrics))
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/Vidkin/metrics/internal/config"
+	"github.com/Vidkin/metrics/internal/repository/storage"
 )
 
 func TestNewRepository(t *testing.T) {
@@ -93,3 +94,21 @@ func TestNewRepository(t *testing.T) {
 		})
 	}
 }
+
+func TestNewRepository_RecoversDamagedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":`), 0666))
+
+	repo, err := NewRepository(&config.ServerConfig{FileStoragePath: path, Restore: true})
+	assert.NoError(t, err)
+	fileStorage, ok := repo.(*storage.FileStorage)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, fileStorage.Gauge)
+
+	matches, err := filepath.Glob(path + storage.QuarantineSuffix + "*")
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	report, err := storage.VerifyFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.FileReport{Metrics: 1}, report)
+}
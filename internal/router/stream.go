@@ -0,0 +1,100 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// ParamMatch is the query parameter with the glob pattern of the metric
+// names streamed by the stream endpoint.
+const ParamMatch = "match"
+
+// StreamKeepalive is the interval of the comments the stream endpoint writes
+// while there are no updates, so proxies don't close an idle connection.
+var StreamKeepalive = 15 * time.Second
+
+// publish publishes the updates accepted from the client that sent the
+// request to the live stream.
+func (mr *MetricRouter) publish(req *http.Request, metrics []metric.Metric) {
+	mr.Stream.Publish(clientid.FromRequest(req), metrics, time.Now())
+}
+
+// StreamHandler handles HTTP GET requests to the "/api/v1/stream" endpoint.
+// It streams the accepted metric updates as server-sent events until the
+// client disconnects. Each "update" event carries one update as JSON; the
+// counters carry the accepted delta. The "match" query parameter is a glob
+// pattern that filters the updates by metric name.
+//
+// A client that reads slower than updates arrive doesn't slow down the
+// ingestion: the updates that don't fit into its buffer are dropped, and a
+// "dropped" event with their number is written before the next update.
+//
+// Parameters:
+//   - res: An http.ResponseWriter used to construct the HTTP response.
+//   - req: An http.Request containing the details of the incoming request.
+func (mr *MetricRouter) StreamHandler(res http.ResponseWriter, req *http.Request) {
+	if mr.Stream == nil {
+		http.Error(res, "stream is disabled", http.StatusNotFound)
+		return
+	}
+	sub, err := mr.Stream.Subscribe(req.URL.Query().Get(ParamMatch))
+	if err != nil {
+		logger.Log.Info("bad match pattern", zap.Error(err))
+		http.Error(res, "bad match pattern", http.StatusBadRequest)
+		return
+	}
+	defer mr.Stream.Unsubscribe(sub)
+
+	rc := http.NewResponseController(res)
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if err = rc.Flush(); err != nil {
+		logger.Log.Info("error flush stream", zap.Error(err))
+		return
+	}
+
+	keepalive := time.NewTicker(StreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err = fmt.Fprint(res, ": keepalive\n\n")
+		case event := <-sub.Events():
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				if err = writeEvent(res, "dropped", map[string]int64{"dropped": dropped}); err != nil {
+					break
+				}
+			}
+			err = writeEvent(res, "update", event)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			logger.Log.Info("error write stream", zap.Error(err))
+			return
+		}
+	}
+}
+
+// writeEvent writes a server-sent event with JSON data.
+func writeEvent(res http.ResponseWriter, name string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", name, b)
+	return err
+}
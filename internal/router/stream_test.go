@@ -0,0 +1,70 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/config"
+)
+
+func TestStreamHandler(t *testing.T) {
+	serverRepository := NewMemoryStorage()
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, serverRepository, &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/stream?match=CPU*", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "")
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Equal(t, 1, metricRouter.Stream.Subscribers())
+
+	resp2, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/FreeMemory/5", false)
+	require.Equal(t, http.StatusOK, resp2.StatusCode)
+	resp2, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/CPUutilization1/10", false)
+	require.Equal(t, http.StatusOK, resp2.StatusCode)
+	resp2, _ = testJSONRequest(t, ts, http.MethodPost, "/updates/",
+		`[{"id":"CPUcount","type":"counter","delta":2}]`, "application/json")
+	require.Equal(t, http.StatusOK, resp2.StatusCode)
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for len(lines) < 6 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.Len(t, lines, 6)
+	assert.Equal(t, "event: update", lines[0])
+	assert.Regexp(t, `^data: \{"time":"[^"]+","client":"ip:127.0.0.1","value":10,"id":"CPUutilization1","type":"gauge"\}$`, lines[1])
+	assert.Equal(t, "", lines[2])
+	assert.Equal(t, "event: update", lines[3])
+	assert.Contains(t, lines[4], `"delta":2,"id":"CPUcount","type":"counter"`)
+
+	cancel()
+	assert.Eventually(t, func() bool { return metricRouter.Stream.Subscribers() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamHandler_BadPattern(t *testing.T) {
+	chiRouter := chi.NewRouter()
+	metricRouter := NewMetricRouter(chiRouter, NewMemoryStorage(), &config.ServerConfig{StoreInterval: 300})
+	ts := httptest.NewServer(metricRouter.Router)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodGet, "/api/v1/stream?match=%5B", false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 0, metricRouter.Stream.Subscribers())
+}
@@ -0,0 +1,114 @@
+// Package silence provides silences: time-bounded matchers that suppress
+// alert notifications, e.g. during planned maintenance.
+//
+// A silence matches an alert if all of its matchers match. A matcher
+// compares a glob pattern with the value of a label of the alert. Besides
+// the labels of the rule, alerts have the alert.LabelAlertName label with the
+// rule name and the LabelMetric label with the metric name of the rule.
+package silence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/alert"
+)
+
+// LabelMetric is the label that holds the metric name of the rule of an
+// alert.
+const LabelMetric = "metric"
+
+// ErrNotFound is returned by storages if a silence doesn't exist.
+var ErrNotFound = errors.New("silence not found")
+
+// Matcher matches the value of a label with a glob pattern, see path.Match.
+type Matcher struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Silence suppresses notifications of the matching alerts between StartsAt
+// and EndsAt.
+type Silence struct {
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	ID        string    `json:"id"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	Matchers  []Matcher `json:"matchers"`
+}
+
+// NewID returns a random silence ID.
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Validate checks that the silence has matchers with valid patterns and
+// ends after it starts.
+func (s Silence) Validate() error {
+	if len(s.Matchers) == 0 {
+		return errors.New("silence has no matchers")
+	}
+	for _, m := range s.Matchers {
+		if m.Name == "" {
+			return errors.New("silence matcher has no label name")
+		}
+		if _, err := path.Match(m.Value, ""); err != nil {
+			return fmt.Errorf("bad silence matcher %s=%q: %w", m.Name, m.Value, err)
+		}
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("silence must end after it starts")
+	}
+	return nil
+}
+
+// Active reports whether the silence is in effect at the moment now.
+func (s Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Matches reports whether all matchers of the silence match the alert.
+func (s Silence) Matches(a alert.Alert) bool {
+	for _, m := range s.Matchers {
+		var value string
+		switch m.Name {
+		case alert.LabelAlertName:
+			value = a.Name
+		case LabelMetric:
+			value = a.Metric
+		default:
+			value = a.Labels[m.Name]
+		}
+		if ok, _ := path.Match(m.Value, value); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Silenced reports whether an active silence of the list matches the alert.
+//
+// Parameters:
+//   - silences: The silences to check.
+//   - a: The alert.
+//   - now: The moment of the check.
+//
+// Returns:
+//   - The ID of the first matching active silence and true, or an empty
+//     string and false if the alert isn't silenced.
+func Silenced(silences []Silence, a alert.Alert, now time.Time) (string, bool) {
+	for _, s := range silences {
+		if s.Active(now) && s.Matches(a) {
+			return s.ID, true
+		}
+	}
+	return "", false
+}
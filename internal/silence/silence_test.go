@@ -0,0 +1,85 @@
+package silence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Vidkin/metrics/internal/alert"
+)
+
+func TestSilence_Validate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		silence Silence
+		wantErr bool
+	}{
+		{
+			name:    "test valid",
+			silence: Silence{Matchers: []Matcher{{Name: "metric", Value: "CPU*"}}, StartsAt: now, EndsAt: now.Add(time.Hour)},
+		},
+		{
+			name:    "test no matchers",
+			silence: Silence{StartsAt: now, EndsAt: now.Add(time.Hour)},
+			wantErr: true,
+		},
+		{
+			name:    "test bad pattern",
+			silence: Silence{Matchers: []Matcher{{Name: "metric", Value: "[CPU"}}, StartsAt: now, EndsAt: now.Add(time.Hour)},
+			wantErr: true,
+		},
+		{
+			name:    "test ends before start",
+			silence: Silence{Matchers: []Matcher{{Name: "metric", Value: "CPU"}}, StartsAt: now, EndsAt: now},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.silence.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSilenced(t *testing.T) {
+	now := time.Now()
+	a := alert.Alert{Name: "HighCPU", Metric: "CPUutilization1", Labels: map[string]string{"host": "db1"}}
+	silences := []Silence{
+		{
+			ID:       "expired",
+			Matchers: []Matcher{{Name: alert.LabelAlertName, Value: "HighCPU"}},
+			StartsAt: now.Add(-2 * time.Hour),
+			EndsAt:   now.Add(-time.Hour),
+		},
+		{
+			ID:       "other-host",
+			Matchers: []Matcher{{Name: LabelMetric, Value: "CPU*"}, {Name: "host", Value: "web*"}},
+			StartsAt: now.Add(-time.Hour),
+			EndsAt:   now.Add(time.Hour),
+		},
+	}
+
+	_, ok := Silenced(silences, a, now)
+	assert.False(t, ok)
+
+	silences = append(silences, Silence{
+		ID:       "maintenance",
+		Matchers: []Matcher{{Name: LabelMetric, Value: "CPU*"}, {Name: "host", Value: "db*"}},
+		StartsAt: now.Add(-time.Hour),
+		EndsAt:   now.Add(time.Hour),
+	})
+	id, ok := Silenced(silences, a, now)
+	assert.True(t, ok)
+	assert.Equal(t, "maintenance", id)
+
+	_, ok = Silenced(silences, a, now.Add(2*time.Hour))
+	assert.False(t, ok)
+}
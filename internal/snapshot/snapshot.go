@@ -0,0 +1,252 @@
+// Package snapshot keeps point-in-time snapshots of the metrics in a
+// directory.
+//
+// A snapshot is a file in the JSON format of the export package, named after
+// the time it was taken, so it can also be imported with the import endpoint
+// or restored with metricsctl. Snapshots are written to a temporary file that
+// is synced and renamed into place, so a crash never leaves a partial
+// snapshot behind. After each snapshot the oldest ones beyond the retention
+// count are deleted.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/export"
+	"github.com/Vidkin/metrics/internal/metric"
+	"github.com/Vidkin/metrics/pkg/atomicfile"
+)
+
+// File names of the snapshots: the prefix, the UTC time in timeLayout and the
+// suffix. The names sort in the order the snapshots were taken.
+const (
+	filePrefix = "metrics-"
+	fileSuffix = ".json"
+	timeLayout = "20060102T150405.000Z"
+)
+
+// ErrNotFound means there is no snapshot with the name.
+var ErrNotFound = errors.New("snapshot not found")
+
+// Info describes a snapshot.
+//
+// Fields:
+//   - Name: The file name of the snapshot, which identifies it.
+//   - Time: The time the snapshot was taken.
+//   - Size: The size of the file in bytes.
+//   - Metrics: The number of metrics in the snapshot. It is only known for
+//     the snapshots just written or read.
+type Info struct {
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Metrics int       `json:"metrics,omitempty"`
+}
+
+// Store keeps the snapshots in a directory. It is safe for concurrent use.
+//
+// Fields:
+//   - Dir: The directory of the snapshot files.
+//   - Retain: The number of snapshots kept. Zero means all are kept.
+type Store struct {
+	Dir    string
+	Retain int
+	mu     sync.Mutex
+}
+
+// NewStore creates a Store and its directory.
+//
+// Parameters:
+//   - dir: The directory of the snapshot files.
+//   - retain: The number of snapshots kept. Zero means all are kept.
+//
+// Returns:
+//   - A pointer to the newly created Store.
+//   - An error if the directory can't be created.
+func NewStore(dir string, retain int) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir, Retain: retain}, nil
+}
+
+// parseName returns the time of a snapshot from its file name.
+func parseName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+	return at, err == nil
+}
+
+// Write writes a snapshot of the metrics and deletes the oldest snapshots
+// beyond the retention count.
+//
+// Parameters:
+//   - metrics: The metrics as of the time of the snapshot.
+//   - at: The time of the snapshot.
+//
+// Returns:
+//   - The description of the written snapshot.
+//   - An error if the snapshot can't be written. Errors deleting old
+//     snapshots are not reported, they are retried after the next one.
+func (s *Store) Write(metrics []*metric.Metric, at time.Time) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Two snapshots taken within a millisecond get distinct names.
+	at = at.UTC().Truncate(time.Millisecond)
+	name := filePrefix + at.Format(timeLayout) + fileSuffix
+	for {
+		if _, err := os.Stat(filepath.Join(s.Dir, name)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		at = at.Add(time.Millisecond)
+		name = filePrefix + at.Format(timeLayout) + fileSuffix
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-"+name)
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if err = writeMetrics(tmp, metrics); err != nil {
+		tmp.Close()
+		return Info{}, err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return Info{}, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return Info{}, err
+	}
+	if err = tmp.Close(); err != nil {
+		return Info{}, err
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(s.Dir, name)); err != nil {
+		return Info{}, err
+	}
+	atomicfile.SyncDir(s.Dir)
+
+	s.prune()
+	return Info{Name: name, Time: at, Size: info.Size(), Metrics: len(metrics)}, nil
+}
+
+// writeMetrics writes the metrics in the JSON export format.
+func writeMetrics(w io.Writer, metrics []*metric.Metric) error {
+	ew, err := export.NewWriter(export.FormatJSON, w)
+	if err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if err = ew.Write(m); err != nil {
+			return err
+		}
+	}
+	return ew.Close()
+}
+
+// prune deletes the oldest snapshots beyond the retention count. It must be
+// called with the mutex held.
+func (s *Store) prune() {
+	if s.Retain <= 0 {
+		return
+	}
+	infos, err := s.list()
+	if err != nil {
+		return
+	}
+	for _, info := range infos[min(s.Retain, len(infos)):] {
+		_ = os.Remove(filepath.Join(s.Dir, info.Name))
+	}
+}
+
+// List returns the snapshots, the newest first.
+//
+// Returns:
+//   - The descriptions of the snapshots.
+//   - An error if the directory can't be read.
+func (s *Store) List() ([]Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+func (s *Store) list() ([]Info, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(entries))
+	for _, entry := range entries {
+		at, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, Info{Name: entry.Name(), Time: at, Size: fi.Size()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name > infos[j].Name })
+	return infos, nil
+}
+
+// Read reads the metrics of a snapshot.
+//
+// Parameters:
+//   - name: The name of the snapshot.
+//
+// Returns:
+//   - The description of the snapshot.
+//   - The metrics of the snapshot.
+//   - ErrNotFound if there is no snapshot with the name, or an error if the
+//     snapshot can't be read or is malformed.
+func (s *Store) Read(name string) (Info, []metric.Metric, error) {
+	at, ok := parseName(name)
+	if !ok || filepath.Base(name) != name {
+		return Info{}, nil, ErrNotFound
+	}
+
+	file, err := os.Open(filepath.Join(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, nil, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return Info{}, nil, err
+	}
+
+	r, err := export.NewReader(export.FormatJSON, file)
+	if err != nil {
+		return Info{}, nil, err
+	}
+	var metrics []metric.Metric
+	for {
+		m, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Info{}, nil, fmt.Errorf("snapshot %s: %w", name, err)
+		}
+		metrics = append(metrics, m)
+	}
+	return Info{Name: name, Time: at, Size: fi.Size(), Metrics: len(metrics)}, metrics, nil
+}
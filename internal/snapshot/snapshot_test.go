@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	store, err := NewStore(dir, 2)
+	require.NoError(t, err)
+
+	value, delta := 1.5, int64(7)
+	metrics := []*metric.Metric{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first, err := store.Write(metrics, at)
+	require.NoError(t, err)
+	assert.Equal(t, "metrics-20240501T120000.000Z.json", first.Name)
+	assert.Equal(t, 2, first.Metrics)
+
+	// A snapshot taken at the same time gets the next millisecond.
+	second, err := store.Write(metrics[:1], at)
+	require.NoError(t, err)
+	assert.Equal(t, "metrics-20240501T120000.001Z.json", second.Name)
+
+	third, err := store.Write(nil, at.Add(time.Hour))
+	require.NoError(t, err)
+
+	// Only the newest two snapshots are kept.
+	infos, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, third.Name, infos[0].Name)
+	assert.Equal(t, second.Name, infos[1].Name)
+	assert.Equal(t, at.Add(time.Hour), infos[0].Time)
+
+	_, _, err = store.Read(first.Name)
+	assert.ErrorIs(t, err, ErrNotFound)
+	info, read, err := store.Read(second.Name)
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.Metrics)
+	assert.Equal(t, []metric.Metric{*metrics[0]}, read)
+	_, read, err = store.Read(third.Name)
+	require.NoError(t, err)
+	assert.Empty(t, read)
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestStore_Read_BadName(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "snapshots"), 0)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metrics-20240501T120000.000Z.json"), []byte("[]"), 0666))
+
+	for _, name := range []string{"", "metrics.json", "../metrics-20240501T120000.000Z.json", "metrics-20240501T120000.000Z.json"} {
+		_, _, err = store.Read(name)
+		assert.ErrorIs(t, err, ErrNotFound, name)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(store.Dir, "metrics-20240501T120000.000Z.json"), []byte(`[{"id":"a","type":"gauge"}]`), 0666))
+	_, _, err = store.Read("metrics-20240501T120000.000Z.json")
+	assert.ErrorContains(t, err, "record 1")
+}
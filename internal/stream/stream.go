@@ -0,0 +1,162 @@
+// Package stream broadcasts accepted metric updates to live subscribers.
+//
+// Every ingestion path publishes the updates it accepted to a Hub, and every
+// subscriber, e.g. an open server-sent events connection, receives the
+// updates whose names match its pattern. Publishing never blocks: each
+// subscriber has a bounded buffer, and the updates that don't fit while a
+// slow subscriber catches up are dropped and counted, so the subscriber can
+// tell that it missed updates and reload the current values.
+package stream
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+// DefaultBuffer is the default number of updates buffered per subscriber.
+const DefaultBuffer = 256
+
+// Event is an accepted metric update. Counters carry the accepted delta, not
+// the new total.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client,omitempty"`
+	metric.Metric
+}
+
+// Subscription receives the updates that match its pattern.
+type Subscription struct {
+	events  chan Event
+	pattern string
+	dropped int64
+	mu      sync.Mutex
+}
+
+// Events returns the channel of the updates. It is closed when the
+// subscription is cancelled with Hub.Unsubscribe.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// TakeDropped returns the number of updates dropped since the last call
+// because the buffer of the subscription was full.
+func (s *Subscription) TakeDropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
+
+func (s *Subscription) matches(name string) bool {
+	if s.pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(s.pattern, name)
+	return ok
+}
+
+// Hub broadcasts updates to subscriptions. It is safe for concurrent use.
+// Publishing to a nil Hub does nothing.
+//
+// Fields:
+//   - Buffer: The number of updates buffered per subscription.
+type Hub struct {
+	subs   map[*Subscription]struct{}
+	Buffer int
+	mu     sync.RWMutex
+}
+
+// NewHub creates a Hub.
+//
+// Parameters:
+//   - buffer: The number of updates buffered per subscription.
+//
+// Returns:
+//   - A pointer to the newly created Hub.
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Hub{
+		Buffer: buffer,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe subscribes to the updates of the metrics whose names match a glob
+// pattern.
+//
+// Parameters:
+//   - pattern: The glob pattern, see path.Match. An empty pattern matches all
+//     metrics.
+//
+// Returns:
+//   - The subscription. It must be cancelled with Unsubscribe.
+//   - path.ErrBadPattern if the pattern is malformed.
+func (h *Hub) Subscribe(pattern string) (*Subscription, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	s := &Subscription{pattern: pattern, events: make(chan Event, h.Buffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Unsubscribe cancels a subscription and closes its channel.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// Subscribers returns the number of subscriptions.
+func (h *Hub) Subscribers() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Publish sends accepted updates to the matching subscriptions without
+// blocking. An update that doesn't fit into the buffer of a subscription is
+// dropped for it.
+//
+// Parameters:
+//   - client: The identity of the client that sent the updates.
+//   - metrics: The accepted updates.
+//   - now: The time the updates were accepted.
+func (h *Hub) Publish(client string, metrics []metric.Metric, now time.Time) {
+	if h == nil || len(metrics) == 0 {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs {
+		for _, m := range metrics {
+			if !s.matches(m.ID) {
+				continue
+			}
+			select {
+			case s.events <- Event{Time: now, Client: client, Metric: m}:
+			default:
+				s.mu.Lock()
+				s.dropped++
+				s.mu.Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/internal/metric"
+)
+
+func gauge(name string, value float64) metric.Metric {
+	return metric.Metric{ID: name, MType: "gauge", Value: &value}
+}
+
+func TestHub_Publish(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHub(2)
+	all, err := h.Subscribe("")
+	require.NoError(t, err)
+	cpu, err := h.Subscribe("CPU*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Subscribers())
+
+	h.Publish("ip:127.0.0.1", []metric.Metric{gauge("CPUutilization1", 10), gauge("FreeMemory", 5)}, now)
+	event := <-cpu.Events()
+	assert.Equal(t, "CPUutilization1", event.ID)
+	assert.Equal(t, "ip:127.0.0.1", event.Client)
+	assert.Equal(t, now, event.Time)
+	assert.Len(t, cpu.Events(), 0)
+	assert.Len(t, all.Events(), 2)
+
+	h.Publish("", []metric.Metric{gauge("CPUutilization2", 1)}, now)
+	assert.Equal(t, int64(1), all.TakeDropped(), "a full buffer drops updates")
+	assert.Equal(t, int64(0), all.TakeDropped())
+	assert.Equal(t, int64(0), cpu.TakeDropped())
+
+	h.Unsubscribe(all)
+	h.Unsubscribe(all)
+	assert.Equal(t, 1, h.Subscribers())
+	<-all.Events()
+	<-all.Events()
+	_, ok := <-all.Events()
+	assert.False(t, ok, "the channel is closed")
+}
+
+func TestHub_Subscribe_BadPattern(t *testing.T) {
+	_, err := NewHub(0).Subscribe("[")
+	assert.Error(t, err)
+}
+
+func TestHub_Nil(t *testing.T) {
+	var h *Hub
+	h.Publish("", []metric.Metric{gauge("FreeMemory", 5)}, time.Now())
+	h.Unsubscribe(nil)
+	assert.Equal(t, 0, h.Subscribers())
+}
@@ -0,0 +1,56 @@
+// Package atomicfile provides writing of files that survive a crash: a file
+// is either replaced completely or left as it was.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces a file atomically: the data is written to a temporary
+// file, which is synced and renamed over the file.
+//
+// Parameters:
+//   - path: The path of the file.
+//   - data: The new contents of the file.
+//
+// Returns:
+//   - An error if the file can't be written. The file is unchanged then.
+func WriteFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	SyncDir(filepath.Dir(path))
+	return nil
+}
+
+// SyncDir syncs a directory, so a rename in it survives a crash. Not every
+// platform can sync directories, so errors are ignored.
+func SyncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
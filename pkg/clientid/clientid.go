@@ -0,0 +1,162 @@
+// Package clientid provides utilities for resolving the identity of a client
+// that sends metrics to the server.
+//
+// The identity is used to account requests per client (rate limiting, quotas,
+// auditing). It is resolved in the following order of preference: the common
+// name of a verified TLS client certificate and, otherwise, the address of
+// the client. Names the client chooses freely, e.g. a key ID header, are not
+// used: a client could send a new one with every request and never be
+// limited. For the same reason the real IP address reported in the X-Real-IP
+// header is only used if the request comes from a trusted proxy; see
+// WithRealIP.
+package clientid
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// HeaderRealIP is the name of the header with the real IP address of the
+// agent.
+const HeaderRealIP = "X-Real-IP"
+
+// Prefixes of the resolved identities. They make it possible to tell apart
+// a certificate name from an IP address with the same textual value.
+const (
+	PrefixCert = "cert:"
+	PrefixIP   = "ip:"
+)
+
+// Proxies is a list of the networks of trusted reverse proxies. The real IP
+// addresses of the clients are taken from the requests they forward.
+type Proxies []*net.IPNet
+
+// ParseProxies parses a comma-separated list of CIDR subnets of trusted
+// proxies, e.g. "10.0.0.0/8,192.168.1.1/32". An empty string gives no
+// proxies.
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %q: %w", cidr, err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+// Contains reports whether the host of a network address belongs to one of
+// the proxies.
+func (p Proxies) Contains(addr string) bool {
+	ip := net.ParseIP(hostOf(addr))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type realIPKey struct{}
+
+// WithRealIP returns a copy of the context of a request that carries the real
+// IP address of the client. It must only be called for requests forwarded by
+// a trusted proxy, since the address is then used as the identity of the
+// client.
+func WithRealIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, realIPKey{}, ip)
+}
+
+// FromRequest resolves the identity of the client that sent the HTTP request.
+//
+// Parameters:
+//   - r: The incoming HTTP request.
+//
+// Returns:
+//   - A string identifying the client, e.g. "cert:host.local" or
+//     "ip:10.0.0.1".
+func FromRequest(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if cn := r.TLS.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return PrefixCert + cn
+		}
+	}
+	return PrefixIP + RemoteAddr(r)
+}
+
+// RemoteAddr returns the network address of the client that sent the HTTP
+// request: the real IP address set with WithRealIP or, otherwise, the
+// address of the connection.
+func RemoteAddr(r *http.Request) string {
+	if realIP, ok := r.Context().Value(realIPKey{}).(string); ok && realIP != "" {
+		return realIP
+	}
+	return PeerAddr(r)
+}
+
+// PeerAddr returns the address of the connection the HTTP request came from.
+func PeerAddr(r *http.Request) string {
+	return hostOf(r.RemoteAddr)
+}
+
+// ReportedIP returns the real IP address reported in the X-Real-IP header of
+// the HTTP request. It isn't checked, so it is only recorded and never used
+// to identify the client.
+func ReportedIP(r *http.Request) string {
+	return r.Header.Get(HeaderRealIP)
+}
+
+// FromContext resolves the identity of the client of an incoming gRPC call.
+//
+// Parameters:
+//   - ctx: The context of the incoming gRPC call.
+//
+// Returns:
+//   - A string identifying the client, or an empty string if the context
+//     carries no peer information.
+func FromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		if cn := tlsInfo.State.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return PrefixCert + cn
+		}
+	}
+	if p.Addr == nil {
+		return ""
+	}
+	return PrefixIP + hostOf(p.Addr.String())
+}
+
+// RemoteAddrFromContext returns the network address of the client of an
+// incoming gRPC call.
+func RemoteAddrFromContext(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return hostOf(p.Addr.String())
+	}
+	return ""
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,58 @@
+package clientid
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/peer"
+)
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set(HeaderRealIP, "10.0.0.2")
+
+	// The reported address is ignored unless a trusted proxy set it.
+	assert.Equal(t, "ip:10.0.0.1", FromRequest(req))
+	assert.Equal(t, "10.0.0.1", RemoteAddr(req))
+
+	proxied := req.WithContext(WithRealIP(req.Context(), "10.0.0.2"))
+	assert.Equal(t, "ip:10.0.0.2", FromRequest(proxied))
+	assert.Equal(t, "10.0.0.2", RemoteAddr(proxied))
+	assert.Equal(t, "10.0.0.1", PeerAddr(proxied))
+
+	proxied.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "host.local"}}}}
+	assert.Equal(t, "cert:host.local", FromRequest(proxied))
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Empty(t, FromContext(context.Background()))
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	assert.Equal(t, "ip:10.0.0.1", FromContext(ctx))
+	assert.Equal(t, "10.0.0.1", RemoteAddrFromContext(ctx))
+}
+
+func TestParseProxies(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.168.1.1/32")
+	require.NoError(t, err)
+	assert.True(t, proxies.Contains("10.1.2.3:80"))
+	assert.True(t, proxies.Contains("192.168.1.1"))
+	assert.False(t, proxies.Contains("192.168.1.2:80"))
+	assert.False(t, proxies.Contains("bad"))
+
+	proxies, err = ParseProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	_, err = ParseProxies("10.0.0.1")
+	assert.Error(t, err)
+}
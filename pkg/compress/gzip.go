@@ -61,6 +61,19 @@ func (c *Writer) WriteHeader(statusCode int) {
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush writes the compressed data buffered so far to the underlying
+// ResponseWriter and flushes it to the client. It allows streaming responses,
+// e.g. server-sent events, to be compressed.
+func (c *Writer) Flush() {
+	if err := c.zw.Flush(); err != nil {
+		logger.Log.Info("error flush compress writer", zap.Error(err))
+		return
+	}
+	if err := http.NewResponseController(c.w).Flush(); err != nil {
+		logger.Log.Info("error flush response", zap.Error(err))
+	}
+}
+
 // Close closes the gzip.Writer, flushing any buffered data to the underlying writer.
 // It should be called to ensure all data is sent before the response is completed.
 func (c *Writer) Close() error {
@@ -4,6 +4,7 @@ package exitcheck
 
 import (
 	"go/ast"
+	"strings"
 
 	"golang.org/x/tools/go/analysis"
 )
@@ -16,6 +17,13 @@ var ExitMainAnalyzer = &analysis.Analyzer{
 	Run:  run,
 }
 
+// excludedSuffix is the import path suffix of the main package that is
+// allowed to call os.Exit in main: cmd/metricsctl. Its exit status code
+// (ctl.ExitOK, ctl.ExitError or ctl.ExitUsage) is part of its interface, so
+// it can't panic like the server and the agent. Its main only passes the code
+// returned by run to os.Exit, after the deferred calls of run have run.
+const excludedSuffix = "cmd/metricsctl"
+
 // run is the main analysis function for the ExitMainAnalyzer.
 // It inspects the abstract syntax tree (AST) of Go source files
 // to identify calls to os.Exit within the main function of the
@@ -27,6 +35,9 @@ var ExitMainAnalyzer = &analysis.Analyzer{
 // Returns:
 //   - An interface{} (always nil in this case) and an error (always nil).
 func run(pass *analysis.Pass) (interface{}, error) {
+	if strings.HasSuffix(pass.Pkg.Path(), excludedSuffix) {
+		return nil, nil
+	}
 	for _, f := range pass.Files {
 		if f.Name.Name == "main" {
 			ast.Inspect(f, func(n ast.Node) bool {
@@ -9,5 +9,5 @@ import (
 func TestExitAnalyzer(t *testing.T) {
 	// функция analysistest.Run применяет тестируемый анализатор ExitMainAnalyzer
 	// к пакетам из папки testdata и проверяет ожидания
-	analysistest.Run(t, analysistest.TestData(), ExitMainAnalyzer, "./exit")
+	analysistest.Run(t, analysistest.TestData(), ExitMainAnalyzer, "./exit", "./cmd/metricsctl")
 }
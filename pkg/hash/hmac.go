@@ -0,0 +1,21 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// GetHMACSHA256 computes the HMAC-SHA256 of the provided data with the
+// specified key.
+//
+// Parameters:
+//   - key: A string used as the secret key of the HMAC.
+//   - data: A byte slice containing the data to be signed.
+//
+// Returns:
+//   - A byte slice containing the 32 bytes long HMAC-SHA256 of the data.
+func GetHMACSHA256(key string, data []byte) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,39 @@
+package interceptors
+
+import (
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/pkg/clientid"
+	"github.com/Vidkin/metrics/pkg/ratelimit"
+)
+
+// RetryAfterKey is the trailer key that carries the number of seconds a
+// client should wait before retrying a rejected call.
+const RetryAfterKey = "retry-after"
+
+func RateLimitInterceptor(limiter *ratelimit.Limiter) func(context.Context, interface{}, *grpc.UnaryServerInfo, grpc.UnaryHandler) (interface{}, error) {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if limiter == nil {
+			return handler(ctx, req)
+		}
+
+		client := clientid.FromContext(ctx)
+		if ok, wait := limiter.Allow(client); !ok {
+			logger.Log.Info("rate limit exceeded", zap.String("client", client))
+			retryAfter := strconv.Itoa(ratelimit.RetryAfterSeconds(wait))
+			if err := grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterKey, retryAfter)); err != nil {
+				logger.Log.Error("error set retry-after trailer", zap.Error(err))
+			}
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,43 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/Vidkin/metrics/pkg/ratelimit"
+)
+
+func TestRateLimitInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/metrics.Metrics/UpdateMetrics"}
+	call := func(interceptor grpc.UnaryServerInterceptor, ip string) (interface{}, error) {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 5000}})
+		return interceptor(ctx, nil, info, handler)
+	}
+
+	interceptor := RateLimitInterceptor(ratelimit.New(0.001, 1))
+	resp, err := call(interceptor, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = call(interceptor, "10.0.0.1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = call(interceptor, "10.0.0.2")
+	assert.NoError(t, err)
+
+	unlimited := RateLimitInterceptor(nil)
+	for i := 0; i < 3; i++ {
+		_, err = call(unlimited, "10.0.0.1")
+		assert.NoError(t, err)
+	}
+}
@@ -8,5 +8,11 @@
 // logging.go includes middleware for logging request and response data,
 // which can be useful for monitoring and debugging purposes.
 //
+// problem.go includes middleware for writing error responses as RFC 7807 problem details.
+//
+// real_ip.go includes middleware for taking the address of the client from the X-Real-IP header of trusted proxies.
+//
+// rate_limit.go includes middleware for limiting the request rate per client and the size of request bodies.
+//
 // trusted_subnet.go includes middleware for checking that real ip of agent is in the server trusted subnet.
 package middleware
@@ -18,6 +18,12 @@ type hashResponseWriter struct {
 	HashSHA256 string
 }
 
+// Unwrap returns the underlying ResponseWriter, so http.ResponseController
+// can reach its optional methods, e.g. Flush.
+func (rw hashResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Hash is an HTTP middleware function that validates the integrity of incoming
 // request bodies using SHA-256 hashes.
 //
@@ -48,6 +48,12 @@ func (rw *loggingResponseWriter) WriteHeader(statusCode int) {
 	rw.responseData.status = statusCode
 }
 
+// Unwrap returns the underlying ResponseWriter, so http.ResponseController
+// can reach its optional methods, e.g. Flush.
+func (rw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Logging is an HTTP middleware function that logs details about incoming
 // requests and outgoing responses.
 //
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+)
+
+// ProblemContentType is the media type of problem details, see RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is the body of an error response, see RFC 7807.
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Status   int    `json:"status"`
+}
+
+// problemResponseWriter holds back error responses with a plain text body,
+// or no body at all, so Problem can replace them with problem details.
+type problemResponseWriter struct {
+	http.ResponseWriter
+	body    bytes.Buffer
+	status  int
+	problem bool
+}
+
+// WriteHeader sends the status code, unless it is an error status of a plain
+// text response, which is held back.
+func (rw *problemResponseWriter) WriteHeader(statusCode int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = statusCode
+	contentType := rw.Header().Get("Content-Type")
+	if statusCode >= http.StatusBadRequest && (contentType == "" || strings.HasPrefix(contentType, "text/plain")) {
+		rw.problem = true
+		return
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the data to the underlying ResponseWriter, or buffers it if
+// the response is held back.
+func (rw *problemResponseWriter) Write(data []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.problem {
+		return rw.body.Write(data)
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the underlying ResponseWriter, so http.ResponseController
+// can reach its optional methods, e.g. Flush.
+func (rw *problemResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// detail returns the held back body as text. The body is compressed if the
+// Gzip middleware runs after Problem. It is checked by the magic bytes
+// rather than the Content-Encoding header, since the gzip writer writes an
+// empty stream without setting the header when the body is empty.
+func (rw *problemResponseWriter) detail() string {
+	var body io.Reader = &rw.body
+	if bytes.HasPrefix(rw.body.Bytes(), gzipMagic) {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return ""
+		}
+		defer zr.Close()
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Problem is an HTTP middleware function that turns the plain text error
+// responses of the requests under a path prefix into problem details, see
+// RFC 7807. The text of an error becomes the detail of the problem. Error
+// responses that already have another content type, e.g. JSON, are left
+// as is.
+//
+// Parameters:
+//   - prefix: The path prefix of the requests whose errors are turned into
+//     problem details.
+//
+// Returns:
+//   - A function that takes an http.Handler and returns a new http.Handler
+//     that writes problem details.
+func Problem(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			problemRW := &problemResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(problemRW, r)
+			if !problemRW.problem {
+				return
+			}
+
+			problem := ProblemDetails{
+				Type:     "about:blank",
+				Title:    http.StatusText(problemRW.status),
+				Status:   problemRW.status,
+				Detail:   problemRW.detail(),
+				Instance: r.URL.Path,
+			}
+			if problem.Detail == problem.Title {
+				problem.Detail = ""
+			}
+			w.Header().Del("Content-Encoding")
+			w.Header().Del("Content-Length")
+			w.Header().Del("X-Content-Type-Options")
+			w.Header().Set("Content-Type", ProblemContentType)
+			w.WriteHeader(problem.Status)
+			if err := json.NewEncoder(w).Encode(problem); err != nil {
+				logger.Log.Error("error encode problem details", zap.Error(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/Vidkin/metrics/internal/logger"
+	"github.com/Vidkin/metrics/pkg/clientid"
+	"github.com/Vidkin/metrics/pkg/ratelimit"
+)
+
+// RateLimit is an HTTP middleware function that limits the rate of requests
+// per client and the size of request bodies.
+//
+// Clients are identified with clientid.FromRequest. A client that exceeds its
+// rate gets a 429 Too Many Requests response with a Retry-After header that
+// tells it when to retry. A request whose body is larger than maxBodySize gets
+// a 413 Request Entity Too Large response.
+//
+// Parameters:
+//   - limiter: A per-client rate limiter. If it is nil, the request rate is
+//     not limited.
+//   - maxBodySize: The maximum size of a request body in bytes. If it is zero
+//     or negative, the body size is not limited.
+//
+// Returns:
+//   - A function that takes an http.Handler and returns a new http.Handler
+//     that includes the rate limiting logic.
+func RateLimit(limiter *ratelimit.Limiter, maxBodySize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limiter != nil {
+				client := clientid.FromRequest(r)
+				if ok, wait := limiter.Allow(client); !ok {
+					logger.Log.Info("rate limit exceeded", zap.String("client", client))
+					w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(wait)))
+					http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			if maxBodySize > 0 {
+				if r.ContentLength > maxBodySize {
+					logger.Log.Info("request body too large", zap.Int64("size", r.ContentLength))
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Vidkin/metrics/pkg/clientid"
+	"github.com/Vidkin/metrics/pkg/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(ratelimit.New(0.001, 1), 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(remoteAddr, realIP string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		req.RemoteAddr = remoteAddr
+		if realIP != "" {
+			req.Header.Set(clientid.HeaderRealIP, realIP)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:5000", "").Code)
+
+	rec := send("10.0.0.1:5001", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// A new reported address doesn't make a new client.
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.1:5002", "192.168.1.1").Code)
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.2:5000", "").Code)
+}
+
+func TestRateLimit_MaxBodySize(t *testing.T) {
+	handler := RateLimit(nil, 4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{name: "small body", body: "1234", statusCode: http.StatusOK},
+		{name: "large body", body: "12345", statusCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, test.statusCode, rec.Code)
+		})
+	}
+}
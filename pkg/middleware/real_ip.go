@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+// RealIP is an HTTP middleware function that takes the address of the client
+// from the X-Real-IP header of the requests forwarded by trusted proxies. The
+// header of any other request is ignored, so a client can't change its
+// identity by sending a new address with every request.
+//
+// Parameters:
+//   - proxies: The networks of the trusted proxies. If it is empty, the
+//     header is never trusted.
+//
+// Returns:
+//   - A function that takes an http.Handler and returns a new http.Handler
+//     that includes the real IP resolution logic.
+func RealIP(proxies clientid.Proxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(proxies) > 0 && proxies.Contains(r.RemoteAddr) {
+				if ip := net.ParseIP(r.Header.Get(clientid.HeaderRealIP)); ip != nil {
+					r = r.WithContext(clientid.WithRealIP(r.Context(), ip.String()))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
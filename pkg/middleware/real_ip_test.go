@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Vidkin/metrics/pkg/clientid"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := clientid.ParseProxies("10.0.0.0/24")
+	require.NoError(t, err)
+
+	var client string
+	handler := RealIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = clientid.FromRequest(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{name: "trusted proxy", remoteAddr: "10.0.0.5:5000", realIP: "192.168.1.7", want: "ip:192.168.1.7"},
+		{name: "untrusted client", remoteAddr: "172.16.0.1:5000", realIP: "192.168.1.7", want: "ip:172.16.0.1"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.5:5000", want: "ip:10.0.0.5"},
+		{name: "trusted proxy with bad header", remoteAddr: "10.0.0.5:5000", realIP: "bad", want: "ip:10.0.0.5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.realIP != "" {
+				req.Header.Set(clientid.HeaderRealIP, test.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, test.want, client)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Package ratelimit provides a token bucket rate limiter that keeps a separate
+// bucket for every client.
+//
+// Each bucket holds up to burst tokens and is refilled at a constant rate of
+// tokens per second. A request is allowed when its bucket holds enough
+// tokens; otherwise the limiter reports how long the client has to wait
+// before the request would be allowed.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idleTTL is the period after which the bucket of a silent client is
+// forgotten. A forgotten bucket is recreated full on the next request, which
+// is exactly the state it would have reached by refilling.
+const idleTTL = 10 * time.Minute
+
+type bucket struct {
+	last   time.Time
+	tokens float64
+}
+
+// Limiter is a per-client token bucket rate limiter. It is safe for
+// concurrent use.
+type Limiter struct {
+	buckets   map[string]*bucket
+	now       func() time.Time
+	lastSweep time.Time
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+}
+
+// New creates a Limiter that allows rate requests per second for every
+// client, with bursts of up to burst requests.
+//
+// Parameters:
+//   - rate: The number of tokens added to every bucket per second.
+//   - burst: The capacity of every bucket. If it is less than 1, the
+//     capacity is set to rate rounded up, but not less than 1.
+//
+// Returns:
+//   - A pointer to the newly created Limiter.
+func New(rate float64, burst int) *Limiter {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+		rate:    rate,
+		burst:   b,
+	}
+}
+
+// Allow reports whether a single request of the given client may be
+// processed now. It is a shorthand for AllowN(key, 1).
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n requests of the given client may be processed now
+// and, if so, takes n tokens from the client's bucket.
+//
+// Parameters:
+//   - key: The identity of the client.
+//   - n: The number of tokens the request costs.
+//
+// Returns:
+//   - true if the request is allowed.
+//   - The time the client has to wait before the request would be allowed,
+//     or zero if the request is allowed.
+func (l *Limiter) AllowN(key string, n int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	}
+	b.last = now
+
+	cost := float64(n)
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, idleTTL
+	}
+	wait := (cost - b.tokens) / l.rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// sweep forgets buckets that have not been used for idleTTL. It runs at most
+// once per idleTTL, so the amortized cost per request stays constant.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RetryAfterSeconds converts a wait duration to the whole number of seconds
+// suitable for the Retry-After header. The result is never less than 1.
+func RetryAfterSeconds(d time.Duration) int {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
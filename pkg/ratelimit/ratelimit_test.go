@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_AllowN(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		burst     int
+		requests  int
+		advance   time.Duration
+		wantOK    bool
+		wantAfter time.Duration
+	}{
+		{
+			name:     "test within burst",
+			rate:     1,
+			burst:    3,
+			requests: 2,
+			wantOK:   true,
+		},
+		{
+			name:      "test burst exhausted",
+			rate:      2,
+			burst:     3,
+			requests:  3,
+			wantOK:    false,
+			wantAfter: 500 * time.Millisecond,
+		},
+		{
+			name:     "test refilled after wait",
+			rate:     2,
+			burst:    3,
+			requests: 3,
+			advance:  time.Second,
+			wantOK:   true,
+		},
+		{
+			name:      "test default burst",
+			rate:      1.5,
+			requests:  2,
+			wantOK:    false,
+			wantAfter: 666666667 * time.Nanosecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Now()
+			l := New(test.rate, test.burst)
+			l.now = func() time.Time { return now }
+
+			for i := 0; i < test.requests; i++ {
+				ok, _ := l.Allow("client")
+				assert.True(t, ok)
+			}
+			now = now.Add(test.advance)
+
+			ok, after := l.Allow("client")
+			assert.Equal(t, test.wantOK, ok)
+			assert.Equal(t, test.wantAfter, after)
+
+			ok, _ = l.Allow("another client")
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Now()
+	l := New(1, 1)
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("client")
+	assert.True(t, ok)
+	assert.Len(t, l.buckets, 1)
+
+	now = now.Add(idleTTL)
+	ok, _ = l.Allow("another client")
+	assert.True(t, ok)
+	assert.Len(t, l.buckets, 1)
+	assert.Contains(t, l.buckets, "another client")
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	assert.Equal(t, 1, RetryAfterSeconds(0))
+	assert.Equal(t, 1, RetryAfterSeconds(100*time.Millisecond))
+	assert.Equal(t, 2, RetryAfterSeconds(1100*time.Millisecond))
+}